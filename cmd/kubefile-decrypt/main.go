@@ -0,0 +1,158 @@
+// kubefile-decrypt downloads and decrypts end-to-end encrypted KubeFile
+// shares.
+//
+//	kubefile-decrypt [-o out] 'https://host/e2e/<id>#<key>'
+//	kubefile-decrypt -key <key> -meta <blob> [-o out] file.enc
+//
+// The second form decrypts a ciphertext that was already downloaded; the
+// metadata blob is the X-Encrypted-Metadata header of the download.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Maruqes/KubeFile/shared/e2e"
+)
+
+func main() {
+	output := flag.String("o", "", "output file (default: the original file name, \"-\" for stdout)")
+	keyFlag := flag.String("key", "", "key for a local ciphertext file")
+	metaFlag := flag.String("meta", "", "metadata blob for a local ciphertext file")
+	force := flag.Bool("f", false, "overwrite the output file if it exists")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [-o out] [-f] 'https://host/e2e/<id>#<key>'\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -key <key> -meta <blob> [-o out] [-f] file.enc\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+
+	var src io.ReadCloser
+	var key, blob []byte
+	var err error
+	if *keyFlag != "" {
+		src, key, blob, err = openLocal(flag.Arg(0), *keyFlag, *metaFlag)
+	} else {
+		src, key, blob, err = openLink(flag.Arg(0))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+
+	meta, err := e2e.OpenMetadata(key, blob)
+	if err != nil {
+		log.Fatal(err)
+	}
+	reader, err := e2e.NewReader(src, key, meta)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *output == "-" {
+		if _, err := io.Copy(os.Stdout, reader); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := writeFile(outputPath(*output, meta.Name), reader, *force); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func openLocal(path, keyVal, metaVal string) (io.ReadCloser, []byte, []byte, error) {
+	key, err := e2e.ParseKey(keyVal)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	blob, err := e2e.DecodeBlob(metaVal)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid metadata blob: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return f, key, blob, nil
+}
+
+// openLink downloads the ciphertext of a share link. The key comes from the
+// fragment, which is never sent to the server.
+func openLink(link string) (io.ReadCloser, []byte, []byte, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid link: %v", err)
+	}
+	key, err := e2e.ParseKey(u.Fragment)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	id := strings.TrimPrefix(u.EscapedPath(), "/e2e/")
+	if id == u.EscapedPath() || id == "" {
+		return nil, nil, nil, fmt.Errorf("not a KubeFile encrypted share link: %s", link)
+	}
+	download := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/download/"}
+	res, err := http.Get(download.String() + id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, nil, nil, fmt.Errorf("download failed: %s", res.Status)
+	}
+	blob, err := e2e.DecodeBlob(res.Header.Get(e2e.MetadataHeader))
+	if err != nil || len(blob) == 0 {
+		res.Body.Close()
+		return nil, nil, nil, fmt.Errorf("the server sent no metadata for this file, is it an encrypted share?")
+	}
+	return res.Body, key, blob, nil
+}
+
+// outputPath defaults to the original name, stripped of any directories.
+func outputPath(output, name string) string {
+	if output != "" {
+		return output
+	}
+	name = filepath.Base(filepath.FromSlash(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return "download"
+	}
+	return name
+}
+
+// writeFile writes to a temporary file first so a failed authentication
+// never leaves a partial plaintext behind under the real name.
+func writeFile(path string, r io.Reader, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists (use -f to overwrite)", path)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".kubefile-decrypt-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	log.Printf("Decrypted %s", path)
+	return nil
+}
@@ -13,10 +13,18 @@ Options:
       --auth-user        Gateway AUTH_USERNAME value (requires all auth flags)
       --auth-password    Gateway AUTH_PASSWORD value (requires all auth flags)
       --auth-secret      Gateway AUTH_SECRET value (requires all auth flags)
+      --lifecycle        on|off: let MinIO expire files with bucket lifecycle rules
+                         (default: leave the filesharing manifest as it is)
   -h, --help             Show this help and exit
 
 The script updates k8s/minio-statefulset.yaml and, when auth flags are provided,
 k8s/gateway-service.yaml. Always commit or back up your changes before running.
+
+With lifecycle on, the filesharing service installs one expiration rule per
+expiry class (kubefile-expiry-<N>d, matching objects tagged kubefile-expiry=<N>d)
+when it starts, and MinIO deletes expired files on its own. With it off, or on
+servers without lifecycle support, the filesharing leader deletes them. Check
+the installed rules with: mc ilm rule ls <alias>/ficheiros
 EOF
 }
 
@@ -27,6 +35,7 @@ MINIO_SIZE_GI=""
 AUTH_USER=""
 AUTH_PASSWORD=""
 AUTH_SECRET=""
+LIFECYCLE=""
 
 while [[ $# -gt 0 ]]; do
 	case "$1" in
@@ -58,6 +67,10 @@ while [[ $# -gt 0 ]]; do
 		AUTH_SECRET="${2:-}"
 		shift 2
 		;;
+	--lifecycle)
+		LIFECYCLE="${2:-}"
+		shift 2
+		;;
 	-h|--help)
 		usage
 		exit 0
@@ -81,6 +94,11 @@ if ! [[ "$MINIO_SIZE_GI" =~ ^[0-9]+$ ]]; then
 	exit 1
 fi
 
+if [[ -n "$LIFECYCLE" && "$LIFECYCLE" != "on" && "$LIFECYCLE" != "off" ]]; then
+	echo "Error: --lifecycle must be on or off." >&2
+	exit 1
+fi
+
 if [[ -n "$AUTH_USER" || -n "$AUTH_PASSWORD" || -n "$AUTH_SECRET" ]]; then
 	if [[ -z "$AUTH_USER" || -z "$AUTH_PASSWORD" || -z "$AUTH_SECRET" ]]; then
 		echo "Error: --auth-user, --auth-password, and --auth-secret must be provided together." >&2
@@ -109,7 +127,7 @@ if [[ -n "$AUTH_USER" ]] && [[ ! -f "$GATEWAY_MANIFEST_FILE" ]]; then
 fi
 
 export MINIO_USER MINIO_PASSWORD MINIO_PVC_NAME MINIO_SIZE_GI MANIFEST_FILE
-export AUTH_USER AUTH_PASSWORD AUTH_SECRET GATEWAY_MANIFEST_FILE FILESHARING_MANIFEST_FILE
+export AUTH_USER AUTH_PASSWORD AUTH_SECRET GATEWAY_MANIFEST_FILE FILESHARING_MANIFEST_FILE LIFECYCLE
 
 python3 <<'PY'
 import os
@@ -189,6 +207,14 @@ apply_filesharing(
     "filesharing MINIO_SECRET_KEY",
 )
 
+lifecycle = os.environ.get("LIFECYCLE")
+if lifecycle:
+    apply_filesharing(
+        r"(-\s+name:\s+STORAGE_LIFECYCLE\s*\n\s+value:\s+)" r'"[^"]+"' r"(\s+# managed by configure-minio\.sh)",
+        rf'\g<1>"{lifecycle}"\g<2>',
+        "filesharing STORAGE_LIFECYCLE",
+    )
+
 filesharing_path.write_text(filesharing_contents, encoding="utf-8")
 
 auth_user = os.environ.get("AUTH_USER")
@@ -237,3 +263,7 @@ if [[ -n "$AUTH_USER" ]]; then
 else
 	echo "Updated ${MANIFEST_FILE} (MinIO) and ${FILESHARING_MANIFEST_FILE} (filesharing env) with user '${MINIO_USER}', PVC '${MINIO_PVC_NAME}', and size ${MINIO_SIZE_GI}Gi."
 fi
+
+if [[ -n "$LIFECYCLE" ]]; then
+	echo "Bucket lifecycle expiry turned ${LIFECYCLE}; it takes effect when the filesharing service restarts."
+fi
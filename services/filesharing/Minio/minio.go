@@ -1,100 +1,263 @@
 package MinioImpl
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
 )
 
-func GetChuckLastIndex(ctx context.Context, minioClient *minio.Client, bucketName, objectName string) (int64, error) {
-	//get all object names starting with the objectName prefix
-	objectsCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix: objectName,
-	})
-	var lastIndex int64 = -1
-	for obj := range objectsCh {
-		if obj.Err != nil {
-			return -1, fmt.Errorf("error listing objects: %v", obj.Err)
-		}
-		// Check if the object name starts with the objectName prefix
-		if len(obj.Key) > len(objectName) && obj.Key[:len(objectName)] == objectName {
-			// Extract the index from the object name
-			var index int64
-			_, err := fmt.Sscanf(obj.Key[len(objectName):], "_chunk_%d", &index)
-			if err != nil {
-				log.Printf("⚠️  Warning: Failed to parse index from object name %s: %v", obj.Key, err)
-				continue
-			}
-			if index > lastIndex {
-				lastIndex = index
-			}
+// MinioStorage implements StorageImpl.Storage on top of one MinIO/S3 bucket.
+type MinioStorage struct {
+	client *minio.Client
+	core   minio.Core
+	bucket string
+}
+
+func NewMinioStorage(client *minio.Client, bucket string) *MinioStorage {
+	return &MinioStorage{
+		client: client,
+		core:   minio.Core{Client: client},
+		bucket: bucket,
+	}
+}
+
+func (m *MinioStorage) Client() *minio.Client {
+	return m.client
+}
+
+func (m *MinioStorage) Bucket() string {
+	return m.bucket
+}
+
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchUpload":
+		return StorageImpl.ErrNotFound
+	}
+	return err
+}
+
+const userMetaPrefix = "X-Amz-Meta-"
+
+func toObjectInfo(obj minio.ObjectInfo) StorageImpl.ObjectInfo {
+	metadata := map[string]string{}
+	for k, v := range obj.UserMetadata {
+		// Listings with metadata keep the header prefix, StatObject strips it.
+		if len(k) > len(userMetaPrefix) && strings.EqualFold(k[:len(userMetaPrefix)], userMetaPrefix) {
+			k = k[len(userMetaPrefix):]
 		}
+		metadata[http.CanonicalHeaderKey(k)] = v
 	}
-	if lastIndex == -1 {
-		return -1, nil // No chunks found, return -1 so first chunk will be index 0
+	return StorageImpl.ObjectInfo{
+		Key:          obj.Key,
+		Size:         obj.Size,
+		LastModified: obj.LastModified,
+		ETag:         obj.ETag,
+		ContentType:  obj.ContentType,
+		Metadata:     metadata,
 	}
-	return lastIndex, nil
 }
 
-func AddChunkToFile(ctx context.Context, minioClient *minio.Client, bucketName, objectName string, chunkData []byte) error {
-	last_index, err := GetChuckLastIndex(ctx, minioClient, bucketName, objectName)
+func (m *MinioStorage) Put(ctx context.Context, key string, r io.Reader, size int64, opts StorageImpl.PutOptions) (StorageImpl.ObjectInfo, error) {
+	info, err := m.client.PutObject(ctx, m.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+		UserTags:     opts.Tags,
+	})
 	if err != nil {
-		return fmt.Errorf("error getting last chunk index: %v", err)
+		return StorageImpl.ObjectInfo{}, fmt.Errorf("error uploading %s: %v", key, err)
 	}
+	return StorageImpl.ObjectInfo{
+		Key:          key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         info.ETag,
+		ContentType:  opts.ContentType,
+		Metadata:     opts.Metadata,
+	}, nil
+}
 
-	chunk_add := objectName + "_chunk_" + fmt.Sprintf("%d", last_index+1)
-	reader := bytes.NewReader(chunkData)
-	_, err = minioClient.PutObject(ctx, bucketName, chunk_add, reader, int64(len(chunkData)), minio.PutObjectOptions{})
+func (m *MinioStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	if offset > 0 || length >= 0 {
+		end := int64(0) // 0 means "until the end" for SetRange
+		if length >= 0 {
+			if length == 0 {
+				return io.NopCloser(&io.LimitedReader{}), nil
+			}
+			end = offset + length - 1
+		}
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+	// GetObject is lazy; the reader is returned by the core API so a missing
+	// key is reported here instead of on the first Read.
+	reader, _, _, err := m.core.GetObject(ctx, m.bucket, key, opts)
 	if err != nil {
-		return fmt.Errorf("error uploading temporary chunk: %v", err)
+		return nil, convertError(err)
 	}
-	return nil
+	return reader, nil
 }
 
-func ClearFile(ctx context.Context, minioClient *minio.Client, bucketName, objectName string) error {
-	objectsCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-		Prefix: objectName,
-	})
+func (m *MinioStorage) Stat(ctx context.Context, key string) (StorageImpl.ObjectInfo, error) {
+	info, err := m.client.StatObject(ctx, m.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return StorageImpl.ObjectInfo{}, convertError(err)
+	}
+	return toObjectInfo(info), nil
+}
+
+func (m *MinioStorage) List(ctx context.Context, prefix, startAfter string, limit int) ([]StorageImpl.ObjectInfo, error) {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
+	objectsCh := m.client.ListObjects(listCtx, m.bucket, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		StartAfter:   startAfter,
+		Recursive:    true,
+		WithMetadata: true, // MinIO extension, other S3 servers ignore it
+	})
+	var out []StorageImpl.ObjectInfo
 	for obj := range objectsCh {
-		minioClient.RemoveObject(ctx, bucketName, obj.Key, minio.RemoveObjectOptions{})
 		if obj.Err != nil {
-			return fmt.Errorf("error listing objects: %v", obj.Err)
+			return nil, fmt.Errorf("error listing objects: %v", obj.Err)
+		}
+		out = append(out, toObjectInfo(obj))
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *MinioStorage) Delete(ctx context.Context, key string) error {
+	return convertError(m.client.RemoveObject(ctx, m.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+func (m *MinioStorage) NewMultipartUpload(ctx context.Context, key string, opts StorageImpl.PutOptions) (string, error) {
+	return m.core.NewMultipartUpload(ctx, m.bucket, key, minio.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+		UserTags:     opts.Tags,
+	})
+}
+
+func (m *MinioStorage) PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (StorageImpl.Part, error) {
+	part, err := m.core.PutObjectPart(ctx, m.bucket, key, uploadID, number, r, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return StorageImpl.Part{}, convertError(err)
+	}
+	return StorageImpl.Part{Number: part.PartNumber, ETag: part.ETag, Size: part.Size}, nil
+}
+
+func (m *MinioStorage) ListParts(ctx context.Context, key, uploadID string) ([]StorageImpl.Part, error) {
+	var parts []StorageImpl.Part
+	marker := 0
+	for {
+		res, err := m.core.ListObjectParts(ctx, m.bucket, key, uploadID, marker, 1000)
+		if err != nil {
+			return nil, convertError(err)
 		}
+		for _, part := range res.ObjectParts {
+			parts = append(parts, StorageImpl.Part{Number: part.PartNumber, ETag: part.ETag, Size: part.Size})
+		}
+		if !res.IsTruncated {
+			return parts, nil
+		}
+		marker = res.NextPartNumberMarker
+	}
+}
+
+func (m *MinioStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []StorageImpl.Part) (StorageImpl.ObjectInfo, error) {
+	complete := make([]minio.CompletePart, len(parts))
+	for i, part := range parts {
+		complete[i] = minio.CompletePart{PartNumber: part.Number, ETag: part.ETag}
 	}
+	if _, err := m.core.CompleteMultipartUpload(ctx, m.bucket, key, uploadID, complete, minio.PutObjectOptions{}); err != nil {
+		return StorageImpl.ObjectInfo{}, convertError(err)
+	}
+	return m.Stat(ctx, key)
+}
+
+func (m *MinioStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	return convertError(m.core.AbortMultipartUpload(ctx, m.bucket, key, uploadID))
+}
+
+// EnsureBucket creates the bucket if it does not exist yet.
+func (m *MinioStorage) EnsureBucket(ctx context.Context) error {
+	exists, err := m.client.BucketExists(ctx, m.bucket)
+	if err != nil {
+		return fmt.Errorf("error checking bucket: %v", err)
+	}
+
+	if !exists {
+		err = m.client.MakeBucket(ctx, m.bucket, minio.MakeBucketOptions{})
+		if err != nil {
+			return fmt.Errorf("error creating bucket: %v", err)
+		}
+		log.Printf("✅ Bucket created: %s", m.bucket)
+	} else {
+		log.Printf("ℹ️  Bucket already exists: %s", m.bucket)
+	}
+
 	return nil
 }
 
-func GetStorageLimitsData(ctx context.Context, minioClient *minio.Client) (filesharing.GetStorageInfoResponse, error) {
-	//get Gigas ocupied in minio
-	buckets, err := minioClient.ListBuckets(ctx)
+// SetExpiryRules installs tag-filtered expiration rules on the bucket. The
+// lifecycle configuration is one document, so rules set by anyone else are
+// read back and kept.
+func (m *MinioStorage) SetExpiryRules(ctx context.Context, idPrefix string, rules []StorageImpl.ExpiryRule) error {
+	config, err := m.client.GetBucketLifecycle(ctx, m.bucket)
 	if err != nil {
-		return filesharing.GetStorageInfoResponse{}, fmt.Errorf("error listing buckets: %v", err)
-	}
-	var totalSize int64 = 0
-	for _, bucket := range buckets {
-		objectsCh := minioClient.ListObjects(ctx, bucket.Name, minio.ListObjectsOptions{})
-		for obj := range objectsCh {
-			if obj.Err != nil {
-				return filesharing.GetStorageInfoResponse{}, fmt.Errorf("error listing objects: %v", obj.Err)
-			}
-			totalSize += obj.Size
+		switch minio.ToErrorResponse(err).Code {
+		case "NoSuchLifecycleConfiguration":
+			config = lifecycle.NewConfiguration()
+		case "NotImplemented":
+			return StorageImpl.ErrLifecycleUnsupported
+		default:
+			return fmt.Errorf("error reading bucket lifecycle: %v", err)
 		}
 	}
-	// Convert total size to gigabytes
-	gigasOccupied := int(totalSize / (1024 * 1024 * 1024))
-	gigasLimit := 200 // Assuming a hardcoded limit of 200 GB
-	return filesharing.GetStorageInfoResponse{
-		TotalSize: int64(gigasLimit),
-		UsedSize:  int64(gigasOccupied),
-	}, nil
+
+	kept := config.Rules[:0]
+	for _, rule := range config.Rules {
+		if !strings.HasPrefix(rule.ID, idPrefix) {
+			kept = append(kept, rule)
+		}
+	}
+	config.Rules = kept
+	for _, rule := range rules {
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:         rule.ID,
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Tag: lifecycle.Tag{Key: rule.TagKey, Value: rule.TagValue}},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(rule.Days)},
+		})
+	}
+
+	// An empty configuration removes the lifecycle from the bucket.
+	err = m.client.SetBucketLifecycle(ctx, m.bucket, config)
+	if minio.ToErrorResponse(err).Code == "NotImplemented" {
+		return StorageImpl.ErrLifecycleUnsupported
+	}
+	if err != nil {
+		return fmt.Errorf("error setting bucket lifecycle: %v", err)
+	}
+	return nil
 }
 
 func getEnv(key, fallback string) string {
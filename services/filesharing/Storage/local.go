@@ -0,0 +1,367 @@
+package StorageImpl
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/google/uuid"
+)
+
+const (
+	localDataDir      = "data"
+	localMetaDir      = "meta"
+	localMultipartDir = "multipart"
+)
+
+type localMeta struct {
+	ETag        string            `json:"etag"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// LocalStorage stores objects as plain files below a root directory:
+// object bytes in data/, their metadata in meta/ and in-progress multipart
+// uploads in multipart/. Writes go through a temp file and a rename.
+type LocalStorage struct {
+	root string
+}
+
+func NewLocalStorage(root string) (*LocalStorage, error) {
+	for _, dir := range []string{localDataDir, localMetaDir, localMultipartDir} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating storage directory: %v", err)
+		}
+	}
+	return &LocalStorage{root: root}, nil
+}
+
+// path maps key below dir, rejecting keys that would escape it.
+func (l *LocalStorage) path(dir, key string) (string, error) {
+	if key == "" || strings.Contains(key, "\x00") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." || part == "." {
+			return "", fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return filepath.Join(l.root, dir, filepath.FromSlash(key)), nil
+}
+
+func writeFileAtomic(path string, r io.Reader) (int64, string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		tmp.Close()
+		return 0, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (l *LocalStorage) writeMeta(key string, meta localMeta) error {
+	path, err := l.path(localMetaDir, key)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, _, err = writeFileAtomic(path, bytes.NewReader(data))
+	return err
+}
+
+func (l *LocalStorage) readMeta(key string) localMeta {
+	var meta localMeta
+	path, err := l.path(localMetaDir, key)
+	if err != nil {
+		return meta
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+func (l *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error) {
+	path, err := l.path(localDataDir, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	n, etag, err := writeFileAtomic(path, r)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("error writing %s: %v", key, err)
+	}
+	if size >= 0 && n != size {
+		os.Remove(path)
+		return ObjectInfo{}, fmt.Errorf("short write: got %d bytes, expected %d", n, size)
+	}
+	meta := localMeta{ETag: etag, ContentType: opts.ContentType, Metadata: opts.Metadata}
+	if err := l.writeMeta(key, meta); err != nil {
+		return ObjectInfo{}, fmt.Errorf("error writing metadata for %s: %v", key, err)
+	}
+	return l.Stat(ctx, key)
+}
+
+type sectionReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func (l *LocalStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	path, err := l.path(localDataDir, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		// ENOTDIR: a key below another object, like "a/b" next to "a".
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if st, err := f.Stat(); err == nil && st.IsDir() {
+		f.Close()
+		return nil, ErrNotFound // only a prefix of other keys
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return sectionReadCloser{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+func (l *LocalStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := l.path(localDataDir, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	st, err := os.Stat(path)
+	if err != nil || st.IsDir() {
+		return ObjectInfo{}, ErrNotFound
+	}
+	meta := l.readMeta(key)
+	return ObjectInfo{
+		Key:          key,
+		Size:         st.Size(),
+		LastModified: st.ModTime(),
+		ETag:         meta.ETag,
+		ContentType:  meta.ContentType,
+		Metadata:     meta.Metadata,
+	}, nil
+}
+
+func (l *LocalStorage) List(ctx context.Context, prefix, startAfter string, limit int) ([]ObjectInfo, error) {
+	dataRoot := filepath.Join(l.root, localDataDir)
+	var keys []string
+	err := filepath.WalkDir(dataRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(dataRoot, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s: %v", dataRoot, err)
+	}
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	out := make([]ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		info, err := l.Stat(ctx, key)
+		if err != nil {
+			continue // removed while listing
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+func (l *LocalStorage) Delete(ctx context.Context, key string) error {
+	for _, dir := range []string{localDataDir, localMetaDir} {
+		path, err := l.path(dir, key)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Drop directories the key leaves empty; Remove fails on the first
+		// non-empty one, which ends the walk.
+		top := filepath.Join(l.root, dir)
+		for parent := filepath.Dir(path); parent != top && strings.HasPrefix(parent, top); parent = filepath.Dir(parent) {
+			if os.Remove(parent) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (l *LocalStorage) uploadDir(uploadID string) (string, error) {
+	if _, err := uuid.Parse(uploadID); err != nil {
+		return "", ErrNotFound
+	}
+	return filepath.Join(l.root, localMultipartDir, uploadID), nil
+}
+
+func (l *LocalStorage) NewMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error) {
+	if _, err := l.path(localDataDir, key); err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	dir, _ := l.uploadDir(id)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(struct {
+		Key  string
+		Opts PutOptions
+	}{key, opts})
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), data, 0o644); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (l *LocalStorage) PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return Part{}, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return Part{}, ErrNotFound
+	}
+	n, etag, err := writeFileAtomic(filepath.Join(dir, strconv.Itoa(number)), r)
+	if err != nil {
+		return Part{}, err
+	}
+	// Keep the ETag next to the part so ListParts doesn't have to rehash it.
+	if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(number)+".etag"), []byte(etag), 0o644); err != nil {
+		return Part{}, err
+	}
+	return Part{Number: number, ETag: etag, Size: n}, nil
+}
+
+func (l *LocalStorage) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var parts []Part
+	for _, entry := range entries {
+		number, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue // upload.json, .etag files, temp files
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		etag, _ := os.ReadFile(filepath.Join(dir, entry.Name()+".etag"))
+		parts = append(parts, Part{Number: number, ETag: string(etag), Size: info.Size()})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+func (l *LocalStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) (ObjectInfo, error) {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	var upload struct {
+		Key  string
+		Opts PutOptions
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err := json.Unmarshal(data, &upload); err != nil || upload.Key != key {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, part := range parts {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(part.Number)))
+		if err != nil {
+			return ObjectInfo{}, fmt.Errorf("part %d was never uploaded", part.Number)
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+	}
+
+	info, err := l.Put(ctx, key, io.MultiReader(readers...), -1, upload.Opts)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	os.RemoveAll(dir)
+	return info, nil
+}
+
+func (l *LocalStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	dir, err := l.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
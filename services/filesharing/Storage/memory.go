@@ -0,0 +1,214 @@
+package StorageImpl
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type memoryObject struct {
+	data []byte
+	info ObjectInfo
+}
+
+type memoryUpload struct {
+	key   string
+	opts  PutOptions
+	parts map[int][]byte
+}
+
+func (u *memoryUpload) part(number int) Part {
+	sum := md5.Sum(u.parts[number])
+	return Part{Number: number, ETag: hex.EncodeToString(sum[:]), Size: int64(len(u.parts[number]))}
+}
+
+// MemoryStorage keeps every object in process memory. Data is lost on
+// restart; it exists for single-node experiments and handler tests.
+type MemoryStorage struct {
+	mu      sync.RWMutex
+	objects map[string]*memoryObject
+	uploads map[string]*memoryUpload
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		objects: map[string]*memoryObject{},
+		uploads: map[string]*memoryUpload{},
+	}
+}
+
+func copyMetadata(md map[string]string) map[string]string {
+	if md == nil {
+		return nil
+	}
+	out := make(map[string]string, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+func (m *MemoryStorage) store(key string, data []byte, opts PutOptions) ObjectInfo {
+	sum := md5.Sum(data)
+	info := ObjectInfo{
+		Key:          key,
+		Size:         int64(len(data)),
+		LastModified: time.Now(),
+		ETag:         hex.EncodeToString(sum[:]),
+		ContentType:  opts.ContentType,
+		Metadata:     copyMetadata(opts.Metadata),
+	}
+	m.objects[key] = &memoryObject{data: data, info: info}
+	return info
+}
+
+func (m *MemoryStorage) Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if size >= 0 && int64(len(data)) != size {
+		return ObjectInfo{}, fmt.Errorf("short write: got %d bytes, expected %d", len(data), size)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store(key, data, opts), nil
+}
+
+func (m *MemoryStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	data := obj.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *MemoryStorage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	info := obj.info
+	info.Metadata = copyMetadata(info.Metadata)
+	return info, nil
+}
+
+func (m *MemoryStorage) List(ctx context.Context, prefix, startAfter string, limit int) ([]ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	out := make([]ObjectInfo, 0, len(keys))
+	for _, key := range keys {
+		info := m.objects[key].info
+		info.Metadata = copyMetadata(info.Metadata)
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *MemoryStorage) NewMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := uuid.NewString()
+	m.uploads[id] = &memoryUpload{key: key, opts: opts, parts: map[int][]byte{}}
+	return id, nil
+}
+
+func (m *MemoryStorage) PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return Part{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return Part{}, ErrNotFound
+	}
+	upload.parts[number] = data
+	return upload.part(number), nil
+}
+
+func (m *MemoryStorage) ListParts(ctx context.Context, key, uploadID string) ([]Part, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return nil, ErrNotFound
+	}
+	parts := make([]Part, 0, len(upload.parts))
+	for number := range upload.parts {
+		parts = append(parts, upload.part(number))
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+	return parts, nil
+}
+
+func (m *MemoryStorage) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) (ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[uploadID]
+	if !ok || upload.key != key {
+		return ObjectInfo{}, ErrNotFound
+	}
+
+	var buf bytes.Buffer
+	for _, part := range parts {
+		data, ok := upload.parts[part.Number]
+		if !ok {
+			return ObjectInfo{}, fmt.Errorf("part %d was never uploaded", part.Number)
+		}
+		buf.Write(data)
+	}
+	delete(m.uploads, uploadID)
+	return m.store(key, buf.Bytes(), upload.opts), nil
+}
+
+func (m *MemoryStorage) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, uploadID)
+	return nil
+}
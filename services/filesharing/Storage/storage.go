@@ -0,0 +1,96 @@
+package StorageImpl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when a key (or multipart upload) does not exist.
+var ErrNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	ContentType  string
+	Metadata     map[string]string
+}
+
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string
+	// Tags are object tags for lifecycle rules to match on. Backends
+	// without lifecycle support ignore them.
+	Tags map[string]string
+}
+
+type Part struct {
+	Number int
+	ETag   string
+	Size   int64
+}
+
+// Storage is the object store the filesharing service keeps its data in.
+// Keys are flat strings; "/" has no special meaning to implementations.
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, opts PutOptions) (ObjectInfo, error)
+	// Get returns length bytes starting at offset. A negative length reads
+	// until the end of the object.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns up to limit objects whose key starts with prefix and sorts
+	// after startAfter, in key order. A limit <= 0 lists everything.
+	List(ctx context.Context, prefix, startAfter string, limit int) ([]ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+
+	// NewMultipartUpload starts assembling key from parts; nothing is
+	// visible under key until CompleteMultipartUpload. The other methods
+	// return ErrNotFound for upload IDs that are unknown or already ended.
+	NewMultipartUpload(ctx context.Context, key string, opts PutOptions) (string, error)
+	// PutPart stores part number of an upload, replacing any earlier one.
+	PutPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error)
+	// ListParts returns the parts uploaded so far, ordered by part number.
+	ListParts(ctx context.Context, key, uploadID string) ([]Part, error)
+	// CompleteMultipartUpload writes key as the concatenation of parts, in
+	// the order given, and ends the upload.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) (ObjectInfo, error)
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+}
+
+// ErrLifecycleUnsupported is returned by SetExpiryRules when the server
+// does not implement bucket lifecycle rules.
+var ErrLifecycleUnsupported = errors.New("lifecycle rules not supported")
+
+// ExpiryRule makes the store delete objects tagged TagKey=TagValue Days
+// after they were written.
+type ExpiryRule struct {
+	ID       string
+	TagKey   string
+	TagValue string
+	Days     int
+}
+
+// Lifecycle is implemented by backends that can expire objects by
+// themselves.
+type Lifecycle interface {
+	// SetExpiryRules replaces the bucket rules whose ID starts with
+	// idPrefix with rules, leaving any other rule alone.
+	SetExpiryRules(ctx context.Context, idPrefix string, rules []ExpiryRule) error
+}
+
+// ReadAll reads a whole object into memory. Only meant for small objects.
+func ReadAll(ctx context.Context, s Storage, key string) ([]byte, error) {
+	r, err := s.Get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
@@ -0,0 +1,258 @@
+package StorageImpl
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, NewMemoryStorage())
+}
+
+func TestLocalStorage(t *testing.T) {
+	s, err := NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	for _, key := range []string{"../escape", "a/./b", "a/../../b", ""} {
+		if _, err := s.Put(context.Background(), key, strings.NewReader("x"), 1, PutOptions{}); err == nil {
+			t.Errorf("Put(%q): no error", key)
+		}
+	}
+}
+
+func put(t *testing.T, s Storage, key, data string, opts PutOptions) ObjectInfo {
+	t.Helper()
+	info, err := s.Put(context.Background(), key, strings.NewReader(data), int64(len(data)), opts)
+	if err != nil {
+		t.Fatalf("Put(%s): %v", key, err)
+	}
+	return info
+}
+
+func listKeys(t *testing.T, s Storage, prefix, startAfter string, limit int) string {
+	t.Helper()
+	infos, err := s.List(context.Background(), prefix, startAfter, limit)
+	if err != nil {
+		t.Fatalf("List(%q, %q, %d): %v", prefix, startAfter, limit, err)
+	}
+	keys := make([]string, len(infos))
+	for i, info := range infos {
+		keys[i] = info.Key
+	}
+	return strings.Join(keys, ",")
+}
+
+// testStorage checks the behaviour every Storage has to share.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+
+	t.Run("put and stat", func(t *testing.T) {
+		sum := md5.Sum([]byte("hello world"))
+		opts := PutOptions{ContentType: "text/plain", Metadata: map[string]string{"owner": "alice"}}
+		info := put(t, s, "stat/hello.txt", "hello world", opts)
+		if info.Key != "stat/hello.txt" || info.Size != 11 || info.ETag != hex.EncodeToString(sum[:]) {
+			t.Errorf("Put returned %+v", info)
+		}
+
+		// The caller's map is not kept.
+		opts.Metadata["owner"] = "mallory"
+		st, err := s.Stat(ctx, "stat/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Size != 11 || st.ETag != info.ETag || st.ContentType != "text/plain" || st.Metadata["owner"] != "alice" || st.LastModified.IsZero() {
+			t.Errorf("Stat returned %+v", st)
+		}
+
+		put(t, s, "stat/hello.txt", "bye", PutOptions{})
+		if st, err := s.Stat(ctx, "stat/hello.txt"); err != nil || st.Size != 3 || st.ContentType != "" || len(st.Metadata) != 0 {
+			t.Errorf("Stat after overwrite: %+v, %v", st, err)
+		}
+	})
+
+	t.Run("short write", func(t *testing.T) {
+		if _, err := s.Put(ctx, "short/a", strings.NewReader("abc"), 10, PutOptions{}); err == nil {
+			t.Error("Put with fewer bytes than the size: no error")
+		}
+		if _, err := s.Stat(ctx, "short/a"); !IsNotFound(err) {
+			t.Errorf("Stat after a short write: got %v, want not found", err)
+		}
+		// A negative size takes whatever the reader has.
+		if info, err := s.Put(ctx, "short/b", strings.NewReader("abc"), -1, PutOptions{}); err != nil || info.Size != 3 {
+			t.Errorf("Put with unknown size: %+v, %v", info, err)
+		}
+	})
+
+	t.Run("get ranges", func(t *testing.T) {
+		put(t, s, "get/digits", "0123456789", PutOptions{})
+		tests := []struct {
+			offset, length int64
+			want           string
+		}{
+			{0, -1, "0123456789"},
+			{0, 4, "0123"},
+			{3, 4, "3456"},
+			{7, -1, "789"},
+			{7, 100, "789"},
+			{10, -1, ""},
+			{4, 0, ""},
+		}
+		for _, tt := range tests {
+			r, err := s.Get(ctx, "get/digits", tt.offset, tt.length)
+			if err != nil {
+				t.Errorf("Get(%d, %d): %v", tt.offset, tt.length, err)
+				continue
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || string(data) != tt.want {
+				t.Errorf("Get(%d, %d) = %q, %v; want %q", tt.offset, tt.length, data, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		put(t, s, "nf/dir/file", "x", PutOptions{})
+		// "nf/dir" is only a prefix of another key.
+		for _, key := range []string{"nf/missing", "nf/dir", "nf/dir/file/below"} {
+			if _, err := s.Stat(ctx, key); !IsNotFound(err) {
+				t.Errorf("Stat(%s): got %v, want not found", key, err)
+			}
+			if _, err := s.Get(ctx, key, 0, -1); !IsNotFound(err) {
+				t.Errorf("Get(%s): got %v, want not found", key, err)
+			}
+		}
+		if err := s.Delete(ctx, "nf/missing"); err != nil {
+			t.Errorf("Delete of a missing key: %v", err)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		for _, key := range []string{"list/b", "list/a/2", "list/a/1", "list/c", "listing", "other/a"} {
+			put(t, s, key, key, PutOptions{})
+		}
+		tests := []struct {
+			prefix, startAfter string
+			limit              int
+			want               string
+		}{
+			{"list/", "", 0, "list/a/1,list/a/2,list/b,list/c"},
+			{"list", "", 0, "list/a/1,list/a/2,list/b,list/c,listing"},
+			{"list/a/", "", 0, "list/a/1,list/a/2"},
+			{"list/", "", 2, "list/a/1,list/a/2"},
+			{"list/", "list/a/2", 2, "list/b,list/c"},
+			{"list/", "list/a", 0, "list/a/1,list/a/2,list/b,list/c"},
+			{"list/", "list/c", 0, ""},
+			{"nothing/", "", 0, ""},
+		}
+		for _, tt := range tests {
+			if got := listKeys(t, s, tt.prefix, tt.startAfter, tt.limit); got != tt.want {
+				t.Errorf("List(%q, %q, %d) = %s; want %s", tt.prefix, tt.startAfter, tt.limit, got, tt.want)
+			}
+		}
+
+		infos, err := s.List(ctx, "list/b", "", 0)
+		if err != nil || len(infos) != 1 || infos[0].Size != int64(len("list/b")) || infos[0].ETag == "" {
+			t.Errorf("List of list/b: %+v, %v", infos, err)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		id, err := s.NewMultipartUpload(ctx, "mp/file", PutOptions{ContentType: "text/plain", Metadata: map[string]string{"owner": "alice"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		putPart := func(number int, data string) Part {
+			t.Helper()
+			part, err := s.PutPart(ctx, "mp/file", id, number, strings.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("PutPart(%d): %v", number, err)
+			}
+			return part
+		}
+		putPart(2, "second ")
+		putPart(1, "stale")
+		first := putPart(1, "first ") // replaces the stale part
+		putPart(3, "third")
+		if _, err := s.Stat(ctx, "mp/file"); !IsNotFound(err) {
+			t.Errorf("Stat before completing: got %v, want not found", err)
+		}
+
+		parts, err := s.ListParts(ctx, "mp/file", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parts) != 3 || parts[0] != first || parts[1].Number != 2 || parts[2].Number != 3 || parts[1].Size != 7 || parts[1].ETag == "" {
+			t.Fatalf("ListParts = %+v", parts)
+		}
+		info, err := s.CompleteMultipartUpload(ctx, "mp/file", id, parts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != int64(len("first second third")) {
+			t.Errorf("completed object %+v", info)
+		}
+		data, err := ReadAll(ctx, s, "mp/file")
+		if err != nil || string(data) != "first second third" {
+			t.Errorf("completed object holds %q, %v", data, err)
+		}
+		if st, err := s.Stat(ctx, "mp/file"); err != nil || st.ContentType != "text/plain" || st.Metadata["owner"] != "alice" {
+			t.Errorf("Stat of the completed object: %+v, %v", st, err)
+		}
+		if _, err := s.ListParts(ctx, "mp/file", id); !IsNotFound(err) {
+			t.Errorf("ListParts of a completed upload: got %v, want not found", err)
+		}
+
+		aborted, err := s.NewMultipartUpload(ctx, "mp/aborted", PutOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.PutPart(ctx, "mp/aborted", aborted, 1, strings.NewReader("x"), 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AbortMultipartUpload(ctx, "mp/aborted", aborted); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.PutPart(ctx, "mp/aborted", aborted, 2, strings.NewReader("y"), 1); !IsNotFound(err) {
+			t.Errorf("PutPart after abort: got %v, want not found", err)
+		}
+		if _, err := s.Stat(ctx, "mp/aborted"); !IsNotFound(err) {
+			t.Errorf("Stat of an aborted upload: got %v, want not found", err)
+		}
+		if got := listKeys(t, s, "mp/", "", 0); got != "mp/file" {
+			t.Errorf("List after multipart uploads = %s", got)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		put(t, s, "del/a/b", "x", PutOptions{})
+		put(t, s, "del/a/c", "y", PutOptions{})
+		if err := s.Delete(ctx, "del/a/b"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Stat(ctx, "del/a/b"); !IsNotFound(err) {
+			t.Errorf("Stat of a deleted key: got %v, want not found", err)
+		}
+		if got := listKeys(t, s, "del/", "", 0); got != "del/a/c" {
+			t.Errorf("List after delete = %s", got)
+		}
+		if err := s.Delete(ctx, "del/a/c"); err != nil {
+			t.Fatal(err)
+		}
+		if got := listKeys(t, s, "del/", "", 0); got != "" {
+			t.Errorf("List after deleting everything = %s", got)
+		}
+		// The key can be written again, also as the prefix it used to be.
+		put(t, s, "del/a", "z", PutOptions{})
+		if data, err := ReadAll(ctx, s, "del/a"); err != nil || string(data) != "z" {
+			t.Errorf("ReadAll(del/a) = %q, %v", data, err)
+		}
+	})
+}
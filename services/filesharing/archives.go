@@ -0,0 +1,395 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Archives are looked into where they are stored, never extracted. A ZIP
+// is read through its central directory, so listing it or reading one
+// member only fetches those bytes of the chunks; a plain tar is walked
+// header by header, seeking over the members in between. A tar.gz has no
+// index: it is decompressed from the start, streamed and thrown away up to
+// the member wanted.
+
+const (
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+
+	// maxArchiveEntries caps how many members ListArchiveEntries returns.
+	maxArchiveEntries = 20000
+	// archiveBlockSize is how much of a plain chunk fileReaderAt fetches at
+	// a time.
+	archiveBlockSize = 256 * 1024
+	// archiveReadBuffer is the read buffer in front of a tar.gz.
+	archiveReadBuffer = 1 << 20
+)
+
+var (
+	errNotArchive   = errors.New("not a ZIP or tar archive")
+	errEntryMissing = errors.New("no such entry")
+)
+
+// fileReaderAt is an io.ReaderAt over a stored file. Compressed and
+// encrypted chunks can only be decoded whole, so it keeps the last two
+// blocks it read: reading a ZIP goes back and forth between the central
+// directory and the member, in small reads.
+type fileReaderAt struct {
+	ctx    context.Context
+	store  StorageImpl.Storage
+	file   *storedFile
+	starts []int64 // where each segment starts in the file
+	blocks [2]fileBlock
+}
+
+type fileBlock struct {
+	start int64
+	data  []byte
+}
+
+func newFileReaderAt(ctx context.Context, store StorageImpl.Storage, file *storedFile) *fileReaderAt {
+	starts := make([]int64, len(file.Segments))
+	var offset int64
+	for i, seg := range file.Segments {
+		starts[i] = offset
+		offset += seg.Size
+	}
+	return &fileReaderAt{ctx: ctx, store: store, file: file, starts: starts}
+}
+
+func (r *fileReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= r.file.Size {
+			return n, io.EOF
+		}
+		block, err := r.block(pos)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block.data[pos-block.start:])
+	}
+	return n, nil
+}
+
+// block returns a cached or freshly read block holding the byte at off.
+func (r *fileReaderAt) block(off int64) (fileBlock, error) {
+	for i, b := range r.blocks {
+		if off >= b.start && off < b.start+int64(len(b.data)) {
+			r.blocks[0], r.blocks[i] = b, r.blocks[0]
+			return b, nil
+		}
+	}
+
+	i := sort.Search(len(r.starts), func(i int) bool { return r.starts[i] > off }) - 1
+	seg := r.file.Segments[i]
+	start, length := off, min(archiveBlockSize, r.starts[i]+seg.Size-off)
+	if seg.Codec != codecNone || seg.aead != nil {
+		start, length = r.starts[i], seg.Size
+	}
+	reader := r.file.NewReader(r.ctx, r.store, start, length)
+	defer reader.Close()
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return fileBlock{}, fmt.Errorf("error reading %s at %d: %v", r.file.Name, start, err)
+	}
+	r.blocks[1], r.blocks[0] = r.blocks[0], fileBlock{start: start, data: data}
+	return r.blocks[0], nil
+}
+
+// archiveFormat tells the kind of archive from its first bytes.
+func archiveFormat(r io.ReaderAt, size int64) (string, error) {
+	head := make([]byte, min(size, 512))
+	if _, err := r.ReadAt(head, 0); err != nil && err != io.EOF {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return archiveZip, nil
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return archiveTarGz, nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return archiveTar, nil
+	}
+	return "", errNotArchive
+}
+
+// archiveMember is one file inside an archive, ready to be read.
+type archiveMember struct {
+	Size int64
+	open func(offset, length int64) (io.ReadCloser, error)
+	done func()
+}
+
+// Open reads length bytes of the member from offset.
+func (m *archiveMember) Open(offset, length int64) (io.ReadCloser, error) {
+	return m.open(offset, length)
+}
+
+// Close releases what finding the member held open.
+func (m *archiveMember) Close() {
+	if m.done != nil {
+		m.done()
+	}
+}
+
+// readCloser pairs a reader with what closes it.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error { return r.close() }
+
+func nopClose() error { return nil }
+
+// skipTo discards offset bytes of r and limits it to length more.
+func skipTo(r io.Reader, offset, length int64) (io.Reader, error) {
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil {
+		return nil, fmt.Errorf("error skipping to %d: %v", offset, err)
+	}
+	return io.LimitReader(r, length), nil
+}
+
+// tarEntries opens the tar stream of an archive: seekable for a tar,
+// decompressed from the start for a tar.gz. done releases it.
+func tarEntries(ctx context.Context, store StorageImpl.Storage, file *storedFile, ra io.ReaderAt, format string) (tr *tar.Reader, done func(), err error) {
+	if format == archiveTar {
+		return tar.NewReader(io.NewSectionReader(ra, 0, file.Size)), func() {}, nil
+	}
+	reader := file.NewReader(ctx, store, 0, file.Size)
+	gz, err := gzip.NewReader(bufio.NewReaderSize(reader, archiveReadBuffer))
+	if err != nil {
+		reader.Close()
+		return nil, nil, fmt.Errorf("%w: %v", errNotArchive, err)
+	}
+	return tar.NewReader(gz), func() { reader.Close() }, nil
+}
+
+// listArchive lists the members of file, at most maxArchiveEntries of
+// them.
+func listArchive(ctx context.Context, store StorageImpl.Storage, file *storedFile) (format string, entries []*filesharing.ArchiveEntry, truncated bool, err error) {
+	ra := newFileReaderAt(ctx, store, file)
+	format, err = archiveFormat(ra, file.Size)
+	if err != nil {
+		return "", nil, false, err
+	}
+
+	if format == archiveZip {
+		zr, err := zip.NewReader(ra, file.Size)
+		if err != nil {
+			return "", nil, false, fmt.Errorf("%w: %v", errNotArchive, err)
+		}
+		for _, f := range zr.File {
+			if len(entries) == maxArchiveEntries {
+				return format, entries, true, nil
+			}
+			entries = append(entries, &filesharing.ArchiveEntry{
+				Path:           f.Name,
+				Size:           int64(f.UncompressedSize64),
+				CompressedSize: int64(f.CompressedSize64),
+				Modified:       unixOrZero(f.Modified),
+				Dir:            f.FileInfo().IsDir(),
+			})
+		}
+		return format, entries, false, nil
+	}
+
+	tr, done, err := tarEntries(ctx, store, file, ra, format)
+	if err != nil {
+		return "", nil, false, err
+	}
+	defer done()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return format, entries, false, nil
+		}
+		if err != nil {
+			if len(entries) == 0 {
+				return "", nil, false, fmt.Errorf("%w: %v", errNotArchive, err)
+			}
+			return "", nil, false, fmt.Errorf("error reading archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		if len(entries) == maxArchiveEntries {
+			return format, entries, true, nil
+		}
+		entries = append(entries, &filesharing.ArchiveEntry{
+			Path:     hdr.Name,
+			Size:     hdr.Size,
+			Modified: unixOrZero(hdr.ModTime),
+			Dir:      hdr.Typeflag == tar.TypeDir,
+		})
+	}
+}
+
+// openArchiveMember finds the member called name in file. Folders are not
+// members that can be read.
+func openArchiveMember(ctx context.Context, store StorageImpl.Storage, file *storedFile, name string) (*archiveMember, error) {
+	ra := newFileReaderAt(ctx, store, file)
+	format, err := archiveFormat(ra, file.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == archiveZip {
+		zr, err := zip.NewReader(ra, file.Size)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errNotArchive, err)
+		}
+		for _, f := range zr.File {
+			if f.Name != name || f.FileInfo().IsDir() {
+				continue
+			}
+			return &archiveMember{Size: int64(f.UncompressedSize64), open: func(offset, length int64) (io.ReadCloser, error) {
+				if f.Method == zip.Store {
+					start, err := f.DataOffset()
+					if err != nil {
+						return nil, fmt.Errorf("error locating %s: %v", name, err)
+					}
+					return readCloser{io.NewSectionReader(ra, start+offset, length), nopClose}, nil
+				}
+				rc, err := f.Open()
+				if err != nil {
+					return nil, fmt.Errorf("error opening %s: %v", name, err)
+				}
+				r, err := skipTo(rc, offset, length)
+				if err != nil {
+					rc.Close()
+					return nil, err
+				}
+				return readCloser{r, rc.Close}, nil
+			}}, nil
+		}
+		return nil, errEntryMissing
+	}
+
+	tr, done, err := tarEntries(ctx, store, file, ra, format)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			done()
+			return nil, errEntryMissing
+		}
+		if err != nil {
+			done()
+			return nil, fmt.Errorf("%w: %v", errNotArchive, err)
+		}
+		if hdr.Name != name || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		return &archiveMember{Size: hdr.Size, done: done, open: func(offset, length int64) (io.ReadCloser, error) {
+			r, err := skipTo(tr, offset, length)
+			if err != nil {
+				return nil, err
+			}
+			return readCloser{r, nopClose}, nil
+		}}, nil
+	}
+}
+
+// archiveError turns the errors of reading an archive into gRPC statuses.
+func archiveError(err error, fileName, entry string) error {
+	switch {
+	case errors.Is(err, errNotArchive):
+		return status.Errorf(codes.InvalidArgument, "%s is %v", fileName, err)
+	case err == errEntryMissing:
+		return status.Errorf(codes.NotFound, "%s has no entry %s", fileName, entry)
+	}
+	return fmt.Errorf("error reading archive %s: %v", fileName, err)
+}
+
+// openArchive opens the file or version an archive request is about.
+func (f *FilesharingService) openArchive(ctx context.Context, fileName string, version int32) (*storedFile, error) {
+	if version < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid version %d", version)
+	}
+	var file *storedFile
+	var err error
+	if version > 0 {
+		file, err = openVersion(ctx, f.store, fileName, int(version))
+	} else {
+		file, err = openFile(ctx, f.store, fileName)
+	}
+	if StorageImpl.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "file %s does not exist", fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	if len(file.EncryptedMetadata) > 0 {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is end-to-end encrypted", fileName)
+	}
+	return file, nil
+}
+
+func (f *FilesharingService) ListArchiveEntries(ctx context.Context, req *filesharing.ListArchiveEntriesRequest) (*filesharing.ListArchiveEntriesResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	file, err := f.openArchive(ctx, req.FileName, req.Version)
+	if err != nil {
+		return nil, err
+	}
+	format, entries, truncated, err := listArchive(ctx, f.store, file)
+	if err != nil {
+		return nil, archiveError(err, req.FileName, "")
+	}
+	return &filesharing.ListArchiveEntriesResponse{Format: format, Entries: entries, Truncated: truncated}, nil
+}
+
+// downloadEntry is DownloadFile for one member of an archive.
+func (f *FilesharingService) downloadEntry(req *filesharing.DownloadFileRequest, stream filesharing.FileUpload_DownloadFileServer) error {
+	ctx := stream.Context()
+	file, err := f.openArchive(ctx, req.FileName, req.Version)
+	if err != nil {
+		return err
+	}
+	member, err := openArchiveMember(ctx, f.store, file, req.Entry)
+	if err != nil {
+		return archiveError(err, req.FileName, req.Entry)
+	}
+	defer member.Close()
+
+	name := req.FileName + "!/" + req.Entry
+	offset, length, err := readRange(name, member.Size, req.Offset, req.Length)
+	if err != nil {
+		return err
+	}
+	reader, err := member.Open(offset, length)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	defer reader.Close()
+
+	first := &filesharing.DownloadFileResponse{
+		Size:        member.Size,
+		Offset:      offset,
+		Length:      length,
+		ExpiresAt:   unixOrZero(file.Expires),
+		ContentType: cmp.Or(contentTypeByName(req.Entry), defaultContentType),
+	}
+	return sendStream(stream, name, first, reader)
+}
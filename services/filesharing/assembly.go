@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// Upload sessions assemble their file into one object with the backend's
+// own multipart upload: each piece of at least minPartSize (as stored,
+// after compression and encryption) becomes a backend part, and completing
+// the session completes the backend upload into chunks/<uploadID>. The
+// manifest then points every piece at its range of that object, so a plain
+// file is read back with a single ranged Get.
+//
+// Smaller pieces can't be backend parts, since S3 rejects uploads with
+// parts under 5 MiB before the last one; they are stored as chunks, and a
+// small last piece is moved into the object when the session completes.
+// Pieces in the object aren't deduplicated against chunks; an upload with
+// the same content as an object a file already holds reuses that object
+// instead (see reuseAssembly).
+const (
+	minPartSize = 5 * 1024 * 1024
+	// maxAssemblyParts is the highest part number S3 takes. Pieces past it,
+	// like those of multipart sessions, are stored as chunks.
+	maxAssemblyParts = 10000
+
+	assembliesPrefix = "assemblies/"
+)
+
+func assemblyKey(session *uploadSession) string {
+	return chunkObjectKey(session.UploadID)
+}
+
+// startAssembly opens the backend upload for the session uploadID.
+func startAssembly(ctx context.Context, store StorageImpl.Storage, uploadID string) (string, error) {
+	return store.NewMultipartUpload(ctx, chunkObjectKey(uploadID), StorageImpl.PutOptions{
+		ContentType: "application/octet-stream",
+		Metadata:    map[string]string{chunkAssembledKey: "true"},
+	})
+}
+
+// putPiece stores data as part number of session, in the session's
+// assembly when it is big enough to be a backend part and as a chunk held
+// by the part otherwise. Records of pieces in the assembly have Part set.
+func putPiece(ctx context.Context, store StorageImpl.Storage, session *uploadSession, number int, data []byte, seal *chunkSeal) (manifestChunk, bool, error) {
+	holder := partHolder(session.UploadID, number)
+	if session.Assembly == "" || number > maxAssemblyParts || len(data) < minPartSize {
+		return putChunk(ctx, store, data, holder, session.Codec, seal)
+	}
+	stored, codec, err := encodePiece(data, session.Codec, seal)
+	if err != nil {
+		return manifestChunk{}, false, err
+	}
+	if len(stored) < minPartSize {
+		return putEncodedChunk(ctx, store, data, stored, codec, holder, seal != nil)
+	}
+	part, err := store.PutPart(ctx, assemblyKey(session), session.Assembly, number, bytes.NewReader(stored), int64(len(stored)))
+	if err != nil {
+		return manifestChunk{}, false, fmt.Errorf("error storing part: %v", err)
+	}
+	hash := hashChunk(data)
+	if seal != nil {
+		hash = hashChunk(stored)
+	}
+	return manifestChunk{Hash: hash, Size: int64(len(data)), StoredSize: int64(len(stored)), Codec: codec, Part: part.ETag}, false, nil
+}
+
+func abortAssembly(ctx context.Context, store StorageImpl.Storage, session *uploadSession) error {
+	if session.Assembly == "" {
+		return nil
+	}
+	err := store.AbortMultipartUpload(ctx, assemblyKey(session), session.Assembly)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		return fmt.Errorf("error aborting assembly: %v", err)
+	}
+	return nil
+}
+
+// assemble completes the assembly of session, whose pieces are chunks with
+// chunk i being part i+1, and returns the chunks pointing into the object.
+// The file target takes its reference to a reused object here.
+func assemble(ctx context.Context, store StorageImpl.Storage, session *uploadSession, chunks []manifestChunk, target string) ([]manifestChunk, error) {
+	if session.Assembly == "" {
+		return chunks, nil
+	}
+	last := len(chunks) - 1
+	if last > 0 && chunks[last].Part == "" && chunks[last-1].Part != "" && last+1 <= maxAssemblyParts {
+		// The last part may be small. The chunk's reference goes with the
+		// part records once the upload is committed.
+		tail := &chunks[last]
+		stored, err := StorageImpl.ReadAll(ctx, store, chunkObjectKey(tail.Hash))
+		if err != nil {
+			return nil, fmt.Errorf("error reading last part: %v", err)
+		}
+		part, err := store.PutPart(ctx, assemblyKey(session), session.Assembly, last+1, bytes.NewReader(stored), int64(len(stored)))
+		if err != nil {
+			return nil, fmt.Errorf("error storing last part: %v", err)
+		}
+		tail.Part, tail.StoredSize = part.ETag, int64(len(stored))
+	}
+
+	var parts []StorageImpl.Part
+	var size int64
+	for i, chunk := range chunks {
+		if chunk.Part != "" {
+			parts = append(parts, StorageImpl.Part{Number: i + 1, ETag: chunk.Part, Size: chunk.StoredSize})
+			size += chunk.StoredSize
+		}
+	}
+	if len(parts) == 0 {
+		return chunks, abortAssembly(ctx, store, session)
+	}
+
+	aead, err := session.Encryption.AEAD()
+	if err != nil {
+		return nil, err
+	}
+	// Encrypted pieces differ for every upload, so only plain files can
+	// share an object.
+	content := ""
+	if aead == nil && len(parts) == len(chunks) {
+		content = contentID(chunks)
+		if reused, ok := reuseAssembly(ctx, store, content, target); ok {
+			if err := abortAssembly(ctx, store, session); err != nil {
+				log.Printf("⚠️  Warning: could not abort assembly of %s: %v", session.FileName, err)
+			}
+			log.Printf("Upload of %s reused object %s", session.FileName, reused[0].Object)
+			return reused, nil
+		}
+	}
+
+	key := assemblyKey(session)
+	if _, err := store.CompleteMultipartUpload(ctx, key, session.Assembly, parts); err != nil {
+		// A retry after a commit that failed further on finds the upload
+		// already completed.
+		info, statErr := store.Stat(ctx, key)
+		if !StorageImpl.IsNotFound(err) || statErr != nil || info.Size != size {
+			return nil, fmt.Errorf("error completing assembly: %v", err)
+		}
+	}
+	var offset int64
+	for i := range chunks {
+		if chunks[i].Part == "" {
+			continue
+		}
+		chunks[i].Object, chunks[i].Offset, chunks[i].Part = session.UploadID, offset, ""
+		offset += chunks[i].StoredSize
+	}
+	if content != "" {
+		if err := writeJSON(ctx, store, assembliesPrefix+content, chunks, StorageImpl.PutOptions{}); err != nil {
+			log.Printf("⚠️  Warning: could not record content of %s: %v", session.FileName, err)
+		}
+	}
+	return chunks, nil
+}
+
+// contentID identifies the content of an upload made of chunks: the same
+// data sent in the same pieces has the same ID, whatever it was compressed
+// with.
+func contentID(chunks []manifestChunk) string {
+	h := sha256.New()
+	for _, chunk := range chunks {
+		h.Write([]byte(chunk.Hash + ":" + strconv.FormatInt(chunk.Size, 10) + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// reuseAssembly returns the chunks of an assembled object with content,
+// with target holding a reference to it. Only objects some file holds are
+// reused: the orphan collection can't put an assembled object back the way
+// it does chunks (see reclaimChunk).
+func reuseAssembly(ctx context.Context, store StorageImpl.Storage, content, target string) ([]manifestChunk, bool) {
+	var chunks []manifestChunk
+	if err := readJSON(ctx, store, assembliesPrefix+content, &chunks); err != nil {
+		if !StorageImpl.IsNotFound(err) {
+			log.Printf("⚠️  Warning: could not read assembled content %s: %v", content, err)
+		}
+		return nil, false
+	}
+	if len(chunks) == 0 || chunks[0].Object == "" {
+		return nil, false
+	}
+	object := chunks[0].Object
+	if count, err := refCount(ctx, store, object); err != nil || count == 0 {
+		return nil, false
+	}
+	if err := addRef(ctx, store, object, fileHolder(target)); err != nil {
+		return nil, false
+	}
+	if _, err := store.Stat(ctx, chunkObjectKey(object)); err != nil {
+		// The orphan collection releases the reference.
+		return nil, false
+	}
+	return chunks, true
+}
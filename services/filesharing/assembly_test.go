@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+)
+
+// countingStorage counts the Gets of chunk objects.
+type countingStorage struct {
+	StorageImpl.Storage
+	gets atomic.Int32
+}
+
+func (s *countingStorage) Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if strings.HasPrefix(key, chunksPrefix) {
+		s.gets.Add(1)
+	}
+	return s.Storage.Get(ctx, key, offset, length)
+}
+
+func uploadPieces(t *testing.T, f *FilesharingService, name string, pieces ...[]byte) *fileManifest {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := f.UploadFile(ctx, &filesharing.UploadFileRequest{FileName: name, FileContent: pieces[0], Owner: "alice"}); err != nil {
+		t.Fatalf("starting upload of %s: %v", name, err)
+	}
+	for _, piece := range pieces[1:] {
+		if _, err := f.AddChunk(ctx, &filesharing.AddChunkRequest{FileName: name, ChunkData: piece, Owner: "alice"}); err != nil {
+			t.Fatalf("AddChunk to %s: %v", name, err)
+		}
+	}
+	if _, err := f.CompleteUpload(ctx, &filesharing.CompleteUploadRequest{FileName: name, Owner: "alice"}); err != nil {
+		t.Fatalf("CompleteUpload of %s: %v", name, err)
+	}
+	manifest, err := loadManifest(ctx, f.store, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest
+}
+
+func TestUploadIsAssembledIntoOneObject(t *testing.T) {
+	f := newTestService(t)
+	store := &countingStorage{Storage: f.store}
+	ctx := context.Background()
+
+	rng := rand.New(rand.NewSource(1))
+	pieces := [][]byte{make([]byte, minPartSize+100), make([]byte, minPartSize), []byte("the end")}
+	for _, piece := range pieces[:2] {
+		rng.Read(piece)
+	}
+	data := bytes.Join(pieces, nil)
+
+	manifest := uploadPieces(t, f, "data.bin", pieces...)
+	object := manifest.Chunks[0].Object
+	if object == "" || manifest.Size != int64(len(data)) || len(manifest.Chunks) != 3 {
+		t.Fatalf("manifest %+v", manifest)
+	}
+	var offset int64
+	for i, chunk := range manifest.Chunks {
+		if chunk.Object != object || chunk.Offset != offset || chunk.Part != "" {
+			t.Errorf("chunk %d is %+v, want offset %d of %s", i, chunk, offset, object)
+		}
+		offset += chunk.StoredSize
+	}
+	// The last piece was a chunk until the upload completed.
+	if count, err := refCount(ctx, f.store, hashChunk(pieces[2])); err != nil || count != 0 {
+		t.Errorf("last piece's chunk has %d references, %v", count, err)
+	}
+
+	file, err := openFile(ctx, store, "data.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := file.NewReader(ctx, store, 10, int64(len(data))-20)
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, data[10:len(data)-10]) {
+		t.Errorf("ranged read: %d bytes, %v", len(got), err)
+	}
+	if n := store.gets.Load(); n != 1 {
+		t.Errorf("ranged read took %d Gets, want 1", n)
+	}
+
+	// The same content sent again shares the object.
+	again := uploadPieces(t, f, "copy.bin", pieces...)
+	if again.Chunks[0].Object != object {
+		t.Errorf("second upload stored in %s, want %s", again.Chunks[0].Object, object)
+	}
+	if got := readStoredFile(t, f, "copy.bin"); !bytes.Equal(got, data) {
+		t.Errorf("second upload reads back %d bytes", len(got))
+	}
+	if count, err := refCount(ctx, f.store, object); err != nil || count != 2 {
+		t.Errorf("object has %d references, %v", count, err)
+	}
+
+	for _, name := range []string{"data.bin", "copy.bin"} {
+		if err := deleteFile(ctx, f.store, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := refCount(ctx, f.store, object); err != nil || count != 0 {
+		t.Errorf("object has %d references after deleting both files, %v", count, err)
+	}
+	if removed, err := reclaimChunk(ctx, f.store, object); err != nil || !removed {
+		t.Errorf("reclaimChunk: %v, %v", removed, err)
+	}
+}
+
+func TestEncryptedUploadIsAssembled(t *testing.T) {
+	setMasterKeys(t, "k1 "+strings.Repeat("01", 32))
+	f := newTestService(t)
+	rng := rand.New(rand.NewSource(2))
+	pieces := [][]byte{make([]byte, minPartSize), []byte("the end")}
+	rng.Read(pieces[0])
+
+	manifest := uploadPieces(t, f, "secret.bin", pieces...)
+	if manifest.Encryption == nil || manifest.Chunks[0].Object == "" || manifest.Chunks[1].Object != manifest.Chunks[0].Object {
+		t.Fatalf("manifest %+v", manifest)
+	}
+	if got := readStoredFile(t, f, "secret.bin"); !bytes.Equal(got, bytes.Join(pieces, nil)) {
+		t.Errorf("reads back %d bytes", len(got))
+	}
+}
+
+func TestSmallPiecesStayChunks(t *testing.T) {
+	f := newTestService(t)
+	manifest := uploadPieces(t, f, "small.txt", []byte("one "), []byte("two "), []byte("three"))
+	for i, chunk := range manifest.Chunks {
+		if chunk.Object != "" {
+			t.Errorf("chunk %d is in object %s", i, chunk.Object)
+		}
+	}
+	if got := readStoredFile(t, f, "small.txt"); string(got) != "one two three" {
+		t.Errorf("stored %q", got)
+	}
+}
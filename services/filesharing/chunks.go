@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// Chunks are stored once per distinct content under chunks/<sha256>. Each
+// holder of a chunk (a finished file or a part of an open upload) owns an
+// empty marker object refs/<sha256>/<holder>; the chunk's refcount is the
+// number of markers. Markers are independent keys, so concurrent uploads
+// never lose each other's increments the way a shared counter would.
+const (
+	chunksPrefix = "chunks/"
+	refsPrefix   = "refs/"
+)
+
+func chunkObjectKey(hash string) string {
+	return chunksPrefix + hash
+}
+
+func refPrefix(hash string) string {
+	return refsPrefix + hash + "/"
+}
+
+func refKey(hash, holder string) string {
+	return refPrefix(hash) + url.QueryEscape(holder)
+}
+
+func fileHolder(fileName string) string {
+	return "file:" + fileName
+}
+
+func partHolder(uploadID string, number int) string {
+	return fmt.Sprintf("part:%s:%d", uploadID, number)
+}
+
+func hashChunk(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func addRef(ctx context.Context, store StorageImpl.Storage, hash, holder string) error {
+	_, err := store.Put(ctx, refKey(hash, holder), bytes.NewReader(nil), 0, StorageImpl.PutOptions{})
+	return err
+}
+
+func refCount(ctx context.Context, store StorageImpl.Storage, hash string) (int, error) {
+	refs, err := store.List(ctx, refPrefix(hash), "", 0)
+	return len(refs), err
+}
+
+// Metadata entries on chunk objects.
+const (
+	chunkCodecKey      = "Codec"
+	chunkRawSizeKey    = "Raw-Size"
+	chunkEncryptionKey = "Encryption"
+	chunkAssembledKey  = "Assembled" // set on objects assembled from uploads, see assembly.go
+)
+
+// putChunk stores data under its hash, encoded with codec, unless an
+// identical chunk is already there, in which case the stored copy (and its
+// codec) is reused. The reference is taken before the check, so the orphan
+// collection, which deletes chunks without references, either sees it or
+// puts the chunk back (see reclaimChunk).
+//
+// With a seal the chunk is compressed, then encrypted, and addressed by the
+// hash of the ciphertext. Every file has its own data key, so encrypted
+// chunks are never shared: with encryption on, new uploads aren't
+// deduplicated (see the README).
+func putChunk(ctx context.Context, store StorageImpl.Storage, data []byte, holder, codec string, seal *chunkSeal) (manifestChunk, bool, error) {
+	var stored []byte
+	if seal != nil {
+		var err error
+		if stored, codec, err = encodePiece(data, codec, seal); err != nil {
+			return manifestChunk{}, false, err
+		}
+	}
+	return putEncodedChunk(ctx, store, data, stored, codec, holder, seal != nil)
+}
+
+// encodePiece returns data as it is stored, encoded with codec and then
+// sealed, along with the codec actually used.
+func encodePiece(data []byte, codec string, seal *chunkSeal) ([]byte, string, error) {
+	stored, codec := encodeChunk(data, codec)
+	if seal == nil {
+		return stored, codec, nil
+	}
+	sealed, err := sealChunk(seal.aead, seal.index, stored)
+	if err != nil {
+		return nil, "", fmt.Errorf("error encrypting chunk: %v", err)
+	}
+	return sealed, codec, nil
+}
+
+// putEncodedChunk is putChunk for data that may already be encoded as
+// stored; sealed data always is. A nil stored is only encoded once the
+// chunk turns out not to be there yet.
+func putEncodedChunk(ctx context.Context, store StorageImpl.Storage, data, stored []byte, codec, holder string, sealed bool) (manifestChunk, bool, error) {
+	hash := hashChunk(data)
+	if sealed {
+		hash = hashChunk(stored)
+	}
+	if err := addRef(ctx, store, hash, holder); err != nil {
+		return manifestChunk{}, false, fmt.Errorf("error adding chunk reference: %v", err)
+	}
+
+	if !sealed {
+		info, err := store.Stat(ctx, chunkObjectKey(hash))
+		if err == nil {
+			rawSize := info.Size // chunks stored before compression have no Raw-Size
+			if val, ok := info.Metadata[chunkRawSizeKey]; ok {
+				rawSize, _ = strconv.ParseInt(val, 10, 64)
+			}
+			if rawSize == int64(len(data)) {
+				return manifestChunk{Hash: hash, Size: rawSize, StoredSize: info.Size, Codec: info.Metadata[chunkCodecKey]}, true, nil
+			}
+		} else if !StorageImpl.IsNotFound(err) {
+			return manifestChunk{}, false, fmt.Errorf("error checking chunk: %v", err)
+		}
+		if stored == nil {
+			stored, codec = encodeChunk(data, codec)
+		}
+	}
+
+	metadata := map[string]string{chunkRawSizeKey: strconv.Itoa(len(data))}
+	if codec != codecNone {
+		metadata[chunkCodecKey] = codec
+	}
+	if sealed {
+		metadata[chunkEncryptionKey] = encryptionAlgorithm
+	}
+	_, err := store.Put(ctx, chunkObjectKey(hash), bytes.NewReader(stored), int64(len(stored)), StorageImpl.PutOptions{
+		ContentType: "application/octet-stream",
+		Metadata:    metadata,
+	})
+	if err != nil {
+		store.Delete(ctx, refKey(hash, holder))
+		return manifestChunk{}, false, fmt.Errorf("error storing chunk: %v", err)
+	}
+	return manifestChunk{Hash: hash, Size: int64(len(data)), StoredSize: int64(len(stored)), Codec: codec}, false, nil
+}
+
+// releaseChunk drops holder's reference. Chunks nobody references are left
+// to the orphan collection (gc.go): deleting one here could race with a
+// concurrent putChunk that has just taken a reference and found the chunk
+// still there.
+func releaseChunk(ctx context.Context, store StorageImpl.Storage, hash, holder string) error {
+	if err := store.Delete(ctx, refKey(hash, holder)); err != nil && !StorageImpl.IsNotFound(err) {
+		return fmt.Errorf("error removing chunk reference: %v", err)
+	}
+	return nil
+}
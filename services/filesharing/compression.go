@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs a chunk can be stored with. The codec is kept in the chunk's object
+// metadata and in every manifest entry that points at it.
+const (
+	codecNone = ""
+	codecZstd = "zstd"
+)
+
+// Chunks that don't shrink below this share of their size are stored raw;
+// decompressing them would cost more than it saves.
+const minCompressionGain = 0.97
+
+var (
+	// EncodeAll/DecodeAll are safe for concurrent use on a shared instance.
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(2*chunkSize))
+)
+
+// defaultCodec is what uploads use when they don't ask for a codec, taken
+// from STORAGE_COMPRESSION ("zstd" or "none").
+var defaultCodec = codecNone
+
+func loadCompressionConfig() {
+	switch val := strings.ToLower(getEnv("STORAGE_COMPRESSION", "none")); val {
+	case "zstd":
+		defaultCodec = codecZstd
+	case "none", "off", "false":
+		defaultCodec = codecNone
+	default:
+		log.Printf("invalid STORAGE_COMPRESSION value %q, compression disabled", val)
+		defaultCodec = codecNone
+	}
+}
+
+// Extensions of formats that are already compressed.
+var compressedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true,
+	".7z": true, ".rar": true, ".lz4": true, ".br": true, ".jar": true, ".apk": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".mp3": true, ".ogg": true, ".flac": true, ".aac": true, ".m4a": true, ".opus": true,
+	".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true,
+}
+
+// Magic numbers http.DetectContentType doesn't know about.
+var compressedMagic = [][]byte{
+	{0x28, 0xb5, 0x2f, 0xfd},           // zstd
+	{0xfd, '7', 'z', 'X', 'Z', 0x00},   // xz
+	{'B', 'Z', 'h'},                    // bzip2
+	{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}, // 7z
+	{0x04, 0x22, 0x4d, 0x18},           // lz4
+}
+
+// chooseCodec picks the codec for a new upload. requested is the client's
+// choice ("zstd", "none" or empty for the server default); fileName and the
+// first bytes of the file let us skip formats that won't compress further.
+func chooseCodec(requested, fileName string, sample []byte) (string, error) {
+	codec := defaultCodec
+	switch strings.ToLower(requested) {
+	case "":
+	case "zstd":
+		codec = codecZstd
+	case "none":
+		codec = codecNone
+	default:
+		return "", fmt.Errorf("unknown compression %q (zstd or none)", requested)
+	}
+	if codec == codecNone || isCompressedFormat(fileName, sample) {
+		return codecNone, nil
+	}
+	return codec, nil
+}
+
+func isCompressedFormat(fileName string, sample []byte) bool {
+	if compressedExtensions[strings.ToLower(path.Ext(fileName))] {
+		return true
+	}
+	for _, magic := range compressedMagic {
+		if bytes.HasPrefix(sample, magic) {
+			return true
+		}
+	}
+	contentType := http.DetectContentType(sample)
+	for _, prefix := range []string{"image/", "video/", "audio/", "application/zip", "application/x-gzip", "application/x-rar-compressed", "font/woff"} {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeChunk returns data as it should be stored under codec, falling back
+// to the raw bytes when compression doesn't pay off.
+func encodeChunk(data []byte, codec string) ([]byte, string) {
+	if codec != codecZstd || len(data) == 0 {
+		return data, codecNone
+	}
+	compressed := zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+	if float64(len(compressed)) >= float64(len(data))*minCompressionGain {
+		return data, codecNone
+	}
+	return compressed, codecZstd
+}
+
+func decodeChunk(stored []byte, codec string) ([]byte, error) {
+	switch codec {
+	case codecNone:
+		return stored, nil
+	case codecZstd:
+		return zstdDecoder.DecodeAll(stored, nil)
+	}
+	return nil, fmt.Errorf("unknown chunk codec %q", codec)
+}
+
+// PickEncoding chooses the content coding to send the whole file with, out
+// of the ones the caller accepts. Only files that were stored compressed
+// qualify; the rest didn't compress well enough to be worth it.
+func (f *storedFile) PickEncoding(accepted []string) string {
+	compressed := false
+	for _, seg := range f.Segments {
+		if seg.Codec == codecZstd {
+			compressed = true
+			break
+		}
+	}
+	if !compressed {
+		return ""
+	}
+	for _, preferred := range []string{"zstd", "gzip"} {
+		for _, enc := range accepted {
+			if strings.EqualFold(enc, preferred) {
+				return preferred
+			}
+		}
+	}
+	return ""
+}
+
+// NewEncodedReader streams the whole file with the given content coding and
+// returns the encoded length, or -1 when it can't be known in advance.
+//
+// Concatenated zstd frames are a valid zstd stream, so for zstd the stored
+// chunks are passed through (decrypted, but not decoded) and only raw chunks
+// get compressed.
+// gzip is produced by recompressing the decoded file.
+func (f *storedFile) NewEncodedReader(ctx context.Context, store StorageImpl.Storage, encoding string) (io.ReadCloser, int64) {
+	if encoding == "gzip" {
+		pr, pw := io.Pipe()
+		go func() {
+			src := f.NewReader(ctx, store, 0, f.Size)
+			defer src.Close()
+			gz, _ := gzip.NewWriterLevel(pw, gzip.BestSpeed)
+			_, err := io.Copy(gz, src)
+			if err == nil {
+				err = gz.Close()
+			}
+			pw.CloseWithError(err)
+		}()
+		return pr, -1
+	}
+
+	length := int64(0)
+	for _, seg := range f.Segments {
+		if seg.Codec != codecZstd {
+			length = -1
+			break
+		}
+		length += seg.StoredSize - sealOverhead(seg.aead)
+	}
+	return &zstdFrameReader{ctx: ctx, store: store, segments: f.Segments}, length
+}
+
+// zstdFrameReader emits one zstd frame per segment.
+type zstdFrameReader struct {
+	ctx      context.Context
+	store    StorageImpl.Storage
+	segments []segment
+	current  io.ReadCloser
+}
+
+func (r *zstdFrameReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.segments) == 0 {
+				return 0, io.EOF
+			}
+			seg := r.segments[0]
+			r.segments = r.segments[1:]
+			switch {
+			case seg.Codec == codecZstd && seg.aead == nil:
+				rc, err := seg.getStored(r.ctx, r.store)
+				if err != nil {
+					return 0, err
+				}
+				r.current = rc
+			case seg.Codec == codecZstd:
+				frame, err := readStored(r.ctx, r.store, seg)
+				if err != nil {
+					return 0, err
+				}
+				r.current = io.NopCloser(bytes.NewReader(frame))
+			default:
+				rc, err := openSegment(r.ctx, r.store, seg, 0, seg.Size)
+				if err != nil {
+					return 0, err
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					return 0, err
+				}
+				r.current = io.NopCloser(bytes.NewReader(zstdEncoder.EncodeAll(data, nil)))
+			}
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (r *zstdFrameReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
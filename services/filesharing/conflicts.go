@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/paths"
+)
+
+// Upload conflict policies: what an upload does when its name is taken.
+const (
+	conflictOverwrite = "overwrite"
+	conflictReject    = "reject"
+	conflictRename    = "rename"
+)
+
+// maxRenameAttempts bounds the "name (N).ext" names tried for one upload.
+const maxRenameAttempts = 1000
+
+var (
+	errNameTaken    = errors.New("name is taken")
+	errUploadBusy   = errors.New("upload in progress")
+	errPrecondition = errors.New("precondition failed")
+)
+
+// uploadConflict is how an upload treats a file already under its name.
+// IfMatch and IfNoneMatch are comma-separated ETags or "*", as in HTTP.
+type uploadConflict struct {
+	Policy      string `json:"policy,omitempty"`
+	IfMatch     string `json:"ifMatch,omitempty"`
+	IfNoneMatch string `json:"ifNoneMatch,omitempty"`
+}
+
+func parseConflictPolicy(val string) (string, error) {
+	switch val {
+	case "", conflictOverwrite:
+		return conflictOverwrite, nil
+	case conflictReject, conflictRename:
+		return val, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q (overwrite, rename or reject)", val)
+}
+
+// guarded reports whether the upload cares what is under its name. Plain
+// overwrites replace whatever is there, as uploads always did.
+func (c uploadConflict) guarded() bool {
+	return (c.Policy != "" && c.Policy != conflictOverwrite) || c.IfMatch != "" || c.IfNoneMatch != ""
+}
+
+func matchesETag(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || (etag != "" && candidate == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// check tests the preconditions against the file under the name, nil if
+// there is none.
+func (c uploadConflict) check(current *fileInfo) error {
+	if c.IfMatch != "" && (current == nil || !matchesETag(c.IfMatch, current.ETag)) {
+		return errPrecondition
+	}
+	if c.IfNoneMatch != "" && current != nil && matchesETag(c.IfNoneMatch, current.ETag) {
+		return errPrecondition
+	}
+	return nil
+}
+
+// numberedName returns "name (n).ext" for a clean path.
+func numberedName(name string, n int) string {
+	base := paths.Base(name)
+	ext := path.Ext(base)
+	if ext == base {
+		ext = "" // ".env" is a name, not an extension
+	}
+	return paths.Join(paths.Dir(name), fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(base, ext), n, ext))
+}
+
+// uploadTarget works out the name an upload of name by owner is stored
+// under, checking c against what is there now. It runs when the upload
+// starts and again when it is committed, since the name can be taken in
+// between. An unfinished upload of the name by someone else counts as
+// taking it for guarded uploads: starting one would throw that upload away.
+func uploadTarget(ctx context.Context, store StorageImpl.Storage, name, owner string, c uploadConflict) (string, error) {
+	if !c.guarded() {
+		return name, nil
+	}
+	current, busy, err := nameInUse(ctx, store, name, owner)
+	if err != nil {
+		return "", err
+	}
+	if err := c.check(current); err != nil {
+		return "", err
+	}
+	switch {
+	case c.Policy == conflictRename && (current != nil || busy):
+		for n := 1; n <= maxRenameAttempts; n++ {
+			candidate := numberedName(name, n)
+			current, busy, err := nameInUse(ctx, store, candidate, owner)
+			if err != nil {
+				return "", err
+			}
+			taken, err := isFolder(ctx, store, candidate)
+			if err != nil {
+				return "", err
+			}
+			if current == nil && !busy && !taken {
+				return candidate, nil
+			}
+		}
+		return "", errNameTaken
+	case c.Policy == conflictReject && current != nil:
+		return "", errNameTaken
+	case busy:
+		return "", errUploadBusy
+	}
+	return name, nil
+}
+
+// nameInUse returns the file stored under name, and whether someone other
+// than owner has an upload of it in progress.
+func nameInUse(ctx context.Context, store StorageImpl.Storage, name, owner string) (*fileInfo, bool, error) {
+	current, err := statFile(ctx, store, name)
+	if StorageImpl.IsNotFound(err) {
+		current, err = nil, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	session, err := loadSession(ctx, store, name)
+	if StorageImpl.IsNotFound(err) {
+		return current, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return current, session.Owner != owner, nil
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+const encryptionAlgorithm = "AES-256-GCM"
+
+// keyRing holds the master keys from MASTER_KEY_FILE. Data keys are always
+// wrapped with the active key; the others are only used to unwrap data keys
+// that haven't been rotated yet.
+type keyRing struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// masterKeys is nil when encryption at rest is disabled.
+var masterKeys *keyRing
+
+// loadMasterKeys reads MASTER_KEY_FILE, one key per line as
+// "<id> <key>", where the key is 32 bytes in hex or base64. The first key is
+// the active one. Blank lines and lines starting with # are ignored.
+func loadMasterKeys() error {
+	path := getEnv("MASTER_KEY_FILE", "")
+	if path == "" {
+		log.Println("⚠️  MASTER_KEY_FILE not set, files are stored unencrypted")
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading master key file: %v", err)
+	}
+	ring, err := parseKeyFile(data)
+	if err != nil {
+		return fmt.Errorf("invalid master key file %s: %v", path, err)
+	}
+	masterKeys = ring
+	log.Printf("Encryption at rest enabled, active master key %q (%d keys loaded)", ring.activeID, len(ring.keys))
+	return nil
+}
+
+func parseKeyFile(data []byte) (*keyRing, error) {
+	ring := &keyRing{keys: map[string][]byte{}}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<id> <key>\"", i+1)
+		}
+		key, err := decodeKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, dup := ring.keys[fields[0]]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key id %q", i+1, fields[0])
+		}
+		ring.keys[fields[0]] = key
+		if ring.activeID == "" {
+			ring.activeID = fields[0]
+		}
+	}
+	if ring.activeID == "" {
+		return nil, fmt.Errorf("no keys found")
+	}
+	return ring, nil
+}
+
+func decodeKey(val string) ([]byte, error) {
+	if key, err := hex.DecodeString(val); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(val); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("key must be 32 bytes in hex or base64")
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// fileEncryption is stored in sessions and manifests of encrypted files:
+// the file's data key, wrapped by master key KeyID.
+type fileEncryption struct {
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"keyId"`
+	WrappedKey string `json:"wrappedKey"`
+}
+
+// newFileEncryption creates a data key for a new file, or returns nil when
+// encryption is disabled.
+func newFileEncryption() (*fileEncryption, error) {
+	if masterKeys == nil {
+		return nil, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	return wrapDataKey(dataKey, masterKeys.activeID)
+}
+
+// wrapAAD binds a wrapped key to the master key id it was wrapped with.
+func wrapAAD(keyID string) []byte {
+	return []byte("kubefile-data-key|" + keyID)
+}
+
+func wrapDataKey(dataKey []byte, keyID string) (*fileEncryption, error) {
+	gcm, err := newGCM(masterKeys.keys[keyID])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	wrapped := gcm.Seal(nonce, nonce, dataKey, wrapAAD(keyID))
+	return &fileEncryption{
+		Algorithm:  encryptionAlgorithm,
+		KeyID:      keyID,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	}, nil
+}
+
+func (e *fileEncryption) dataKey() ([]byte, error) {
+	if masterKeys == nil {
+		return nil, fmt.Errorf("file is encrypted but no master key is configured")
+	}
+	if e.Algorithm != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", e.Algorithm)
+	}
+	master, ok := masterKeys.keys[e.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q is not loaded", e.KeyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(e.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt wrapped key: %v", err)
+	}
+	gcm, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("corrupt wrapped key")
+	}
+	nonce, sealed := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, sealed, wrapAAD(e.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping data key: %v", err)
+	}
+	return dataKey, nil
+}
+
+// AEAD returns the cipher for the file's chunks, or nil for a nil e.
+func (e *fileEncryption) AEAD() (cipher.AEAD, error) {
+	if e == nil {
+		return nil, nil
+	}
+	dataKey, err := e.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	return newGCM(dataKey)
+}
+
+// rewrap re-wraps the data key with the active master key. It reports
+// whether anything changed.
+func (e *fileEncryption) rewrap() (bool, error) {
+	if e == nil || e.KeyID == masterKeys.activeID {
+		return false, nil
+	}
+	dataKey, err := e.dataKey()
+	if err != nil {
+		return false, err
+	}
+	rewrapped, err := wrapDataKey(dataKey, masterKeys.activeID)
+	if err != nil {
+		return false, err
+	}
+	*e = *rewrapped
+	return true, nil
+}
+
+// chunkAAD authenticates a chunk's position in its file, so chunks swapped
+// or reordered in a manifest fail to decrypt.
+func chunkAAD(index int) []byte {
+	return binary.BigEndian.AppendUint64([]byte("kubefile-chunk|"), uint64(index))
+}
+
+// chunkSeal tells putChunk to encrypt a chunk as position index of a file.
+type chunkSeal struct {
+	aead  cipher.AEAD
+	index int
+}
+
+// sealOverhead is how much bigger sealing makes a chunk.
+func sealOverhead(aead cipher.AEAD) int64 {
+	if aead == nil {
+		return 0
+	}
+	return int64(aead.NonceSize() + aead.Overhead())
+}
+
+// sealChunk encrypts data as chunk index; the nonce is prepended.
+func sealChunk(aead cipher.AEAD, index int, data []byte) ([]byte, error) {
+	return sealData(aead, chunkAAD(index), data)
+}
+
+func openChunk(aead cipher.AEAD, index int, sealed []byte) ([]byte, error) {
+	data, err := openData(aead, chunkAAD(index), sealed)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d failed authentication: %v", index, err)
+	}
+	return data, nil
+}
+
+// sealData encrypts data bound to aad, with a random nonce prepended.
+func sealData(aead cipher.AEAD, aad, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, aad), nil
+}
+
+func openData(aead cipher.AEAD, aad, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// rotateKeys rewraps the data keys of every manifest, old version and open
+// upload session that still uses an older master key. Chunk data is
+// untouched.
+func rotateKeys(ctx context.Context, store StorageImpl.Storage) (int, int, error) {
+	var rewrapped, failed int
+	for _, prefix := range []string{manifestsPrefix, versionsPrefix, uploadsPrefix} {
+		objects, err := store.List(ctx, prefix, "", 0)
+		if err != nil {
+			return rewrapped, failed, fmt.Errorf("error listing %s: %v", prefix, err)
+		}
+		for _, obj := range objects {
+			changed, err := rewrapObject(ctx, store, obj)
+			if err != nil {
+				log.Printf("Failed to rewrap data key of %s: %v", obj.Key, err)
+				failed++
+			} else if changed {
+				rewrapped++
+			}
+		}
+	}
+	log.Printf("Key rotation finished: %d rewrapped, %d failed", rewrapped, failed)
+	return rewrapped, failed, nil
+}
+
+func rewrapObject(ctx context.Context, store StorageImpl.Storage, obj StorageImpl.ObjectInfo) (bool, error) {
+	if strings.HasPrefix(obj.Key, uploadsPrefix) {
+		var session uploadSession
+		if err := readJSON(ctx, store, obj.Key, &session); err != nil {
+			return false, err
+		}
+		changed, err := session.Encryption.rewrap()
+		if err != nil || !changed {
+			return false, err
+		}
+		return true, writeJSON(ctx, store, obj.Key, &session, StorageImpl.PutOptions{})
+	}
+
+	var manifest fileManifest
+	if err := readJSON(ctx, store, obj.Key, &manifest); err != nil {
+		return false, err
+	}
+	changed, err := manifest.Encryption.rewrap()
+	if err != nil || !changed {
+		return false, err
+	}
+	if strings.HasPrefix(obj.Key, versionsPrefix) {
+		return true, writeJSON(ctx, store, obj.Key, &manifest, StorageImpl.PutOptions{})
+	}
+	// Rewriting restarts the lifecycle countdown, so the tag is worked out
+	// again for the time the file has left. The metadata comes from the
+	// manifest too: not every backend lists it.
+	return true, writeJSON(ctx, store, obj.Key, &manifest, StorageImpl.PutOptions{
+		Metadata: manifestMetadata(&manifest),
+		Tags:     expiryTags(&manifest, time.Now()),
+	})
+}
+
+// readStored returns a segment's stored bytes with any encryption removed,
+// still encoded with the segment's codec.
+func readStored(ctx context.Context, store StorageImpl.Storage, seg segment) ([]byte, error) {
+	rc, err := seg.getStored(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if seg.aead == nil {
+		return stored, nil
+	}
+	return openChunk(seg.aead, seg.Index, stored)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+)
+
+// bareListStorage lists objects without their metadata, as plain S3 does.
+type bareListStorage struct {
+	StorageImpl.Storage
+}
+
+func (s bareListStorage) List(ctx context.Context, prefix, startAfter string, limit int) ([]StorageImpl.ObjectInfo, error) {
+	objects, err := s.Storage.List(ctx, prefix, startAfter, limit)
+	for i := range objects {
+		objects[i].Metadata = nil
+	}
+	return objects, err
+}
+
+func setMasterKeys(t *testing.T, file string) {
+	t.Helper()
+	ring, err := parseKeyFile([]byte(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := masterKeys
+	masterKeys = ring
+	t.Cleanup(func() { masterKeys = old })
+}
+
+func TestRotateKeysKeepsManifestMetadata(t *testing.T) {
+	const key1 = "k1 " + "0101010101010101010101010101010101010101010101010101010101010101"
+	const key2 = "k2 " + "0202020202020202020202020202020202020202020202020202020202020202"
+	setMasterKeys(t, key1)
+	f := newTestService(t)
+	ctx := context.Background()
+	_, err := f.UploadFile(ctx, &filesharing.UploadFileRequest{
+		FileName:    "docs/notes.txt",
+		FileContent: []byte("encrypted at rest"),
+		Complete:    true,
+		Owner:       "alice",
+		Tags:        []string{"work"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := f.store.Stat(ctx, manifestKey("docs/notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setMasterKeys(t, key2+"\n"+key1)
+	rewrapped, failed, err := rotateKeys(ctx, bareListStorage{f.store})
+	if err != nil || rewrapped != 1 || failed != 0 {
+		t.Fatalf("rotateKeys: %d rewrapped, %d failed, %v", rewrapped, failed, err)
+	}
+	after, err := f.store.Stat(ctx, manifestKey("docs/notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(after.Metadata, before.Metadata) {
+		t.Errorf("metadata after rotation %v, want %v", after.Metadata, before.Metadata)
+	}
+	manifest, err := loadManifest(ctx, f.store, "docs/notes.txt")
+	if err != nil || manifest.Encryption.KeyID != "k2" {
+		t.Fatalf("manifest after rotation: %+v, %v", manifest, err)
+	}
+
+	// Only the new key is needed from now on.
+	setMasterKeys(t, key2)
+	if got := readStoredFile(t, f, "docs/notes.txt"); string(got) != "encrypted at rest" {
+		t.Errorf("read back %q", got)
+	}
+}
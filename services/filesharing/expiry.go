@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// Every file with an expiry has an entry expiry/<unix>/<name>/<version>
+// written next to its manifest, for when the sweeper has to look at it (see
+// sweepAt). The version is the manifest's creation time, so replacing a file
+// the store already deleted doesn't overwrite the old entry.
+// Listing expiry/ returns files in that order, so the sweeper only ever reads
+// the entries that are due. The entry lists the file's chunks, which is all
+// that's left of it once lifecycle rules have deleted the manifest.
+const expiryPrefix = "expiry/"
+
+// Expiry settings: FILE_TTL_HOURS is what uploads get when they don't ask
+// for anything, FILE_MAX_TTL_HOURS the longest they can ask for.
+var (
+	defaultFileTTL = 5 * 24 * time.Hour
+	maxFileTTL     = 30 * 24 * time.Hour
+)
+
+const (
+	// sweepInterval is how often the sweeper runs.
+	sweepInterval = time.Minute
+	// sweepPageSize bounds how many keys one run reads.
+	sweepPageSize = 1000
+)
+
+func getHoursEnv(key string, fallback time.Duration) time.Duration {
+	val := getEnv(key, "")
+	if val == "" {
+		return fallback
+	}
+	hours, err := strconv.Atoi(val)
+	if err != nil || hours <= 0 {
+		log.Printf("invalid %s value %q, using default %s", key, val, fallback)
+		return fallback
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+func loadExpiryConfig() {
+	defaultFileTTL = getHoursEnv("FILE_TTL_HOURS", defaultFileTTL)
+	maxFileTTL = getHoursEnv("FILE_MAX_TTL_HOURS", maxFileTTL)
+	if maxFileTTL < defaultFileTTL {
+		log.Printf("FILE_MAX_TTL_HOURS is below FILE_TTL_HOURS, raising it to %s", defaultFileTTL)
+		maxFileTTL = defaultFileTTL
+	}
+	log.Printf("File TTL set to %s (max %s)", defaultFileTTL, maxFileTTL)
+}
+
+// fileTTL validates the expiry an upload asked for.
+func fileTTL(expiresInSeconds int64) (time.Duration, error) {
+	if expiresInSeconds == 0 {
+		return defaultFileTTL, nil
+	}
+	ttl := time.Duration(expiresInSeconds) * time.Second
+	if expiresInSeconds < 0 || ttl > maxFileTTL {
+		return 0, fmt.Errorf("expiry must be between 1 second and %d seconds", int64(maxFileTTL/time.Second))
+	}
+	return ttl, nil
+}
+
+// expiresAt returns when a file committed at now expires, or the zero time
+// for files kept forever.
+func (o uploadOptions) expiresAt(now time.Time) time.Time {
+	if o.KeepForever {
+		return time.Time{}
+	}
+	ttl := o.TTL
+	if ttl <= 0 {
+		ttl = defaultFileTTL
+	}
+	return now.Add(ttl)
+}
+
+// unixOrZero is how expiry times travel over gRPC: 0 means never.
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func expiryKey(at time.Time, fileName string, version time.Time) string {
+	return fmt.Sprintf("%s%012d/%s/%d", expiryPrefix, at.Unix(), url.QueryEscape(fileName), version.UnixNano())
+}
+
+// entryKey returns the key of m's expiry entry.
+func (m *fileManifest) entryKey() (string, bool) {
+	at, ok := m.sweepAt()
+	if !ok {
+		return "", false
+	}
+	return expiryKey(at, m.Name, m.Created), true
+}
+
+func parseExpiryKey(key string) (time.Time, string, bool) {
+	stamp, escaped, ok := strings.Cut(strings.TrimPrefix(key, expiryPrefix), "/")
+	if !ok {
+		return time.Time{}, "", false
+	}
+	// Entries written before versions have none; names never contain a
+	// raw "/" once escaped.
+	escaped, _, _ = strings.Cut(escaped, "/")
+	unix, err := strconv.ParseInt(stamp, 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	fileName, err := url.QueryUnescape(escaped)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return time.Unix(unix, 0), fileName, true
+}
+
+// ExpiresAt returns when the file expires and false if it never does.
+// Manifests from before per-file expiry get the default TTL.
+func (m *fileManifest) ExpiresAt() (time.Time, bool) {
+	switch {
+	case m.KeepForever:
+		return time.Time{}, false
+	case !m.Expires.IsZero():
+		return m.Expires, true
+	}
+	return m.Created.Add(defaultFileTTL), true
+}
+
+// manifestExpiry reads the expiry of a manifest object from its metadata,
+// falling back to the default TTL for manifests written before expiry was
+// stored there.
+func manifestExpiry(obj StorageImpl.ObjectInfo) (time.Time, bool) {
+	switch val := obj.Metadata[manifestExpiresKey]; val {
+	case "never":
+		return time.Time{}, false
+	case "":
+		return manifestCreated(obj).Add(defaultFileTTL), true
+	default:
+		if expires, err := time.Parse(time.RFC3339, val); err == nil {
+			return expires, true
+		}
+		return manifestCreated(obj).Add(defaultFileTTL), true
+	}
+}
+
+type expiryEntry struct {
+	Chunks []string `json:"chunks"`
+}
+
+func writeExpiryEntry(ctx context.Context, store StorageImpl.Storage, m *fileManifest) error {
+	key, ok := m.entryKey()
+	if !ok {
+		return nil
+	}
+	var entry expiryEntry
+	seen := map[string]bool{}
+	for _, chunk := range m.Chunks {
+		if !seen[chunk.ref()] {
+			seen[chunk.ref()] = true
+			entry.Chunks = append(entry.Chunks, chunk.ref())
+		}
+	}
+	return writeJSON(ctx, store, key, &entry, StorageImpl.PutOptions{})
+}
+
+func expiryMetadata(m *fileManifest) string {
+	expires, ok := m.ExpiresAt()
+	if !ok {
+		return "never"
+	}
+	return expires.UTC().Format(time.RFC3339)
+}
+
+// sweeper deletes expired files. Each tick drains the due part of the expiry
+// index and then reads one page of a slow scan over the rest of the store,
+// which catches files from before the index, stale upload sessions and
+// index entries lost to a crash. Neither pass lists the whole bucket at
+// once. It runs as the "expiry" job, on the leader.
+type sweeper struct {
+	store  StorageImpl.Storage
+	cursor string // where the scan continues, "" to start over
+}
+
+func newSweeper(store StorageImpl.Storage) *sweeper {
+	return &sweeper{store: store}
+}
+
+func (s *sweeper) Sweep(ctx context.Context) error {
+	now := time.Now()
+	if err := s.sweepDue(ctx, now); err != nil {
+		return fmt.Errorf("error sweeping expired files: %v", err)
+	}
+	if err := s.scanPage(ctx, now); err != nil {
+		return fmt.Errorf("error scanning for expired objects: %v", err)
+	}
+	return nil
+}
+
+// sweepDue handles every entry of the expiry index that is due.
+func (s *sweeper) sweepDue(ctx context.Context, now time.Time) error {
+	after := ""
+	for {
+		entries, err := s.store.List(ctx, expiryPrefix, after, sweepPageSize)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			expires, fileName, ok := parseExpiryKey(entry.Key)
+			if ok && expires.After(now) {
+				return nil // the index is sorted, nothing after this is due
+			}
+			after = entry.Key
+			if !ok {
+				s.store.Delete(ctx, entry.Key)
+				continue
+			}
+			s.expire(ctx, fileName, entry.Key, now)
+		}
+		if len(entries) < sweepPageSize {
+			return nil
+		}
+	}
+}
+
+// expire deletes fileName if its manifest is due and the store hasn't
+// deleted it by now. entryKey is the index entry that led here, "" if none.
+// Entries that aren't the manifest's own (it was deleted by the store,
+// replaced, or the entry is from before lifecycle rules) give back the
+// chunks the manifest doesn't hold and are dropped, after making sure the
+// manifest has its own entry.
+func (s *sweeper) expire(ctx context.Context, fileName, entryKey string, now time.Time) {
+	manifest, err := loadManifest(ctx, s.store, fileName)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		log.Printf("Failed to check expiry of %s: %v", fileName, err)
+		return
+	}
+	ownKey := ""
+	if manifest != nil {
+		expires, expiring := manifest.ExpiresAt()
+		at, _ := manifest.sweepAt()
+		ownKey, _ = manifest.entryKey()
+		switch {
+		case expiring && !expires.After(now) && !at.After(now):
+			if err := deleteFile(ctx, s.store, fileName); err != nil {
+				log.Printf("Failed to remove expired file %s: %v", fileName, err)
+				return
+			}
+			log.Printf("Removed expired file: %s", fileName)
+		case expiring && ownKey != entryKey:
+			if err := writeExpiryEntry(ctx, s.store, manifest); err != nil {
+				log.Printf("Failed to index expiry of %s: %v", fileName, err)
+				return
+			}
+		}
+	}
+	if entryKey != "" && entryKey != ownKey {
+		s.reclaim(ctx, fileName, entryKey, manifest)
+	}
+}
+
+// reclaim releases the chunks listed in entryKey that current (the file's
+// manifest now, nil if there is none) doesn't hold, then drops the entry.
+func (s *sweeper) reclaim(ctx context.Context, fileName, entryKey string, current *fileManifest) {
+	var entry expiryEntry
+	if err := readJSON(ctx, s.store, entryKey, &entry); err != nil && !StorageImpl.IsNotFound(err) {
+		// Entries from before they listed chunks are empty.
+		log.Printf("Ignoring unreadable expiry entry %s: %v", entryKey, err)
+	}
+	held := map[string]bool{}
+	if current != nil {
+		for _, chunk := range current.Chunks {
+			held[chunk.ref()] = true
+		}
+	}
+	released := 0
+	for _, hash := range entry.Chunks {
+		if held[hash] {
+			continue
+		}
+		if err := releaseChunk(ctx, s.store, hash, fileHolder(fileName)); err != nil {
+			log.Printf("Failed to release chunk %s of %s: %v", hash, fileName, err)
+			return // keep the entry to retry
+		}
+		released++
+	}
+	s.store.Delete(ctx, entryKey)
+	if current == nil {
+		// The lifecycle rules took the manifest, its versions and thumbnails
+		// go with it.
+		if err := deleteVersions(ctx, s.store, fileName); err != nil {
+			log.Printf("Failed to remove old versions of %s: %v", fileName, err)
+		}
+		if err := deleteThumbnails(ctx, s.store, fileName); err != nil {
+			log.Printf("Failed to remove thumbnails of %s: %v", fileName, err)
+		}
+	}
+	if current == nil && released > 0 {
+		log.Printf("Reclaimed %d chunks of expired file %s", released, fileName)
+	}
+}
+
+// Prefixes the scan has nothing to do in; it jumps over them.
+var scanSkipped = []string{assembliesPrefix, chunksPrefix, refsPrefix, partsPrefix, expiryPrefix, indexPrefix, foldersPrefix, sharesPrefix, versionsPrefix, thumbsPrefix, statusPrefix}
+
+// scanPage reads the next page of the store after the cursor.
+func (s *sweeper) scanPage(ctx context.Context, now time.Time) error {
+	objects, err := s.store.List(ctx, "", s.cursor, sweepPageSize)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if prefix, ok := skippedPrefix(obj.Key); ok {
+			// Everything under prefix sorts before prefix + U+FFFF.
+			s.cursor = prefix + "\uffff"
+			return nil
+		}
+		s.cursor = obj.Key
+		s.scanObject(ctx, obj, now)
+	}
+	if len(objects) < sweepPageSize {
+		s.cursor = "" // wrap around for the next pass
+	}
+	return nil
+}
+
+func skippedPrefix(key string) (string, bool) {
+	for _, prefix := range scanSkipped {
+		if strings.HasPrefix(key, prefix) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+func (s *sweeper) scanObject(ctx context.Context, obj StorageImpl.ObjectInfo, now time.Time) {
+	switch {
+	case strings.HasPrefix(obj.Key, uploadsPrefix):
+		if !strings.HasSuffix(obj.Key, ".json") || now.Sub(obj.LastModified) <= defaultFileTTL {
+			return
+		}
+		fileName := strings.TrimSuffix(strings.TrimPrefix(obj.Key, uploadsPrefix), ".json")
+		if err := abortUpload(ctx, s.store, fileName); err != nil {
+			log.Printf("Failed to abort stale upload %s: %v", fileName, err)
+		} else {
+			log.Printf("Aborted stale upload: %s", fileName)
+		}
+	case strings.HasPrefix(obj.Key, manifestsPrefix):
+		fileName := strings.TrimPrefix(obj.Key, manifestsPrefix)
+		if expires, ok := manifestExpiry(obj); ok && !expires.After(now) {
+			s.expire(ctx, fileName, "", now)
+		}
+	default:
+		if now.Sub(obj.LastModified) <= defaultFileTTL {
+			return
+		}
+		// Files in the older layouts go whole, all legacy chunks at once.
+		// Leftovers next to a newer manifest only take themselves.
+		fileName, ok := legacyFileName(obj.Key)
+		if ok {
+			if _, err := s.store.Stat(ctx, manifestKey(fileName)); err == nil {
+				ok = false
+			}
+		}
+		if !ok {
+			if err := s.store.Delete(ctx, obj.Key); err != nil {
+				log.Printf("Failed to remove expired object %s: %v", obj.Key, err)
+			} else {
+				log.Printf("Removed expired object: %s", obj.Key)
+			}
+			return
+		}
+		if err := deleteFile(ctx, s.store, fileName); err != nil {
+			log.Printf("Failed to remove expired file %s: %v", fileName, err)
+		} else {
+			log.Printf("Removed expired file: %s", fileName)
+		}
+	}
+}
+
+// legacyFileName returns the file a files/ object or _chunk_N object belongs
+// to.
+func legacyFileName(key string) (string, bool) {
+	if strings.HasPrefix(key, filesPrefix) {
+		return strings.TrimPrefix(key, filesPrefix), true
+	}
+	i := strings.LastIndex(key, legacyChunk)
+	if i < 0 {
+		return "", false
+	}
+	if index, err := strconv.Atoi(key[i+len(legacyChunk):]); err != nil || index < 0 {
+		return "", false
+	}
+	return key[:i], true
+}
@@ -0,0 +1,978 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/google/uuid"
+)
+
+// Object layout inside the storage backend:
+//
+//	manifests/<name>              finished files, see fileManifest
+//	chunks/<sha256>, refs/...     deduplicated chunk data (chunks.go)
+//	chunks/<uploadID>             files assembled by upload sessions (assembly.go)
+//	assemblies/<sha256>           where assembled content can be found again
+//	uploads/<name>.json           open upload sessions, see uploadSession
+//	parts/<uploadID>/<N>          pieces received so far by a session
+//	files/<name>                  single-object files from multipart uploads, read-only
+//	<name>_chunk_<N>              files from before either of the above, read-only
+const (
+	manifestsPrefix = "manifests/"
+	uploadsPrefix   = "uploads/"
+	partsPrefix     = "parts/"
+	filesPrefix     = "files/"
+	legacyChunk     = "_chunk_"
+)
+
+func manifestKey(fileName string) string {
+	return manifestsPrefix + fileName
+}
+
+func sessionKey(fileName string) string {
+	return uploadsPrefix + fileName + ".json"
+}
+
+func partKey(uploadID string, number int) string {
+	return fmt.Sprintf("%s%s/%06d", partsPrefix, uploadID, number)
+}
+
+func fileKey(fileName string) string {
+	return filesPrefix + fileName
+}
+
+type manifestChunk struct {
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	// StoredSize and Codec describe the chunk object; StoredSize is zero for
+	// manifests written before compression, meaning "same as Size".
+	StoredSize int64  `json:"storedSize,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+	// Seal is the index an encrypted chunk was sealed with when that isn't
+	// its position in the file: multipart pieces are sealed before their
+	// position is known, with their part number less one, never 0.
+	Seal int `json:"seal,omitempty"`
+	// Object is set for pieces of an assembled object (see assembly.go):
+	// the stored bytes are StoredSize bytes at Offset of chunks/<Object>
+	// rather than a chunk of their own, and references are taken on Object.
+	Object string `json:"object,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	// Part is the backend ETag of a piece sent to an assembly that isn't
+	// complete yet; only part records have it.
+	Part string `json:"part,omitempty"`
+}
+
+// ref is what holders of chunk take their reference on.
+func (c manifestChunk) ref() string {
+	if c.Object != "" {
+		return c.Object
+	}
+	return c.Hash
+}
+
+// sealIndex is the index chunk, at position i of its file, was sealed with.
+func (c manifestChunk) sealIndex(i int) int {
+	if c.Seal != 0 {
+		return c.Seal
+	}
+	return i
+}
+
+// fileManifest is what a file is: its chunks in order. Chunk data is shared
+// between every manifest that lists the same hash.
+type fileManifest struct {
+	Name    string          `json:"name"`
+	Size    int64           `json:"size"`
+	Chunks  []manifestChunk `json:"chunks"`
+	Created time.Time       `json:"created"`
+	// Encryption is set for files encrypted at rest. Chunk i is sealed with
+	// index i, or its Seal, so the order of Chunks is authenticated.
+	Encryption *fileEncryption `json:"encryption,omitempty"`
+	// EncryptedMetadata is the client's sealed metadata blob for end-to-end
+	// encrypted files. It is opaque to the server.
+	EncryptedMetadata []byte `json:"encryptedMetadata,omitempty"`
+	// Expires is when the file is deleted, unless KeepForever is set.
+	// Manifests with neither expire FILE_TTL_HOURS after Created.
+	Expires     time.Time `json:"expires,omitzero"`
+	KeepForever bool      `json:"keepForever,omitempty"`
+	// Owner is the user who uploaded the file, empty for files from before
+	// owners were recorded.
+	Owner string   `json:"owner,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	// Version counts the contents the file has had, see versions.go.
+	Version int `json:"version,omitempty"`
+	// ContentType is the MIME type sniffed when the file was uploaded, empty
+	// for end-to-end encrypted files and files from before it was stored.
+	ContentType string `json:"contentType,omitempty"`
+}
+
+// ETag identifies the content of the file: it only changes when the chunk
+// list does.
+func (m *fileManifest) ETag() string {
+	h := sha256.New()
+	for _, chunk := range m.Chunks {
+		h.Write([]byte(chunk.Hash))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// uploadSession ties a file name to the parts received for it so far. It
+// lives in the store itself so every replica sees the same sessions.
+type uploadSession struct {
+	FileName string    `json:"fileName"`
+	UploadID string    `json:"uploadId"`
+	Codec    string    `json:"codec,omitempty"`
+	Created  time.Time `json:"created"`
+	// Encryption holds the data key parts are sealed with; part N is chunk
+	// N-1 of the finished file.
+	Encryption        *fileEncryption `json:"encryption,omitempty"`
+	EncryptedMetadata []byte          `json:"encryptedMetadata,omitempty"`
+	TTLSeconds        int64           `json:"ttlSeconds,omitempty"`
+	KeepForever       bool            `json:"keepForever,omitempty"`
+	Owner             string          `json:"owner,omitempty"`
+	Tags              []string        `json:"tags,omitempty"`
+	Conflict          uploadConflict  `json:"conflict,omitzero"`
+	ContentType       string          `json:"contentType,omitempty"`
+	// Length and Metadata are set for resumable uploads: the size the file
+	// will have and what the client attached to it.
+	Length   int64  `json:"length,omitempty"`
+	Metadata string `json:"metadata,omitempty"`
+	// Multipart sessions store their parts in pieces, see partPieces.
+	Multipart bool `json:"multipart,omitempty"`
+	// Assembly is the backend upload the file is assembled in, see
+	// assembly.go. Sessions from before, and multipart ones, have none.
+	Assembly string `json:"assembly,omitempty"`
+}
+
+// uploadOptions are the choices made when an upload starts that apply to
+// the whole file. The expiry counts from when the file is committed.
+type uploadOptions struct {
+	Codec             string
+	EncryptedMetadata []byte
+	TTL               time.Duration
+	KeepForever       bool
+	Owner             string
+	Tags              []string
+	Conflict          uploadConflict
+	ContentType       string
+	Length            int64
+	Metadata          string
+	Multipart         bool
+}
+
+func readJSON(ctx context.Context, store StorageImpl.Storage, key string, v any) error {
+	data, err := StorageImpl.ReadAll(ctx, store, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("corrupt object %s: %v", key, err)
+	}
+	return nil
+}
+
+func writeJSON(ctx context.Context, store StorageImpl.Storage, key string, v any, opts StorageImpl.PutOptions) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	opts.ContentType = "application/json"
+	_, err = store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), opts)
+	return err
+}
+
+// Metadata entries commitManifest stores on manifests, so storage accounting,
+// cleanup and listings don't have to read every manifest. The creation time
+// is kept because rewrapping keys rewrites manifests and moves their
+// LastModified. The owner is query-escaped, metadata has to be ASCII.
+const (
+	manifestSizeKey     = "Logical-Size"
+	manifestCreatedKey  = "Created"
+	manifestExpiresKey  = "Expires"
+	manifestOwnerKey    = "Owner"
+	manifestTagsKey     = "Tags"
+	manifestEndToEndKey = "End-To-End"
+	manifestMimeTypeKey = "Mime-Type" // Content-Type would be taken for the object's own
+	manifestETagKey     = "File-Etag"
+)
+
+// manifestSize returns the file size a manifest object describes.
+func manifestSize(ctx context.Context, store StorageImpl.Storage, obj StorageImpl.ObjectInfo) int64 {
+	if size, err := strconv.ParseInt(obj.Metadata[manifestSizeKey], 10, 64); err == nil {
+		return size
+	}
+	manifest, err := loadManifest(ctx, store, strings.TrimPrefix(obj.Key, manifestsPrefix))
+	if err != nil {
+		return 0
+	}
+	return manifest.Size
+}
+
+// manifestCreated returns when the file a manifest object describes was
+// stored.
+func manifestCreated(obj StorageImpl.ObjectInfo) time.Time {
+	if created, err := time.Parse(time.RFC3339, obj.Metadata[manifestCreatedKey]); err == nil {
+		return created
+	}
+	return obj.LastModified
+}
+
+func manifestMetadata(m *fileManifest) map[string]string {
+	metadata := map[string]string{
+		manifestSizeKey:    strconv.FormatInt(m.Size, 10),
+		manifestCreatedKey: m.Created.UTC().Format(time.RFC3339),
+		manifestExpiresKey: expiryMetadata(m),
+		manifestETagKey:    m.ETag(),
+	}
+	if m.Owner != "" {
+		metadata[manifestOwnerKey] = url.QueryEscape(m.Owner)
+	}
+	if len(m.Tags) > 0 {
+		metadata[manifestTagsKey] = strings.Join(m.Tags, ",")
+	}
+	if len(m.EncryptedMetadata) > 0 {
+		metadata[manifestEndToEndKey] = "true"
+	}
+	if m.ContentType != "" {
+		metadata[manifestMimeTypeKey] = m.ContentType
+	}
+	return metadata
+}
+
+func loadManifest(ctx context.Context, store StorageImpl.Storage, fileName string) (*fileManifest, error) {
+	var manifest fileManifest
+	if err := readJSON(ctx, store, manifestKey(fileName), &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+func loadSession(ctx context.Context, store StorageImpl.Storage, fileName string) (*uploadSession, error) {
+	var session uploadSession
+	if err := readJSON(ctx, store, sessionKey(fileName), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// startUpload opens a fresh session for fileName, dropping any unfinished
+// one. The current file stays readable until the new upload completes.
+func startUpload(ctx context.Context, store StorageImpl.Storage, fileName string, opts uploadOptions) (*uploadSession, error) {
+	if err := abortUpload(ctx, store, fileName); err != nil && !StorageImpl.IsNotFound(err) {
+		log.Printf("⚠️  Warning: could not abort previous upload of %s: %v", fileName, err)
+	}
+
+	encryption, err := newFileEncryption()
+	if err != nil {
+		return nil, fmt.Errorf("error creating data key: %v", err)
+	}
+	session := &uploadSession{
+		FileName:          fileName,
+		UploadID:          uuid.NewString(),
+		Codec:             opts.Codec,
+		Created:           time.Now(),
+		Encryption:        encryption,
+		EncryptedMetadata: opts.EncryptedMetadata,
+		TTLSeconds:        int64(opts.TTL / time.Second),
+		KeepForever:       opts.KeepForever,
+		Owner:             opts.Owner,
+		Tags:              opts.Tags,
+		Conflict:          opts.Conflict,
+		ContentType:       opts.ContentType,
+		Length:            opts.Length,
+		Metadata:          opts.Metadata,
+		Multipart:         opts.Multipart,
+	}
+	if !opts.Multipart {
+		session.Assembly, err = startAssembly(ctx, store, session.UploadID)
+		if err != nil {
+			return nil, fmt.Errorf("error starting assembly: %v", err)
+		}
+	}
+	if err := writeJSON(ctx, store, sessionKey(fileName), session, StorageImpl.PutOptions{}); err != nil {
+		abortAssembly(ctx, store, session)
+		return nil, fmt.Errorf("error saving upload session: %v", err)
+	}
+	return session, nil
+}
+
+// listParts returns the parts of a session ordered by part number.
+func listParts(ctx context.Context, store StorageImpl.Storage, uploadID string) ([]int, []manifestChunk, error) {
+	prefix := partsPrefix + uploadID + "/"
+	objects, err := store.List(ctx, prefix, "", 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing parts: %v", err)
+	}
+	type part struct {
+		number int
+		chunk  manifestChunk
+	}
+	var parts []part
+	for _, obj := range objects {
+		number, err := strconv.Atoi(strings.TrimPrefix(obj.Key, prefix))
+		if err != nil {
+			continue
+		}
+		var chunk manifestChunk
+		if err := readJSON(ctx, store, obj.Key, &chunk); err != nil {
+			return nil, nil, err
+		}
+		parts = append(parts, part{number, chunk})
+	}
+	// Keys only sort by number up to six digits; multipart pieces go past.
+	sort.Slice(parts, func(i, j int) bool { return parts[i].number < parts[j].number })
+	numbers := make([]int, len(parts))
+	chunks := make([]manifestChunk, len(parts))
+	for i, p := range parts {
+		numbers[i], chunks[i] = p.number, p.chunk
+	}
+	return numbers, chunks, nil
+}
+
+// putPart stores data as part number of the session. A number <= 0 appends
+// after the highest part uploaded so far. Sending the same part twice
+// replaces it, which makes client retries safe.
+func putPart(ctx context.Context, store StorageImpl.Storage, session *uploadSession, number int, data []byte) (int, error) {
+	if number <= 0 {
+		numbers, _, err := listParts(ctx, store, session.UploadID)
+		if err != nil {
+			return 0, err
+		}
+		number = 1
+		if len(numbers) > 0 {
+			number = numbers[len(numbers)-1] + 1
+		}
+	}
+
+	var previous manifestChunk
+	hadPrevious := readJSON(ctx, store, partKey(session.UploadID, number), &previous) == nil
+
+	aead, err := session.Encryption.AEAD()
+	if err != nil {
+		return 0, err
+	}
+	var seal *chunkSeal
+	if aead != nil {
+		seal = &chunkSeal{aead: aead, index: number - 1}
+	}
+
+	chunk, deduped, err := putPiece(ctx, store, session, number, data, seal)
+	if err != nil {
+		return 0, err
+	}
+	if deduped {
+		log.Printf("Part %d of %s deduplicated against chunk %s", number, session.FileName, chunk.Hash[:12])
+	}
+	if seal != nil && session.Multipart {
+		chunk.Seal = seal.index
+	}
+	if err := writeJSON(ctx, store, partKey(session.UploadID, number), chunk, StorageImpl.PutOptions{}); err != nil {
+		return 0, fmt.Errorf("error saving part: %v", err)
+	}
+	// A replaced chunk is released; a replaced piece of the assembly is
+	// just left out when it completes.
+	if hadPrevious && previous.Part == "" && (chunk.Part != "" || previous.Hash != chunk.Hash) {
+		if err := releaseChunk(ctx, store, previous.Hash, partHolder(session.UploadID, number)); err != nil {
+			log.Printf("⚠️  Warning: could not release replaced part %d of %s: %v", number, session.FileName, err)
+		}
+	}
+	return number, nil
+}
+
+// dropParts releases every part of a session along with its part records.
+// Pieces in the assembly go with it.
+func dropParts(ctx context.Context, store StorageImpl.Storage, uploadID string) error {
+	numbers, chunks, err := listParts(ctx, store, uploadID)
+	if err != nil {
+		return err
+	}
+	for i, number := range numbers {
+		if chunks[i].Part == "" {
+			if err := releaseChunk(ctx, store, chunks[i].Hash, partHolder(uploadID, number)); err != nil {
+				return err
+			}
+		}
+		if err := store.Delete(ctx, partKey(uploadID, number)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func completeUpload(ctx context.Context, store StorageImpl.Storage, fileName string) (*fileManifest, error) {
+	session, err := loadSession(ctx, store, fileName)
+	if err != nil {
+		return nil, err
+	}
+	numbers, chunks, err := listParts(ctx, store, session.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("upload of %s has no parts", fileName)
+	}
+	for i, number := range numbers {
+		if number != i+1 {
+			return nil, fmt.Errorf("upload of %s is missing part %d", fileName, i+1)
+		}
+	}
+	return commitUpload(ctx, store, session, chunks)
+}
+
+// commitUpload makes chunks, which must belong to parts of session, the
+// content of its file and ends the session.
+func commitUpload(ctx context.Context, store StorageImpl.Storage, session *uploadSession, chunks []manifestChunk) (*fileManifest, error) {
+	fileName := session.FileName
+	// The name may have been taken since the upload started. An upload
+	// that can't be stored any more is dropped.
+	target, err := uploadTarget(ctx, store, fileName, session.Owner, session.Conflict)
+	if err == errNameTaken || err == errUploadBusy || err == errPrecondition {
+		if err := abortUpload(ctx, store, fileName); err != nil {
+			log.Printf("⚠️  Warning: could not drop upload of %s: %v", fileName, err)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	chunks, err = assemble(ctx, store, session, chunks, target)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := uploadOptions{TTL: time.Duration(session.TTLSeconds) * time.Second, KeepForever: session.KeepForever}
+	manifest, err := commitManifest(ctx, store, &fileManifest{
+		Name:              target,
+		Chunks:            chunks,
+		Encryption:        session.Encryption,
+		EncryptedMetadata: session.EncryptedMetadata,
+		Expires:           opts.expiresAt(time.Now()),
+		KeepForever:       opts.KeepForever,
+		Owner:             session.Owner,
+		Tags:              session.Tags,
+		ContentType:       session.ContentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The file now holds its own references, so the part ones can go.
+	if err := dropParts(ctx, store, session.UploadID); err != nil {
+		log.Printf("⚠️  Warning: could not remove parts of %s: %v", fileName, err)
+	}
+	if err := store.Delete(ctx, sessionKey(fileName)); err != nil {
+		log.Printf("⚠️  Warning: could not remove upload session of %s: %v", fileName, err)
+	}
+	return manifest, nil
+}
+
+func abortUpload(ctx context.Context, store StorageImpl.Storage, fileName string) error {
+	session, err := loadSession(ctx, store, fileName)
+	if err != nil {
+		return err
+	}
+	if err := dropParts(ctx, store, session.UploadID); err != nil {
+		return fmt.Errorf("error removing parts: %v", err)
+	}
+	if err := abortAssembly(ctx, store, session); err != nil {
+		return err
+	}
+	return store.Delete(ctx, sessionKey(fileName))
+}
+
+// putWholeFile stores data as the complete file in one request.
+func putWholeFile(ctx context.Context, store StorageImpl.Storage, fileName string, data []byte, opts uploadOptions) (*fileManifest, error) {
+	if err := abortUpload(ctx, store, fileName); err != nil && !StorageImpl.IsNotFound(err) {
+		log.Printf("⚠️  Warning: could not abort previous upload of %s: %v", fileName, err)
+	}
+	encryption, err := newFileEncryption()
+	if err != nil {
+		return nil, fmt.Errorf("error creating data key: %v", err)
+	}
+	aead, err := encryption.AEAD()
+	if err != nil {
+		return nil, err
+	}
+	var seal *chunkSeal
+	if aead != nil {
+		seal = &chunkSeal{aead: aead, index: 0}
+	}
+	chunk, _, err := putChunk(ctx, store, data, fileHolder(fileName), opts.Codec, seal)
+	if err != nil {
+		return nil, err
+	}
+	return commitManifest(ctx, store, &fileManifest{
+		Name:              fileName,
+		Chunks:            []manifestChunk{chunk},
+		Encryption:        encryption,
+		EncryptedMetadata: opts.EncryptedMetadata,
+		Expires:           opts.expiresAt(time.Now()),
+		KeepForever:       opts.KeepForever,
+		Owner:             opts.Owner,
+		Tags:              opts.Tags,
+		ContentType:       opts.ContentType,
+	})
+}
+
+// commitManifest makes manifest.Chunks the content of manifest.Name; Size and
+// Created are filled in here. References for the new chunks are taken before
+// the manifest is written and the ones only the previous version used are
+// released after, so readers never see a manifest pointing at deleted chunks.
+func commitManifest(ctx context.Context, store StorageImpl.Storage, manifest *fileManifest) (*fileManifest, error) {
+	fileName := manifest.Name
+	holder := fileHolder(fileName)
+	keep := make(map[string]bool, len(manifest.Chunks))
+	manifest.Size, manifest.Created = 0, time.Now()
+	for _, chunk := range manifest.Chunks {
+		if !keep[chunk.ref()] {
+			if err := addRef(ctx, store, chunk.ref(), holder); err != nil {
+				return nil, fmt.Errorf("error adding chunk reference: %v", err)
+			}
+			keep[chunk.ref()] = true
+		}
+		manifest.Size += chunk.Size
+	}
+
+	previous, err := loadManifest(ctx, store, fileName)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		return nil, err
+	}
+	if previous != nil {
+		// New content doesn't make a new owner: whoever may replace a file
+		// (see checkReplace) isn't necessarily its owner.
+		if previous.Owner != "" {
+			manifest.Owner = previous.Owner
+		}
+		manifest.Version = previous.Number() + 1
+		if versioningEnabled() {
+			if err := archiveVersion(ctx, store, previous); err != nil {
+				return nil, fmt.Errorf("error keeping previous version: %v", err)
+			}
+		}
+	} else {
+		// Versions left behind by a file whose manifest the lifecycle rules
+		// took aren't this file's history.
+		if err := deleteVersions(ctx, store, fileName); err != nil {
+			log.Printf("⚠️  Warning: could not remove stale versions of %s: %v", fileName, err)
+		}
+		manifest.Version = max(manifest.Version, 1)
+	}
+	// The expiry entry goes first: one without a manifest only makes the
+	// sweeper give back chunks nobody holds, a manifest without one would
+	// only be found by its scan.
+	if err := writeExpiryEntry(ctx, store, manifest); err != nil {
+		return nil, fmt.Errorf("error saving expiry: %v", err)
+	}
+	// Index entries without a manifest are skipped by listings.
+	if err := writeIndexEntries(ctx, store, manifest); err != nil {
+		return nil, fmt.Errorf("error indexing file: %v", err)
+	}
+	if err := writeJSON(ctx, store, manifestKey(fileName), manifest, StorageImpl.PutOptions{
+		Metadata: manifestMetadata(manifest),
+		Tags:     expiryTags(manifest, manifest.Created),
+	}); err != nil {
+		return nil, fmt.Errorf("error saving manifest: %v", err)
+	}
+	if previous != nil {
+		if old, ok := previous.entryKey(); ok {
+			store.Delete(ctx, old)
+		}
+		dropIndexEntries(ctx, store, previous, manifest)
+	}
+
+	if previous != nil {
+		for _, chunk := range previous.Chunks {
+			if keep[chunk.ref()] {
+				continue
+			}
+			keep[chunk.ref()] = true // release each old hash only once
+			if err := releaseChunk(ctx, store, chunk.ref(), holder); err != nil {
+				log.Printf("⚠️  Warning: could not release chunk %s of %s: %v", chunk.ref(), fileName, err)
+			}
+		}
+	}
+	// Copies in the older layouts would otherwise linger until the TTL removes them.
+	if err := store.Delete(ctx, fileKey(fileName)); err != nil && !StorageImpl.IsNotFound(err) {
+		log.Printf("⚠️  Warning: could not remove old copy of %s: %v", fileName, err)
+	}
+	if err := clearLegacyChunks(ctx, store, fileName); err != nil {
+		log.Printf("⚠️  Warning: could not remove legacy chunks of %s: %v", fileName, err)
+	}
+	if previous != nil {
+		if _, err := pruneVersions(ctx, store, fileName, manifest.Created); err != nil {
+			log.Printf("⚠️  Warning: could not prune old versions of %s: %v", fileName, err)
+		}
+	}
+	return manifest, nil
+}
+
+// deleteFile removes fileName in whatever layout it is stored, releasing the
+// chunks of a manifest. The manifest goes first, so readers see the whole
+// file disappear at once rather than losing chunks one by one.
+func deleteFile(ctx context.Context, store StorageImpl.Storage, fileName string) error {
+	manifest, err := loadManifest(ctx, store, fileName)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		return err
+	}
+	if manifest != nil {
+		if err := store.Delete(ctx, manifestKey(fileName)); err != nil {
+			return err
+		}
+		if key, ok := manifest.entryKey(); ok {
+			store.Delete(ctx, key)
+		}
+		dropIndexEntries(ctx, store, manifest, nil)
+		released := map[string]bool{}
+		for _, chunk := range manifest.Chunks {
+			if released[chunk.ref()] {
+				continue
+			}
+			released[chunk.ref()] = true
+			if err := releaseChunk(ctx, store, chunk.ref(), fileHolder(fileName)); err != nil {
+				log.Printf("⚠️  Warning: could not release chunk %s of %s: %v", chunk.ref(), fileName, err)
+			}
+		}
+	}
+	if err := deleteVersions(ctx, store, fileName); err != nil {
+		return err
+	}
+	if err := deleteThumbnails(ctx, store, fileName); err != nil {
+		log.Printf("⚠️  Warning: could not remove thumbnails of %s: %v", fileName, err)
+	}
+	if err := store.Delete(ctx, fileKey(fileName)); err != nil && !StorageImpl.IsNotFound(err) {
+		return err
+	}
+	return clearLegacyChunks(ctx, store, fileName)
+}
+
+// copyManifest makes dst a file with the content of src. Only a manifest is
+// written: dst takes its own references to src's chunks, and shares its data
+// key when it is encrypted, since chunks are sealed by position and not by
+// name. The copy keeps src's expiry and tags and belongs to owner.
+func copyManifest(ctx context.Context, store StorageImpl.Storage, src *fileManifest, dst, owner string) (*fileManifest, error) {
+	return commitManifest(ctx, store, copyOf(src, dst, owner))
+}
+
+func copyOf(src *fileManifest, dst, owner string) *fileManifest {
+	copied := &fileManifest{
+		Name:              dst,
+		Chunks:            src.Chunks,
+		Encryption:        src.Encryption,
+		EncryptedMetadata: src.EncryptedMetadata,
+		KeepForever:       src.KeepForever,
+		Owner:             owner,
+		Tags:              src.Tags,
+		ContentType:       src.ContentType,
+	}
+	if expires, ok := src.ExpiresAt(); ok {
+		copied.Expires = expires
+	}
+	return copied
+}
+
+// renameFile moves src to dst. The new name is committed before the old one
+// is deleted, so a crash in between leaves both rather than neither. The
+// old versions of src move along, unless dst is replaced and keeps its own.
+func renameFile(ctx context.Context, store StorageImpl.Storage, src *fileManifest, dst string) (*fileManifest, error) {
+	_, err := loadManifest(ctx, store, dst)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		return nil, err
+	}
+	replacing := err == nil
+	renamed := copyOf(src, dst, src.Owner)
+	if !replacing {
+		renamed.Version = src.Number()
+	}
+	renamed, err = commitManifest(ctx, store, renamed)
+	if err != nil {
+		return nil, err
+	}
+	if !replacing {
+		if err := moveVersions(ctx, store, src.Name, dst); err != nil {
+			log.Printf("⚠️  Warning: could not move old versions of %s: %v", src.Name, err)
+		}
+	}
+	if err := deleteFile(ctx, store, src.Name); err != nil {
+		return nil, fmt.Errorf("%s was copied to %s but could not be removed: %v", src.Name, dst, err)
+	}
+	return renamed, nil
+}
+
+// listLegacyChunks returns the _chunk_N objects of fileName ordered by N.
+func listLegacyChunks(ctx context.Context, store StorageImpl.Storage, fileName string) ([]StorageImpl.ObjectInfo, error) {
+	prefix := fileName + legacyChunk
+	objects, err := store.List(ctx, prefix, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects: %v", err)
+	}
+	type indexed struct {
+		index int
+		obj   StorageImpl.ObjectInfo
+	}
+	var chunks []indexed
+	for _, obj := range objects {
+		// The prefix also matches chunks of files named "<fileName>_chunk_x...".
+		index, err := strconv.Atoi(strings.TrimPrefix(obj.Key, prefix))
+		if err != nil || index < 0 {
+			continue
+		}
+		chunks = append(chunks, indexed{index, obj})
+	}
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].index < chunks[j].index })
+
+	out := make([]StorageImpl.ObjectInfo, len(chunks))
+	for i, chunk := range chunks {
+		out[i] = chunk.obj
+	}
+	return out, nil
+}
+
+func clearLegacyChunks(ctx context.Context, store StorageImpl.Storage, fileName string) error {
+	chunks, err := listLegacyChunks(ctx, store, fileName)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if err := store.Delete(ctx, chunk.Key); err != nil {
+			return fmt.Errorf("error removing %s: %v", chunk.Key, err)
+		}
+	}
+	return nil
+}
+
+// segment is one stored object of a file, or for assembled files one piece
+// of it, StoredSize bytes at Offset. Size is the decoded size. Segments of
+// encrypted files carry the file's cipher and their chunk index.
+type segment struct {
+	Key        string
+	Size       int64
+	StoredSize int64
+	Codec      string
+	Index      int
+	Offset     int64
+	Assembled  bool
+	aead       cipher.AEAD
+}
+
+// getStored opens the stored bytes of seg.
+func (s segment) getStored(ctx context.Context, store StorageImpl.Storage) (io.ReadCloser, error) {
+	if s.Assembled {
+		return store.Get(ctx, s.Key, s.Offset, s.StoredSize)
+	}
+	return store.Get(ctx, s.Key, 0, -1)
+}
+
+// continues reports whether next is the piece after s in the same object,
+// both plain, so that one ranged Get reads through the two.
+func (s segment) continues(next segment) bool {
+	return s.Assembled && next.Assembled && s.Key == next.Key && s.Offset+s.StoredSize == next.Offset &&
+		s.Codec == codecNone && s.aead == nil && next.Codec == codecNone && next.aead == nil
+}
+
+// storedFile is a readable file: its bytes are the concatenation of its
+// segments. Chunked files (manifests and legacy _chunk_N files) have one
+// segment per chunk, which for assembled files are ranges of one object;
+// multipart-era files are a single object.
+type storedFile struct {
+	Name     string
+	Size     int64
+	Segments []segment
+	Chunked  bool
+	// EncryptedMetadata is set for end-to-end encrypted files.
+	EncryptedMetadata []byte
+	// Expires is when the file will be deleted, zero if never.
+	Expires time.Time
+	// ContentType is the stored MIME type, or one guessed from the name.
+	ContentType string
+	// ETag is the manifest's, empty for files from before manifests.
+	ETag     string
+	Modified time.Time
+}
+
+func openFile(ctx context.Context, store StorageImpl.Storage, fileName string) (*storedFile, error) {
+	manifest, err := loadManifest(ctx, store, fileName)
+	if err == nil {
+		if expires, ok := manifest.ExpiresAt(); ok && !expires.After(time.Now()) {
+			// Expired but not deleted yet, by the store or the sweeper.
+			return nil, StorageImpl.ErrNotFound
+		}
+		return manifestFile(manifest)
+	}
+	if !StorageImpl.IsNotFound(err) {
+		return nil, err
+	}
+
+	info, err := store.Stat(ctx, fileKey(fileName))
+	if err == nil {
+		return &storedFile{
+			Name:        fileName,
+			Size:        info.Size,
+			Segments:    []segment{{Key: info.Key, Size: info.Size, StoredSize: info.Size}},
+			Expires:     info.LastModified.Add(defaultFileTTL),
+			ContentType: contentTypeByName(fileName),
+			Modified:    info.LastModified,
+		}, nil
+	}
+	if !StorageImpl.IsNotFound(err) {
+		return nil, err
+	}
+
+	chunks, err := listLegacyChunks(ctx, store, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, StorageImpl.ErrNotFound
+	}
+	file := &storedFile{Name: fileName, Chunked: true, Expires: chunks[0].LastModified.Add(defaultFileTTL), ContentType: contentTypeByName(fileName), Modified: chunks[0].LastModified}
+	for _, chunk := range chunks {
+		file.Segments = append(file.Segments, segment{Key: chunk.Key, Size: chunk.Size, StoredSize: chunk.Size})
+		file.Size += chunk.Size
+	}
+	return file, nil
+}
+
+// manifestFile returns the file made of the chunks of manifest.
+func manifestFile(manifest *fileManifest) (*storedFile, error) {
+	aead, err := manifest.Encryption.AEAD()
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", manifest.Name, err)
+	}
+	file := &storedFile{
+		Name:              manifest.Name,
+		Size:              manifest.Size,
+		Chunked:           true,
+		EncryptedMetadata: manifest.EncryptedMetadata,
+		ContentType:       manifest.ContentType,
+		ETag:              manifest.ETag(),
+		Modified:          manifest.Created,
+	}
+	if file.ContentType == "" && len(manifest.EncryptedMetadata) == 0 {
+		file.ContentType = contentTypeByName(manifest.Name)
+	}
+	if expires, ok := manifest.ExpiresAt(); ok {
+		file.Expires = expires
+	}
+	for i, chunk := range manifest.Chunks {
+		file.Segments = append(file.Segments, segment{
+			Key:        chunkObjectKey(chunk.ref()),
+			Size:       chunk.Size,
+			StoredSize: chunk.StoredSize,
+			Codec:      chunk.Codec,
+			Index:      chunk.sealIndex(i),
+			Offset:     chunk.Offset,
+			Assembled:  chunk.Object != "",
+			aead:       aead,
+		})
+	}
+	return file, nil
+}
+
+// segmentReader reads a byte range of a storedFile, opening one ranged Get
+// per segment as it goes so only the current segment is ever in flight.
+// Plain pieces that follow each other in an assembled object are read with
+// a single Get.
+type segmentReader struct {
+	ctx       context.Context
+	store     StorageImpl.Storage
+	segments  []segment
+	offset    int64 // offset into segments[0]
+	remaining int64
+	current   io.ReadCloser
+}
+
+func (f *storedFile) NewReader(ctx context.Context, store StorageImpl.Storage, offset, length int64) io.ReadCloser {
+	if length < 0 || offset+length > f.Size {
+		length = f.Size - offset
+	}
+	segments := f.Segments
+	for len(segments) > 0 && offset >= segments[0].Size {
+		offset -= segments[0].Size
+		segments = segments[1:]
+	}
+	return &segmentReader{ctx: ctx, store: store, segments: segments, offset: offset, remaining: length}
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if r.current == nil {
+			if len(r.segments) == 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			seg := r.segments[0]
+			length := seg.Size - r.offset
+			next := 1
+			for ; next < len(r.segments) && length < r.remaining && r.segments[next-1].continues(r.segments[next]); next++ {
+				length += r.segments[next].Size
+			}
+			if length > r.remaining {
+				length = r.remaining
+			}
+			rc, err := openSegment(r.ctx, r.store, seg, r.offset, length)
+			if err != nil {
+				return 0, err
+			}
+			r.current = rc
+			r.segments = r.segments[next:]
+			r.offset = 0
+		}
+
+		if int64(len(p)) > r.remaining {
+			p = p[:r.remaining]
+		}
+		n, err := r.current.Read(p)
+		r.remaining -= int64(n)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// openSegment returns length decoded bytes of seg starting at offset; plain
+// segments can be read on into the pieces that continue them. Compressed and
+// encrypted segments can't be read from the middle, so they are fetched,
+// decrypted and decoded whole; chunks are bounded by chunkSize so that stays
+// cheap.
+func openSegment(ctx context.Context, store StorageImpl.Storage, seg segment, offset, length int64) (io.ReadCloser, error) {
+	if seg.Codec == codecNone && seg.aead == nil {
+		return store.Get(ctx, seg.Key, seg.Offset+offset, length)
+	}
+	stored, err := readStored(ctx, store, seg)
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeChunk(stored, seg.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", seg.Key, err)
+	}
+	if offset+length > int64(len(data)) {
+		return nil, fmt.Errorf("chunk %s decoded to %d bytes, expected %d", seg.Key, len(data), seg.Size)
+	}
+	return io.NopCloser(bytes.NewReader(data[offset : offset+length])), nil
+}
+
+func (r *segmentReader) Close() error {
+	if r.current != nil {
+		return r.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/paths"
+)
+
+// Files are named by slash-separated paths and folders group them. A file
+// "a/b/c" lies in folder "a/b", whose record lives next to the manifests:
+//
+//	folders/<path>    one folder record per folder
+//	shares/<token>    share links, pointing at a folder
+//
+// Folder paths are query-escaped so a record is never below another one;
+// as in the index, a path prefix is still a key prefix. Manifest keys keep
+// the slashes, which is why a name can't be a file and a folder at once.
+const (
+	foldersPrefix = "folders/"
+	sharesPrefix  = "shares/"
+)
+
+const (
+	maxFolderMembers = 100
+	// maxSubfolders bounds the subfolders a listing returns.
+	maxSubfolders = 1000
+)
+
+var (
+	errFolderNotEmpty = errors.New("folder is not empty")
+	errBadShareToken  = errors.New("invalid share token")
+)
+
+// folder is the record of a folder. Whoever owns a folder, or one above
+// it, manages everything in it; members can read it and upload into it.
+type folder struct {
+	Path    string    `json:"path"`
+	Owner   string    `json:"owner"`
+	Members []string  `json:"members,omitempty"`
+	Created time.Time `json:"created"`
+	// Shares are the tokens of the folder's share links, so they can follow
+	// it when it moves and go with it when it is deleted.
+	Shares []string `json:"shares,omitempty"`
+}
+
+// folderShare is a share link: its token is the key.
+type folderShare struct {
+	Folder    string    `json:"folder"`
+	CreatedBy string    `json:"createdBy"`
+	Created   time.Time `json:"created"`
+}
+
+func folderKey(path string) string {
+	return foldersPrefix + url.QueryEscape(path)
+}
+
+func shareKey(token string) string {
+	return sharesPrefix + token
+}
+
+func loadFolder(ctx context.Context, store StorageImpl.Storage, path string) (*folder, error) {
+	var fd folder
+	if err := readJSON(ctx, store, folderKey(path), &fd); err != nil {
+		return nil, err
+	}
+	return &fd, nil
+}
+
+func saveFolder(ctx context.Context, store StorageImpl.Storage, fd *folder) error {
+	return writeJSON(ctx, store, folderKey(fd.Path), fd, StorageImpl.PutOptions{})
+}
+
+func isFolder(ctx context.Context, store StorageImpl.Storage, path string) (bool, error) {
+	_, err := store.Stat(ctx, folderKey(path))
+	if StorageImpl.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// isFile reports whether a manifest is stored under name, expired or not.
+func isFile(ctx context.Context, store StorageImpl.Storage, name string) (bool, error) {
+	_, err := store.Stat(ctx, manifestKey(name))
+	if StorageImpl.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// fileAbove returns the first folder of name that is taken by a file.
+func fileAbove(ctx context.Context, store StorageImpl.Storage, name string) (string, error) {
+	for _, parent := range paths.Parents(name) {
+		taken, err := isFile(ctx, store, parent)
+		if err != nil || taken {
+			return parent, err
+		}
+	}
+	return "", nil
+}
+
+// folderChain returns the records of path and of the folders above it,
+// outermost first. Folders without a record are left out.
+func folderChain(ctx context.Context, store StorageImpl.Storage, path string) ([]*folder, error) {
+	if path == "" {
+		return nil, nil
+	}
+	var chain []*folder
+	for _, p := range append(paths.Parents(path), path) {
+		fd, err := loadFolder(ctx, store, p)
+		if StorageImpl.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, fd)
+	}
+	return chain, nil
+}
+
+type folderAccess int
+
+const (
+	accessNone folderAccess = iota
+	accessMember
+	accessManager
+)
+
+// accessTo works out what user can do in the folder chain leads to. The
+// root, and folders nobody has a record for, are open to everyone.
+func accessTo(chain []*folder, user string, isAdmin bool) folderAccess {
+	if isAdmin {
+		return accessManager
+	}
+	if len(chain) == 0 {
+		return accessMember
+	}
+	access := accessNone
+	for _, fd := range chain {
+		if user == "" {
+			break
+		}
+		if fd.Owner == user {
+			return accessManager
+		}
+		if slices.Contains(fd.Members, user) {
+			access = accessMember
+		}
+	}
+	return access
+}
+
+// makeFolders creates the records missing among folders, for owner.
+func makeFolders(ctx context.Context, store StorageImpl.Storage, folders []string, owner string) error {
+	for _, path := range folders {
+		exists, err := isFolder(ctx, store, path)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := saveFolder(ctx, store, &folder{Path: path, Owner: owner, Created: time.Now().UTC()}); err != nil {
+			return fmt.Errorf("error creating folder %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// normalizeMembers trims, deduplicates and sorts the members of a folder.
+func normalizeMembers(members []string) ([]string, error) {
+	var out []string
+	for _, member := range members {
+		member = strings.TrimSpace(member)
+		if member != "" && !slices.Contains(out, member) {
+			out = append(out, member)
+		}
+	}
+	if len(out) > maxFolderMembers {
+		return nil, fmt.Errorf("a folder can have at most %d members", maxFolderMembers)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// subfolders returns the folders directly in path, "" being the root.
+func subfolders(ctx context.Context, store StorageImpl.Storage, path string) ([]*folder, error) {
+	prefix := foldersPrefix
+	if path != "" {
+		prefix = folderKey(path + "/")
+	}
+	var out []*folder
+	err := listAll(ctx, store, prefix, func(obj StorageImpl.ObjectInfo) error {
+		name, err := url.QueryUnescape(strings.TrimPrefix(obj.Key, foldersPrefix))
+		if err != nil || paths.Dir(name) != path || len(out) == maxSubfolders {
+			return nil
+		}
+		fd, err := loadFolder(ctx, store, name)
+		if StorageImpl.IsNotFound(err) {
+			return nil // removed while listing
+		}
+		if err != nil {
+			return err
+		}
+		out = append(out, fd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// folderTree returns the record of path and those of every folder below
+// it, outermost first.
+func folderTree(ctx context.Context, store StorageImpl.Storage, path string) ([]*folder, error) {
+	root, err := loadFolder(ctx, store, path)
+	if err != nil {
+		return nil, err
+	}
+	tree := []*folder{root}
+	err = listAll(ctx, store, folderKey(path+"/"), func(obj StorageImpl.ObjectInfo) error {
+		name, err := url.QueryUnescape(strings.TrimPrefix(obj.Key, foldersPrefix))
+		if err != nil {
+			return nil
+		}
+		fd, err := loadFolder(ctx, store, name)
+		if StorageImpl.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		tree = append(tree, fd)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tree, func(i, j int) bool { return tree[i].Path < tree[j].Path })
+	return tree, nil
+}
+
+// filesIn returns the names of every file below path, in folders below it
+// too.
+func filesIn(ctx context.Context, store StorageImpl.Storage, path string) ([]string, error) {
+	var names []string
+	err := listAll(ctx, store, manifestKey(path+"/"), func(obj StorageImpl.ObjectInfo) error {
+		names = append(names, strings.TrimPrefix(obj.Key, manifestsPrefix))
+		return nil
+	})
+	return names, err
+}
+
+// moveFolder moves src and everything below it to dst, which must be free.
+// The new folder records go first, then the files one by one like
+// renameFile, then the old records, so an interrupted move can be finished
+// by moving src again.
+func moveFolder(ctx context.Context, store StorageImpl.Storage, src, dst string) (*folder, int, error) {
+	tree, err := folderTree(ctx, store, src)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, fd := range tree {
+		moved := *fd
+		moved.Path = dst + strings.TrimPrefix(fd.Path, src)
+		if err := saveFolder(ctx, store, &moved); err != nil {
+			return nil, 0, fmt.Errorf("error creating folder %s: %v", moved.Path, err)
+		}
+		for _, token := range fd.Shares {
+			var share folderShare
+			if err := readJSON(ctx, store, shareKey(token), &share); err != nil {
+				continue
+			}
+			share.Folder = moved.Path
+			if err := writeJSON(ctx, store, shareKey(token), &share, StorageImpl.PutOptions{}); err != nil {
+				return nil, 0, fmt.Errorf("error moving share link of %s: %v", fd.Path, err)
+			}
+		}
+	}
+
+	names, err := filesIn(ctx, store, src)
+	if err != nil {
+		return nil, 0, err
+	}
+	moved := 0
+	for _, name := range names {
+		manifest, err := loadManifest(ctx, store, name)
+		if StorageImpl.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, moved, err
+		}
+		if _, err := renameFile(ctx, store, manifest, dst+strings.TrimPrefix(name, src)); err != nil {
+			return nil, moved, err
+		}
+		moved++
+	}
+
+	for i := len(tree) - 1; i >= 0; i-- {
+		if err := store.Delete(ctx, folderKey(tree[i].Path)); err != nil {
+			log.Printf("⚠️  Warning: could not remove folder %s after moving it: %v", tree[i].Path, err)
+		}
+	}
+	root := *tree[0]
+	root.Path = dst
+	return &root, moved, nil
+}
+
+// deleteFolder removes path, which has to be empty unless recursive is
+// set, and returns how many files went with it.
+func deleteFolder(ctx context.Context, store StorageImpl.Storage, path string, recursive bool) (int, error) {
+	tree, err := folderTree(ctx, store, path)
+	if err != nil {
+		return 0, err
+	}
+	names, err := filesIn(ctx, store, path)
+	if err != nil {
+		return 0, err
+	}
+	if !recursive && (len(tree) > 1 || len(names) > 0) {
+		return 0, errFolderNotEmpty
+	}
+
+	for i, name := range names {
+		if err := deleteFile(ctx, store, name); err != nil {
+			return i, fmt.Errorf("error deleting %s: %v", name, err)
+		}
+	}
+	for i := len(tree) - 1; i >= 0; i-- {
+		for _, token := range tree[i].Shares {
+			store.Delete(ctx, shareKey(token))
+		}
+		if err := store.Delete(ctx, folderKey(tree[i].Path)); err != nil {
+			return len(names), fmt.Errorf("error deleting folder %s: %v", tree[i].Path, err)
+		}
+	}
+	return len(names), nil
+}
+
+// newShareToken returns 128 random bits, base64url encoded.
+func newShareToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func validShareToken(token string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	return err == nil && len(decoded) == 16
+}
+
+// shareFolder creates a share link for fd.
+func shareFolder(ctx context.Context, store StorageImpl.Storage, fd *folder, user string) (string, error) {
+	token, err := newShareToken()
+	if err != nil {
+		return "", err
+	}
+	share := folderShare{Folder: fd.Path, CreatedBy: user, Created: time.Now().UTC()}
+	if err := writeJSON(ctx, store, shareKey(token), &share, StorageImpl.PutOptions{}); err != nil {
+		return "", err
+	}
+	fd.Shares = append(fd.Shares, token)
+	if err := saveFolder(ctx, store, fd); err != nil {
+		store.Delete(ctx, shareKey(token))
+		return "", err
+	}
+	return token, nil
+}
+
+func loadShare(ctx context.Context, store StorageImpl.Storage, token string) (*folderShare, error) {
+	if !validShareToken(token) {
+		return nil, errBadShareToken
+	}
+	var share folderShare
+	if err := readJSON(ctx, store, shareKey(token), &share); err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+// revokeShare deletes the share link token of fd.
+func revokeShare(ctx context.Context, store StorageImpl.Storage, fd *folder, token string) error {
+	if err := store.Delete(ctx, shareKey(token)); err != nil {
+		return err
+	}
+	fd.Shares = slices.DeleteFunc(fd.Shares, func(t string) bool { return t == token })
+	return saveFolder(ctx, store, fd)
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+const (
+	// gcInterval is how often the leader looks for orphaned chunks.
+	gcInterval = 6 * time.Hour
+	// orphanGrace protects new objects: uploads take their references before
+	// writing the manifest or part record that accounts for them, and store
+	// chunks before their first reference is visible to a listing.
+	orphanGrace = time.Hour
+)
+
+// collectOrphans is the "orphan-gc" job. Crashes and failed requests can
+// leave references whose holder is gone; this releases them, and deletes the
+// chunks nobody references, which only ever happens here (see releaseChunk).
+// It also forgets assembled contents whose object is gone, and drops
+// metadata index entries of files that are gone or no longer have that
+// owner or tag, such as files the store expired, and thumbnails of content
+// the file no longer has.
+func collectOrphans(ctx context.Context, store StorageImpl.Storage) error {
+	cutoff := time.Now().Add(-orphanGrace)
+	var refs, chunks, assemblies, entries, thumbs int
+
+	err := listAll(ctx, store, refsPrefix, func(obj StorageImpl.ObjectInfo) error {
+		if obj.LastModified.After(cutoff) {
+			return nil
+		}
+		hash, holder, ok := parseRefKey(obj.Key)
+		if !ok {
+			return nil
+		}
+		held, err := holderHolds(ctx, store, holder, hash)
+		if err != nil || held {
+			return err
+		}
+		if err := releaseChunk(ctx, store, hash, holder); err != nil {
+			return err
+		}
+		log.Printf("Released orphaned reference of %s to chunk %s", holder, hash)
+		refs++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error collecting references: %v", err)
+	}
+
+	err = listAll(ctx, store, chunksPrefix, func(obj StorageImpl.ObjectInfo) error {
+		if obj.LastModified.After(cutoff) {
+			return nil
+		}
+		hash := strings.TrimPrefix(obj.Key, chunksPrefix)
+		removed, err := reclaimChunk(ctx, store, hash)
+		if err != nil || !removed {
+			return err
+		}
+		log.Printf("Removed orphaned chunk %s", hash)
+		chunks++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error collecting chunks: %v", err)
+	}
+
+	err = listAll(ctx, store, assembliesPrefix, func(obj StorageImpl.ObjectInfo) error {
+		if obj.LastModified.After(cutoff) {
+			return nil
+		}
+		var chunks []manifestChunk
+		if err := readJSON(ctx, store, obj.Key, &chunks); err != nil && !StorageImpl.IsNotFound(err) {
+			return err
+		}
+		if len(chunks) > 0 {
+			_, err := store.Stat(ctx, chunkObjectKey(chunks[0].ref()))
+			if !StorageImpl.IsNotFound(err) {
+				return err
+			}
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil && !StorageImpl.IsNotFound(err) {
+			return err
+		}
+		assemblies++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error collecting assembled contents: %v", err)
+	}
+
+	err = listAll(ctx, store, indexPrefix, func(obj StorageImpl.ObjectInfo) error {
+		if obj.LastModified.After(cutoff) {
+			return nil
+		}
+		current, err := indexEntryCurrent(ctx, store, obj.Key)
+		if err != nil || current {
+			return err
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil && !StorageImpl.IsNotFound(err) {
+			return err
+		}
+		entries++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error collecting index entries: %v", err)
+	}
+
+	err = listAll(ctx, store, thumbsPrefix, func(obj StorageImpl.ObjectInfo) error {
+		if obj.LastModified.After(cutoff) {
+			return nil
+		}
+		current, err := thumbnailCurrent(ctx, store, obj.Key)
+		if err != nil || current {
+			return err
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil && !StorageImpl.IsNotFound(err) {
+			return err
+		}
+		thumbs++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error collecting thumbnails: %v", err)
+	}
+
+	if refs > 0 || chunks > 0 || assemblies > 0 || entries > 0 || thumbs > 0 {
+		log.Printf("Orphan collection released %d references, removed %d chunks, %d stale assembled contents, %d stale index entries and %d stale thumbnails", refs, chunks, assemblies, entries, thumbs)
+	}
+	return nil
+}
+
+// reclaimChunk deletes the chunk hash if nothing references it. An upload
+// can take a reference and find the chunk between the count and the delete
+// (see putChunk), so the chunk is read first and put back when a reference
+// shows up afterwards. Assembled objects are too big to hold on to; uploads
+// only reuse those a file still references (see reuseAssembly).
+func reclaimChunk(ctx context.Context, store StorageImpl.Storage, hash string) (bool, error) {
+	count, err := refCount(ctx, store, hash)
+	if err != nil || count > 0 {
+		return false, err
+	}
+	key := chunkObjectKey(hash)
+	info, err := store.Stat(ctx, key)
+	if StorageImpl.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Metadata[chunkAssembledKey] != "" {
+		if err := store.Delete(ctx, key); err != nil && !StorageImpl.IsNotFound(err) {
+			return false, err
+		}
+		return true, nil
+	}
+	data, err := StorageImpl.ReadAll(ctx, store, key)
+	if err != nil {
+		return false, err
+	}
+	if err := store.Delete(ctx, key); err != nil && !StorageImpl.IsNotFound(err) {
+		return false, err
+	}
+
+	count, err = refCount(ctx, store, hash)
+	if err == nil && count == 0 {
+		return true, nil
+	}
+	if _, err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), StorageImpl.PutOptions{
+		ContentType: info.ContentType,
+		Metadata:    info.Metadata,
+	}); err != nil {
+		return false, fmt.Errorf("error restoring chunk %s: %v", hash, err)
+	}
+	log.Printf("Kept chunk %s, which was referenced while it was being removed", hash)
+	return false, nil
+}
+
+func parseRefKey(key string) (string, string, bool) {
+	hash, escaped, ok := strings.Cut(strings.TrimPrefix(key, refsPrefix), "/")
+	if !ok {
+		return "", "", false
+	}
+	holder, err := url.QueryUnescape(escaped)
+	if err != nil {
+		return "", "", false
+	}
+	return hash, holder, true
+}
+
+// holderHolds reports whether holder still accounts for a reference to
+// hash. Holders it doesn't recognise are assumed to.
+func holderHolds(ctx context.Context, store StorageImpl.Storage, holder, hash string) (bool, error) {
+	if fileName, ok := strings.CutPrefix(holder, "file:"); ok {
+		manifest, err := loadManifest(ctx, store, fileName)
+		if StorageImpl.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, chunk := range manifest.Chunks {
+			if chunk.ref() == hash {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if part, ok := strings.CutPrefix(holder, "part:"); ok {
+		i := strings.LastIndex(part, ":")
+		if i < 0 {
+			return true, nil
+		}
+		number, err := strconv.Atoi(part[i+1:])
+		if err != nil {
+			return true, nil
+		}
+		var chunk manifestChunk
+		err = readJSON(ctx, store, partKey(part[:i], number), &chunk)
+		if StorageImpl.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return chunk.Part == "" && chunk.ref() == hash, nil
+	}
+	if fileName, number, ok := parseVersionHolder(holder); ok {
+		version, err := loadVersion(ctx, store, fileName, number)
+		if StorageImpl.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		for _, chunk := range version.Chunks {
+			if chunk.ref() == hash {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
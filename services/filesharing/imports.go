@@ -0,0 +1,589 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/paths"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Imports download a URL into storage on the service's side, so a file
+// doesn't have to travel through a user's laptop. The body is stored as it
+// arrives, chunk by chunk through an ordinary upload session, and a
+// dropped connection is picked up where it stopped with a Range request
+// when the origin supports them. Each import's progress is recorded at
+// status/imports/<id>, for any replica to report; the replica running it
+// rewrites the record at least every importProgressEvery.
+//
+// Imports only connect to public addresses: the check runs on every
+// connection, redirects included, against the address actually dialled,
+// so DNS can't be used to point one at the cluster's network.
+const importsPrefix = statusPrefix + "imports/"
+
+const (
+	importRunning = "running"
+	importDone    = "done"
+	importFailed  = "failed"
+)
+
+// Import limits. IMPORT_ALLOW_PRIVATE lifts the address check, for
+// deployments that import from their own network.
+var (
+	importMaxActive          = 4
+	importMaxBytes     int64 = 10 * 1024 * 1024 * 1024
+	importTimeout            = 2 * time.Hour
+	importAllowPrivate       = false
+)
+
+const (
+	importHeaderTimeout = 30 * time.Second
+	// importIdleTimeout drops a connection that sent nothing for this long;
+	// the download is then resumed if it can be.
+	importIdleTimeout = time.Minute
+	// A download is tried importAttempts times in all, the first included,
+	// waiting importRetryDelay longer after each dropped connection.
+	importAttempts      = 5
+	importRetryDelay    = 2 * time.Second
+	importMaxRedirects  = 5
+	importProgressEvery = time.Second
+	// A running import whose record hasn't changed in importStaleAfter died
+	// with its replica.
+	importStaleAfter = 2 * time.Minute
+	// importKeep is how long the records of finished imports are kept.
+	importKeep = 24 * time.Hour
+)
+
+var (
+	errPrivateAddress = errors.New("address is not public")
+	errImportTooLarge = errors.New("file is larger than the import limit")
+)
+
+func loadImportConfig() {
+	importMaxActive = getCountEnv("IMPORT_MAX_ACTIVE", importMaxActive)
+	importMaxBytes = int64(getCountEnv("IMPORT_MAX_MB", int(importMaxBytes>>20))) << 20
+	importTimeout = time.Duration(getCountEnv("IMPORT_TIMEOUT_MINUTES", int(importTimeout/time.Minute))) * time.Minute
+	if val := getEnv("IMPORT_ALLOW_PRIVATE", ""); val != "" {
+		allow, err := strconv.ParseBool(val)
+		if err != nil {
+			log.Printf("invalid IMPORT_ALLOW_PRIVATE value %q, using default %t", val, importAllowPrivate)
+		} else {
+			importAllowPrivate = allow
+		}
+	}
+	log.Printf("URL imports: %d at a time, up to %d MB, %s each, private addresses allowed: %t",
+		importMaxActive, importMaxBytes>>20, importTimeout, importAllowPrivate)
+}
+
+// importRecord is the progress of an import, kept at importKey(ID).
+type importRecord struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	FileName string    `json:"fileName"`
+	Owner    string    `json:"owner,omitempty"`
+	State    string    `json:"state"`
+	Received int64     `json:"received"`
+	Total    int64     `json:"total,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Resumes  int       `json:"resumes,omitempty"`
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+}
+
+func importKey(id string) string {
+	return importsPrefix + id
+}
+
+func loadImport(ctx context.Context, store StorageImpl.Storage, id string) (*importRecord, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, StorageImpl.ErrNotFound
+	}
+	var rec importRecord
+	if err := readJSON(ctx, store, importKey(id), &rec); err != nil {
+		return nil, err
+	}
+	if rec.State == importRunning && time.Since(rec.Updated) > importStaleAfter {
+		rec.State = importFailed
+		rec.Error = "interrupted: the service stopped while importing"
+	}
+	return &rec, nil
+}
+
+func toImportInfo(rec *importRecord) *filesharing.ImportInfo {
+	return &filesharing.ImportInfo{
+		ID:       rec.ID,
+		URL:      rec.URL,
+		FileName: rec.FileName,
+		State:    rec.State,
+		Received: rec.Received,
+		Total:    rec.Total,
+		Error:    rec.Error,
+		Started:  unixOrZero(rec.Started),
+		Updated:  unixOrZero(rec.Updated),
+		Resumes:  int32(rec.Resumes),
+		Owner:    rec.Owner,
+	}
+}
+
+// pruneImports drops the records of imports that finished, or died,
+// importKeep ago.
+func pruneImports(ctx context.Context, store StorageImpl.Storage) error {
+	objects, err := store.List(ctx, importsPrefix, "", 0)
+	if err != nil {
+		return fmt.Errorf("error listing imports: %v", err)
+	}
+	for _, obj := range objects {
+		if time.Since(obj.LastModified) <= importKeep {
+			continue
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil && !StorageImpl.IsNotFound(err) {
+			return fmt.Errorf("error removing %s: %v", obj.Key, err)
+		}
+	}
+	return nil
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		// Carrier-grade NAT and the "this network" block aren't covered by
+		// IsPrivate.
+		if ip4[0] == 0 || (ip4[0] == 100 && ip4[1]&0xc0 == 64) {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkDialAddress is the dialer's Control hook: it runs after DNS, on the
+// address about to be connected to.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if importAllowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return fmt.Errorf("%s: %w", host, errPrivateAddress)
+	}
+	return nil
+}
+
+func newImportClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: checkDialAddress}
+	return &http.Client{
+		Transport: &http.Transport{
+			// No proxy: the address check has to see the origin's address.
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: importHeaderTimeout,
+			ForceAttemptHTTP2:     true,
+			// Bytes are stored as sent; asking for gzip would make the
+			// transport decompress them.
+			DisableCompression: true,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= importMaxRedirects {
+				return fmt.Errorf("more than %d redirects", importMaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to a %s URL", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// parseImportURL checks that raw is an absolute HTTP(S) URL without
+// credentials in it.
+func parseImportURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("only http and https URLs can be imported")
+	}
+	if u.Host == "" || u.Opaque != "" {
+		return nil, fmt.Errorf("invalid URL")
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("URLs with credentials can't be imported")
+	}
+	u.Fragment = ""
+	return u, nil
+}
+
+// importName works out where an import is stored: requested as given, or
+// requested (a folder, or the root when empty) plus the name the origin
+// gave the file or the last segment of the URL.
+func importName(requested string, resp *http.Response) string {
+	if requested != "" && !strings.HasSuffix(requested, "/") {
+		return requested
+	}
+	var base string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		base = params["filename"]
+	}
+	if base == "" {
+		base = path.Base(resp.Request.URL.Path)
+	}
+	base = base[strings.LastIndexAny(base, `/\`)+1:]
+	if _, err := paths.Clean(base); err != nil || base == "." {
+		base = "download"
+	}
+	return requested + base
+}
+
+// importDownload is the connection an import is reading from, and what it
+// needs to reconnect.
+type importDownload struct {
+	url       string
+	validator string // a strong ETag or Last-Modified, for If-Range
+	ranges    bool
+	resp      *http.Response
+	idle      *time.Timer
+	cancel    context.CancelFunc
+}
+
+// get starts a GET of d.url from offset. The connection is cut when it
+// sends nothing for importIdleTimeout.
+func (d *importDownload) get(ctx context.Context, client *http.Client, offset int64) error {
+	attemptCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(attemptCtx, http.MethodGet, d.url, nil)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", d.validator)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	want := http.StatusOK
+	if offset > 0 {
+		want = http.StatusPartialContent
+	}
+	if resp.StatusCode != want {
+		resp.Body.Close()
+		cancel()
+		if offset > 0 && resp.StatusCode == http.StatusOK {
+			return fmt.Errorf("the file changed on the server")
+		}
+		return fmt.Errorf("the server answered %s", resp.Status)
+	}
+	if offset > 0 {
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			resp.Body.Close()
+			cancel()
+			return fmt.Errorf("the server resumed at the wrong place")
+		}
+	}
+	d.resp, d.cancel = resp, cancel
+	d.idle = time.AfterFunc(importIdleTimeout, cancel)
+	return nil
+}
+
+func (d *importDownload) Read(p []byte) (int, error) {
+	n, err := d.resp.Body.Read(p)
+	d.idle.Reset(importIdleTimeout)
+	return n, err
+}
+
+func (d *importDownload) Close() {
+	if d.resp != nil {
+		d.idle.Stop()
+		d.resp.Body.Close()
+		d.cancel()
+		d.resp = nil
+	}
+}
+
+// total is the size of the file from the current response, 0 if unknown.
+func (d *importDownload) total() int64 {
+	if d.resp.StatusCode == http.StatusPartialContent {
+		_, size, _ := strings.Cut(d.resp.Header.Get("Content-Range"), "/")
+		n, _ := strconv.ParseInt(size, 10, 64)
+		return n
+	}
+	return max(d.resp.ContentLength, 0)
+}
+
+// importJob is an import whose origin has answered.
+type importJob struct {
+	rec      *importRecord
+	download *importDownload
+	opts     uploadOptions
+	isAdmin  bool
+	saved    time.Time
+}
+
+// importer runs imports in the background, at most importMaxActive at a
+// time on each replica.
+type importer struct {
+	store  StorageImpl.Storage
+	thumbs *thumbnailer
+	client *http.Client
+	slots  chan struct{}
+}
+
+func newImporter(store StorageImpl.Storage, thumbs *thumbnailer) *importer {
+	return &importer{store: store, thumbs: thumbs, client: newImportClient(), slots: make(chan struct{}, importMaxActive)}
+}
+
+// Start connects to the origin and, once it has answered, stores the file
+// in the background. opts.Owner is who the file is stored for; fileName is
+// as for importName.
+func (i *importer) Start(ctx context.Context, f *FilesharingService, rawURL, fileName string, opts uploadOptions, isAdmin bool) (*importRecord, error) {
+	u, err := parseImportURL(rawURL)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	select {
+	case i.slots <- struct{}{}:
+	default:
+		return nil, status.Errorf(codes.ResourceExhausted, "%d imports are running already, try again later", importMaxActive)
+	}
+	started := false
+	defer func() {
+		if !started {
+			<-i.slots
+		}
+	}()
+
+	// The download outlives the request that started it.
+	importCtx, cancel := context.WithTimeout(context.Background(), importTimeout)
+	defer func() {
+		if !started {
+			cancel()
+		}
+	}()
+	download := &importDownload{url: u.String()}
+	if err := download.get(importCtx, i.client, 0); err != nil {
+		if errors.Is(err, errPrivateAddress) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s points to an address that is not public", u.Host)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "could not download %s: %v", u.Redacted(), err)
+	}
+	defer func() {
+		if !started {
+			download.Close()
+		}
+	}()
+	resp := download.resp
+	if resp.ContentLength > importMaxBytes {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s is %d MB, more than the %d MB limit", u.Redacted(), resp.ContentLength>>20, importMaxBytes>>20)
+	}
+	// Redirects are followed once; resuming goes straight to where they led.
+	download.url = resp.Request.URL.String()
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		download.validator = etag
+	} else {
+		download.validator = resp.Header.Get("Last-Modified")
+	}
+	download.ranges = resp.Header.Get("Accept-Ranges") == "bytes" && download.validator != ""
+
+	name := importName(fileName, resp)
+	if err := cleanName(&name); err != nil {
+		return nil, err
+	}
+	if err := f.prepareFile(ctx, name, opts.Owner, isAdmin); err != nil {
+		return nil, err
+	}
+	target, err := uploadTarget(ctx, i.store, name, opts.Owner, opts.Conflict)
+	if err != nil {
+		return nil, conflictError(name, err)
+	}
+	if err := f.checkReplace(ctx, target, opts.Owner, isAdmin); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	rec := &importRecord{
+		ID:       uuid.NewString(),
+		URL:      u.String(),
+		FileName: target,
+		Owner:    opts.Owner,
+		State:    importRunning,
+		Total:    download.total(),
+		Started:  now,
+		Updated:  now,
+	}
+	if err := writeJSON(ctx, i.store, importKey(rec.ID), rec, StorageImpl.PutOptions{}); err != nil {
+		return nil, fmt.Errorf("error saving import: %v", err)
+	}
+
+	started = true
+	job := &importJob{rec: rec, download: download, opts: opts, isAdmin: isAdmin, saved: now}
+	go func() {
+		defer func() { <-i.slots }()
+		defer cancel()
+		defer download.Close()
+		i.run(importCtx, job)
+	}()
+	return rec, nil
+}
+
+// run stores the body of job's download and records how it ended.
+func (i *importer) run(ctx context.Context, job *importJob) {
+	rec := job.rec
+	manifest, err := i.transfer(ctx, job)
+	// The import's own context may be what ran out.
+	finishCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err != nil {
+		rec.State, rec.Error = importFailed, err.Error()
+		log.Printf("Import of %s into %s failed: %v", rec.URL, rec.FileName, err)
+		if err := abortUpload(finishCtx, i.store, rec.FileName); err != nil && !StorageImpl.IsNotFound(err) {
+			log.Printf("⚠️  Warning: could not drop the upload of %s: %v", rec.FileName, err)
+		}
+	} else {
+		rec.State, rec.FileName = importDone, manifest.Name
+		log.Printf("Imported %s into %s (%d bytes)", rec.URL, rec.FileName, manifest.Size)
+	}
+	rec.Updated = time.Now()
+	if err := writeJSON(finishCtx, i.store, importKey(rec.ID), rec, StorageImpl.PutOptions{}); err != nil {
+		log.Printf("⚠️  Warning: could not record the end of import %s: %v", rec.ID, err)
+	}
+}
+
+// transfer reads the download into chunkSize parts of an upload session
+// and commits it. Files that fit in one chunk are stored whole.
+func (i *importer) transfer(ctx context.Context, job *importJob) (*fileManifest, error) {
+	rec, download := job.rec, job.download
+	buf := make([]byte, chunkSize)
+	fill, parts := 0, 0
+	var stored int64
+	var session *uploadSession
+	attempts := 1
+	for {
+		n, err := download.Read(buf[fill:])
+		fill += n
+		rec.Received = stored + int64(fill)
+		if rec.Received > importMaxBytes {
+			return nil, fmt.Errorf("%w (%d MB)", errImportTooLarge, importMaxBytes>>20)
+		}
+		if fill == len(buf) {
+			if session == nil {
+				var err error
+				session, err = startUpload(ctx, i.store, rec.FileName, i.fileOptions(job, buf))
+				if err != nil {
+					return nil, fmt.Errorf("error starting upload: %v", err)
+				}
+			}
+			parts++
+			if _, err := putPart(ctx, i.store, session, parts, buf); err != nil {
+				return nil, fmt.Errorf("error storing part %d: %v", parts, err)
+			}
+			stored += int64(fill)
+			fill = 0
+		}
+		i.progress(ctx, job)
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("import took longer than %s", importTimeout)
+		}
+		if !download.ranges {
+			return nil, fmt.Errorf("connection lost after %d bytes and the server can't resume: %v", rec.Received, err)
+		}
+		download.Close()
+		for err != nil {
+			if attempts >= importAttempts {
+				return nil, fmt.Errorf("connection lost after %d bytes, gave up after %d attempts: %v", rec.Received, attempts, err)
+			}
+			select {
+			case <-ctx.Done():
+				return nil, fmt.Errorf("import took longer than %s", importTimeout)
+			case <-time.After(time.Duration(attempts) * importRetryDelay):
+			}
+			attempts++
+			err = download.get(ctx, i.client, rec.Received)
+		}
+		rec.Resumes++
+		log.Printf("Resumed import of %s at %d bytes", rec.URL, rec.Received)
+	}
+
+	var manifest *fileManifest
+	if session == nil {
+		// The name may have been taken while the file downloaded.
+		target, err := uploadTarget(ctx, i.store, rec.FileName, job.opts.Owner, job.opts.Conflict)
+		if err != nil {
+			return nil, err
+		}
+		rec.FileName = target
+		manifest, err = putWholeFile(ctx, i.store, target, buf[:fill], i.fileOptions(job, buf[:fill]))
+		if err != nil {
+			return nil, fmt.Errorf("error storing file: %v", err)
+		}
+	} else {
+		if fill > 0 {
+			parts++
+			if _, err := putPart(ctx, i.store, session, parts, buf[:fill]); err != nil {
+				return nil, fmt.Errorf("error storing part %d: %v", parts, err)
+			}
+		}
+		var err error
+		manifest, err = completeUpload(ctx, i.store, rec.FileName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	i.thumbs.Enqueue(manifest)
+	return manifest, nil
+}
+
+// fileOptions are the upload options of job, with the compression and
+// content type worked out from the first bytes of the file.
+func (i *importer) fileOptions(job *importJob, data []byte) uploadOptions {
+	opts := job.opts
+	head := data[:min(512, len(data))]
+	if codec, err := chooseCodec("", job.rec.FileName, head); err == nil {
+		opts.Codec = codec
+	}
+	opts.ContentType = sniffContentType(job.rec.FileName, head)
+	return opts
+}
+
+// progress rewrites the record of job when it hasn't been for
+// importProgressEvery.
+func (i *importer) progress(ctx context.Context, job *importJob) {
+	if time.Since(job.saved) < importProgressEvery {
+		return
+	}
+	job.saved = time.Now()
+	job.rec.Updated = job.saved
+	if err := writeJSON(ctx, i.store, importKey(job.rec.ID), job.rec, StorageImpl.PutOptions{}); err != nil {
+		log.Printf("⚠️  Warning: could not record the progress of import %s: %v", job.rec.ID, err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// setImportLimits changes the import settings for one test.
+func setImportLimits(t *testing.T, allowPrivate bool, maxBytes int64, timeout time.Duration) {
+	t.Helper()
+	oldPrivate, oldMax, oldTimeout := importAllowPrivate, importMaxBytes, importTimeout
+	importAllowPrivate, importMaxBytes, importTimeout = allowPrivate, maxBytes, timeout
+	t.Cleanup(func() {
+		importAllowPrivate, importMaxBytes, importTimeout = oldPrivate, oldMax, oldTimeout
+	})
+}
+
+// waitImport polls the import id until it is no longer running.
+func waitImport(t *testing.T, f *FilesharingService, id string) *filesharing.ImportInfo {
+	t.Helper()
+	deadline := time.Now().Add(20 * time.Second)
+	for time.Now().Before(deadline) {
+		res, err := f.GetImport(context.Background(), &filesharing.GetImportRequest{ID: id, User: "alice"})
+		if err != nil {
+			t.Fatalf("GetImport: %v", err)
+		}
+		if res.Import.State != importRunning {
+			return res.Import
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("import %s still running", id)
+	return nil
+}
+
+func startImport(f *FilesharingService, url string) (*filesharing.ImportFromURLResponse, error) {
+	return f.ImportFromURL(context.Background(), &filesharing.ImportFromURLRequest{
+		URL:      url,
+		FileName: "imported.bin",
+		Owner:    "alice",
+	})
+}
+
+func TestImportRefusesPrivateAddresses(t *testing.T) {
+	setImportLimits(t, false, 1<<20, time.Minute)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secret")
+	}))
+	defer srv.Close()
+
+	f := newTestService(t)
+	for _, url := range []string{srv.URL + "/file", strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/file"} {
+		_, err := startImport(f, url)
+		if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "not public") {
+			t.Errorf("import of %s: got %v, want a private address error", url, err)
+		}
+	}
+}
+
+func TestImportRefusesRedirectToPrivateAddress(t *testing.T) {
+	setImportLimits(t, false, 1<<20, time.Minute)
+	private := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secret")
+	}))
+	defer private.Close()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, private.URL+"/secret", http.StatusFound)
+	}))
+	defer origin.Close()
+
+	// origin.test stands for a public host: it is dialled without the
+	// address check, every other host with it.
+	f := newTestService(t)
+	transport := f.imports.client.Transport.(*http.Transport)
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "origin.test:80" {
+			return (&net.Dialer{}).DialContext(ctx, network, origin.Listener.Addr().String())
+		}
+		return dial(ctx, network, addr)
+	}
+
+	_, err := startImport(f, "http://origin.test/file")
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "not public") {
+		t.Fatalf("got %v, want a private address error", err)
+	}
+}
+
+func TestImportSizeLimit(t *testing.T) {
+	setImportLimits(t, true, 1024, time.Minute)
+	data := bytes.Repeat([]byte("x"), 4096)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sized" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		}
+		// Without a Content-Length the limit is only hit while reading.
+		w.Write(data)
+	}))
+	defer srv.Close()
+	f := newTestService(t)
+
+	_, err := startImport(f, srv.URL+"/sized")
+	if status.Code(err) != codes.FailedPrecondition || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("import with a Content-Length over the limit: got %v", err)
+	}
+
+	res, err := startImport(f, srv.URL+"/streamed")
+	if err != nil {
+		t.Fatalf("starting streamed import: %v", err)
+	}
+	info := waitImport(t, f, res.Import.ID)
+	if info.State != importFailed || !strings.Contains(info.Error, errImportTooLarge.Error()) {
+		t.Errorf("streamed import over the limit ended %s: %q", info.State, info.Error)
+	}
+	if _, err := openFile(context.Background(), f.store, "imported.bin"); !StorageImpl.IsNotFound(err) {
+		t.Errorf("file of the failed import: got %v, want not found", err)
+	}
+}
+
+func TestImportTimeout(t *testing.T) {
+	setImportLimits(t, true, 1<<20, 300*time.Millisecond)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	f := newTestService(t)
+
+	res, err := startImport(f, srv.URL+"/slow")
+	if err != nil {
+		t.Fatalf("starting import: %v", err)
+	}
+	info := waitImport(t, f, res.Import.ID)
+	if info.State != importFailed || !strings.Contains(info.Error, "took longer than") {
+		t.Errorf("slow import ended %s: %q", info.State, info.Error)
+	}
+}
+
+// resumingOrigin serves data with an ETag, cutting the first response off
+// after half of it. Resumed requests are answered by http.ServeContent.
+type resumingOrigin struct {
+	data []byte
+
+	mu      sync.Mutex
+	ranges  []string
+	ifRange []string
+}
+
+func (o *resumingOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Range") == "" {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(o.data)))
+		w.Write(o.data[:len(o.data)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	o.mu.Lock()
+	o.ranges = append(o.ranges, r.Header.Get("Range"))
+	o.ifRange = append(o.ifRange, r.Header.Get("If-Range"))
+	o.mu.Unlock()
+	w.Header().Set("ETag", `"v1"`)
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(o.data))
+}
+
+func TestImportResumes(t *testing.T) {
+	setImportLimits(t, true, 1<<20, time.Minute)
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	origin := &resumingOrigin{data: data}
+	srv := httptest.NewServer(origin)
+	defer srv.Close()
+	f := newTestService(t)
+
+	res, err := startImport(f, srv.URL+"/file")
+	if err != nil {
+		t.Fatalf("starting import: %v", err)
+	}
+	info := waitImport(t, f, res.Import.ID)
+	if info.State != importDone || info.Resumes != 1 {
+		t.Fatalf("import ended %s after %d resumes: %q", info.State, info.Resumes, info.Error)
+	}
+	if got := readStoredFile(t, f, "imported.bin"); !bytes.Equal(got, data) {
+		t.Errorf("stored %d bytes, want the %d sent", len(got), len(data))
+	}
+	origin.mu.Lock()
+	defer origin.mu.Unlock()
+	if len(origin.ranges) != 1 || origin.ranges[0] != "bytes=2048-" || origin.ifRange[0] != `"v1"` {
+		t.Errorf("resumed with Range %q and If-Range %q", origin.ranges, origin.ifRange)
+	}
+}
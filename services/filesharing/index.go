@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// The metadata index lets files be listed by owner and tag without reading
+// every manifest. Each entry is an empty object next to the manifest:
+//
+//	index/owner/<owner>/<name>    one per file that has an owner
+//	index/tag/<tag>/<name>        one per tag
+//
+// Owners and names are query-escaped, which keeps prefixes: a name prefix
+// is still a key prefix.
+// Everything else a listing shows comes from the manifest's metadata, so a
+// page costs one List plus, for index listings, one Stat per file.
+const indexPrefix = "index/"
+
+const (
+	maxTags      = 10
+	maxTagLength = 32
+)
+
+// Listing limits: how many files a page holds by default and at most, and
+// how many keys one page may look at before returning what it has.
+const (
+	defaultListCount = 50
+	maxListCount     = 200
+	listScanLimit    = 5000
+)
+
+var errBadCursor = errors.New("invalid cursor")
+
+func ownerIndexPrefix(owner string) string {
+	return indexPrefix + "owner/" + url.QueryEscape(owner) + "/"
+}
+
+func tagIndexPrefix(tag string) string {
+	return indexPrefix + "tag/" + tag + "/"
+}
+
+// indexKeys returns the index entries m should have.
+func (m *fileManifest) indexKeys() []string {
+	var keys []string
+	if m.Owner != "" {
+		keys = append(keys, ownerIndexPrefix(m.Owner)+url.QueryEscape(m.Name))
+	}
+	for _, tag := range m.Tags {
+		keys = append(keys, tagIndexPrefix(tag)+url.QueryEscape(m.Name))
+	}
+	return keys
+}
+
+func writeIndexEntries(ctx context.Context, store StorageImpl.Storage, m *fileManifest) error {
+	for _, key := range m.indexKeys() {
+		if _, err := store.Put(ctx, key, bytes.NewReader(nil), 0, StorageImpl.PutOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropIndexEntries removes the entries of old that current (nil if the file
+// is gone) doesn't have too.
+func dropIndexEntries(ctx context.Context, store StorageImpl.Storage, old, current *fileManifest) {
+	keep := map[string]bool{}
+	if current != nil {
+		for _, key := range current.indexKeys() {
+			keep[key] = true
+		}
+	}
+	for _, key := range old.indexKeys() {
+		if !keep[key] {
+			store.Delete(ctx, key)
+		}
+	}
+}
+
+// normalizeTags lowercases, deduplicates and validates the tags of an
+// upload. They become object keys and metadata, hence the narrow alphabet.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		for _, c := range tag {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+				return nil, fmt.Errorf("tag %q can only contain letters, digits, \".\", \"_\" and \"-\"", tag)
+			}
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	if len(out) > maxTags {
+		return nil, fmt.Errorf("a file can have at most %d tags", maxTags)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// ownedBy reports whether user may change the file. Files from before
+// owners were recorded belong to the admins.
+func (m *fileManifest) ownedBy(user string, isAdmin bool) bool {
+	return isAdmin || (m.Owner != "" && m.Owner == user)
+}
+
+// fileInfo is what listings and StatFile report about a file. Chunks is
+// only known when the manifest was read, and so is ETag for manifests
+// written before it was in their metadata.
+type fileInfo struct {
+	Name     string
+	Size     int64
+	Created  time.Time
+	Expires  time.Time // zero if never
+	Owner    string
+	Tags     []string
+	EndToEnd bool
+	ETag     string
+	Chunks   int
+	Version  int
+	// ContentType is empty for files whose type wasn't stored.
+	ContentType string
+}
+
+func (m *fileManifest) Info() fileInfo {
+	info := fileInfo{
+		Name:        m.Name,
+		Size:        m.Size,
+		Created:     m.Created,
+		Owner:       m.Owner,
+		Tags:        m.Tags,
+		EndToEnd:    len(m.EncryptedMetadata) > 0,
+		ETag:        m.ETag(),
+		Chunks:      len(m.Chunks),
+		Version:     m.Number(),
+		ContentType: m.ContentType,
+	}
+	if expires, ok := m.ExpiresAt(); ok {
+		info.Expires = expires
+	}
+	return info
+}
+
+// manifestInfo describes the file of a manifest object from its metadata.
+// Listings on servers that don't return metadata fall back to a Stat, and
+// manifests from before metadata was stored to reading them.
+func manifestInfo(ctx context.Context, store StorageImpl.Storage, obj StorageImpl.ObjectInfo) (*fileInfo, error) {
+	fileName := strings.TrimPrefix(obj.Key, manifestsPrefix)
+	if _, ok := obj.Metadata[manifestCreatedKey]; !ok {
+		stat, err := store.Stat(ctx, obj.Key)
+		if err != nil {
+			return nil, err
+		}
+		obj = stat
+	}
+	if _, ok := obj.Metadata[manifestCreatedKey]; !ok {
+		manifest, err := loadManifest(ctx, store, fileName)
+		if err != nil {
+			return nil, err
+		}
+		info := manifest.Info()
+		return &info, nil
+	}
+
+	info := &fileInfo{
+		Name:     fileName,
+		Size:     manifestSize(ctx, store, obj),
+		Created:  manifestCreated(obj),
+		EndToEnd: obj.Metadata[manifestEndToEndKey] == "true",
+	}
+	if expires, ok := manifestExpiry(obj); ok {
+		info.Expires = expires
+	}
+	if owner, err := url.QueryUnescape(obj.Metadata[manifestOwnerKey]); err == nil {
+		info.Owner = owner
+	}
+	if tags := obj.Metadata[manifestTagsKey]; tags != "" {
+		info.Tags = strings.Split(tags, ",")
+	}
+	info.ContentType = obj.Metadata[manifestMimeTypeKey]
+	info.ETag = obj.Metadata[manifestETagKey]
+	return info, nil
+}
+
+// fileQuery filters a listing. Zero fields match everything.
+type fileQuery struct {
+	Owner         string
+	Prefix        string
+	Tag           string
+	MinSize       int64
+	MaxSize       int64
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Search is matched against names and tags, case-insensitively.
+	Search string
+	// Direct leaves out files in folders below Prefix.
+	Direct bool
+	// StartAfter starts the listing after that name when there is no
+	// cursor.
+	StartAfter string
+}
+
+// source returns the prefix the query lists: the tag index, the owner index
+// or the manifests, and whether names under it are escaped.
+func (q *fileQuery) source() (string, bool) {
+	switch {
+	case q.Tag != "":
+		return tagIndexPrefix(q.Tag), true
+	case q.Owner != "":
+		return ownerIndexPrefix(q.Owner), true
+	}
+	return manifestsPrefix, false
+}
+
+func (q *fileQuery) matches(info *fileInfo, now time.Time) bool {
+	if !info.Expires.IsZero() && !info.Expires.After(now) {
+		return false // expired, not deleted yet
+	}
+	if q.Owner != "" && info.Owner != q.Owner {
+		return false
+	}
+	if !strings.HasPrefix(info.Name, q.Prefix) {
+		return false
+	}
+	if q.Direct && strings.Contains(info.Name[len(q.Prefix):], "/") {
+		return false
+	}
+	if q.Tag != "" && !slices.Contains(info.Tags, q.Tag) {
+		return false
+	}
+	if (q.MinSize > 0 && info.Size < q.MinSize) || (q.MaxSize > 0 && info.Size > q.MaxSize) {
+		return false
+	}
+	if (!q.CreatedAfter.IsZero() && info.Created.Before(q.CreatedAfter)) ||
+		(!q.CreatedBefore.IsZero() && info.Created.After(q.CreatedBefore)) {
+		return false
+	}
+	if q.Search != "" {
+		search := strings.ToLower(q.Search)
+		if strings.Contains(strings.ToLower(info.Name), search) {
+			return true
+		}
+		for _, tag := range info.Tags {
+			if strings.Contains(tag, search) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// listFiles returns up to count files matching q in name order, starting
+// after cursor, and the cursor of the next page ("" after the last one).
+// Index entries whose file is gone or changed are skipped; the orphan
+// collector removes them.
+func listFiles(ctx context.Context, store StorageImpl.Storage, q fileQuery, cursor string, count int) ([]fileInfo, string, error) {
+	if count <= 0 {
+		count = defaultListCount
+	}
+	count = min(count, maxListCount)
+
+	base, escaped := q.source()
+	prefix := base + q.Prefix
+	if escaped {
+		prefix = base + url.QueryEscape(q.Prefix)
+	}
+	after := ""
+	if cursor != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || !strings.HasPrefix(string(decoded), prefix) {
+			return nil, "", errBadCursor
+		}
+		after = string(decoded)
+	} else if q.StartAfter != "" {
+		after = base + q.StartAfter
+		if escaped {
+			after = base + url.QueryEscape(q.StartAfter)
+		}
+	}
+
+	now := time.Now()
+	batch := max(count, 100)
+	var files []fileInfo
+	scanned := 0
+	for {
+		objects, err := store.List(ctx, prefix, after, batch)
+		if err != nil {
+			return nil, "", fmt.Errorf("error listing files: %v", err)
+		}
+		for _, obj := range objects {
+			after = obj.Key
+			scanned++
+			info, err := lookupFile(ctx, store, obj, base, escaped)
+			if err != nil {
+				if StorageImpl.IsNotFound(err) {
+					continue
+				}
+				return nil, "", err
+			}
+			if !q.matches(info, now) {
+				continue
+			}
+			files = append(files, *info)
+			if len(files) == count {
+				return files, base64.RawURLEncoding.EncodeToString([]byte(after)), nil
+			}
+		}
+		if len(objects) < batch {
+			return files, "", nil
+		}
+		if scanned >= listScanLimit {
+			return files, base64.RawURLEncoding.EncodeToString([]byte(after)), nil
+		}
+	}
+}
+
+// lookupFile describes the file a listed key stands for: a manifest, or an
+// index entry under base.
+func lookupFile(ctx context.Context, store StorageImpl.Storage, obj StorageImpl.ObjectInfo, base string, escaped bool) (*fileInfo, error) {
+	if !escaped {
+		return manifestInfo(ctx, store, obj)
+	}
+	fileName, err := url.QueryUnescape(strings.TrimPrefix(obj.Key, base))
+	if err != nil {
+		return nil, StorageImpl.ErrNotFound
+	}
+	stat, err := store.Stat(ctx, manifestKey(fileName))
+	if err != nil {
+		return nil, err
+	}
+	return manifestInfo(ctx, store, stat)
+}
+
+// statFile describes fileName. Files in the older layouts only have a size
+// and an expiry.
+func statFile(ctx context.Context, store StorageImpl.Storage, fileName string) (*fileInfo, error) {
+	manifest, err := loadManifest(ctx, store, fileName)
+	if err == nil {
+		info := manifest.Info()
+		if !info.Expires.IsZero() && !info.Expires.After(time.Now()) {
+			return nil, StorageImpl.ErrNotFound
+		}
+		return &info, nil
+	}
+	if !StorageImpl.IsNotFound(err) {
+		return nil, err
+	}
+	file, err := openFile(ctx, store, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{Name: fileName, Size: file.Size, Expires: file.Expires, Chunks: len(file.Segments)}, nil
+}
+
+// parseIndexKey returns the file an index entry points at, and the owner or
+// tag it files it under.
+func parseIndexKey(key string) (kind, value, fileName string, ok bool) {
+	rest := strings.TrimPrefix(key, indexPrefix)
+	kind, rest, ok = strings.Cut(rest, "/")
+	if !ok {
+		return "", "", "", false
+	}
+	escapedValue, escapedName, ok := strings.Cut(rest, "/")
+	if !ok {
+		return "", "", "", false
+	}
+	value, err := url.QueryUnescape(escapedValue)
+	if err != nil {
+		return "", "", "", false
+	}
+	fileName, err = url.QueryUnescape(escapedName)
+	if err != nil {
+		return "", "", "", false
+	}
+	return kind, value, fileName, true
+}
+
+// indexEntryCurrent reports whether the index entry key still describes its
+// file.
+func indexEntryCurrent(ctx context.Context, store StorageImpl.Storage, key string) (bool, error) {
+	kind, value, fileName, ok := parseIndexKey(key)
+	if !ok {
+		return false, nil
+	}
+	stat, err := store.Stat(ctx, manifestKey(fileName))
+	if StorageImpl.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	info, err := manifestInfo(ctx, store, stat)
+	if err != nil {
+		return false, err
+	}
+	switch kind {
+	case "owner":
+		return info.Owner == value, nil
+	case "tag":
+		return slices.Contains(info.Tags, value), nil
+	}
+	return false, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// statusPrefix holds what the leader records for every replica to read:
+// the last run of each job and the reconciled usage figures.
+const statusPrefix = "status/"
+
+// jobPoll is how often each job checks whether it is due.
+const jobPoll = 10 * time.Second
+
+// jobStatus is the record of a job's last run, kept at status/jobs/<name>.
+type jobStatus struct {
+	Name     string        `json:"name"`
+	Interval time.Duration `json:"interval"`
+	LastRun  time.Time     `json:"lastRun,omitzero"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	RunBy    string        `json:"runBy,omitempty"`
+}
+
+func jobStatusKey(name string) string {
+	return statusPrefix + "jobs/" + name
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	timeout  time.Duration
+	run      func(ctx context.Context) error
+}
+
+// jobRunner runs the periodic background jobs on the leader only. Each job
+// keeps its schedule across leader changes: the new leader reads when the
+// job last ran instead of starting it over straight away.
+type jobRunner struct {
+	store   StorageImpl.Storage
+	leader  leaderElector
+	backend string
+
+	mu   sync.Mutex
+	jobs []*job
+}
+
+func newJobRunner(store StorageImpl.Storage, leader leaderElector, backend string) *jobRunner {
+	return &jobRunner{store: store, leader: leader, backend: backend}
+}
+
+// Register adds a job that runs every interval and is cancelled after
+// timeout. Jobs registered after Run are not started.
+func (r *jobRunner) Register(name string, interval, timeout time.Duration, run func(ctx context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, &job{name: name, interval: interval, timeout: timeout, run: run})
+}
+
+func (r *jobRunner) Jobs() []*job {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*job(nil), r.jobs...)
+}
+
+func (r *jobRunner) Run(ctx context.Context) {
+	go r.leader.Run(ctx)
+	for _, j := range r.Jobs() {
+		go r.loop(ctx, j)
+	}
+}
+
+func (r *jobRunner) loop(ctx context.Context, j *job) {
+	ticker := time.NewTicker(min(jobPoll, j.interval))
+	defer ticker.Stop()
+	var lastRun time.Time
+	wasLeader := false
+	for {
+		leader := r.leader.IsLeader()
+		if leader && !wasLeader {
+			// Pick up the schedule where the previous leader left it.
+			if status, err := r.status(ctx, j.name); err == nil {
+				lastRun = status.LastRun
+			}
+		}
+		wasLeader = leader
+		if leader && time.Since(lastRun) >= j.interval {
+			lastRun = time.Now()
+			r.runOnce(ctx, j, lastRun)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *jobRunner) runOnce(ctx context.Context, j *job, start time.Time) {
+	runCtx, cancel := context.WithTimeout(ctx, j.timeout)
+	err := j.run(runCtx)
+	cancel()
+
+	status := jobStatus{
+		Name:     j.name,
+		Interval: j.interval,
+		LastRun:  start,
+		Duration: time.Since(start),
+		RunBy:    r.leader.ID(),
+	}
+	if err != nil {
+		status.Error = err.Error()
+		log.Printf("Warning: job %s failed: %v", j.name, err)
+	}
+	if err := writeJSON(ctx, r.store, jobStatusKey(j.name), &status, StorageImpl.PutOptions{}); err != nil {
+		log.Printf("Warning: could not record run of job %s: %v", j.name, err)
+	}
+}
+
+func (r *jobRunner) status(ctx context.Context, name string) (*jobStatus, error) {
+	var status jobStatus
+	if err := readJSON(ctx, r.store, jobStatusKey(name), &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Status returns the last recorded run of every registered job; jobs that
+// never ran only have their name and interval.
+func (r *jobRunner) Status(ctx context.Context) []jobStatus {
+	var out []jobStatus
+	for _, j := range r.Jobs() {
+		status, err := r.status(ctx, j.name)
+		if err != nil {
+			if !StorageImpl.IsNotFound(err) {
+				log.Printf("Warning: could not read status of job %s: %v", j.name, err)
+			}
+			status = &jobStatus{Name: j.name}
+		}
+		status.Interval = j.interval
+		out = append(out, *status)
+	}
+	return out
+}
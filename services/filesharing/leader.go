@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// leaderElector picks the one replica that runs the background jobs, so
+// scaling the deployment doesn't multiply them.
+type leaderElector interface {
+	// Run campaigns for leadership until ctx is done.
+	Run(ctx context.Context)
+	IsLeader() bool
+	// ID is this replica's identity, Leader the identity of the current
+	// leader ("" if there is none).
+	ID() string
+	Leader(ctx context.Context) (string, error)
+}
+
+const (
+	leaderKey = "kubefile:filesharing:leader"
+	// leaderLease is how long leadership lasts without being renewed.
+	leaderLease = 15 * time.Second
+)
+
+// newLeaderElector builds the elector LEADER_ELECTION asks for: "redis" (a
+// lock in REDIS_ADDR), "kubernetes" (a coordination.k8s.io Lease in the
+// pod's namespace) or "none", for a single replica. It returns the backend
+// actually used.
+func newLeaderElector() (leaderElector, string, error) {
+	fallback := "none"
+	if getEnv("REDIS_ADDR", "") != "" {
+		fallback = "redis"
+	}
+	backend := strings.ToLower(getEnv("LEADER_ELECTION", fallback))
+	switch backend {
+	case "redis":
+		addr := getEnv("REDIS_ADDR", "")
+		if addr == "" {
+			return nil, "", errors.New("LEADER_ELECTION=redis needs REDIS_ADDR")
+		}
+		return newRedisElector(redis.NewClient(&redis.Options{Addr: addr}), leaderKey, leaderLease), backend, nil
+	case "kubernetes", "k8s":
+		elector, err := newLeaseElector(getEnv("LEADER_LEASE_NAME", "filesharing-leader"), leaderLease)
+		return elector, "kubernetes", err
+	case "none":
+		log.Println("Leader election disabled, this replica runs every background job")
+		return soloElector{id: instanceID()}, backend, nil
+	}
+	return nil, "", fmt.Errorf("unknown LEADER_ELECTION %q (redis, kubernetes or none)", backend)
+}
+
+type soloElector struct{ id string }
+
+func (soloElector) Run(ctx context.Context)                      {}
+func (soloElector) IsLeader() bool                               { return true }
+func (e soloElector) ID() string                                 { return e.id }
+func (e soloElector) Leader(ctx context.Context) (string, error) { return e.id, nil }
+
+// Both scripts only touch the lock while we still hold it.
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// redisElector holds leadership while it owns a Redis key that expires
+// after lease. The key is renewed three times per lease; a replica that
+// can't reach Redis stops acting as leader once its lease would have run
+// out, which is also when another replica can take over.
+type redisElector struct {
+	client *redis.Client
+	key    string
+	id     string
+	lease  time.Duration
+
+	mu      sync.Mutex
+	leader  bool
+	renewed time.Time
+}
+
+func newRedisElector(client *redis.Client, key string, lease time.Duration) *redisElector {
+	return &redisElector{client: client, key: key, id: instanceID(), lease: lease}
+}
+
+// instanceID names this replica in the lock: the pod name plus a random
+// suffix, so a restarted pod never mistakes its predecessor's lock for its
+// own.
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "filesharing"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
+
+func (e *redisElector) ID() string {
+	return e.id
+}
+
+func (e *redisElector) Leader(ctx context.Context) (string, error) {
+	id, err := e.client.Get(ctx, e.key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return id, err
+}
+
+func (e *redisElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader && time.Since(e.renewed) < e.lease
+}
+
+func (e *redisElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.lease / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *redisElector) campaign(ctx context.Context) {
+	start := time.Now()
+	opCtx, cancel := context.WithTimeout(ctx, e.lease/3)
+	defer cancel()
+
+	e.mu.Lock()
+	wasLeader := e.leader
+	e.mu.Unlock()
+
+	var held bool
+	var err error
+	if wasLeader {
+		var n int64
+		n, err = renewScript.Run(opCtx, e.client, []string{e.key}, e.id, e.lease.Milliseconds()).Int64()
+		held = n == 1
+	} else {
+		held, err = e.client.SetNX(opCtx, e.key, e.id, e.lease).Result()
+	}
+	if err != nil {
+		// Keep whatever we had, IsLeader lets it lapse with the lease.
+		log.Printf("Warning: leader election failed: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	e.leader = held
+	if held {
+		e.renewed = start
+	}
+	e.mu.Unlock()
+
+	switch {
+	case held && !wasLeader:
+		log.Printf("This replica (%s) is now the leader", e.id)
+	case !held && wasLeader:
+		log.Printf("This replica (%s) lost leadership", e.id)
+	}
+}
+
+// release gives up the lock on shutdown so the next leader doesn't have to
+// wait for the lease to run out.
+func (e *redisElector) release() {
+	e.mu.Lock()
+	e.leader = false
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	releaseScript.Run(ctx, e.client, []string{e.key}, e.id)
+}
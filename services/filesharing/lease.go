@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The in-cluster service account files every pod gets.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// microTime is the format of the Lease time fields.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// lease is the part of a coordination.k8s.io/v1 Lease we use.
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// expired reports whether the holder let the lease run out.
+func (l *lease) expired(now time.Time) bool {
+	if l.Spec.HolderIdentity == "" {
+		return true
+	}
+	renewed, err := time.Parse(microTime, l.Spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second))
+}
+
+// leaseElector holds leadership through a Lease object, the way Kubernetes'
+// own controllers do. Writes carry the resourceVersion they were based on,
+// so two replicas taking over at once can't both win. The pod's service
+// account needs get, create and update on leases.
+type leaseElector struct {
+	client    *http.Client
+	url       string // the Lease collection of our namespace
+	name      string
+	tokenFile string
+	id        string
+	duration  time.Duration
+
+	mu      sync.Mutex
+	leader  bool
+	renewed time.Time
+}
+
+func newLeaseElector(name string, duration time.Duration) (*leaseElector, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("LEADER_ELECTION=kubernetes only works inside a cluster")
+	}
+	namespace, err := os.ReadFile(serviceAccountDir + "namespace")
+	if err != nil {
+		return nil, fmt.Errorf("error reading pod namespace: %v", err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates in the cluster CA file")
+	}
+	return &leaseElector{
+		client: &http.Client{
+			Timeout:   duration / 3,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		url: fmt.Sprintf("https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+			net.JoinHostPort(host, port), strings.TrimSpace(string(namespace))),
+		name:      name,
+		tokenFile: serviceAccountDir + "token",
+		id:        instanceID(),
+		duration:  duration,
+	}, nil
+}
+
+var (
+	errLeaseNotFound = errors.New("lease not found")
+	errLeaseConflict = errors.New("lease was changed by another replica")
+)
+
+// do sends one API request. The token is read every time because the
+// kubelet rotates it.
+func (e *leaseElector) do(ctx context.Context, method, url string, body *lease) (*lease, error) {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	token, err := os.ReadFile(e.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		var out lease
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			return nil, fmt.Errorf("error decoding lease: %v", err)
+		}
+		return &out, nil
+	case http.StatusNotFound:
+		return nil, errLeaseNotFound
+	case http.StatusConflict:
+		return nil, errLeaseConflict
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return nil, fmt.Errorf("%s %s: %s: %s", method, url, res.Status, strings.TrimSpace(string(msg)))
+}
+
+func (e *leaseElector) get(ctx context.Context) (*lease, error) {
+	return e.do(ctx, http.MethodGet, e.url+"/"+e.name, nil)
+}
+
+func (e *leaseElector) ID() string {
+	return e.id
+}
+
+func (e *leaseElector) Leader(ctx context.Context) (string, error) {
+	current, err := e.get(ctx)
+	if errors.Is(err, errLeaseNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if current.expired(time.Now()) {
+		return "", nil
+	}
+	return current.Spec.HolderIdentity, nil
+}
+
+func (e *leaseElector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader && time.Since(e.renewed) < e.duration
+}
+
+func (e *leaseElector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.duration / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.release()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *leaseElector) campaign(ctx context.Context) {
+	start := time.Now()
+	e.mu.Lock()
+	wasLeader := e.leader
+	e.mu.Unlock()
+
+	held, err := e.tryAcquire(ctx, start)
+	if err != nil {
+		log.Printf("Warning: leader election failed: %v", err)
+		return
+	}
+
+	e.mu.Lock()
+	e.leader = held
+	if held {
+		e.renewed = start
+	}
+	e.mu.Unlock()
+
+	switch {
+	case held && !wasLeader:
+		log.Printf("This replica (%s) is now the leader", e.id)
+	case !held && wasLeader:
+		log.Printf("This replica (%s) lost leadership", e.id)
+	}
+}
+
+// tryAcquire creates, renews or takes over the lease and reports whether we
+// hold it afterwards.
+func (e *leaseElector) tryAcquire(ctx context.Context, now time.Time) (bool, error) {
+	stamp := now.UTC().Format(microTime)
+	spec := leaseSpec{
+		HolderIdentity:       e.id,
+		LeaseDurationSeconds: int(e.duration / time.Second),
+		AcquireTime:          stamp,
+		RenewTime:            stamp,
+	}
+
+	current, err := e.get(ctx)
+	if errors.Is(err, errLeaseNotFound) {
+		_, err = e.do(ctx, http.MethodPost, e.url, &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: e.name},
+			Spec:       spec,
+		})
+		if errors.Is(err, errLeaseConflict) {
+			return false, nil // someone else created it first
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case current.Spec.HolderIdentity == e.id:
+		spec.AcquireTime = current.Spec.AcquireTime
+		spec.LeaseTransitions = current.Spec.LeaseTransitions
+	case current.expired(now):
+		spec.LeaseTransitions = current.Spec.LeaseTransitions + 1
+	default:
+		return false, nil
+	}
+	current.Spec = spec
+	_, err = e.do(ctx, http.MethodPut, e.url+"/"+e.name, current)
+	if errors.Is(err, errLeaseConflict) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release empties the lease on shutdown so the next leader doesn't have to
+// wait for it to run out.
+func (e *leaseElector) release() {
+	e.mu.Lock()
+	wasLeader := e.leader
+	e.leader = false
+	e.mu.Unlock()
+	if !wasLeader {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	current, err := e.get(ctx)
+	if err != nil || current.Spec.HolderIdentity != e.id {
+		return
+	}
+	current.Spec.HolderIdentity = ""
+	current.Spec.RenewTime = ""
+	e.do(ctx, http.MethodPut, e.url+"/"+e.name, current)
+}
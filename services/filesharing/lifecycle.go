@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// Manifests are tagged with their expiry class, a number of days, and the
+// bucket gets one lifecycle rule per class. The store then deletes every
+// manifest on time by itself, whichever replica wrote it; the sweeper only
+// has to give back the chunks of files that are gone. Lifecycle rules count
+// whole days, so a file becomes unreadable at its exact expiry (openFile
+// checks it) and its manifest goes at the next class boundary.
+const (
+	expiryTagKey        = "kubefile-expiry"
+	lifecycleRulePrefix = "kubefile-expiry-"
+	// lifecycleGrace is how long after a manifest is due the sweeper waits
+	// for the store before deleting the file itself. MinIO applies rules as
+	// its scanner goes through the bucket, which takes a while.
+	lifecycleGrace = 24 * time.Hour
+)
+
+// lifecycleExpiry is set when the store's lifecycle rules delete expired
+// manifests.
+var lifecycleExpiry bool
+
+// expiryClassDays are the classes offered; the maximum TTL is always one too.
+var expiryClassDays = []int{1, 2, 3, 7, 14, 30, 60, 90, 180, 365}
+
+func daysCeil(d time.Duration) int {
+	days := int((d + 24*time.Hour - 1) / (24 * time.Hour))
+	if days < 1 {
+		return 1
+	}
+	return days
+}
+
+func expiryClasses() []int {
+	maxDays := daysCeil(maxFileTTL)
+	var classes []int
+	for _, days := range expiryClassDays {
+		if days < maxDays {
+			classes = append(classes, days)
+		}
+	}
+	return append(classes, maxDays)
+}
+
+// expiryClass returns the shortest class that keeps a file for at least ttl.
+func expiryClass(ttl time.Duration) int {
+	days := daysCeil(ttl)
+	for _, class := range expiryClasses() {
+		if class >= days {
+			return class
+		}
+	}
+	return days // no rule matches, the sweeper deletes it
+}
+
+func expiryTagValue(days int) string {
+	return fmt.Sprintf("%dd", days)
+}
+
+// expiryTags returns the tags for a manifest written at now.
+func expiryTags(m *fileManifest, now time.Time) map[string]string {
+	expires, ok := m.ExpiresAt()
+	if !ok {
+		return nil
+	}
+	return map[string]string{expiryTagKey: expiryTagValue(expiryClass(expires.Sub(now)))}
+}
+
+// lifecycleDeadline is when a rule of days deletes an object written at
+// written at the latest: rules round up to the next midnight UTC.
+func lifecycleDeadline(written time.Time, days int) time.Time {
+	return written.Add(time.Duration(days) * 24 * time.Hour).UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// sweepAt returns when the sweeper should look at m: when it expires, or
+// with lifecycle rules once the store should have deleted it.
+func (m *fileManifest) sweepAt() (time.Time, bool) {
+	expires, ok := m.ExpiresAt()
+	if !ok || !lifecycleExpiry {
+		return expires, ok
+	}
+	days := expiryClass(expires.Sub(m.Created))
+	return lifecycleDeadline(m.Created, days).Add(lifecycleGrace), true
+}
+
+// setupLifecycle installs the expiry rules when STORAGE_LIFECYCLE allows it
+// and the store supports them, and removes ours when it doesn't allow it.
+// Without rules every expired file is deleted by the sweeper.
+func setupLifecycle(ctx context.Context, store StorageImpl.Storage) {
+	lc, ok := store.(StorageImpl.Lifecycle)
+	if !ok {
+		log.Println("Storage backend has no lifecycle rules, expired files are removed by the sweeper")
+		return
+	}
+
+	var rules []StorageImpl.ExpiryRule
+	enabled := strings.ToLower(getEnv("STORAGE_LIFECYCLE", "on"))
+	if enabled != "off" && enabled != "false" {
+		for _, days := range expiryClasses() {
+			rules = append(rules, StorageImpl.ExpiryRule{
+				ID:       lifecycleRulePrefix + expiryTagValue(days),
+				TagKey:   expiryTagKey,
+				TagValue: expiryTagValue(days),
+				Days:     days,
+			})
+		}
+	}
+
+	err := lc.SetExpiryRules(ctx, lifecycleRulePrefix, rules)
+	switch {
+	case errors.Is(err, StorageImpl.ErrLifecycleUnsupported):
+		log.Println("Object store does not support lifecycle rules, expired files are removed by the sweeper")
+	case err != nil:
+		log.Printf("Warning: could not install lifecycle rules, expired files are removed by the sweeper: %v", err)
+	case len(rules) == 0:
+		log.Println("Lifecycle rules disabled, expired files are removed by the sweeper")
+	default:
+		lifecycleExpiry = true
+		log.Printf("Installed %d lifecycle rules, the object store expires files", len(rules))
+	}
+}
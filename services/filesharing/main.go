@@ -3,34 +3,109 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"net"
-	"os"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 
-	MinioImpl "github.com/Maruqes/KubeFile/services/filesharing/Minio"
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/paths"
 	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
-	"github.com/minio/minio-go/v7"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var minioClient *minio.Client
-
 type FilesharingService struct {
 	filesharing.UnimplementedFileUploadServer
+	store   StorageImpl.Storage
+	jobs    *jobRunner
+	thumbs  *thumbnailer
+	imports *importer
+}
+
+func NewFilesharingService(store StorageImpl.Storage, jobs *jobRunner, thumbs *thumbnailer, imports *importer) *FilesharingService {
+	return &FilesharingService{store: store, jobs: jobs, thumbs: thumbs, imports: imports}
 }
 
+// chunkSize is the window GetChunk serves; it matches the 30MB chunks the
+// browser uploads and stays under the 31MB gRPC message limit.
+const chunkSize = 30 * 1024 * 1024
+
+// downloadFrameSize is how much data each DownloadFile message carries.
+const downloadFrameSize = 1024 * 1024
+
+// maxEncryptedMetadata bounds the client metadata blob of end-to-end
+// encrypted files; it only holds a name, a size and a content type.
+const maxEncryptedMetadata = 4096
+
 func (f *FilesharingService) UploadFile(ctx context.Context, req *filesharing.UploadFileRequest) (*filesharing.UploadFileResponse, error) {
-	err := MinioImpl.ClearFile(ctx, minioClient, "ficheiros", req.FileName)
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	if len(req.EncryptedMetadata) > maxEncryptedMetadata {
+		return nil, status.Errorf(codes.InvalidArgument, "encrypted metadata is larger than %d bytes", maxEncryptedMetadata)
+	}
+	ttl, err := fileTTL(req.ExpiresInSeconds)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
 	if err != nil {
-		return nil, fmt.Errorf("error clearing file: %v", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts := uploadOptions{
+		EncryptedMetadata: req.EncryptedMetadata,
+		TTL:               ttl,
+		KeepForever:       req.KeepForever,
+		Owner:             req.Owner,
+		Tags:              tags,
+		Conflict:          uploadConflict{Policy: policy, IfMatch: req.IfMatch, IfNoneMatch: req.IfNoneMatch},
+	}
+	// Ciphertext from end-to-end encrypted uploads never compresses.
+	if len(req.EncryptedMetadata) == 0 {
+		codec, err := chooseCodec(req.Compression, req.FileName, req.FileContent[:min(512, len(req.FileContent))])
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		opts.Codec = codec
+		opts.ContentType = sniffContentType(req.FileName, req.FileContent[:min(512, len(req.FileContent))])
+	}
+	if err := f.prepareFile(ctx, req.FileName, req.Owner, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	target, err := uploadTarget(ctx, f.store, req.FileName, req.Owner, opts.Conflict)
+	if err != nil {
+		return nil, conflictError(req.FileName, err)
+	}
+	if err := f.checkReplace(ctx, target, req.Owner, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	req.FileName = target
+
+	if req.Complete {
+		manifest, err := putWholeFile(ctx, f.store, req.FileName, req.FileContent, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error uploading file: %v", err)
+		}
+		log.Printf("Uploaded file %s (%d bytes)", req.FileName, len(req.FileContent))
+		f.thumbs.Enqueue(manifest)
+		return &filesharing.UploadFileResponse{FileName: req.FileName, ExpiresAt: unixOrZero(manifest.Expires)}, nil
 	}
-	log.Println("Cleared file:", req.FileName)
 
-	err = MinioImpl.AddChunkToFile(ctx, minioClient, "ficheiros", req.FileName, req.FileContent)
+	session, err := startUpload(ctx, f.store, req.FileName, opts)
 	if err != nil {
+		return nil, fmt.Errorf("error starting upload: %v", err)
+	}
+	log.Println("Started upload:", req.FileName)
+
+	if _, err := putPart(ctx, f.store, session, 1, req.FileContent); err != nil {
 		return nil, fmt.Errorf("error uploading file: %v", err)
 	}
 	log.Printf("Added chunk to file %s", req.FileName)
@@ -41,12 +116,34 @@ func (f *FilesharingService) UploadFile(ctx context.Context, req *filesharing.Up
 	return res, nil
 }
 
+// loadUpload finds the upload session of fileName if user started it, or
+// returns a FailedPrecondition status. Other users' uploads look the same as
+// no upload at all.
+func (f *FilesharingService) loadUpload(ctx context.Context, fileName, user string, isAdmin bool) (*uploadSession, error) {
+	session, err := loadSession(ctx, f.store, fileName)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		return nil, fmt.Errorf("error loading upload session: %v", err)
+	}
+	if err != nil || (session.Owner != user && !isAdmin) {
+		return nil, status.Errorf(codes.FailedPrecondition, "no upload in progress for %s", fileName)
+	}
+	return session, nil
+}
+
 func (f *FilesharingService) AddChunk(ctx context.Context, req *filesharing.AddChunkRequest) (*filesharing.AddChunkResponse, error) {
-	err := MinioImpl.AddChunkToFile(ctx, minioClient, "ficheiros", req.FileName, req.ChunkData)
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	session, err := f.loadUpload(ctx, req.FileName, req.Owner, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	number, err := putPart(ctx, f.store, session, int(req.PartNumber), req.ChunkData)
 	if err != nil {
 		return nil, fmt.Errorf("error adding chunk to file: %v", err)
 	}
-	log.Printf("Added chunk to file %s", req.FileName)
+	log.Printf("Added part %d to file %s", number, req.FileName)
 
 	return &filesharing.AddChunkResponse{
 		Success: true,
@@ -54,44 +151,93 @@ func (f *FilesharingService) AddChunk(ctx context.Context, req *filesharing.AddC
 	}, nil
 }
 
-func (f *FilesharingService) GetChunk(ctx context.Context, req *filesharing.GetChunkRequest) (*filesharing.GetChunkResponse, error) {
+func (f *FilesharingService) CompleteUpload(ctx context.Context, req *filesharing.CompleteUploadRequest) (*filesharing.CompleteUploadResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	session, err := f.loadUpload(ctx, req.FileName, req.Owner, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkCommit(ctx, session, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	manifest, err := completeUpload(ctx, f.store, req.FileName)
+	if err != nil {
+		if StorageImpl.IsNotFound(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "no upload in progress for %s", req.FileName)
+		}
+		return nil, conflictError(req.FileName, err)
+	}
+	log.Printf("Completed upload of %s (%d bytes in %d chunks)", manifest.Name, manifest.Size, len(manifest.Chunks))
+	f.thumbs.Enqueue(manifest)
 
-	// Build chunk object name based on index
-	chunkObjectName := req.FileName + "_chunk_" + fmt.Sprintf("%d", req.ChunkIndex)
+	return &filesharing.CompleteUploadResponse{
+		FileName:  manifest.Name,
+		Size:      manifest.Size,
+		ETag:      manifest.ETag(),
+		ExpiresAt: unixOrZero(manifest.Expires),
+	}, nil
+}
 
-	//check if the chunk exists
-	_, err := minioClient.StatObject(ctx, "ficheiros", chunkObjectName, minio.StatObjectOptions{})
-	if err != nil {
-		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-			return nil, fmt.Errorf("chunk %s does not exist", chunkObjectName)
+func (f *FilesharingService) AbortUpload(ctx context.Context, req *filesharing.AbortUploadRequest) (*filesharing.AbortUploadResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	if _, err := f.loadUpload(ctx, req.FileName, req.Owner, req.IsAdmin); err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			return &filesharing.AbortUploadResponse{Success: false}, nil
 		}
-		return nil, fmt.Errorf("error checking chunk existence: %v", err)
+		return nil, err
+	}
+	err := abortUpload(ctx, f.store, req.FileName)
+	if err != nil && !StorageImpl.IsNotFound(err) {
+		return nil, fmt.Errorf("error aborting upload: %v", err)
 	}
+	return &filesharing.AbortUploadResponse{Success: err == nil}, nil
+}
 
-	object, err := minioClient.GetObject(ctx, "ficheiros", chunkObjectName, minio.GetObjectOptions{})
+func (f *FilesharingService) GetChunk(ctx context.Context, req *filesharing.GetChunkRequest) (*filesharing.GetChunkResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	file, err := openFile(ctx, f.store, req.FileName)
 	if err != nil {
-		return nil, fmt.Errorf("error getting chunk from MinIO: %v", err)
+		if StorageImpl.IsNotFound(err) {
+			return nil, fmt.Errorf("file %s does not exist", req.FileName)
+		}
+		return nil, fmt.Errorf("error opening file: %v", err)
 	}
-	defer object.Close()
 
-	const maxChunkSize = 30 * 1024 * 1024 // 30MB
-	chunkData := make([]byte, maxChunkSize)
-
-	n, err := object.Read(chunkData)
-	if err != nil && err.Error() != "EOF" {
-		return nil, fmt.Errorf("error reading chunk data: %v", err)
-	}
-	if n == 0 {
-		return nil, fmt.Errorf("no data read from chunk %s", chunkObjectName)
+	// Chunked files are served chunk by chunk; single-object files in fixed
+	// windows of chunkSize.
+	var offset, length int64
+	var isLastChunk bool
+	if file.Chunked {
+		if req.ChunkIndex < 0 || int(req.ChunkIndex) >= len(file.Segments) {
+			return nil, fmt.Errorf("chunk %d of %s does not exist", req.ChunkIndex, req.FileName)
+		}
+		for _, seg := range file.Segments[:req.ChunkIndex] {
+			offset += seg.Size
+		}
+		length = file.Segments[req.ChunkIndex].Size
+		isLastChunk = int(req.ChunkIndex) == len(file.Segments)-1
+	} else {
+		offset = int64(req.ChunkIndex) * chunkSize
+		if req.ChunkIndex < 0 || (offset >= file.Size && !(offset == 0 && file.Size == 0)) {
+			return nil, fmt.Errorf("chunk %d of %s does not exist", req.ChunkIndex, req.FileName)
+		}
+		length = min(chunkSize, file.Size-offset)
+		isLastChunk = offset+length >= file.Size
 	}
 
-	// Trim the slice to actual data size
-	chunkData = chunkData[:n]
+	reader := file.NewReader(ctx, f.store, offset, length)
+	defer reader.Close()
 
-	// Check if this is the last chunk by looking for the next chunk
-	nextChunkName := req.FileName + "_chunk_" + fmt.Sprintf("%d", req.ChunkIndex+1)
-	_, err = minioClient.StatObject(ctx, "ficheiros", nextChunkName, minio.StatObjectOptions{})
-	isLastChunk := err != nil // If next chunk doesn't exist, this is the last chunk
+	chunkData, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk data: %v", err)
+	}
 
 	return &filesharing.GetChunkResponse{
 		ChunkData:   chunkData,
@@ -100,103 +246,909 @@ func (f *FilesharingService) GetChunk(ctx context.Context, req *filesharing.GetC
 	}, nil
 }
 
+func (f *FilesharingService) DownloadFile(req *filesharing.DownloadFileRequest, stream filesharing.FileUpload_DownloadFileServer) error {
+	if err := cleanName(&req.FileName); err != nil {
+		return err
+	}
+	if req.Entry != "" {
+		return f.downloadEntry(req, stream)
+	}
+	if req.Version < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid version %d", req.Version)
+	}
+	ctx := stream.Context()
+	var file *storedFile
+	var err error
+	if req.Version > 0 {
+		file, err = openVersion(ctx, f.store, req.FileName, int(req.Version))
+	} else {
+		file, err = openFile(ctx, f.store, req.FileName)
+	}
+	if err != nil {
+		if StorageImpl.IsNotFound(err) && req.Version > 0 {
+			return status.Errorf(codes.NotFound, "version %d of %s does not exist", req.Version, req.FileName)
+		}
+		if StorageImpl.IsNotFound(err) {
+			return status.Errorf(codes.NotFound, "file %s does not exist", req.FileName)
+		}
+		return fmt.Errorf("error opening file: %v", err)
+	}
+
+	offset, length, err := readRange(req.FileName, file.Size, req.Offset, req.Length)
+	if err != nil {
+		return err
+	}
+
+	first := &filesharing.DownloadFileResponse{
+		Size:              file.Size,
+		Offset:            offset,
+		Length:            length,
+		EncryptedMetadata: file.EncryptedMetadata,
+		ExpiresAt:         unixOrZero(file.Expires),
+		ContentType:       file.ContentType,
+		ETag:              file.ETag,
+		Modified:          unixOrZero(file.Modified),
+	}
+
+	var reader io.ReadCloser
+	wholeFile := offset == 0 && length == file.Size
+	if encoding := file.PickEncoding(req.AcceptEncoding); wholeFile && encoding != "" {
+		reader, first.Length = file.NewEncodedReader(ctx, f.store, encoding)
+		first.ContentEncoding = encoding
+	} else {
+		reader = file.NewReader(ctx, f.store, offset, length)
+	}
+	defer reader.Close()
+
+	return sendStream(stream, req.FileName, first, reader)
+}
+
+// readRange resolves the Offset and Length of a DownloadFileRequest against
+// a file of size bytes.
+func readRange(fileName string, size, offset, length int64) (int64, int64, error) {
+	if offset < 0 {
+		offset = max(size+offset, 0)
+		length = size - offset
+	}
+	if offset > size || (offset == size && size > 0) {
+		return 0, 0, status.Errorf(codes.OutOfRange, "offset %d is past the end of %s (%d bytes)", offset, fileName, size)
+	}
+	if length <= 0 || offset+length > size {
+		length = size - offset
+	}
+	return offset, length, nil
+}
+
+// sendStream sends first and then the rest of reader in downloadFrameSize
+// messages. first.Length bytes are expected, or everything up to EOF when
+// it is negative.
+func sendStream(stream filesharing.FileUpload_DownloadFileServer, fileName string, first *filesharing.DownloadFileResponse, reader io.Reader) error {
+	length := first.Length
+	buf := make([]byte, downloadFrameSize)
+	msg := first
+	var sent int64
+	for {
+		want := int64(len(buf))
+		if length >= 0 {
+			want = min(want, length-sent)
+		}
+		n, err := io.ReadFull(reader, buf[:want])
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !(eof && length < 0) {
+			return fmt.Errorf("error reading %s at %d: %v", fileName, sent, err)
+		}
+		sent += int64(n)
+
+		msg.Data = buf[:n]
+		if n > 0 || msg == first {
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+		if eof || (length >= 0 && sent >= length) {
+			return nil
+		}
+		msg = &filesharing.DownloadFileResponse{}
+	}
+}
+
 func (f *FilesharingService) GetStorageInfo(ctx context.Context, req *filesharing.GetStorageInfoRequest) (*filesharing.GetStorageInfoResponse, error) {
-	storageInfo, err := MinioImpl.GetStorageLimitsData(ctx, minioClient)
+	storageInfo, err := getStorageLimitsData(ctx, f.store)
 	if err != nil {
 		return nil, fmt.Errorf("error getting storage info: %v", err)
 	}
 
-	return &storageInfo, nil
+	return storageInfo, nil
+}
+
+func (f *FilesharingService) RotateKeys(ctx context.Context, req *filesharing.RotateKeysRequest) (*filesharing.RotateKeysResponse, error) {
+	if masterKeys == nil {
+		return nil, status.Error(codes.FailedPrecondition, "encryption at rest is not enabled")
+	}
+	rewrapped, failed, err := rotateKeys(ctx, f.store)
+	if err != nil {
+		return nil, fmt.Errorf("error rotating keys: %v", err)
+	}
+	return &filesharing.RotateKeysResponse{
+		ActiveKeyId: masterKeys.activeID,
+		Rewrapped:   int32(rewrapped),
+		Failed:      int32(failed),
+	}, nil
+}
+
+func (f *FilesharingService) GetJobStatus(ctx context.Context, req *filesharing.GetJobStatusRequest) (*filesharing.GetJobStatusResponse, error) {
+	leader, err := f.jobs.leader.Leader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the leader: %v", err)
+	}
+	res := &filesharing.GetJobStatusResponse{
+		Backend: f.jobs.backend,
+		Leader:  leader,
+		Replica: f.jobs.leader.ID(),
+	}
+	for _, job := range f.jobs.Status(ctx) {
+		res.Jobs = append(res.Jobs, &filesharing.JobStatus{
+			Name:            job.Name,
+			IntervalSeconds: int64(job.Interval / time.Second),
+			LastRun:         unixOrZero(job.LastRun),
+			DurationMs:      job.Duration.Milliseconds(),
+			Error:           job.Error,
+			RunBy:           job.RunBy,
+		})
+	}
+	thumbs := f.thumbs.Stats()
+	res.Thumbnails = &filesharing.ThumbnailStats{
+		Workers:   int32(thumbs.Workers),
+		Queued:    int32(thumbs.Queued),
+		Active:    int32(thumbs.Active),
+		Enqueued:  thumbs.Enqueued,
+		Generated: thumbs.Generated,
+		Failed:    thumbs.Failed,
+		Retried:   thumbs.Retried,
+		Dropped:   thumbs.Dropped,
+	}
+	return res, nil
+}
+
+func toFileInfo(info *fileInfo) *filesharing.FileInfo {
+	out := &filesharing.FileInfo{
+		Name:        info.Name,
+		Size:        info.Size,
+		ExpiresAt:   unixOrZero(info.Expires),
+		Owner:       info.Owner,
+		Tags:        info.Tags,
+		EndToEnd:    info.EndToEnd,
+		ETag:        info.ETag,
+		Chunks:      int32(info.Chunks),
+		Version:     int32(info.Version),
+		ContentType: info.ContentType,
+		Thumbnail:   info.HasThumbnail(),
+	}
+	if !info.Created.IsZero() {
+		out.Created = info.Created.Unix()
+	}
+	return out
+}
+
+func (f *FilesharingService) listFiles(ctx context.Context, query fileQuery, cursor string, count int32) ([]*filesharing.FileInfo, string, error) {
+	if query.Tag != "" {
+		tags, err := normalizeTags([]string{query.Tag})
+		if err != nil {
+			return nil, "", status.Error(codes.InvalidArgument, err.Error())
+		}
+		query.Tag = tags[0]
+	}
+	files, next, err := listFiles(ctx, f.store, query, cursor, int(count))
+	if err == errBadCursor {
+		return nil, "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing files: %v", err)
+	}
+	out := make([]*filesharing.FileInfo, len(files))
+	for i := range files {
+		out[i] = toFileInfo(&files[i])
+	}
+	return out, next, nil
+}
+
+func (f *FilesharingService) ListFiles(ctx context.Context, req *filesharing.ListFilesRequest) (*filesharing.ListFilesResponse, error) {
+	query := fileQuery{
+		Owner:      req.Owner,
+		Prefix:     req.Prefix,
+		Tag:        req.Tag,
+		MinSize:    req.MinSize,
+		MaxSize:    req.MaxSize,
+		StartAfter: req.StartAfter,
+	}
+	if req.CreatedAfter > 0 {
+		query.CreatedAfter = time.Unix(req.CreatedAfter, 0)
+	}
+	if req.CreatedBefore > 0 {
+		query.CreatedBefore = time.Unix(req.CreatedBefore, 0)
+	}
+	files, next, err := f.listFiles(ctx, query, req.Cursor, req.Count)
+	if err != nil {
+		return nil, err
+	}
+	return &filesharing.ListFilesResponse{Files: files, NextCursor: next}, nil
+}
+
+func (f *FilesharingService) SearchFiles(ctx context.Context, req *filesharing.SearchFilesRequest) (*filesharing.SearchFilesResponse, error) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty search query")
+	}
+	files, next, err := f.listFiles(ctx, fileQuery{Owner: req.Owner, Search: strings.TrimSpace(req.Query)}, req.Cursor, req.Count)
+	if err != nil {
+		return nil, err
+	}
+	return &filesharing.SearchFilesResponse{Files: files, NextCursor: next}, nil
+}
+
+func (f *FilesharingService) StatFile(ctx context.Context, req *filesharing.StatFileRequest) (*filesharing.StatFileResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	info, err := statFile(ctx, f.store, req.FileName)
+	if err != nil {
+		if StorageImpl.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "file %s does not exist", req.FileName)
+		}
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	return &filesharing.StatFileResponse{File: toFileInfo(info)}, nil
 }
 
-func setupBucket(minioClient *minio.Client) error {
-	if minioClient == nil {
-		return fmt.Errorf("MinIO client is not available")
+// cleanName replaces *name with its clean form (see paths.Clean), or
+// returns an InvalidArgument error.
+func cleanName(name *string) error {
+	cleaned, err := paths.Clean(*name)
+	if err == paths.ErrEmpty {
+		return status.Error(codes.InvalidArgument, "path not provided")
 	}
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid path %q", *name)
+	}
+	*name = cleaned
+	return nil
+}
 
-	bucketName := "ficheiros"
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// prepareWrite checks that user can create name in its folder, and creates
+// the folders above name that don't exist yet.
+func (f *FilesharingService) prepareWrite(ctx context.Context, name, user string, isAdmin bool) error {
+	file, err := fileAbove(ctx, f.store, name)
+	if err != nil {
+		return fmt.Errorf("error reading folders: %v", err)
+	}
+	if file != "" {
+		return status.Errorf(codes.InvalidArgument, "%s is a file, not a folder", file)
+	}
+	chain, err := folderChain(ctx, f.store, paths.Dir(name))
+	if err != nil {
+		return fmt.Errorf("error reading folders: %v", err)
+	}
+	if accessTo(chain, user, isAdmin) == accessNone {
+		return status.Errorf(codes.PermissionDenied, "%s can't write to folder %s", user, paths.Dir(name))
+	}
+	return makeFolders(ctx, f.store, paths.Parents(name), user)
+}
 
-	exists, err := minioClient.BucketExists(ctx, bucketName)
+// prepareFile is prepareWrite for a file, whose name can't be a folder.
+func (f *FilesharingService) prepareFile(ctx context.Context, name, user string, isAdmin bool) error {
+	taken, err := isFolder(ctx, f.store, name)
 	if err != nil {
-		return fmt.Errorf("error checking bucket: %v", err)
+		return fmt.Errorf("error reading folders: %v", err)
+	}
+	if taken {
+		return status.Errorf(codes.AlreadyExists, "%s is a folder", name)
 	}
+	return f.prepareWrite(ctx, name, user, isAdmin)
+}
 
-	if !exists {
-		err = minioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
+// conflictError turns the errors of uploadTarget into statuses; others are
+// internal errors of the upload of name.
+func conflictError(name string, err error) error {
+	switch err {
+	case errNameTaken:
+		return status.Errorf(codes.AlreadyExists, "%s already exists", name)
+	case errUploadBusy:
+		return status.Errorf(codes.AlreadyExists, "someone else is uploading %s", name)
+	case errPrecondition:
+		return status.Errorf(codes.Aborted, "%s does not match the upload's preconditions", name)
+	}
+	return fmt.Errorf("error uploading %s: %v", name, err)
+}
+
+// ownedFile loads the manifest of fileName for a change by user, who has to
+// own it or manage a folder it is in. Files in the older layouts have no
+// manifest to change and are a FailedPrecondition.
+func (f *FilesharingService) ownedFile(ctx context.Context, fileName, user string, isAdmin bool) (*fileManifest, error) {
+	manifest, err := loadManifest(ctx, f.store, fileName)
+	if StorageImpl.IsNotFound(err) {
+		if _, err := openFile(ctx, f.store, fileName); err == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s is stored in an old layout, upload it again first", fileName)
+		}
+		return nil, status.Errorf(codes.NotFound, "file %s does not exist", fileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	if expires, ok := manifest.ExpiresAt(); ok && !expires.After(time.Now()) {
+		return nil, status.Errorf(codes.NotFound, "file %s does not exist", fileName)
+	}
+	if !manifest.ownedBy(user, isAdmin) {
+		chain, err := folderChain(ctx, f.store, paths.Dir(fileName))
 		if err != nil {
-			return fmt.Errorf("error creating bucket: %v", err)
+			return nil, fmt.Errorf("error reading folders: %v", err)
+		}
+		if accessTo(chain, user, isAdmin) != accessManager {
+			return nil, status.Errorf(codes.PermissionDenied, "%s is not the owner of %s", user, fileName)
 		}
-		log.Printf("✅ Bucket created: %s", bucketName)
+	}
+	return manifest, nil
+}
+
+// checkDestination makes sure user may write dst: it must be free, or
+// replaceable by them when overwrite is set.
+func (f *FilesharingService) checkDestination(ctx context.Context, src, dst, user string, isAdmin, overwrite bool) error {
+	if dst == src {
+		return status.Error(codes.InvalidArgument, "destination is the file itself")
+	}
+	if !overwrite {
+		if _, err := statFile(ctx, f.store, dst); err == nil {
+			return status.Errorf(codes.AlreadyExists, "%s already exists", dst)
+		} else if !StorageImpl.IsNotFound(err) {
+			return fmt.Errorf("error reading destination: %v", err)
+		}
+		return nil
+	}
+	return f.checkReplace(ctx, dst, user, isAdmin)
+}
+
+// checkReplace makes sure user may replace the file stored as name, if there
+// is one: they have to own it or manage a folder it is in.
+func (f *FilesharingService) checkReplace(ctx context.Context, name, user string, isAdmin bool) error {
+	_, err := f.ownedFile(ctx, name, user, isAdmin)
+	switch {
+	case status.Code(err) == codes.NotFound:
+		return nil
+	case status.Code(err) == codes.FailedPrecondition && isAdmin:
+		return nil // committing the manifest clears the old layouts
+	}
+	return err
+}
+
+// checkCommit is checkReplace for the file session is about to be committed
+// to. Sessions that rename on conflict never replace anything.
+func (f *FilesharingService) checkCommit(ctx context.Context, session *uploadSession, isAdmin bool) error {
+	if session.Conflict.Policy == conflictRename {
+		return nil
+	}
+	return f.checkReplace(ctx, session.FileName, session.Owner, isAdmin)
+}
+
+func (f *FilesharingService) RenameFile(ctx context.Context, req *filesharing.RenameFileRequest) (*filesharing.RenameFileResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	if err := cleanName(&req.Destination); err != nil {
+		return nil, err
+	}
+	src, err := f.ownedFile(ctx, req.FileName, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkDestination(ctx, req.FileName, req.Destination, req.User, req.IsAdmin, req.Overwrite); err != nil {
+		return nil, err
+	}
+	if err := f.prepareFile(ctx, req.Destination, req.User, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	renamed, err := renameFile(ctx, f.store, src, req.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("error renaming file: %v", err)
+	}
+	log.Printf("Renamed %s to %s (requested by %s)", req.FileName, req.Destination, req.User)
+	info := renamed.Info()
+	return &filesharing.RenameFileResponse{File: toFileInfo(&info)}, nil
+}
+
+func (f *FilesharingService) CopyFile(ctx context.Context, req *filesharing.CopyFileRequest) (*filesharing.CopyFileResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	if err := cleanName(&req.Destination); err != nil {
+		return nil, err
+	}
+	src, err := f.ownedFile(ctx, req.FileName, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.checkDestination(ctx, req.FileName, req.Destination, req.User, req.IsAdmin, req.Overwrite); err != nil {
+		return nil, err
+	}
+	if err := f.prepareFile(ctx, req.Destination, req.User, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	copied, err := copyManifest(ctx, f.store, src, req.Destination, req.User)
+	if err != nil {
+		return nil, fmt.Errorf("error copying file: %v", err)
+	}
+	log.Printf("Copied %s to %s (requested by %s)", req.FileName, req.Destination, req.User)
+	info := copied.Info()
+	return &filesharing.CopyFileResponse{File: toFileInfo(&info)}, nil
+}
+
+func (f *FilesharingService) DeleteFile(ctx context.Context, req *filesharing.DeleteFileRequest) (*filesharing.DeleteFileResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	_, err := f.ownedFile(ctx, req.FileName, req.User, req.IsAdmin)
+	if status.Code(err) == codes.FailedPrecondition {
+		// Files in the older layouts have no owner.
+		if !req.IsAdmin {
+			return nil, status.Errorf(codes.PermissionDenied, "%s is not the owner of %s", req.User, req.FileName)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := deleteFile(ctx, f.store, req.FileName); err != nil {
+		return nil, fmt.Errorf("error deleting file: %v", err)
+	}
+	log.Printf("Deleted file %s (requested by %s)", req.FileName, req.User)
+	return &filesharing.DeleteFileResponse{}, nil
+}
+
+func toFileVersion(manifest *fileManifest, current bool) *filesharing.FileVersion {
+	return &filesharing.FileVersion{
+		Version: int32(manifest.Number()),
+		Size:    manifest.Size,
+		Created: manifest.Created.Unix(),
+		ETag:    manifest.ETag(),
+		Owner:   manifest.Owner,
+		Current: current,
+	}
+}
+
+func (f *FilesharingService) ListVersions(ctx context.Context, req *filesharing.ListVersionsRequest) (*filesharing.ListVersionsResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	current, err := loadManifest(ctx, f.store, req.FileName)
+	if err == nil {
+		if expires, ok := current.ExpiresAt(); ok && !expires.After(time.Now()) {
+			err = StorageImpl.ErrNotFound
+		}
+	}
+	if err != nil {
+		if StorageImpl.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "file %s does not exist", req.FileName)
+		}
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+	versions, err := listVersions(ctx, f.store, req.FileName)
+	if err != nil {
+		return nil, err
+	}
+	out := []*filesharing.FileVersion{toFileVersion(current, true)}
+	for i := len(versions) - 1; i >= 0; i-- {
+		out = append(out, toFileVersion(versions[i], false))
+	}
+	return &filesharing.ListVersionsResponse{Versions: out}, nil
+}
+
+func (f *FilesharingService) RestoreVersion(ctx context.Context, req *filesharing.RestoreVersionRequest) (*filesharing.RestoreVersionResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	current, err := f.ownedFile(ctx, req.FileName, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if int(req.Version) == current.Number() {
+		return nil, status.Errorf(codes.InvalidArgument, "version %d is already the current version", req.Version)
+	}
+	version, err := loadVersion(ctx, f.store, req.FileName, int(req.Version))
+	if err != nil {
+		if StorageImpl.IsNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "version %d of %s does not exist", req.Version, req.FileName)
+		}
+		return nil, fmt.Errorf("error reading version: %v", err)
+	}
+	restored, err := restoreVersion(ctx, f.store, current, version)
+	if err != nil {
+		return nil, fmt.Errorf("error restoring version: %v", err)
+	}
+	log.Printf("Restored version %d of %s as version %d (requested by %s)", req.Version, req.FileName, restored.Number(), req.User)
+	info := restored.Info()
+	return &filesharing.RestoreVersionResponse{File: toFileInfo(&info)}, nil
+}
+
+// GetThumbnail serves thumbnails of the current content of a file. Like
+// downloads, anyone who knows the name can have them.
+func (f *FilesharingService) GetThumbnail(ctx context.Context, req *filesharing.GetThumbnailRequest) (*filesharing.GetThumbnailResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	manifest, err := loadManifest(ctx, f.store, req.FileName)
+	if err == nil {
+		if expires, ok := manifest.ExpiresAt(); ok && !expires.After(time.Now()) {
+			err = StorageImpl.ErrNotFound
+		}
+	}
+	if StorageImpl.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "file %s does not exist", req.FileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+
+	size := thumbnailSize(int(req.Size))
+	data, contentType, err := loadThumbnail(ctx, f.store, manifest, size)
+	if err == errNoThumbnail {
+		return nil, status.Errorf(codes.FailedPrecondition, "%s has no thumbnail", req.FileName)
+	}
+	if StorageImpl.IsNotFound(err) {
+		f.thumbs.Enqueue(manifest)
+		return nil, status.Errorf(codes.Unavailable, "thumbnail of %s is not ready yet", req.FileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading thumbnail: %v", err)
+	}
+	return &filesharing.GetThumbnailResponse{
+		Data:        data,
+		ContentType: contentType,
+		Size:        int32(size),
+		ETag:        manifest.ETag(),
+	}, nil
+}
+
+func (f *FilesharingService) ImportFromURL(ctx context.Context, req *filesharing.ImportFromURLRequest) (*filesharing.ImportFromURLResponse, error) {
+	// A trailing slash asks for a folder; the name from the URL goes in it.
+	if strings.Trim(req.FileName, "/") == "" {
+		req.FileName = ""
 	} else {
-		log.Printf("ℹ️  Bucket already exists: %s", bucketName)
+		folder := strings.HasSuffix(req.FileName, "/")
+		if err := cleanName(&req.FileName); err != nil {
+			return nil, err
+		}
+		if folder {
+			req.FileName += "/"
+		}
+	}
+	ttl, err := fileTTL(req.ExpiresInSeconds)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts := uploadOptions{
+		TTL:         ttl,
+		KeepForever: req.KeepForever,
+		Owner:       req.Owner,
+		Tags:        tags,
+		Conflict:    uploadConflict{Policy: policy},
+	}
+	rec, err := f.imports.Start(ctx, f, req.URL, req.FileName, opts, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Importing %s into %s (requested by %s)", rec.URL, rec.FileName, req.Owner)
+	return &filesharing.ImportFromURLResponse{Import: toImportInfo(rec)}, nil
+}
+
+func (f *FilesharingService) GetImport(ctx context.Context, req *filesharing.GetImportRequest) (*filesharing.GetImportResponse, error) {
+	rec, err := loadImport(ctx, f.store, req.ID)
+	if err == nil && rec.Owner != req.User && !req.IsAdmin {
+		err = StorageImpl.ErrNotFound
+	}
+	if StorageImpl.IsNotFound(err) {
+		return nil, status.Errorf(codes.NotFound, "import %s does not exist", req.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading import: %v", err)
+	}
+	return &filesharing.GetImportResponse{Import: toImportInfo(rec)}, nil
+}
+
+func toFolderInfo(fd *folder, manage bool) *filesharing.FolderInfo {
+	out := &filesharing.FolderInfo{
+		Path:    fd.Path,
+		Owner:   fd.Owner,
+		Members: fd.Members,
+		Created: fd.Created.Unix(),
+	}
+	if manage {
+		out.Shares = fd.Shares
+		out.CanManage = true
+	}
+	return out
+}
+
+// managedFolder loads the record of path for a change by user, who has to
+// manage it.
+func (f *FilesharingService) managedFolder(ctx context.Context, path, user string, isAdmin bool) (*folder, error) {
+	chain, err := folderChain(ctx, f.store, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading folders: %v", err)
+	}
+	if len(chain) == 0 || chain[len(chain)-1].Path != path {
+		return nil, status.Errorf(codes.NotFound, "folder %s does not exist", path)
+	}
+	if accessTo(chain, user, isAdmin) != accessManager {
+		return nil, status.Errorf(codes.PermissionDenied, "%s can't manage folder %s", user, path)
 	}
+	return chain[len(chain)-1], nil
+}
 
+// checkFolderFree makes sure no file or folder is called path.
+func (f *FilesharingService) checkFolderFree(ctx context.Context, path string) error {
+	file, err := isFile(ctx, f.store, path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	if file {
+		return status.Errorf(codes.AlreadyExists, "a file called %s already exists", path)
+	}
+	exists, err := isFolder(ctx, f.store, path)
+	if err != nil {
+		return fmt.Errorf("error reading folders: %v", err)
+	}
+	if exists {
+		return status.Errorf(codes.AlreadyExists, "folder %s already exists", path)
+	}
 	return nil
 }
 
-func getFileTTL() time.Duration {
-	const defaultTTL = 5 * 24 * time.Hour
-	val := strings.TrimSpace(os.Getenv("FILE_TTL_HOURS"))
-	if val == "" {
-		return defaultTTL
+func (f *FilesharingService) CreateFolder(ctx context.Context, req *filesharing.CreateFolderRequest) (*filesharing.CreateFolderResponse, error) {
+	if err := cleanName(&req.Path); err != nil {
+		return nil, err
+	}
+	if err := f.checkFolderFree(ctx, req.Path); err != nil {
+		return nil, err
+	}
+	if err := f.prepareWrite(ctx, req.Path, req.User, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	fd := &folder{Path: req.Path, Owner: req.User, Created: time.Now().UTC()}
+	if err := saveFolder(ctx, f.store, fd); err != nil {
+		return nil, fmt.Errorf("error creating folder: %v", err)
+	}
+	log.Printf("Created folder %s (requested by %s)", req.Path, req.User)
+	return &filesharing.CreateFolderResponse{Folder: toFolderInfo(fd, true)}, nil
+}
+
+func (f *FilesharingService) ListFolder(ctx context.Context, req *filesharing.ListFolderRequest) (*filesharing.ListFolderResponse, error) {
+	path, err := paths.CleanFolder(req.Path)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid path %q", req.Path)
+	}
+	chain, err := folderChain(ctx, f.store, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading folders: %v", err)
+	}
+	var current *folder
+	if path != "" {
+		if len(chain) == 0 || chain[len(chain)-1].Path != path {
+			return nil, status.Errorf(codes.NotFound, "folder %s does not exist", path)
+		}
+		current = chain[len(chain)-1]
+	}
+
+	access := accessTo(chain, req.User, req.IsAdmin)
+	if req.Share != "" {
+		share, err := loadShare(ctx, f.store, req.Share)
+		if err == errBadShareToken || StorageImpl.IsNotFound(err) {
+			return nil, status.Error(codes.NotFound, "share link does not exist")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading share link: %v", err)
+		}
+		if !paths.Within(path, share.Folder) {
+			return nil, status.Errorf(codes.PermissionDenied, "%s is not in the shared folder", path)
+		}
+		access = accessMember
+	} else if path != "" && access == accessNone {
+		return nil, status.Errorf(codes.PermissionDenied, "%s can't read folder %s", req.User, path)
+	}
+
+	// The root holds everybody's loose files; users only see their own.
+	query := fileQuery{Prefix: path + "/", Direct: true}
+	if path == "" {
+		query.Prefix = ""
+		if !req.IsAdmin {
+			query.Owner = req.User
+		}
+	}
+	files, next, err := f.listFiles(ctx, query, req.Cursor, req.Count)
+	if err != nil {
+		return nil, err
+	}
+	res := &filesharing.ListFolderResponse{Files: files, NextCursor: next}
+	if current != nil {
+		res.Folder = toFolderInfo(current, access == accessManager)
+	}
+	if req.Cursor != "" {
+		return res, nil
+	}
+
+	subs, err := subfolders(ctx, f.store, path)
+	if err != nil {
+		return nil, fmt.Errorf("error listing folders: %v", err)
+	}
+	for _, sub := range subs {
+		subAccess := access
+		if req.Share == "" && access != accessManager {
+			subAccess = accessTo(append(slices.Clip(chain), sub), req.User, req.IsAdmin)
+		}
+		if subAccess == accessNone {
+			continue // top-level folders of other users
+		}
+		res.Folders = append(res.Folders, toFolderInfo(sub, subAccess == accessManager))
+	}
+	return res, nil
+}
+
+func (f *FilesharingService) MoveFolder(ctx context.Context, req *filesharing.MoveFolderRequest) (*filesharing.MoveFolderResponse, error) {
+	if err := cleanName(&req.Path); err != nil {
+		return nil, err
+	}
+	if err := cleanName(&req.Destination); err != nil {
+		return nil, err
+	}
+	if paths.Within(req.Destination, req.Path) {
+		return nil, status.Errorf(codes.InvalidArgument, "can't move %s into itself", req.Path)
+	}
+	if _, err := f.managedFolder(ctx, req.Path, req.User, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	if err := f.checkFolderFree(ctx, req.Destination); err != nil {
+		return nil, err
+	}
+	if err := f.prepareWrite(ctx, req.Destination, req.User, req.IsAdmin); err != nil {
+		return nil, err
+	}
+
+	moved, files, err := moveFolder(ctx, f.store, req.Path, req.Destination)
+	if err != nil {
+		return nil, fmt.Errorf("error moving folder: %v", err)
+	}
+	log.Printf("Moved folder %s to %s with %d files (requested by %s)", req.Path, req.Destination, files, req.User)
+	return &filesharing.MoveFolderResponse{Folder: toFolderInfo(moved, true), Files: int32(files)}, nil
+}
+
+func (f *FilesharingService) DeleteFolder(ctx context.Context, req *filesharing.DeleteFolderRequest) (*filesharing.DeleteFolderResponse, error) {
+	if err := cleanName(&req.Path); err != nil {
+		return nil, err
+	}
+	if _, err := f.managedFolder(ctx, req.Path, req.User, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	files, err := deleteFolder(ctx, f.store, req.Path, req.Recursive)
+	if err == errFolderNotEmpty {
+		return nil, status.Errorf(codes.FailedPrecondition, "folder %s is not empty", req.Path)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("error deleting folder: %v", err)
+	}
+	log.Printf("Deleted folder %s with %d files (requested by %s)", req.Path, files, req.User)
+	return &filesharing.DeleteFolderResponse{Files: int32(files)}, nil
+}
 
-	hours, err := strconv.Atoi(val)
-	if err != nil || hours <= 0 {
-		log.Printf("invalid FILE_TTL_HOURS value %q, using default %s", val, defaultTTL)
-		return defaultTTL
+func (f *FilesharingService) SetFolderMembers(ctx context.Context, req *filesharing.SetFolderMembersRequest) (*filesharing.SetFolderMembersResponse, error) {
+	if err := cleanName(&req.Path); err != nil {
+		return nil, err
 	}
+	fd, err := f.managedFolder(ctx, req.Path, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	members, err := normalizeMembers(req.Members)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	fd.Members = members
+	if err := saveFolder(ctx, f.store, fd); err != nil {
+		return nil, fmt.Errorf("error saving folder: %v", err)
+	}
+	log.Printf("Set members of folder %s to %v (requested by %s)", req.Path, members, req.User)
+	return &filesharing.SetFolderMembersResponse{Folder: toFolderInfo(fd, true)}, nil
+}
 
-	if hours < 1 {
-		hours = 1
+func (f *FilesharingService) ShareFolder(ctx context.Context, req *filesharing.ShareFolderRequest) (*filesharing.ShareFolderResponse, error) {
+	if err := cleanName(&req.Path); err != nil {
+		return nil, err
 	}
+	fd, err := f.managedFolder(ctx, req.Path, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	token, err := shareFolder(ctx, f.store, fd, req.User)
+	if err != nil {
+		return nil, fmt.Errorf("error sharing folder: %v", err)
+	}
+	log.Printf("Shared folder %s (requested by %s)", req.Path, req.User)
+	return &filesharing.ShareFolderResponse{Token: token}, nil
+}
 
-	return time.Duration(hours) * time.Hour
+func (f *FilesharingService) RevokeShare(ctx context.Context, req *filesharing.RevokeShareRequest) (*filesharing.RevokeShareResponse, error) {
+	share, err := loadShare(ctx, f.store, req.Token)
+	if err == errBadShareToken || StorageImpl.IsNotFound(err) {
+		return nil, status.Error(codes.NotFound, "share link does not exist")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading share link: %v", err)
+	}
+	fd, err := f.managedFolder(ctx, share.Folder, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := revokeShare(ctx, f.store, fd, req.Token); err != nil {
+		return nil, fmt.Errorf("error revoking share link: %v", err)
+	}
+	log.Printf("Revoked a share link of folder %s (requested by %s)", share.Folder, req.User)
+	return &filesharing.RevokeShareResponse{}, nil
+}
+
+func (f *FilesharingService) ResolveShare(ctx context.Context, req *filesharing.ResolveShareRequest) (*filesharing.ResolveShareResponse, error) {
+	share, err := loadShare(ctx, f.store, req.Token)
+	if err == errBadShareToken || StorageImpl.IsNotFound(err) {
+		return nil, status.Error(codes.NotFound, "share link does not exist")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading share link: %v", err)
+	}
+	return &filesharing.ResolveShareResponse{Path: share.Folder}, nil
 }
 
 func main() {
 	ctx := context.Background()
 
-	var err error
-	minioClient, err = MinioImpl.InitializeMinIO(ctx)
-	if err != nil {
-		log.Fatalf("failed to initialize MinIO client: %v", err)
-	}
-
-	if err = setupBucket(minioClient); err != nil {
-		log.Fatalf("bucket setup failed: %v", err)
-	}
-
-	fileTTL := getFileTTL()
-	log.Printf("File TTL set to %s", fileTTL)
-
-	// Start cleanup goroutine to remove objects older than configured TTL
-	go func(ttl time.Duration) {
-		ticker := time.NewTicker(time.Hour)
-		defer ticker.Stop()
-		for {
-			cleanStartCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-			objectsCh := minioClient.ListObjects(cleanStartCtx, "ficheiros", minio.ListObjectsOptions{Recursive: true})
-			for obj := range objectsCh {
-				if obj.Err != nil {
-					log.Printf("Warning: error listing object during cleanup: %v", obj.Err)
-					continue
-				}
-				if time.Since(obj.LastModified) > ttl {
-					err := minioClient.RemoveObject(cleanStartCtx, "ficheiros", obj.Key, minio.RemoveObjectOptions{})
-					if err != nil {
-						log.Printf("Failed to remove expired object %s: %v", obj.Key, err)
-					} else {
-						log.Printf("Removed expired object: %s", obj.Key)
-					}
-				}
-			}
-			cancel()
-			<-ticker.C
-		}
-	}(fileTTL)
+	loadCompressionConfig()
+	loadExpiryConfig()
+	loadVersionConfig()
+	loadThumbnailConfig()
+	loadImportConfig()
+	if err := loadMasterKeys(); err != nil {
+		log.Fatalf("encryption setup failed: %v", err)
+	}
+
+	store, err := initStorage(ctx)
+	if err != nil {
+		log.Fatalf("storage setup failed: %v", err)
+	}
+
+	leader, backend, err := newLeaderElector()
+	if err != nil {
+		log.Fatalf("leader election setup failed: %v", err)
+	}
+	// Background jobs, run by the leader only
+	jobs := newJobRunner(store, leader, backend)
+	jobs.Register("expiry", sweepInterval, 5*time.Minute, newSweeper(store).Sweep)
+	jobs.Register("usage", usageInterval, 5*time.Minute, func(ctx context.Context) error {
+		return reconcileUsage(ctx, store)
+	})
+	jobs.Register("orphan-gc", gcInterval, time.Hour, func(ctx context.Context) error {
+		return collectOrphans(ctx, store)
+	})
+	jobs.Register("versions", versionInterval, time.Hour, func(ctx context.Context) error {
+		return pruneAllVersions(ctx, store)
+	})
+	jobs.Register("imports", time.Hour, 5*time.Minute, func(ctx context.Context) error {
+		return pruneImports(ctx, store)
+	})
+	jobs.Run(ctx)
+
+	// Thumbnail workers, run by every replica
+	thumbs := newThumbnailer(store)
+	thumbs.Run(ctx)
 
 	// Create a TCP listener on port 50052
 	lis, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", 50052))
@@ -213,7 +1165,7 @@ func main() {
 	opts = append(opts, grpc.MaxSendMsgSize(maxMsgSize))
 
 	grpcServer := grpc.NewServer(opts...)
-	filesharing.RegisterFileUploadServer(grpcServer, &FilesharingService{})
+	filesharing.RegisterFileUploadServer(grpcServer, NewFilesharingService(store, jobs, thumbs, newImporter(store, thumbs)))
 
 	log.Println("Starting gRPC server on port 50052...")
 	if err := grpcServer.Serve(lis); err != nil {
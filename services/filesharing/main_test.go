@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestService is a service on an empty memory store.
+func newTestService(t *testing.T) *FilesharingService {
+	t.Helper()
+	store := StorageImpl.NewMemoryStorage()
+	thumbs := newThumbnailer(store)
+	return NewFilesharingService(store, nil, thumbs, newImporter(store, thumbs))
+}
+
+func readStoredFile(t *testing.T, f *FilesharingService, name string) []byte {
+	t.Helper()
+	ctx := context.Background()
+	file, err := openFile(ctx, f.store, name)
+	if err != nil {
+		t.Fatalf("opening %s: %v", name, err)
+	}
+	r := file.NewReader(ctx, f.store, 0, -1)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	return data
+}
+
+func uploadAs(f *FilesharingService, user string, isAdmin bool, name string, data []byte) (*filesharing.UploadFileResponse, error) {
+	return f.UploadFile(context.Background(), &filesharing.UploadFileRequest{
+		FileName:    name,
+		FileContent: data,
+		Complete:    true,
+		Owner:       user,
+		IsAdmin:     isAdmin,
+	})
+}
+
+func TestUploadStatDelete(t *testing.T) {
+	f := newTestService(t)
+	ctx := context.Background()
+	data := []byte("a report nobody reads")
+
+	res, err := uploadAs(f, "alice", false, "/docs//report.txt", data)
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if res.FileName != "docs/report.txt" {
+		t.Errorf("stored as %q, want docs/report.txt", res.FileName)
+	}
+	st, err := f.StatFile(ctx, &filesharing.StatFileRequest{FileName: "docs/report.txt"})
+	if err != nil {
+		t.Fatalf("StatFile: %v", err)
+	}
+	if st.File.Size != int64(len(data)) || st.File.Owner != "alice" || st.File.ETag == "" {
+		t.Errorf("StatFile returned %+v", st.File)
+	}
+	if got := readStoredFile(t, f, "docs/report.txt"); !bytes.Equal(got, data) {
+		t.Errorf("stored %q, want %q", got, data)
+	}
+
+	_, err = f.DeleteFile(ctx, &filesharing.DeleteFileRequest{FileName: "docs/report.txt", User: "bob"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DeleteFile by another user: got %v, want PermissionDenied", err)
+	}
+	if _, err := f.DeleteFile(ctx, &filesharing.DeleteFileRequest{FileName: "docs/report.txt", User: "alice"}); err != nil {
+		t.Fatalf("DeleteFile by the owner: %v", err)
+	}
+	_, err = f.StatFile(ctx, &filesharing.StatFileRequest{FileName: "docs/report.txt"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("StatFile of a deleted file: got %v, want NotFound", err)
+	}
+	_, err = f.DeleteFile(ctx, &filesharing.DeleteFileRequest{FileName: "docs/report.txt", User: "alice"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteFile of a deleted file: got %v, want NotFound", err)
+	}
+}
+
+func TestUploadRefusesBadNames(t *testing.T) {
+	f := newTestService(t)
+	for _, name := range []string{"", "../etc/passwd", "manifests/docs/report.txt", "chunks/x"} {
+		if _, err := uploadAs(f, "alice", false, name, []byte("x")); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("upload to %q: got %v, want InvalidArgument", name, err)
+		}
+	}
+}
+
+func TestUploadReplace(t *testing.T) {
+	f := newTestService(t)
+	ctx := context.Background()
+	if _, err := uploadAs(f, "alice", false, "shared.txt", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := uploadAs(f, "bob", false, "shared.txt", []byte("bob's"))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("replace by another user: got %v, want PermissionDenied", err)
+	}
+	if got := readStoredFile(t, f, "shared.txt"); string(got) != "v1" {
+		t.Errorf("after a refused replace the file holds %q", got)
+	}
+
+	if _, err := uploadAs(f, "alice", false, "shared.txt", []byte("v2")); err != nil {
+		t.Fatalf("replace by the owner: %v", err)
+	}
+	if _, err := uploadAs(f, "root", true, "shared.txt", []byte("v3")); err != nil {
+		t.Fatalf("replace by an admin: %v", err)
+	}
+	st, err := f.StatFile(ctx, &filesharing.StatFileRequest{FileName: "shared.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readStoredFile(t, f, "shared.txt"); st.File.Owner != "alice" || string(got) != "v3" {
+		t.Errorf("after the admin replace: owner %q, content %q", st.File.Owner, got)
+	}
+}
+
+func TestUploadSessionBelongsToUploader(t *testing.T) {
+	f := newTestService(t)
+	ctx := context.Background()
+	_, err := f.UploadFile(ctx, &filesharing.UploadFileRequest{FileName: "big.bin", FileContent: []byte("part one "), Owner: "alice"})
+	if err != nil {
+		t.Fatalf("starting upload: %v", err)
+	}
+
+	_, err = f.AddChunk(ctx, &filesharing.AddChunkRequest{FileName: "big.bin", ChunkData: []byte("bob's"), Owner: "bob"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("AddChunk by another user: got %v, want FailedPrecondition", err)
+	}
+	_, err = f.CompleteUpload(ctx, &filesharing.CompleteUploadRequest{FileName: "big.bin", Owner: "bob"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("CompleteUpload by another user: got %v, want FailedPrecondition", err)
+	}
+	// As for a missing upload, the abort just reports nothing was aborted.
+	if res, err := f.AbortUpload(ctx, &filesharing.AbortUploadRequest{FileName: "big.bin", Owner: "bob"}); err != nil || res.Success {
+		t.Errorf("AbortUpload by another user: %v, %v", res, err)
+	}
+
+	if _, err := f.AddChunk(ctx, &filesharing.AddChunkRequest{FileName: "big.bin", ChunkData: []byte("part two"), Owner: "alice"}); err != nil {
+		t.Fatalf("AddChunk: %v", err)
+	}
+	if _, err := f.CompleteUpload(ctx, &filesharing.CompleteUploadRequest{FileName: "big.bin", Owner: "alice"}); err != nil {
+		t.Fatalf("CompleteUpload: %v", err)
+	}
+	if got := readStoredFile(t, f, "big.bin"); string(got) != "part one part two" {
+		t.Errorf("completed upload holds %q", got)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"cmp"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// extensionTypes covers common extensions the mime package only knows
+// about when the host has a mime.types file, which slim images don't.
+var extensionTypes = map[string]string{
+	".txt":  "text/plain; charset=utf-8",
+	".log":  "text/plain; charset=utf-8",
+	".md":   "text/markdown; charset=utf-8",
+	".csv":  "text/csv; charset=utf-8",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".toml": "application/toml",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".wav":  "audio/wav",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".ogv":  "video/ogg",
+	".flac": "audio/flac",
+	".opus": "audio/opus",
+	".bmp":  "image/bmp",
+	".ico":  "image/x-icon",
+	".heic": "image/heic",
+	".zip":  "application/zip",
+	".gz":   "application/gzip",
+	".tgz":  "application/gzip",
+	".tar":  "application/x-tar",
+	".zst":  "application/zstd",
+	".7z":   "application/x-7z-compressed",
+	".rar":  "application/vnd.rar",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".epub": "application/epub+zip",
+	".apk":  "application/vnd.android.package-archive",
+	".jar":  "application/java-archive",
+}
+
+// contentTypeByName guesses the MIME type of a file from its extension,
+// "" if it has none we know.
+func contentTypeByName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if contentType, ok := extensionTypes[ext]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(ext)
+}
+
+func isTextual(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+xml") ||
+		strings.HasSuffix(mediaType, "/json") || strings.HasSuffix(mediaType, "/javascript") ||
+		strings.HasSuffix(mediaType, "/yaml") || strings.HasSuffix(mediaType, "/toml") ||
+		strings.HasSuffix(mediaType, "/xml")
+}
+
+// sniffContentType works out the MIME type of a file from its first bytes
+// (see http.DetectContentType) and its name. The bytes win, except where
+// they only say what kind of container the file is: plain text, XML, a ZIP
+// or an Ogg stream. There a matching extension is more precise (JSON, SVG,
+// DOCX, Opus...).
+func sniffContentType(name string, head []byte) string {
+	byName := contentTypeByName(name)
+	if len(head) == 0 {
+		return cmp.Or(byName, defaultContentType)
+	}
+	detected := http.DetectContentType(head)
+	mediaType, _, _ := strings.Cut(detected, ";")
+	switch mediaType {
+	case defaultContentType:
+		return cmp.Or(byName, detected)
+	case "text/plain", "text/xml":
+		if byName != "" && isTextual(byName) {
+			return byName
+		}
+	case "application/zip", "application/ogg":
+		if byName != "" && !isTextual(byName) {
+			return byName
+		}
+	}
+	return detected
+}
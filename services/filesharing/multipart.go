@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Multipart uploads are upload sessions whose parts can be larger than a
+// chunk, which is as much as a gRPC message holds. A part arrives as a
+// stream and is stored in pieces of up to chunkSize: piece j (from 1) of
+// part n is session part n*partPieces+j. Completing the upload strings the
+// pieces of the listed parts together, so parts can come in any order and
+// be sent again.
+const (
+	partPieces     = 1000
+	maxPartNumber  = 10000
+	maxPartPieces  = partPieces - 1
+	maxPartSizeMiB = maxPartPieces * chunkSize >> 20
+)
+
+// partRange returns the session part numbers the pieces of part n can have.
+func partRange(n int) (first, last int) {
+	return n*partPieces + 1, n*partPieces + maxPartPieces
+}
+
+// loadMultipart finds the multipart upload of fileName with uploadID that
+// user can see, or returns a NotFound status.
+func (f *FilesharingService) loadMultipart(ctx context.Context, fileName, uploadID, user string, isAdmin bool) (*uploadSession, error) {
+	notFound := status.Errorf(codes.NotFound, "upload %s of %s does not exist", uploadID, fileName)
+	session, err := loadSession(ctx, f.store, fileName)
+	if StorageImpl.IsNotFound(err) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading upload session: %v", err)
+	}
+	if !session.Multipart || session.UploadID != uploadID || (session.Owner != user && !isAdmin) {
+		return nil, notFound
+	}
+	return session, nil
+}
+
+func (f *FilesharingService) StartMultipartUpload(ctx context.Context, req *filesharing.StartMultipartUploadRequest) (*filesharing.StartMultipartUploadResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	ttl, err := fileTTL(req.ExpiresInSeconds)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// As for resumable uploads, the first piece of part 1 settles the
+	// content type and the codec.
+	codec, err := chooseCodec("", req.FileName, nil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts := uploadOptions{
+		Codec:       codec,
+		TTL:         ttl,
+		KeepForever: req.KeepForever,
+		Owner:       req.Owner,
+		Tags:        tags,
+		Conflict:    uploadConflict{Policy: policy, IfMatch: req.IfMatch, IfNoneMatch: req.IfNoneMatch},
+		Multipart:   true,
+	}
+	if err := f.prepareFile(ctx, req.FileName, req.Owner, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	target, err := uploadTarget(ctx, f.store, req.FileName, req.Owner, opts.Conflict)
+	if err != nil {
+		return nil, conflictError(req.FileName, err)
+	}
+	if err := f.checkReplace(ctx, target, req.Owner, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	session, err := startUpload(ctx, f.store, target, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error starting upload: %v", err)
+	}
+	log.Printf("Started multipart upload of %s", target)
+	return &filesharing.StartMultipartUploadResponse{FileName: target, UploadID: session.UploadID}, nil
+}
+
+func (f *FilesharingService) UploadPart(stream filesharing.FileUpload_UploadPartServer) error {
+	ctx := stream.Context()
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "no part sent")
+	}
+	if err != nil {
+		return err
+	}
+	if err := cleanName(&req.FileName); err != nil {
+		return err
+	}
+	if req.PartNumber < 1 || req.PartNumber > maxPartNumber {
+		return status.Errorf(codes.InvalidArgument, "part number %d is not between 1 and %d", req.PartNumber, maxPartNumber)
+	}
+	session, err := f.loadMultipart(ctx, req.FileName, req.UploadID, req.User, req.IsAdmin)
+	if err != nil {
+		return err
+	}
+
+	part := req.PartNumber
+	first, last := partRange(int(part))
+	number := first - 1
+	var size int64
+	buf := make([]byte, 0, chunkSize)
+	flush := func() error {
+		number++
+		if number > last {
+			return status.Errorf(codes.OutOfRange, "part %d is larger than %d MiB", part, maxPartSizeMiB)
+		}
+		if part == 1 && number == first {
+			head := buf[:min(512, len(buf))]
+			session.ContentType = sniffContentType(session.FileName, head)
+			if session.Codec != codecNone && isCompressedFormat(session.FileName, head) {
+				session.Codec = codecNone
+			}
+			if err := writeJSON(ctx, f.store, sessionKey(session.FileName), session, StorageImpl.PutOptions{}); err != nil {
+				return fmt.Errorf("error saving upload session: %v", err)
+			}
+		}
+		if _, err := putPart(ctx, f.store, session, number, buf); err != nil {
+			return fmt.Errorf("error adding data to upload: %v", err)
+		}
+		buf = buf[:0]
+		return nil
+	}
+	for {
+		data := req.Data
+		for len(data) > 0 {
+			n := min(len(data), chunkSize-len(buf))
+			buf = append(buf, data[:n]...)
+			data = data[n:]
+			size += int64(n)
+			if len(buf) == chunkSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		req, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(buf) > 0 || number < first {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	// Pieces past the last one are left from an earlier, longer upload of
+	// the same part.
+	numbers, chunks, err := listParts(ctx, f.store, session.UploadID)
+	if err != nil {
+		return fmt.Errorf("error reading upload: %v", err)
+	}
+	for i, n := range numbers {
+		if n <= number || n > last {
+			continue
+		}
+		if err := releaseChunk(ctx, f.store, chunks[i].Hash, partHolder(session.UploadID, n)); err != nil {
+			return fmt.Errorf("error dropping old piece of part %d: %v", part, err)
+		}
+		if err := f.store.Delete(ctx, partKey(session.UploadID, n)); err != nil {
+			return fmt.Errorf("error dropping old piece of part %d: %v", part, err)
+		}
+	}
+	return stream.SendAndClose(&filesharing.UploadPartResponse{Size: size})
+}
+
+func (f *FilesharingService) CompleteMultipartUpload(ctx context.Context, req *filesharing.CompleteMultipartUploadRequest) (*filesharing.CompleteUploadResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	if len(req.Parts) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "no parts listed")
+	}
+	session, err := f.loadMultipart(ctx, req.FileName, req.UploadID, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	numbers, stored, err := listParts(ctx, f.store, session.UploadID)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload: %v", err)
+	}
+	pieces := make(map[int]manifestChunk, len(numbers))
+	for i, n := range numbers {
+		pieces[n] = stored[i]
+	}
+
+	var chunks []manifestChunk
+	previous := int32(0)
+	for _, part := range req.Parts {
+		if part <= previous || part > maxPartNumber {
+			return nil, status.Errorf(codes.InvalidArgument, "parts must be listed in increasing order from 1 to %d", maxPartNumber)
+		}
+		previous = part
+		first, last := partRange(int(part))
+		if _, ok := pieces[first]; !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "part %d was not uploaded", part)
+		}
+		for n := first; n <= last; n++ {
+			chunk, ok := pieces[n]
+			if !ok {
+				break
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	if err := f.checkCommit(ctx, session, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	manifest, err := commitUpload(ctx, f.store, session, chunks)
+	if err != nil {
+		return nil, conflictError(session.FileName, err)
+	}
+	log.Printf("Completed multipart upload of %s (%d bytes in %d parts)", manifest.Name, manifest.Size, len(req.Parts))
+	f.thumbs.Enqueue(manifest)
+	return &filesharing.CompleteUploadResponse{
+		FileName:  manifest.Name,
+		Size:      manifest.Size,
+		ETag:      manifest.ETag(),
+		ExpiresAt: unixOrZero(manifest.Expires),
+	}, nil
+}
+
+func (f *FilesharingService) AbortMultipartUpload(ctx context.Context, req *filesharing.AbortMultipartUploadRequest) (*filesharing.AbortMultipartUploadResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	session, err := f.loadMultipart(ctx, req.FileName, req.UploadID, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := abortUpload(ctx, f.store, session.FileName); err != nil && !StorageImpl.IsNotFound(err) {
+		return nil, fmt.Errorf("error aborting upload: %v", err)
+	}
+	log.Printf("Aborted multipart upload of %s (requested by %s)", session.FileName, req.User)
+	return &filesharing.AbortMultipartUploadResponse{}, nil
+}
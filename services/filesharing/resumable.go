@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Resumable uploads are upload sessions with a declared length. Where an
+// upload stands is the sum of its parts, and each append is a new part, so
+// nothing else has to be kept in step. An upload's ID is its file name and
+// the session's UploadID, which is random: it names the session without an
+// index, and a restarted upload of the same name gets a new ID.
+
+// resumableID returns the ID of session.
+func resumableID(session *uploadSession) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(session.FileName)) + "." + session.UploadID
+}
+
+// resumableExpiry is when the sweeper drops session if it isn't finished.
+func resumableExpiry(session *uploadSession) time.Time {
+	return session.Created.Add(defaultFileTTL)
+}
+
+// loadResumable finds the unfinished upload with id that user can see, or
+// returns a NotFound status.
+func (f *FilesharingService) loadResumable(ctx context.Context, id, user string, isAdmin bool) (*uploadSession, error) {
+	notFound := status.Errorf(codes.NotFound, "upload %s does not exist", id)
+	encoded, uploadID, ok := strings.Cut(id, ".")
+	if !ok {
+		return nil, notFound
+	}
+	name, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, notFound
+	}
+	fileName := string(name)
+	if cleanName(&fileName) != nil || fileName != string(name) {
+		return nil, notFound
+	}
+	session, err := loadSession(ctx, f.store, fileName)
+	if StorageImpl.IsNotFound(err) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading upload session: %v", err)
+	}
+	if session.UploadID != uploadID || session.Length == 0 || (session.Owner != user && !isAdmin) ||
+		!resumableExpiry(session).After(time.Now()) {
+		return nil, notFound
+	}
+	return session, nil
+}
+
+// resumableOffset returns how many bytes session holds and the number of
+// its last part.
+func resumableOffset(ctx context.Context, store StorageImpl.Storage, session *uploadSession) (int64, int, error) {
+	numbers, chunks, err := listParts(ctx, store, session.UploadID)
+	if err != nil {
+		return 0, 0, err
+	}
+	var offset int64
+	for _, chunk := range chunks {
+		offset += chunk.Size
+	}
+	last := 0
+	if len(numbers) > 0 {
+		last = numbers[len(numbers)-1]
+	}
+	return offset, last, nil
+}
+
+func toResumableUpload(session *uploadSession, offset int64) *filesharing.ResumableUpload {
+	return &filesharing.ResumableUpload{
+		ID:        resumableID(session),
+		FileName:  session.FileName,
+		Offset:    offset,
+		Length:    session.Length,
+		ExpiresAt: resumableExpiry(session).Unix(),
+		Metadata:  session.Metadata,
+	}
+}
+
+func (f *FilesharingService) StartResumableUpload(ctx context.Context, req *filesharing.StartResumableUploadRequest) (*filesharing.ResumableUploadResponse, error) {
+	if err := cleanName(&req.FileName); err != nil {
+		return nil, err
+	}
+	if req.Length < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid length %d", req.Length)
+	}
+	if len(req.Metadata) > maxEncryptedMetadata {
+		return nil, status.Errorf(codes.InvalidArgument, "metadata is larger than %d bytes", maxEncryptedMetadata)
+	}
+	ttl, err := fileTTL(req.ExpiresInSeconds)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The content type and, by the file's first bytes, the codec are
+	// settled when the first part arrives.
+	codec, err := chooseCodec("", req.FileName, nil)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	opts := uploadOptions{
+		Codec:       codec,
+		TTL:         ttl,
+		KeepForever: req.KeepForever,
+		Owner:       req.Owner,
+		Tags:        tags,
+		Conflict:    uploadConflict{Policy: policy},
+		Length:      req.Length,
+		Metadata:    req.Metadata,
+	}
+	if err := f.prepareFile(ctx, req.FileName, req.Owner, req.IsAdmin); err != nil {
+		return nil, err
+	}
+	target, err := uploadTarget(ctx, f.store, req.FileName, req.Owner, opts.Conflict)
+	if err != nil {
+		return nil, conflictError(req.FileName, err)
+	}
+	if err := f.checkReplace(ctx, target, req.Owner, req.IsAdmin); err != nil {
+		return nil, err
+	}
+
+	// An empty file is complete as soon as it is announced.
+	if req.Length == 0 {
+		opts.ContentType = sniffContentType(target, nil)
+		manifest, err := putWholeFile(ctx, f.store, target, nil, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error uploading file: %v", err)
+		}
+		log.Printf("Uploaded file %s (0 bytes)", target)
+		return &filesharing.ResumableUploadResponse{Upload: &filesharing.ResumableUpload{
+			FileName: manifest.Name,
+			Complete: true,
+			ETag:     manifest.ETag(),
+			Metadata: req.Metadata,
+		}}, nil
+	}
+
+	session, err := startUpload(ctx, f.store, target, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error starting upload: %v", err)
+	}
+	log.Printf("Started resumable upload of %s (%d bytes)", target, req.Length)
+	return &filesharing.ResumableUploadResponse{Upload: toResumableUpload(session, 0)}, nil
+}
+
+func (f *FilesharingService) GetResumableUpload(ctx context.Context, req *filesharing.GetResumableUploadRequest) (*filesharing.ResumableUploadResponse, error) {
+	session, err := f.loadResumable(ctx, req.ID, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	offset, _, err := resumableOffset(ctx, f.store, session)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload: %v", err)
+	}
+	return &filesharing.ResumableUploadResponse{Upload: toResumableUpload(session, offset)}, nil
+}
+
+func (f *FilesharingService) AppendResumableUpload(ctx context.Context, req *filesharing.AppendResumableUploadRequest) (*filesharing.ResumableUploadResponse, error) {
+	session, err := f.loadResumable(ctx, req.ID, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	offset, last, err := resumableOffset(ctx, f.store, session)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upload: %v", err)
+	}
+	if req.Offset != offset {
+		return nil, status.Errorf(codes.FailedPrecondition, "upload is at offset %d, not %d", offset, req.Offset)
+	}
+	if offset+int64(len(req.Data)) > session.Length {
+		return nil, status.Errorf(codes.OutOfRange, "upload is %d bytes long, %d bytes at %d go past the end", session.Length, len(req.Data), offset)
+	}
+	if len(req.Data) == 0 {
+		return &filesharing.ResumableUploadResponse{Upload: toResumableUpload(session, offset)}, nil
+	}
+	if offset+int64(len(req.Data)) == session.Length {
+		if err := f.checkCommit(ctx, session, req.IsAdmin); err != nil {
+			return nil, err
+		}
+	}
+
+	if offset == 0 {
+		head := req.Data[:min(512, len(req.Data))]
+		session.ContentType = sniffContentType(session.FileName, head)
+		if session.Codec != codecNone && isCompressedFormat(session.FileName, head) {
+			session.Codec = codecNone
+		}
+		if err := writeJSON(ctx, f.store, sessionKey(session.FileName), session, StorageImpl.PutOptions{}); err != nil {
+			return nil, fmt.Errorf("error saving upload session: %v", err)
+		}
+	}
+	if _, err := putPart(ctx, f.store, session, last+1, req.Data); err != nil {
+		return nil, fmt.Errorf("error adding data to upload: %v", err)
+	}
+	offset += int64(len(req.Data))
+	upload := toResumableUpload(session, offset)
+	if offset < session.Length {
+		return &filesharing.ResumableUploadResponse{Upload: upload}, nil
+	}
+
+	manifest, err := completeUpload(ctx, f.store, session.FileName)
+	if err != nil {
+		return nil, conflictError(session.FileName, err)
+	}
+	log.Printf("Completed resumable upload of %s (%d bytes in %d chunks)", manifest.Name, manifest.Size, len(manifest.Chunks))
+	f.thumbs.Enqueue(manifest)
+	upload.FileName, upload.Complete, upload.ETag = manifest.Name, true, manifest.ETag()
+	return &filesharing.ResumableUploadResponse{Upload: upload}, nil
+}
+
+func (f *FilesharingService) CancelResumableUpload(ctx context.Context, req *filesharing.CancelResumableUploadRequest) (*filesharing.CancelResumableUploadResponse, error) {
+	session, err := f.loadResumable(ctx, req.ID, req.User, req.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if err := abortUpload(ctx, f.store, session.FileName); err != nil && !StorageImpl.IsNotFound(err) {
+		return nil, fmt.Errorf("error cancelling upload: %v", err)
+	}
+	log.Printf("Cancelled resumable upload of %s (requested by %s)", session.FileName, req.User)
+	return &filesharing.CancelResumableUploadResponse{}, nil
+}
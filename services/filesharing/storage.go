@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	MinioImpl "github.com/Maruqes/KubeFile/services/filesharing/Minio"
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+)
+
+const defaultBucket = "ficheiros"
+
+func getEnv(key, fallback string) string {
+	if val := strings.TrimSpace(os.Getenv(key)); val != "" {
+		return val
+	}
+	return fallback
+}
+
+// initStorage builds the backend selected by STORAGE_BACKEND: "minio" (the
+// default, any S3-compatible endpoint), "local" for a directory on disk or
+// "memory" for a throwaway in-process store.
+func initStorage(ctx context.Context) (StorageImpl.Storage, error) {
+	backend := strings.ToLower(getEnv("STORAGE_BACKEND", "minio"))
+	switch backend {
+	case "minio", "s3":
+		client, err := MinioImpl.InitializeMinIO(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize MinIO client: %v", err)
+		}
+		store := MinioImpl.NewMinioStorage(client, getEnv("STORAGE_BUCKET", defaultBucket))
+
+		setupCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		if err := store.EnsureBucket(setupCtx); err != nil {
+			return nil, fmt.Errorf("bucket setup failed: %v", err)
+		}
+		setupLifecycle(setupCtx, store)
+		return store, nil
+	case "local", "fs":
+		root := getEnv("STORAGE_LOCAL_PATH", "/var/lib/kubefile")
+		store, err := StorageImpl.NewLocalStorage(root)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using local storage at %s", root)
+		return store, nil
+	case "memory":
+		log.Println("⚠️  Using in-memory storage, files are lost on restart")
+		return StorageImpl.NewMemoryStorage(), nil
+	}
+	return nil, fmt.Errorf("unknown STORAGE_BACKEND %q (minio, local or memory)", backend)
+}
+
+func getStorageLimitGB() int64 {
+	const defaultLimit = 200
+	val := strings.TrimSpace(os.Getenv("STORAGE_LIMIT_GB"))
+	if val == "" {
+		return defaultLimit
+	}
+	limit, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || limit <= 0 {
+		log.Printf("invalid STORAGE_LIMIT_GB value %q, using default %d", val, defaultLimit)
+		return defaultLimit
+	}
+	return limit
+}
+
+// listAll calls fn for every object under prefix, a page at a time.
+func listAll(ctx context.Context, store StorageImpl.Storage, prefix string, fn func(StorageImpl.ObjectInfo) error) error {
+	after := ""
+	for {
+		objects, err := store.List(ctx, prefix, after, sweepPageSize)
+		if err != nil {
+			return fmt.Errorf("error listing objects: %v", err)
+		}
+		for _, obj := range objects {
+			if err := fn(obj); err != nil {
+				return err
+			}
+			after = obj.Key
+		}
+		if len(objects) < sweepPageSize {
+			return nil
+		}
+	}
+}
+
+// usageInterval is how often the leader recounts storage usage; replicas
+// serve the last count instead of listing the bucket on every request.
+const usageInterval = 5 * time.Minute
+
+const usageKey = statusPrefix + "usage"
+
+type storageUsage struct {
+	LogicalBytes  int64     `json:"logicalBytes"`
+	PhysicalBytes int64     `json:"physicalBytes"`
+	Objects       int64     `json:"objects"`
+	Counted       time.Time `json:"counted"`
+}
+
+func countUsage(ctx context.Context, store StorageImpl.Storage) (*storageUsage, error) {
+	usage := &storageUsage{}
+	err := listAll(ctx, store, "", func(obj StorageImpl.ObjectInfo) error {
+		usage.Objects++
+		usage.PhysicalBytes += obj.Size
+		switch {
+		case strings.HasPrefix(obj.Key, manifestsPrefix):
+			usage.LogicalBytes += manifestSize(ctx, store, obj)
+		case strings.HasPrefix(obj.Key, chunksPrefix), strings.HasPrefix(obj.Key, refsPrefix),
+			strings.HasPrefix(obj.Key, assembliesPrefix),
+			strings.HasPrefix(obj.Key, partsPrefix), strings.HasPrefix(obj.Key, uploadsPrefix),
+			strings.HasPrefix(obj.Key, expiryPrefix), strings.HasPrefix(obj.Key, indexPrefix),
+			strings.HasPrefix(obj.Key, foldersPrefix), strings.HasPrefix(obj.Key, sharesPrefix),
+			strings.HasPrefix(obj.Key, versionsPrefix), strings.HasPrefix(obj.Key, thumbsPrefix),
+			strings.HasPrefix(obj.Key, statusPrefix):
+			// bookkeeping and shared data, not files of their own
+		default:
+			usage.LogicalBytes += obj.Size // multipart-era and legacy chunk objects
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	usage.Counted = time.Now()
+	return usage, nil
+}
+
+// reconcileUsage is the "usage" job: it recounts the bucket and records the
+// result for every replica.
+func reconcileUsage(ctx context.Context, store StorageImpl.Storage) error {
+	usage, err := countUsage(ctx, store)
+	if err != nil {
+		return err
+	}
+	return writeJSON(ctx, store, usageKey, usage, StorageImpl.PutOptions{})
+}
+
+// currentUsage returns the leader's last count, or counts now when there is
+// no recent one (no leader, or the job is failing).
+func currentUsage(ctx context.Context, store StorageImpl.Storage) (*storageUsage, error) {
+	var usage storageUsage
+	if err := readJSON(ctx, store, usageKey, &usage); err == nil && time.Since(usage.Counted) < 3*usageInterval {
+		return &usage, nil
+	}
+	return countUsage(ctx, store)
+}
+
+func getStorageLimitsData(ctx context.Context, store StorageImpl.Storage) (*filesharing.GetStorageInfoResponse, error) {
+	usage, err := currentUsage(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+	// Convert total size to gigabytes
+	return &filesharing.GetStorageInfoResponse{
+		TotalSize:     getStorageLimitGB(),
+		UsedSize:      usage.PhysicalBytes / (1024 * 1024 * 1024),
+		LogicalBytes:  usage.LogicalBytes,
+		PhysicalBytes: usage.PhysicalBytes,
+
+		DefaultExpirySeconds: int64(defaultFileTTL / time.Second),
+		MaxExpirySeconds:     int64(maxFileTTL / time.Second),
+	}, nil
+}
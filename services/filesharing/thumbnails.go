@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Images get thumbnails: scaled-down copies that fit in a square of each of
+// thumbnailSizes, kept at thumbs/<escaped name>/<etag>/<size>. They are made
+// in the background after an upload, or the first time one is asked for.
+// Keying them by the file's ETag means a replaced file never shows the old
+// picture; thumbnails of older content go once the new ones exist, or in
+// the orphan collector. An image that can't be decoded gets a "failed"
+// marker instead, so it isn't tried again until its content changes.
+//
+// PDFs and other documents get none: rendering a page needs a PDF engine,
+// and there is no pure-Go one worth depending on.
+const thumbsPrefix = "thumbs/"
+
+// thumbnailSizes are the sizes made for every image, smallest first.
+var thumbnailSizes = []int{128, 256, 512}
+
+const defaultThumbnailSize = 256
+
+const thumbFailedMarker = "failed"
+
+var thumbnailTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Worker pool settings. THUMBNAIL_WORKERS=0 turns generation off; stored
+// thumbnails are still served.
+var (
+	thumbnailWorkers         = 2
+	thumbnailQueueSize       = 100
+	thumbnailMaxBytes  int64 = 50 * 1024 * 1024
+)
+
+const (
+	// thumbnailMaxPixels bounds the decoded image, so a small file can't
+	// unpack into gigabytes of pixels.
+	thumbnailMaxPixels = 40_000_000
+	thumbnailTimeout   = 2 * time.Minute
+	// A task that fails is retried thumbnailAttempts times in all, waiting
+	// thumbnailRetryDelay longer after each failure.
+	thumbnailAttempts   = 3
+	thumbnailRetryDelay = 5 * time.Second
+	thumbnailQuality    = 80
+)
+
+var (
+	errNoThumbnail = errors.New("file has no thumbnail")
+	errBadImage    = errors.New("image cannot be decoded")
+)
+
+func loadThumbnailConfig() {
+	thumbnailWorkers = getCountEnv("THUMBNAIL_WORKERS", thumbnailWorkers)
+	thumbnailQueueSize = getCountEnv("THUMBNAIL_QUEUE", thumbnailQueueSize)
+	thumbnailMaxBytes = int64(getCountEnv("THUMBNAIL_MAX_MB", int(thumbnailMaxBytes>>20))) << 20
+	log.Printf("Thumbnails: %d workers, queue of %d, images up to %d MB", thumbnailWorkers, thumbnailQueueSize, thumbnailMaxBytes>>20)
+}
+
+// thumbnailSize rounds a requested size up to one that is stored, or down
+// to the largest.
+func thumbnailSize(requested int) int {
+	if requested <= 0 {
+		return defaultThumbnailSize
+	}
+	for _, size := range thumbnailSizes {
+		if requested <= size {
+			return size
+		}
+	}
+	return thumbnailSizes[len(thumbnailSizes)-1]
+}
+
+func canThumbnail(name, contentType string, size int64, endToEnd bool) bool {
+	if endToEnd || size == 0 || size > thumbnailMaxBytes {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(cmp.Or(contentType, contentTypeByName(name)), ";")
+	return thumbnailTypes[strings.TrimSpace(mediaType)]
+}
+
+// HasThumbnail reports whether the file gets thumbnails, made or not yet.
+func (info *fileInfo) HasThumbnail() bool {
+	return canThumbnail(info.Name, info.ContentType, info.Size, info.EndToEnd)
+}
+
+func (m *fileManifest) HasThumbnail() bool {
+	return canThumbnail(m.Name, m.ContentType, m.Size, len(m.EncryptedMetadata) > 0)
+}
+
+func thumbsOf(fileName string) string {
+	return thumbsPrefix + url.QueryEscape(fileName) + "/"
+}
+
+func thumbKey(fileName, etag string, size int) string {
+	return thumbsOf(fileName) + etag + "/" + strconv.Itoa(size)
+}
+
+func thumbFailedKey(fileName, etag string) string {
+	return thumbsOf(fileName) + etag + "/" + thumbFailedMarker
+}
+
+// thumbAAD ties a sealed thumbnail to the file, content and size it is of.
+func thumbAAD(fileName, etag string, size int) []byte {
+	return []byte(fmt.Sprintf("kubefile-thumb|%s|%s|%d", fileName, etag, size))
+}
+
+// loadThumbnail returns the thumbnail of the content of manifest at size,
+// and its MIME type. It returns errNoThumbnail for files that never get
+// one, and StorageImpl.ErrNotFound while it hasn't been made.
+func loadThumbnail(ctx context.Context, store StorageImpl.Storage, manifest *fileManifest, size int) ([]byte, string, error) {
+	if !manifest.HasThumbnail() {
+		return nil, "", errNoThumbnail
+	}
+	etag := manifest.ETag()
+	data, err := StorageImpl.ReadAll(ctx, store, thumbKey(manifest.Name, etag, size))
+	if StorageImpl.IsNotFound(err) {
+		if _, err := store.Stat(ctx, thumbFailedKey(manifest.Name, etag)); err == nil {
+			return nil, "", errNoThumbnail
+		}
+		return nil, "", StorageImpl.ErrNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := manifest.Encryption.AEAD()
+	if err != nil {
+		return nil, "", err
+	}
+	if aead != nil {
+		if data, err = openData(aead, thumbAAD(manifest.Name, etag, size), data); err != nil {
+			return nil, "", fmt.Errorf("thumbnail of %s failed authentication: %v", manifest.Name, err)
+		}
+	}
+	return data, http.DetectContentType(data), nil
+}
+
+// makeThumbnails decodes the content of manifest and stores its thumbnails,
+// sealed with the file's data key when it is encrypted at rest. Errors
+// wrapping errBadImage are about the image itself and not worth retrying.
+func makeThumbnails(ctx context.Context, store StorageImpl.Storage, manifest *fileManifest) error {
+	file, err := manifestFile(manifest)
+	if err != nil {
+		return err
+	}
+	reader := file.NewReader(ctx, store, 0, file.Size)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", manifest.Name, err)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadImage, err)
+	}
+	if config.Width*config.Height > thumbnailMaxPixels {
+		return fmt.Errorf("%w: %dx%d pixels is too large", errBadImage, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errBadImage, err)
+	}
+
+	aead, err := manifest.Encryption.AEAD()
+	if err != nil {
+		return err
+	}
+	etag := manifest.ETag()
+	// Largest first, each scaled from the one before: resampling the full
+	// image once is what costs.
+	src := img
+	for i := len(thumbnailSizes) - 1; i >= 0; i-- {
+		size := thumbnailSizes[i]
+		thumb := scaleToFit(src, size)
+		encoded, contentType, err := encodeThumbnail(thumb)
+		if err != nil {
+			return fmt.Errorf("error encoding thumbnail: %v", err)
+		}
+		if aead != nil {
+			if encoded, err = sealData(aead, thumbAAD(manifest.Name, etag, size), encoded); err != nil {
+				return fmt.Errorf("error encrypting thumbnail: %v", err)
+			}
+		}
+		_, err = store.Put(ctx, thumbKey(manifest.Name, etag, size), bytes.NewReader(encoded), int64(len(encoded)), StorageImpl.PutOptions{
+			ContentType: contentType,
+		})
+		if err != nil {
+			return fmt.Errorf("error saving thumbnail: %v", err)
+		}
+		src = thumb
+	}
+	dropStaleThumbnails(ctx, store, manifest.Name, etag)
+	return nil
+}
+
+// scaleToFit returns img scaled to fit in a size x size square. Smaller
+// images keep their size.
+func scaleToFit(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if longest := max(w, h); longest > size {
+		w = max(1, w*size/longest)
+		h = max(1, h*size/longest)
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(thumb, thumb.Bounds(), img, bounds, draw.Src, nil)
+	return thumb
+}
+
+// encodeThumbnail writes opaque thumbnails as JPEG and ones with
+// transparency as PNG.
+func encodeThumbnail(thumb *image.RGBA) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if thumb.Opaque() {
+		err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: thumbnailQuality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err := png.Encode(&buf, thumb)
+	return buf.Bytes(), "image/png", err
+}
+
+func markThumbnailFailed(ctx context.Context, store StorageImpl.Storage, manifest *fileManifest) error {
+	_, err := store.Put(ctx, thumbFailedKey(manifest.Name, manifest.ETag()), bytes.NewReader(nil), 0, StorageImpl.PutOptions{})
+	return err
+}
+
+// dropStaleThumbnails removes the thumbnails of fileName that aren't of
+// the content etag.
+func dropStaleThumbnails(ctx context.Context, store StorageImpl.Storage, fileName, etag string) {
+	objects, err := store.List(ctx, thumbsOf(fileName), "", 0)
+	if err != nil {
+		log.Printf("⚠️  Warning: could not list thumbnails of %s: %v", fileName, err)
+		return
+	}
+	for _, obj := range objects {
+		if strings.HasPrefix(obj.Key, thumbsOf(fileName)+etag+"/") {
+			continue
+		}
+		if err := store.Delete(ctx, obj.Key); err != nil && !StorageImpl.IsNotFound(err) {
+			log.Printf("⚠️  Warning: could not remove thumbnail %s: %v", obj.Key, err)
+		}
+	}
+}
+
+// deleteThumbnails removes every thumbnail of fileName.
+func deleteThumbnails(ctx context.Context, store StorageImpl.Storage, fileName string) error {
+	objects, err := store.List(ctx, thumbsOf(fileName), "", 0)
+	if err != nil {
+		return fmt.Errorf("error listing thumbnails: %v", err)
+	}
+	for _, obj := range objects {
+		if err := store.Delete(ctx, obj.Key); err != nil && !StorageImpl.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// thumbnailCurrent reports whether the thumbnail object at key is of the
+// current content of its file.
+func thumbnailCurrent(ctx context.Context, store StorageImpl.Storage, key string) (bool, error) {
+	escaped, rest, ok := strings.Cut(strings.TrimPrefix(key, thumbsPrefix), "/")
+	etag, _, ok2 := strings.Cut(rest, "/")
+	if !ok || !ok2 {
+		return false, nil
+	}
+	fileName, err := url.QueryUnescape(escaped)
+	if err != nil {
+		return false, nil
+	}
+	manifest, err := loadManifest(ctx, store, fileName)
+	if StorageImpl.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return manifest.ETag() == etag, nil
+}
+
+type thumbnailTask struct {
+	name    string
+	etag    string
+	attempt int
+}
+
+// thumbnailStats are the counters of one replica's thumbnail workers since
+// it started.
+type thumbnailStats struct {
+	Workers   int
+	Queued    int
+	Active    int64
+	Enqueued  int64
+	Generated int64
+	Failed    int64
+	Retried   int64
+	Dropped   int64
+}
+
+// thumbnailer is the pool of workers making thumbnails. The queue is in
+// memory and bounded: when it is full, new work is dropped and the
+// thumbnail is made when someone asks for it instead. Every replica runs
+// its own pool for the uploads it receives.
+type thumbnailer struct {
+	store StorageImpl.Storage
+	queue chan thumbnailTask
+
+	mu      sync.Mutex
+	pending map[string]bool // name and ETag of every queued or running task
+
+	active, enqueued, generated, failed, retried, dropped atomic.Int64
+}
+
+func newThumbnailer(store StorageImpl.Storage) *thumbnailer {
+	return &thumbnailer{
+		store:   store,
+		queue:   make(chan thumbnailTask, thumbnailQueueSize),
+		pending: map[string]bool{},
+	}
+}
+
+func (t *thumbnailer) Run(ctx context.Context) {
+	for i := 0; i < thumbnailWorkers; i++ {
+		go t.work(ctx)
+	}
+}
+
+// Enqueue asks for the thumbnails of manifest's content, unless the file
+// has none or they are already on their way.
+func (t *thumbnailer) Enqueue(manifest *fileManifest) {
+	if thumbnailWorkers == 0 || !manifest.HasThumbnail() {
+		return
+	}
+	task := thumbnailTask{name: manifest.Name, etag: manifest.ETag()}
+	id := task.name + "\x00" + task.etag
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending[id] {
+		return
+	}
+	select {
+	case t.queue <- task:
+		t.pending[id] = true
+		t.enqueued.Add(1)
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+func (t *thumbnailer) done(task thumbnailTask) {
+	t.mu.Lock()
+	delete(t.pending, task.name+"\x00"+task.etag)
+	t.mu.Unlock()
+}
+
+// retry puts task back in the queue after a delay. It stays pending
+// meanwhile, so requests for it don't queue it twice.
+func (t *thumbnailer) retry(task thumbnailTask) {
+	task.attempt++
+	t.retried.Add(1)
+	time.AfterFunc(time.Duration(task.attempt)*thumbnailRetryDelay, func() {
+		select {
+		case t.queue <- task:
+		default:
+			t.dropped.Add(1)
+			t.done(task)
+		}
+	})
+}
+
+func (t *thumbnailer) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task := <-t.queue:
+			t.process(ctx, task)
+		}
+	}
+}
+
+func (t *thumbnailer) process(ctx context.Context, task thumbnailTask) {
+	t.active.Add(1)
+	defer t.active.Add(-1)
+
+	runCtx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+	manifest, err := loadManifest(runCtx, t.store, task.name)
+	if err == nil && manifest.ETag() != task.etag {
+		// Replaced since; the new content has a task of its own.
+		t.done(task)
+		return
+	}
+	if err == nil {
+		err = makeThumbnails(runCtx, t.store, manifest)
+	}
+	switch {
+	case err == nil:
+		t.generated.Add(1)
+	case StorageImpl.IsNotFound(err):
+		// Deleted since.
+	case errors.Is(err, errBadImage):
+		t.failed.Add(1)
+		log.Printf("⚠️  Warning: no thumbnail for %s: %v", task.name, err)
+		if err := markThumbnailFailed(runCtx, t.store, manifest); err != nil {
+			log.Printf("⚠️  Warning: could not record failed thumbnail of %s: %v", task.name, err)
+		}
+	case task.attempt+1 < thumbnailAttempts && ctx.Err() == nil:
+		log.Printf("⚠️  Warning: thumbnail of %s failed (attempt %d), retrying: %v", task.name, task.attempt+1, err)
+		t.retry(task)
+		return
+	default:
+		t.failed.Add(1)
+		log.Printf("⚠️  Warning: giving up on thumbnail of %s: %v", task.name, err)
+	}
+	t.done(task)
+}
+
+func (t *thumbnailer) Stats() thumbnailStats {
+	return thumbnailStats{
+		Workers:   thumbnailWorkers,
+		Queued:    len(t.queue),
+		Active:    t.active.Load(),
+		Enqueued:  t.enqueued.Load(),
+		Generated: t.generated.Load(),
+		Failed:    t.failed.Load(),
+		Retried:   t.retried.Load(),
+		Dropped:   t.dropped.Load(),
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	StorageImpl "github.com/Maruqes/KubeFile/services/filesharing/Storage"
+)
+
+// Replacing a file keeps the manifest it replaces as an older version:
+// versions/<escaped name>/<number> holds a copy of it, and the copy holds
+// references to its chunks under the holder version:<name>:<number>, so
+// they outlive the file's own references. The current manifest has the
+// highest number. Versions go when the file is deleted, and retention
+// trims them on every commit and in the versions job.
+const versionsPrefix = "versions/"
+
+// Retention settings: a version is kept while it is one of the newest
+// VERSION_KEEP versions or younger than VERSION_KEEP_DAYS days. With both
+// at 0, replacing a file discards the old content as it always did.
+var (
+	versionKeep     = 10
+	versionKeepDays time.Duration
+)
+
+// versionInterval is how often the versions job applies the day limit to
+// files that haven't changed since.
+const versionInterval = time.Hour
+
+func loadVersionConfig() {
+	versionKeep = getCountEnv("VERSION_KEEP", versionKeep)
+	versionKeepDays = time.Duration(getCountEnv("VERSION_KEEP_DAYS", 0)) * 24 * time.Hour
+	log.Printf("Keeping %d old versions of each file, and those from the last %s", versionKeep, versionKeepDays)
+}
+
+func getCountEnv(key string, fallback int) int {
+	val := getEnv(key, "")
+	if val == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		log.Printf("invalid %s value %q, using default %d", key, val, fallback)
+		return fallback
+	}
+	return n
+}
+
+func versioningEnabled() bool {
+	return versionKeep > 0 || versionKeepDays > 0
+}
+
+// Number is the version number of the manifest. Manifests from before
+// versions were kept are version 1.
+func (m *fileManifest) Number() int {
+	return max(m.Version, 1)
+}
+
+func versionsOf(fileName string) string {
+	return versionsPrefix + url.QueryEscape(fileName) + "/"
+}
+
+func versionKey(fileName string, number int) string {
+	return versionsOf(fileName) + fmt.Sprintf("%010d", number)
+}
+
+func versionHolder(fileName string, number int) string {
+	return fmt.Sprintf("version:%s:%d", fileName, number)
+}
+
+// parseVersionHolder splits a version holder into file name and number.
+func parseVersionHolder(holder string) (string, int, bool) {
+	rest, ok := strings.CutPrefix(holder, "version:")
+	i := strings.LastIndex(rest, ":")
+	if !ok || i < 0 {
+		return "", 0, false
+	}
+	number, err := strconv.Atoi(rest[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return rest[:i], number, true
+}
+
+// archiveVersion stores manifest as an old version of manifest.Name. The
+// references go first: references without a version are only garbage for
+// the orphan collector, a version without them could lose its chunks.
+func archiveVersion(ctx context.Context, store StorageImpl.Storage, manifest *fileManifest) error {
+	holder := versionHolder(manifest.Name, manifest.Number())
+	added := map[string]bool{}
+	for _, chunk := range manifest.Chunks {
+		if added[chunk.ref()] {
+			continue
+		}
+		if err := addRef(ctx, store, chunk.ref(), holder); err != nil {
+			return fmt.Errorf("error adding chunk reference: %v", err)
+		}
+		added[chunk.ref()] = true
+	}
+	// Versions carry no expiry tags: they go with the file, never on their own.
+	return writeJSON(ctx, store, versionKey(manifest.Name, manifest.Number()), manifest, StorageImpl.PutOptions{})
+}
+
+func loadVersion(ctx context.Context, store StorageImpl.Storage, fileName string, number int) (*fileManifest, error) {
+	var manifest fileManifest
+	if err := readJSON(ctx, store, versionKey(fileName, number), &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// listVersions returns the old versions of fileName, oldest first.
+func listVersions(ctx context.Context, store StorageImpl.Storage, fileName string) ([]*fileManifest, error) {
+	objects, err := store.List(ctx, versionsOf(fileName), "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("error listing versions: %v", err)
+	}
+	var versions []*fileManifest
+	for _, obj := range objects {
+		var manifest fileManifest
+		if err := readJSON(ctx, store, obj.Key, &manifest); err != nil {
+			if StorageImpl.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		versions = append(versions, &manifest)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number() < versions[j].Number() })
+	return versions, nil
+}
+
+// deleteVersion removes an old version and gives back its chunks.
+func deleteVersion(ctx context.Context, store StorageImpl.Storage, manifest *fileManifest) error {
+	if err := store.Delete(ctx, versionKey(manifest.Name, manifest.Number())); err != nil && !StorageImpl.IsNotFound(err) {
+		return err
+	}
+	holder := versionHolder(manifest.Name, manifest.Number())
+	released := map[string]bool{}
+	for _, chunk := range manifest.Chunks {
+		if released[chunk.ref()] {
+			continue
+		}
+		released[chunk.ref()] = true
+		if err := releaseChunk(ctx, store, chunk.ref(), holder); err != nil {
+			log.Printf("⚠️  Warning: could not release chunk %s of %s version %d: %v", chunk.ref(), manifest.Name, manifest.Number(), err)
+		}
+	}
+	return nil
+}
+
+// deleteVersions removes every old version of fileName.
+func deleteVersions(ctx context.Context, store StorageImpl.Storage, fileName string) error {
+	versions, err := listVersions(ctx, store, fileName)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		if err := deleteVersion(ctx, store, version); err != nil {
+			return fmt.Errorf("error removing version %d of %s: %v", version.Number(), fileName, err)
+		}
+	}
+	return nil
+}
+
+// moveVersions gives the old versions of src to dst, keeping their
+// numbers. dst must not have versions of its own.
+func moveVersions(ctx context.Context, store StorageImpl.Storage, src, dst string) error {
+	versions, err := listVersions(ctx, store, src)
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		moved := *version
+		moved.Name = dst
+		if err := archiveVersion(ctx, store, &moved); err != nil {
+			return fmt.Errorf("error moving version %d of %s: %v", version.Number(), src, err)
+		}
+		if err := deleteVersion(ctx, store, version); err != nil {
+			return fmt.Errorf("error moving version %d of %s: %v", version.Number(), src, err)
+		}
+	}
+	return nil
+}
+
+// pruneVersions drops the old versions of fileName that retention no
+// longer keeps, and returns how many it dropped.
+func pruneVersions(ctx context.Context, store StorageImpl.Storage, fileName string, now time.Time) (int, error) {
+	versions, err := listVersions(ctx, store, fileName)
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for i, version := range versions {
+		newer := len(versions) - 1 - i
+		if newer < versionKeep || (versionKeepDays > 0 && now.Sub(version.Created) < versionKeepDays) {
+			continue
+		}
+		if err := deleteVersion(ctx, store, version); err != nil {
+			return pruned, fmt.Errorf("error removing version %d of %s: %v", version.Number(), fileName, err)
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// pruneAllVersions applies retention to every file with old versions, for
+// the versions that aged out without the file changing.
+func pruneAllVersions(ctx context.Context, store StorageImpl.Storage) error {
+	objects, err := store.List(ctx, versionsPrefix, "", 0)
+	if err != nil {
+		return fmt.Errorf("error listing versions: %v", err)
+	}
+	seen := map[string]bool{}
+	pruned := 0
+	for _, obj := range objects {
+		escaped, _, ok := strings.Cut(strings.TrimPrefix(obj.Key, versionsPrefix), "/")
+		if !ok || seen[escaped] {
+			continue
+		}
+		seen[escaped] = true
+		fileName, err := url.QueryUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		n, err := pruneVersions(ctx, store, fileName, time.Now())
+		if err != nil {
+			return err
+		}
+		pruned += n
+	}
+	if pruned > 0 {
+		log.Printf("Removed %d old file versions", pruned)
+	}
+	return nil
+}
+
+// openVersion opens version number of fileName, which may be the current
+// one. Old versions share the expiry of the file.
+func openVersion(ctx context.Context, store StorageImpl.Storage, fileName string, number int) (*storedFile, error) {
+	current, err := loadManifest(ctx, store, fileName)
+	if err != nil {
+		return nil, err
+	}
+	if expires, ok := current.ExpiresAt(); ok && !expires.After(time.Now()) {
+		return nil, StorageImpl.ErrNotFound
+	}
+	if current.Number() == number {
+		return manifestFile(current)
+	}
+	version, err := loadVersion(ctx, store, fileName, number)
+	if err != nil {
+		return nil, err
+	}
+	file, err := manifestFile(version)
+	if err != nil {
+		return nil, err
+	}
+	file.Expires = time.Time{}
+	if expires, ok := current.ExpiresAt(); ok {
+		file.Expires = expires
+	}
+	return file, nil
+}
+
+// restoreVersion makes the content of an old version the current content
+// of the file, as a new version. Owner, tags and expiry stay those of the
+// current file.
+func restoreVersion(ctx context.Context, store StorageImpl.Storage, current, version *fileManifest) (*fileManifest, error) {
+	restored := &fileManifest{
+		Name:              current.Name,
+		Chunks:            version.Chunks,
+		Encryption:        version.Encryption,
+		EncryptedMetadata: version.EncryptedMetadata,
+		KeepForever:       current.KeepForever,
+		Owner:             current.Owner,
+		Tags:              current.Tags,
+		ContentType:       version.ContentType,
+	}
+	if expires, ok := current.ExpiresAt(); ok {
+		restored.Expires = expires
+	}
+	return commitManifest(ctx, store, restored)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/paths"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxArchiveFiles bounds how many files one archive holds, folders
+	// included, and maxArchiveForm the body of a POST listing them.
+	maxArchiveFiles = 10000
+	maxArchiveForm  = 1 << 20
+	// archivePageSize is how many files each folder listing asks for.
+	archivePageSize = 200
+	// archiveManifest lists what went into an archive and what didn't.
+	archiveManifest = "MANIFEST.txt"
+)
+
+// archiveItem is a file to put in an archive, at Path inside it.
+type archiveItem struct {
+	Info *filesharing.FileInfo
+	Path string
+}
+
+// archiveResult is one line of the archive's manifest.
+type archiveResult struct {
+	Path  string
+	Size  int64
+	Error string
+}
+
+// archiveWriter writes the entries of a ZIP or tar.gz archive, one after
+// another, straight to the response.
+type archiveWriter interface {
+	// Create starts an entry of size bytes; its data is written to the
+	// returned writer before the next call.
+	Create(path string, size int64, modified time.Time, compress bool) (io.Writer, error)
+	Close() error
+}
+
+type zipArchive struct {
+	zw *zip.Writer
+}
+
+// Create writes entries with data descriptors, so the size isn't needed up
+// front; archive/zip switches an entry to zip64 once it passes 4 GiB.
+func (a *zipArchive) Create(path string, size int64, modified time.Time, compress bool) (io.Writer, error) {
+	header := &zip.FileHeader{Name: path, Modified: modified, Method: zip.Store}
+	if compress {
+		header.Method = zip.Deflate
+	}
+	header.SetMode(0o644)
+	return a.zw.CreateHeader(header)
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
+
+type tarArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a *tarArchive) Create(path string, size int64, modified time.Time, compress bool) (io.Writer, error) {
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+	})
+	return a.tw, err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// newArchiveWriter returns the writer for format ("zip", "tar.gz" or
+// "tgz"), with its MIME type and file extension.
+func newArchiveWriter(format string, w io.Writer) (archiveWriter, string, string, bool) {
+	switch format {
+	case "", "zip":
+		zw := zip.NewWriter(w)
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, flate.BestSpeed)
+		})
+		return &zipArchive{zw: zw}, "application/zip", ".zip", true
+	case "tar.gz", "tgz":
+		gz, _ := gzip.NewWriterLevel(w, gzip.BestSpeed)
+		return &tarArchive{gz: gz, tw: tar.NewWriter(gz)}, "application/gzip", ".tar.gz", true
+	}
+	return nil, "", "", false
+}
+
+// compressible reports whether deflating a file of contentType is worth
+// it; media and archives are compressed already.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch {
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"):
+		return mediaType == "image/svg+xml" || mediaType == "image/bmp"
+	case mediaType == "application/zip", mediaType == "application/gzip",
+		mediaType == "application/zstd", mediaType == "application/pdf",
+		strings.HasPrefix(mediaType, "application/x-7z"), mediaType == "application/vnd.rar",
+		strings.Contains(mediaType, "openxmlformats"), mediaType == "application/epub+zip":
+		return false
+	}
+	return true
+}
+
+// handleArchive serves /archive: the files named by file= (repeatable) and
+// everything below folder=, as one ZIP or tar.gz (format=) built while it
+// downloads. The parameters can come in the query or a POST form, for
+// selections too long for a URL.
+func handleArchive(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxArchiveForm)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Pedido inválido", http.StatusBadRequest)
+		return
+	}
+	names := r.Form["file"]
+	folder := r.Form.Get("folder")
+	if len(names) == 0 && folder == "" {
+		http.Error(w, "Nenhum ficheiro indicado", http.StatusBadRequest)
+		return
+	}
+	if len(names) > maxArchiveFiles {
+		http.Error(w, fmt.Sprintf("No máximo %d ficheiros por arquivo", maxArchiveFiles), http.StatusBadRequest)
+		return
+	}
+	format := r.Form.Get("format")
+	if _, _, _, ok := newArchiveWriter(format, io.Discard); !ok {
+		http.Error(w, "Formato inválido (zip ou tar.gz)", http.StatusBadRequest)
+		return
+	}
+
+	var items []archiveItem
+	var results []archiveResult
+	archiveName := "files"
+	if folder != "" {
+		path, err := paths.CleanFolder(folder)
+		if err != nil || path == "" {
+			http.Error(w, "Caminho inválido", http.StatusBadRequest)
+			return
+		}
+		list := func(ctx context.Context, path, cursor string) (*filesharing.ListFolderResponse, error) {
+			return client.ListFolder(ctx, &filesharing.ListFolderRequest{
+				Path:    path,
+				User:    requestUser(r),
+				IsAdmin: isAdmin,
+				Cursor:  cursor,
+				Count:   archivePageSize,
+			})
+		}
+		items, results, err = walkFolder(r.Context(), list, path, paths.Dir(path))
+		if err != nil {
+			writeFolderError(w, err, path, "archive")
+			return
+		}
+		archiveName = paths.Base(path)
+	}
+	for _, name := range names {
+		fileName, err := paths.Clean(name)
+		if err != nil {
+			http.Error(w, "Filename inválido", http.StatusBadRequest)
+			return
+		}
+		res, err := client.StatFile(r.Context(), &filesharing.StatFileRequest{FileName: fileName})
+		if err != nil {
+			results = append(results, archiveResult{Path: fileName, Error: archiveError(err)})
+			continue
+		}
+		items = append(items, archiveItem{Info: res.File, Path: fileName})
+	}
+	if len(names) == 1 && folder == "" {
+		archiveName = paths.Base(names[0])
+	}
+	streamArchive(w, r, client, format, archiveName, items, results)
+}
+
+// folderLister lists one page of a folder, for walkFolder.
+type folderLister func(ctx context.Context, path, cursor string) (*filesharing.ListFolderResponse, error)
+
+// walkFolder collects the files below root, with their paths relative to
+// base. Subfolders that can't be listed are noted in the results; failing
+// to list root itself is an error.
+func walkFolder(ctx context.Context, list folderLister, root, base string) ([]archiveItem, []archiveResult, error) {
+	var items []archiveItem
+	var results []archiveResult
+	pending := []string{root}
+	for len(pending) > 0 && len(items) < maxArchiveFiles {
+		path := pending[0]
+		pending = pending[1:]
+		cursor := ""
+		for {
+			res, err := list(ctx, path, cursor)
+			if err != nil && path == root {
+				return nil, nil, err
+			}
+			if err != nil {
+				results = append(results, archiveResult{Path: relativeTo(path, base) + "/", Error: archiveError(err)})
+				break
+			}
+			for _, sub := range res.Folders {
+				pending = append(pending, sub.Path)
+			}
+			for _, file := range res.Files {
+				items = append(items, archiveItem{Info: file, Path: relativeTo(file.Name, base)})
+			}
+			cursor = res.NextCursor
+			if cursor == "" || len(items) >= maxArchiveFiles {
+				break
+			}
+		}
+	}
+	if len(items) >= maxArchiveFiles {
+		items = items[:maxArchiveFiles]
+		results = append(results, archiveResult{Path: relativeTo(root, base) + "/", Error: fmt.Sprintf("only the first %d files were included", maxArchiveFiles)})
+	}
+	return items, results, nil
+}
+
+func relativeTo(name, base string) string {
+	if base == "" {
+		return name
+	}
+	return strings.TrimPrefix(name, base+"/")
+}
+
+// archiveError is how a failed file is described in the manifest.
+func archiveError(err error) string {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return "not found"
+	case codes.PermissionDenied:
+		return "permission denied"
+	}
+	return status.Convert(err).Message()
+}
+
+// streamArchive sends items as an archive called name. Once the headers are
+// out, a file that fails is left out (or cut short, if it failed midway)
+// and the failure is written to MANIFEST.txt, the last entry, next to the
+// list of the files that made it. Nothing is held in memory beyond one
+// download frame.
+func streamArchive(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, format, name string, items []archiveItem, results []archiveResult) {
+	archive, contentType, ext, _ := newArchiveWriter(format, w)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", name+ext))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	seen := map[string]bool{}
+	for _, item := range items {
+		if seen[item.Path] {
+			continue
+		}
+		seen[item.Path] = true
+		if r.Context().Err() != nil {
+			return // the client went away
+		}
+		results = append(results, writeArchiveEntry(r.Context(), archive, client, item))
+	}
+
+	manifestName := archiveManifest
+	for n := 1; seen[manifestName]; n++ {
+		manifestName = fmt.Sprintf("MANIFEST (%d).txt", n)
+	}
+	text := archiveManifestText(results)
+	manifest, err := archive.Create(manifestName, int64(len(text)), time.Now(), true)
+	if err == nil {
+		_, err = io.WriteString(manifest, text)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		log.Printf("Erro ao criar arquivo %s: %v", name, err)
+	}
+}
+
+// writeArchiveEntry downloads one file into the archive. An entry is only
+// started once the download has begun, so files that can't be read at all
+// leave nothing behind.
+func writeArchiveEntry(ctx context.Context, archive archiveWriter, client filesharing.FileUploadClient, item archiveItem) archiveResult {
+	result := archiveResult{Path: item.Path}
+	if item.Info.EndToEnd {
+		result.Error = "end-to-end encrypted, open it with its share link"
+		return result
+	}
+	stream, err := client.DownloadFile(ctx, &filesharing.DownloadFileRequest{FileName: item.Info.Name})
+	var first *filesharing.DownloadFileResponse
+	if err == nil {
+		first, err = stream.Recv()
+	}
+	if err != nil {
+		result.Error = archiveError(err)
+		return result
+	}
+
+	modified := time.Now()
+	if item.Info.Created != 0 {
+		modified = time.Unix(item.Info.Created, 0)
+	}
+	contentType := cmp.Or(first.ContentType, item.Info.ContentType)
+	entry, err := archive.Create(item.Path, first.Length, modified, compressible(contentType))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var written int64
+	for msg := first; ; {
+		n, err := entry.Write(msg.Data)
+		written += int64(n)
+		if err != nil {
+			// The response itself failed; nothing more can be sent.
+			result.Error = err.Error()
+			return result
+		}
+		msg, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Error = fmt.Sprintf("cut short after %d of %d bytes: %s", written, first.Length, archiveError(err))
+			break
+		}
+	}
+	result.Size = written
+	if written < first.Length {
+		// tar entries must be as long as their header says.
+		if _, ok := archive.(*tarArchive); ok {
+			io.CopyN(entry, zeroReader{}, first.Length-written)
+		}
+		if result.Error == "" {
+			result.Error = fmt.Sprintf("cut short after %d of %d bytes", written, first.Length)
+		}
+	}
+	return result
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func archiveManifestText(results []archiveResult) string {
+	var ok, failed int
+	for _, result := range results {
+		if result.Error == "" {
+			ok++
+		} else {
+			failed++
+		}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "KubeFile archive, created %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "%d files included, %d failed\n\n", ok, failed)
+	for _, result := range results {
+		if result.Error == "" {
+			fmt.Fprintf(&b, "OK      %s (%s)\n", result.Path, formatSize(result.Size))
+		}
+	}
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(&b, "FAILED  %s: %s\n", result.Path, result.Error)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/paths"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// entrySeparator splits an archive's name from the path of one of its
+// members in /download/ and /view/ URLs: /download/reports.zip!/index.html.
+const entrySeparator = "!/"
+
+// archiveExtensions are the names entrySeparator is looked for after, so
+// other files whose folders end in "!" keep working.
+var archiveExtensions = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+// splitArchiveEntry splits name at the first entrySeparator that follows an
+// archive's name. entry is "" when name is a plain file.
+func splitArchiveEntry(name string) (fileName, entry string) {
+	for rest := 0; ; {
+		i := strings.Index(name[rest:], entrySeparator)
+		if i < 0 {
+			return name, ""
+		}
+		i += rest
+		lower := strings.ToLower(name[:i])
+		for _, ext := range archiveExtensions {
+			if strings.HasSuffix(lower, ext) {
+				return name[:i], name[i+len(entrySeparator):]
+			}
+		}
+		rest = i + len(entrySeparator)
+	}
+}
+
+// publicEntryName is publicFileName for URLs that can name a member of an
+// archive as well as a file. Only the archive's name is cleaned: member
+// paths are whatever the archive holds and never touch a filesystem.
+func publicEntryName(w http.ResponseWriter, r *http.Request, prefix string) (fileName, entry string, ok bool) {
+	rawName := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+	if rawName == "" {
+		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		return "", "", false
+	}
+	name, err := url.PathUnescape(rawName)
+	if err == nil {
+		fileName, entry = splitArchiveEntry(name)
+		fileName, err = paths.Clean(fileName)
+	}
+	if err != nil {
+		http.Error(w, "Filename inválido", http.StatusBadRequest)
+		return "", "", false
+	}
+	return fileName, entry, true
+}
+
+// entryRecord is how GET /files/<name>?entries describes a member of an
+// archive.
+type entryRecord struct {
+	Path           string `json:"path"`
+	Size           int64  `json:"size"`
+	CompressedSize int64  `json:"compressedSize,omitempty"`
+	Modified       string `json:"modified,omitempty"`
+	Dir            bool   `json:"dir"`
+	DownloadURL    string `json:"downloadUrl,omitempty"`
+}
+
+// handleListArchiveEntries lists what a ZIP, tar or tar.gz file holds,
+// with a link to download each member on its own. ?v=N lists version N.
+func handleListArchiveEntries(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, fileName string) {
+	var version int64
+	if v := r.URL.Query().Get("v"); v != "" {
+		var err error
+		version, err = strconv.ParseInt(v, 10, 32)
+		if err != nil || version <= 0 {
+			http.Error(w, "Versão inválida", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := client.ListArchiveEntries(r.Context(), &filesharing.ListArchiveEntriesRequest{
+		FileName: fileName,
+		Version:  int32(version),
+	})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
+		case codes.InvalidArgument, codes.FailedPrecondition:
+			http.Error(w, "Não é possível abrir este ficheiro como arquivo: "+st.Message(), http.StatusBadRequest)
+		default:
+			log.Printf("Error listing entries of %s: %v", fileName, err)
+			http.Error(w, "Erro ao ler o arquivo", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	downloadURL := requestBaseURL(r) + "/download/" + escapePath(fileName) + entrySeparator
+	query := ""
+	if version > 0 {
+		query = "?v=" + strconv.Itoa(int(version))
+	}
+	out := struct {
+		Format    string        `json:"format"`
+		Entries   []entryRecord `json:"entries"`
+		Truncated bool          `json:"truncated,omitempty"`
+	}{Format: res.Format, Entries: make([]entryRecord, 0, len(res.Entries)), Truncated: res.Truncated}
+	for _, e := range res.Entries {
+		record := entryRecord{
+			Path:           e.Path,
+			Size:           e.Size,
+			CompressedSize: e.CompressedSize,
+			Dir:            e.Dir,
+		}
+		if e.Modified > 0 {
+			record.Modified = time.Unix(e.Modified, 0).UTC().Format(time.RFC3339)
+		}
+		if !e.Dir {
+			record.DownloadURL = downloadURL + escapePath(e.Path) + query
+		}
+		out.Entries = append(out.Entries, record)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fileRecord is how the file browser endpoints describe a file.
+type fileRecord struct {
+	Name        string   `json:"name"`
+	Size        int64    `json:"size"`
+	Created     string   `json:"created,omitempty"`
+	ExpiresAt   *string  `json:"expiresAt"` // null if the file never expires
+	Owner       string   `json:"owner,omitempty"`
+	Tags        []string `json:"tags"`
+	EndToEnd    bool     `json:"endToEnd,omitempty"`
+	ETag        string   `json:"etag,omitempty"`
+	Chunks      int32    `json:"chunks,omitempty"`
+	Version     int32    `json:"version,omitempty"`
+	ContentType string   `json:"contentType,omitempty"`
+	DownloadURL string   `json:"downloadUrl"`
+	ViewURL     string   `json:"viewUrl,omitempty"` // set for types /view/ shows inline
+	ThumbURL    string   `json:"thumbUrl,omitempty"`
+}
+
+func newFileRecord(info *filesharing.FileInfo, baseURL string) fileRecord {
+	record := fileRecord{
+		Name:        info.Name,
+		Size:        info.Size,
+		Owner:       info.Owner,
+		Tags:        info.Tags,
+		EndToEnd:    info.EndToEnd,
+		ETag:        info.ETag,
+		Chunks:      info.Chunks,
+		Version:     info.Version,
+		ContentType: info.ContentType,
+		DownloadURL: baseURL + "/download/" + escapePath(info.Name),
+	}
+	if _, ok := inlineContentType(info.ContentType); ok && !info.EndToEnd {
+		record.ViewURL = baseURL + "/view/" + escapePath(info.Name)
+	}
+	if info.Thumbnail {
+		record.ThumbURL = baseURL + "/thumb/" + escapePath(info.Name)
+	}
+	if record.Tags == nil {
+		record.Tags = []string{}
+	}
+	if info.Created != 0 {
+		record.Created = time.Unix(info.Created, 0).UTC().Format(time.RFC3339)
+	}
+	if info.ExpiresAt != 0 {
+		expires := formatExpiry(info.ExpiresAt)
+		record.ExpiresAt = &expires
+	}
+	return record
+}
+
+// escapePath escapes each segment of a file path for a URL, keeping the
+// slashes between them.
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// parseTags reads the comma-separated tags query parameter of an upload.
+// The filesharing service validates them.
+func parseTags(val string) []string {
+	var tags []string
+	for _, tag := range strings.Split(val, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parseDate accepts RFC 3339 times and plain dates. A plain date used as an
+// upper bound covers the whole day.
+func parseDate(val string, endOfDay bool) (int64, bool) {
+	if val == "" {
+		return 0, true
+	}
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t.Unix(), true
+	}
+	t, err := time.Parse("2006-01-02", val)
+	if err != nil {
+		return 0, false
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t.Unix(), true
+}
+
+func parseSize(val string) (int64, bool) {
+	if val == "" {
+		return 0, true
+	}
+	size, err := strconv.ParseInt(val, 10, 64)
+	return size, err == nil && size >= 0
+}
+
+// listOwner works out whose files a listing covers: the caller's by default.
+// Admins can ask for another user's files or, with "all", everyone's.
+func listOwner(r *http.Request, isAdmin bool) (string, bool) {
+	user := requestUser(r)
+	switch owner := r.URL.Query().Get("owner"); owner {
+	case "", user:
+		return user, true
+	case "all":
+		return "", isAdmin
+	default:
+		return owner, isAdmin
+	}
+}
+
+// handleListFiles serves GET /files: the caller's files in name order, a
+// page at a time. With q it searches names and tags instead; otherwise
+// prefix, tag, minSize, maxSize, after and before filter the listing.
+func handleListFiles(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	owner, ok := listOwner(r, isAdmin)
+	if !ok {
+		http.Error(w, "Apenas administradores podem ver ficheiros de outros utilizadores", http.StatusForbidden)
+		return
+	}
+	count, _ := strconv.Atoi(query.Get("count"))
+
+	var files []*filesharing.FileInfo
+	var nextCursor string
+	var err error
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		var res *filesharing.SearchFilesResponse
+		res, err = client.SearchFiles(r.Context(), &filesharing.SearchFilesRequest{
+			Query:  q,
+			Owner:  owner,
+			Cursor: query.Get("cursor"),
+			Count:  int32(count),
+		})
+		if err == nil {
+			files, nextCursor = res.Files, res.NextCursor
+		}
+	} else {
+		minSize, okMin := parseSize(query.Get("minSize"))
+		maxSize, okMax := parseSize(query.Get("maxSize"))
+		after, okAfter := parseDate(query.Get("after"), false)
+		before, okBefore := parseDate(query.Get("before"), true)
+		if !okMin || !okMax || !okAfter || !okBefore {
+			http.Error(w, "Filtro inválido", http.StatusBadRequest)
+			return
+		}
+		var res *filesharing.ListFilesResponse
+		res, err = client.ListFiles(r.Context(), &filesharing.ListFilesRequest{
+			Owner:         owner,
+			Prefix:        query.Get("prefix"),
+			Tag:           query.Get("tag"),
+			MinSize:       minSize,
+			MaxSize:       maxSize,
+			CreatedAfter:  after,
+			CreatedBefore: before,
+			Cursor:        query.Get("cursor"),
+			Count:         int32(count),
+		})
+		if err == nil {
+			files, nextCursor = res.Files, res.NextCursor
+		}
+	}
+	if err != nil {
+		if status.Code(err) == codes.InvalidArgument {
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error listing files: %v", err)
+		http.Error(w, "Erro ao listar ficheiros", http.StatusInternalServerError)
+		return
+	}
+
+	out := struct {
+		Files      []fileRecord `json:"files"`
+		NextCursor string       `json:"nextCursor,omitempty"`
+	}{
+		Files:      make([]fileRecord, 0, len(files)),
+		NextCursor: nextCursor,
+	}
+	baseURL := requestBaseURL(r)
+	for _, file := range files {
+		out.Files = append(out.Files, newFileRecord(file, baseURL))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// versionRecord is how GET /files/<name>?versions describes a version.
+type versionRecord struct {
+	Version     int32  `json:"version"`
+	Size        int64  `json:"size"`
+	Created     string `json:"created"`
+	ETag        string `json:"etag"`
+	Owner       string `json:"owner,omitempty"`
+	Current     bool   `json:"current"`
+	DownloadURL string `json:"downloadUrl"`
+}
+
+func handleListVersions(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, fileName string) {
+	res, err := client.ListVersions(r.Context(), &filesharing.ListVersionsRequest{FileName: fileName})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error listing versions of %s: %v", fileName, err)
+		http.Error(w, "Erro ao obter as versões", http.StatusInternalServerError)
+		return
+	}
+	downloadURL := requestBaseURL(r) + "/download/" + escapePath(fileName)
+	out := struct {
+		Versions []versionRecord `json:"versions"`
+	}{Versions: make([]versionRecord, 0, len(res.Versions))}
+	for _, v := range res.Versions {
+		out.Versions = append(out.Versions, versionRecord{
+			Version:     v.Version,
+			Size:        v.Size,
+			Created:     time.Unix(v.Created, 0).UTC().Format(time.RFC3339),
+			ETag:        v.ETag,
+			Owner:       v.Owner,
+			Current:     v.Current,
+			DownloadURL: downloadURL + "?v=" + strconv.Itoa(int(v.Version)),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// fileAction is the body of POST /files/<name>.
+type fileAction struct {
+	Action      string `json:"action"` // "rename", "copy" or "restore"
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite"`
+	Version     int32  `json:"version"` // for restore
+}
+
+// handleFile serves /files/<name>: GET describes the file (or lists its
+// versions with ?versions, or what is in it with ?entries), DELETE removes it and POST renames, copies or
+// restores an old version of it, which only the file's owner or an admin
+// can do.
+func handleFile(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	fileName := strings.TrimPrefix(r.URL.Path, "/files/")
+	if fileName == "" {
+		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Has("versions") {
+			handleListVersions(w, r, client, fileName)
+			return
+		}
+		if r.URL.Query().Has("entries") {
+			handleListArchiveEntries(w, r, client, fileName)
+			return
+		}
+		res, err := client.StatFile(r.Context(), &filesharing.StatFileRequest{FileName: fileName})
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error reading %s: %v", fileName, err)
+			http.Error(w, "Erro ao obter o ficheiro", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newFileRecord(res.File, requestBaseURL(r)))
+	case http.MethodDelete:
+		_, err := client.DeleteFile(r.Context(), &filesharing.DeleteFileRequest{
+			FileName: fileName,
+			User:     requestUser(r),
+			IsAdmin:  isAdmin,
+		})
+		if err != nil {
+			switch status.Code(err) {
+			case codes.NotFound:
+				http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
+			case codes.PermissionDenied:
+				http.Error(w, "Só o dono do ficheiro o pode apagar", http.StatusForbidden)
+			default:
+				log.Printf("Error deleting %s: %v", fileName, err)
+				http.Error(w, "Erro ao apagar o ficheiro", http.StatusInternalServerError)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		handleFileAction(w, r, client, fileName, isAdmin)
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleFileAction(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, fileName string, isAdmin bool) {
+	var action fileAction
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&action); err != nil {
+		http.Error(w, "Pedido inválido", http.StatusBadRequest)
+		return
+	}
+
+	var file *filesharing.FileInfo
+	var err error
+	switch action.Action {
+	case "rename":
+		var res *filesharing.RenameFileResponse
+		res, err = client.RenameFile(r.Context(), &filesharing.RenameFileRequest{
+			FileName:    fileName,
+			Destination: action.Destination,
+			User:        requestUser(r),
+			IsAdmin:     isAdmin,
+			Overwrite:   action.Overwrite,
+		})
+		if err == nil {
+			file = res.File
+		}
+	case "copy":
+		var res *filesharing.CopyFileResponse
+		res, err = client.CopyFile(r.Context(), &filesharing.CopyFileRequest{
+			FileName:    fileName,
+			Destination: action.Destination,
+			User:        requestUser(r),
+			IsAdmin:     isAdmin,
+			Overwrite:   action.Overwrite,
+		})
+		if err == nil {
+			file = res.File
+		}
+	case "restore":
+		var res *filesharing.RestoreVersionResponse
+		res, err = client.RestoreVersion(r.Context(), &filesharing.RestoreVersionRequest{
+			FileName: fileName,
+			Version:  action.Version,
+			User:     requestUser(r),
+			IsAdmin:  isAdmin,
+		})
+		if err == nil {
+			file = res.File
+		}
+	default:
+		http.Error(w, "Ação desconhecida (rename, copy ou restore)", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			if action.Action == "restore" {
+				http.Error(w, "Ficheiro ou versão não encontrados", http.StatusNotFound)
+			} else {
+				http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
+			}
+		case codes.PermissionDenied:
+			http.Error(w, "Só o dono do ficheiro o pode alterar", http.StatusForbidden)
+		case codes.AlreadyExists:
+			http.Error(w, "Já existe um ficheiro com esse nome", http.StatusConflict)
+		case codes.InvalidArgument, codes.FailedPrecondition:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+		default:
+			log.Printf("Error running %s on %s: %v", action.Action, fileName, err)
+			http.Error(w, "Erro ao alterar o ficheiro", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newFileRecord(file, requestBaseURL(r)))
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/paths"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// folderRecord is how the folder endpoints describe a folder.
+type folderRecord struct {
+	Path    string   `json:"path"`
+	Name    string   `json:"name"`
+	Owner   string   `json:"owner,omitempty"`
+	Members []string `json:"members"`
+	Created string   `json:"created,omitempty"`
+	// CanManage and Shares are only set for users who manage the folder.
+	CanManage bool          `json:"canManage,omitempty"`
+	Shares    []shareRecord `json:"shares,omitempty"`
+}
+
+type shareRecord struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+func shareURL(baseURL, token string) string {
+	return baseURL + "/shared/" + token + "/"
+}
+
+func newFolderRecord(info *filesharing.FolderInfo, baseURL string) folderRecord {
+	record := folderRecord{
+		Path:      info.Path,
+		Name:      paths.Base(info.Path),
+		Owner:     info.Owner,
+		Members:   info.Members,
+		CanManage: info.CanManage,
+	}
+	if record.Members == nil {
+		record.Members = []string{}
+	}
+	if info.Created != 0 {
+		record.Created = time.Unix(info.Created, 0).UTC().Format(time.RFC3339)
+	}
+	for _, token := range info.Shares {
+		record.Shares = append(record.Shares, shareRecord{Token: token, URL: shareURL(baseURL, token)})
+	}
+	return record
+}
+
+// writeFolderError answers a failed folder request.
+func writeFolderError(w http.ResponseWriter, err error, path, what string) {
+	switch status.Code(err) {
+	case codes.NotFound:
+		http.Error(w, "Pasta não encontrada", http.StatusNotFound)
+	case codes.PermissionDenied:
+		http.Error(w, "Sem permissão para esta pasta", http.StatusForbidden)
+	case codes.AlreadyExists:
+		http.Error(w, "Já existe um ficheiro ou pasta com esse nome", http.StatusConflict)
+	case codes.FailedPrecondition:
+		http.Error(w, "A pasta não está vazia", http.StatusConflict)
+	case codes.InvalidArgument:
+		http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+	default:
+		log.Printf("Error running %s on folder %s: %v", what, path, err)
+		http.Error(w, "Erro ao alterar a pasta", http.StatusInternalServerError)
+	}
+}
+
+// handleFolder serves /folders/<path>: GET lists the folder (/folders is
+// the root), PUT creates it, DELETE removes it, along with everything in it
+// when recursive=true, and POST moves it, shares it or sets its members.
+func handleFolder(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/folders"), "/")
+	if path == "" && r.Method != http.MethodGet {
+		http.Error(w, "Pasta não indicada", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		handleListFolder(w, r, client, path, isAdmin)
+	case http.MethodPut:
+		res, err := client.CreateFolder(r.Context(), &filesharing.CreateFolderRequest{
+			Path:    path,
+			User:    requestUser(r),
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			writeFolderError(w, err, path, "create")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newFolderRecord(res.Folder, requestBaseURL(r)))
+	case http.MethodDelete:
+		_, err := client.DeleteFolder(r.Context(), &filesharing.DeleteFolderRequest{
+			Path:      path,
+			User:      requestUser(r),
+			IsAdmin:   isAdmin,
+			Recursive: parseBool(r.URL.Query().Get("recursive")),
+		})
+		if err != nil {
+			writeFolderError(w, err, path, "delete")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		handleFolderAction(w, r, client, path, isAdmin)
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+func handleListFolder(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, path string, isAdmin bool) {
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	res, err := client.ListFolder(r.Context(), &filesharing.ListFolderRequest{
+		Path:    path,
+		User:    requestUser(r),
+		IsAdmin: isAdmin,
+		Cursor:  r.URL.Query().Get("cursor"),
+		Count:   int32(count),
+	})
+	if err != nil {
+		writeFolderError(w, err, path, "list")
+		return
+	}
+
+	baseURL := requestBaseURL(r)
+	out := struct {
+		Folder     *folderRecord  `json:"folder"` // null for the root
+		Folders    []folderRecord `json:"folders"`
+		Files      []fileRecord   `json:"files"`
+		NextCursor string         `json:"nextCursor,omitempty"`
+	}{
+		Folders:    make([]folderRecord, 0, len(res.Folders)),
+		Files:      make([]fileRecord, 0, len(res.Files)),
+		NextCursor: res.NextCursor,
+	}
+	if res.Folder != nil {
+		record := newFolderRecord(res.Folder, baseURL)
+		out.Folder = &record
+	}
+	for _, folder := range res.Folders {
+		out.Folders = append(out.Folders, newFolderRecord(folder, baseURL))
+	}
+	for _, file := range res.Files {
+		out.Files = append(out.Files, newFileRecord(file, baseURL))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// folderAction is the body of POST /folders/<path>.
+type folderAction struct {
+	Action      string   `json:"action"` // "move", "share", "unshare" or "members"
+	Destination string   `json:"destination"`
+	Token       string   `json:"token"` // the share link "unshare" revokes
+	Members     []string `json:"members"`
+}
+
+func handleFolderAction(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, path string, isAdmin bool) {
+	var action folderAction
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&action); err != nil {
+		http.Error(w, "Pedido inválido", http.StatusBadRequest)
+		return
+	}
+
+	user := requestUser(r)
+	baseURL := requestBaseURL(r)
+	w.Header().Set("Content-Type", "application/json")
+	switch action.Action {
+	case "move":
+		res, err := client.MoveFolder(r.Context(), &filesharing.MoveFolderRequest{
+			Path:        path,
+			Destination: action.Destination,
+			User:        user,
+			IsAdmin:     isAdmin,
+		})
+		if err != nil {
+			writeFolderError(w, err, path, action.Action)
+			return
+		}
+		json.NewEncoder(w).Encode(struct {
+			Folder folderRecord `json:"folder"`
+			Files  int32        `json:"files"`
+		}{newFolderRecord(res.Folder, baseURL), res.Files})
+	case "share":
+		res, err := client.ShareFolder(r.Context(), &filesharing.ShareFolderRequest{
+			Path:    path,
+			User:    user,
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			writeFolderError(w, err, path, action.Action)
+			return
+		}
+		json.NewEncoder(w).Encode(shareRecord{Token: res.Token, URL: shareURL(baseURL, res.Token)})
+	case "unshare":
+		_, err := client.RevokeShare(r.Context(), &filesharing.RevokeShareRequest{
+			Token:   action.Token,
+			User:    user,
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			writeFolderError(w, err, path, action.Action)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "members":
+		res, err := client.SetFolderMembers(r.Context(), &filesharing.SetFolderMembersRequest{
+			Path:    path,
+			Members: action.Members,
+			User:    user,
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			writeFolderError(w, err, path, action.Action)
+			return
+		}
+		json.NewEncoder(w).Encode(newFolderRecord(res.Folder, baseURL))
+	default:
+		http.Error(w, "Ação desconhecida (move, share, unshare ou members)", http.StatusBadRequest)
+	}
+}
+
+// sharedEntry is a folder or file in a shared folder listing. Paths are
+// relative to the shared folder.
+type sharedEntry struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Folder  bool   `json:"folder,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	Created string `json:"created,omitempty"`
+	URL     string `json:"url"`
+	// ThumbURL is set for images; add &size= for other sizes.
+	ThumbURL string `json:"thumbUrl,omitempty"`
+}
+
+type sharedListing struct {
+	Folder     string        `json:"folder"`
+	Path       string        `json:"path"`
+	Entries    []sharedEntry `json:"entries"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+	NextURL    string        `json:"-"`
+	ParentURL  string        `json:"-"`
+}
+
+// handleShared serves the share links of folders, without a session:
+// /shared/<token>/<path>/ lists a folder within the shared one, as a page
+// for browsers and JSON otherwise, /shared/<token>/<path>/?archive=zip (or
+// tar.gz) downloads all of it, /shared/<token>/<path> downloads a file and
+// /shared/<token>/<path>?thumb serves its thumbnail. Paths are cleaned before they are joined to the shared folder, so
+// they can't leave it.
+func handleShared(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, rawPath, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/shared/"), "/")
+	if token == "" {
+		http.Error(w, "Link de partilha não encontrado", http.StatusNotFound)
+		return
+	}
+	if !ok {
+		http.Redirect(w, r, "/shared/"+token+"/", http.StatusMovedPermanently)
+		return
+	}
+	relPath, err := url.PathUnescape(rawPath)
+	if err == nil {
+		relPath, err = paths.CleanFolder(relPath)
+	}
+	if err != nil {
+		http.Error(w, "Caminho inválido", http.StatusBadRequest)
+		return
+	}
+
+	share, err := client.ResolveShare(r.Context(), &filesharing.ResolveShareRequest{Token: token})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "Link de partilha não encontrado", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error resolving share link: %v", err)
+		http.Error(w, "Erro ao abrir o link de partilha", http.StatusInternalServerError)
+		return
+	}
+	fullPath := paths.Join(share.Path, relPath)
+	if relPath != "" && !strings.HasSuffix(rawPath, "/") {
+		if r.URL.Query().Has("thumb") {
+			serveThumbnail(w, r, client, fullPath)
+			return
+		}
+		serveDownload(w, r, client, fullPath, "", false)
+		return
+	}
+	if format := r.URL.Query().Get("archive"); r.URL.Query().Has("archive") {
+		serveSharedArchive(w, r, client, token, fullPath, format)
+		return
+	}
+
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	res, err := client.ListFolder(r.Context(), &filesharing.ListFolderRequest{
+		Path:   fullPath,
+		Share:  token,
+		Cursor: r.URL.Query().Get("cursor"),
+		Count:  int32(count),
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound, codes.PermissionDenied:
+			http.Error(w, "Pasta não encontrada", http.StatusNotFound)
+		default:
+			log.Printf("Error listing shared folder %s: %v", fullPath, err)
+			http.Error(w, "Erro ao listar a pasta", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	base := requestBaseURL(r) + "/shared/" + token + "/"
+	relative := func(name string) string {
+		return strings.TrimPrefix(strings.TrimPrefix(name, share.Path), "/")
+	}
+	listing := sharedListing{
+		Folder:     paths.Base(share.Path),
+		Path:       relPath,
+		Entries:    []sharedEntry{},
+		NextCursor: res.NextCursor,
+	}
+	for _, folder := range res.Folders {
+		rel := relative(folder.Path)
+		listing.Entries = append(listing.Entries, sharedEntry{
+			Name:   paths.Base(rel),
+			Path:   rel,
+			Folder: true,
+			URL:    base + escapePath(rel) + "/",
+		})
+	}
+	for _, file := range res.Files {
+		rel := relative(file.Name)
+		entry := sharedEntry{Name: paths.Base(rel), Path: rel, Size: file.Size, URL: base + escapePath(rel)}
+		if file.Thumbnail {
+			entry.ThumbURL = entry.URL + "?thumb"
+		}
+		if file.Created != 0 {
+			entry.Created = time.Unix(file.Created, 0).UTC().Format(time.RFC3339)
+		}
+		listing.Entries = append(listing.Entries, entry)
+	}
+
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(listing)
+		return
+	}
+	if relPath != "" {
+		listing.ParentURL = base
+		if parent := paths.Dir(relPath); parent != "" {
+			listing.ParentURL += escapePath(parent) + "/"
+		}
+	}
+	if res.NextCursor != "" {
+		listing.NextURL = "?cursor=" + url.QueryEscape(res.NextCursor)
+	}
+	serveSharedPage(w, listing)
+}
+
+// serveSharedArchive sends everything below path, a folder within a share
+// link, as one archive.
+func serveSharedArchive(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, token, path, format string) {
+	if _, _, _, ok := newArchiveWriter(format, io.Discard); !ok {
+		http.Error(w, "Formato inválido (zip ou tar.gz)", http.StatusBadRequest)
+		return
+	}
+	path = strings.TrimSuffix(path, "/") // the shared folder itself is "<folder>/"
+	list := func(ctx context.Context, path, cursor string) (*filesharing.ListFolderResponse, error) {
+		return client.ListFolder(ctx, &filesharing.ListFolderRequest{
+			Path:   path,
+			Share:  token,
+			Cursor: cursor,
+			Count:  archivePageSize,
+		})
+	}
+	items, results, err := walkFolder(r.Context(), list, path, paths.Dir(path))
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound, codes.PermissionDenied:
+			http.Error(w, "Pasta não encontrada", http.StatusNotFound)
+		default:
+			log.Printf("Error listing shared folder %s: %v", path, err)
+			http.Error(w, "Erro ao listar a pasta", http.StatusInternalServerError)
+		}
+		return
+	}
+	streamArchive(w, r, client, format, paths.Base(path), items, results)
+}
+
+func serveSharedPage(w http.ResponseWriter, listing sharedListing) {
+	tmpl, err := template.New("shared.html").Funcs(template.FuncMap{"formatSize": formatSize}).
+		ParseFiles(filepath.Join(".", "static", "shared.html"))
+	if err != nil {
+		log.Printf("Error loading shared folder template: %v", err)
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	if err := tmpl.Execute(w, listing); err != nil {
+		log.Printf("Error rendering shared folder page: %v", err)
+	}
+}
+
+// formatSize renders a byte count for people.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + "KMGTP"[exp:exp+1] + "B"
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// importRecord is how /import describes an import.
+type importRecord struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	FileName string `json:"fileName"`
+	State    string `json:"state"`
+	Received int64  `json:"received"`
+	Total    int64  `json:"total,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Resumes  int32  `json:"resumes,omitempty"`
+	Started  string `json:"started"`
+	Updated  string `json:"updated"`
+	// DownloadURL is set once the file is stored.
+	DownloadURL string `json:"downloadUrl,omitempty"`
+}
+
+func newImportRecord(info *filesharing.ImportInfo, baseURL string) importRecord {
+	record := importRecord{
+		ID:       info.ID,
+		URL:      info.URL,
+		FileName: info.FileName,
+		State:    info.State,
+		Received: info.Received,
+		Total:    info.Total,
+		Error:    info.Error,
+		Resumes:  info.Resumes,
+		Started:  time.Unix(info.Started, 0).UTC().Format(time.RFC3339),
+		Updated:  time.Unix(info.Updated, 0).UTC().Format(time.RFC3339),
+	}
+	if info.State == "done" {
+		record.DownloadURL = baseURL + "/download/" + escapePath(info.FileName)
+	}
+	return record
+}
+
+// handleImport serves /import: POST has the server download the url= form
+// value into storage, as filename= (a name, or a folder ending in "/"),
+// with the expires=, tags= and conflict= of /upload. It answers 202 with
+// the import, whose progress GET /import/<id> reports.
+func handleImport(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/import"), "/")
+	switch {
+	case r.Method == http.MethodGet && id != "":
+		res, err := client.GetImport(r.Context(), &filesharing.GetImportRequest{
+			ID:      id,
+			User:    requestUser(r),
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				http.Error(w, "Importação não encontrada", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error reading import %s: %v", id, err)
+			http.Error(w, "Erro ao obter a importação", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(newImportRecord(res.Import, requestBaseURL(r)))
+	case r.Method == http.MethodPost && id == "":
+		startImport(w, r, client, isAdmin)
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+func startImport(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	rawURL := strings.TrimSpace(r.FormValue("url"))
+	if rawURL == "" {
+		http.Error(w, "URL não fornecida", http.StatusBadRequest)
+		return
+	}
+	expiresIn, keepForever, ok := parseExpiry(r.FormValue("expires"))
+	if !ok {
+		http.Error(w, "Validade inválida (segundos ou never)", http.StatusBadRequest)
+		return
+	}
+	if keepForever && !isAdmin {
+		http.Error(w, "Apenas administradores podem guardar ficheiros para sempre", http.StatusForbidden)
+		return
+	}
+
+	res, err := client.ImportFromURL(r.Context(), &filesharing.ImportFromURLRequest{
+		URL:              rawURL,
+		FileName:         r.FormValue("filename"),
+		Owner:            requestUser(r),
+		IsAdmin:          isAdmin,
+		ExpiresInSeconds: expiresIn,
+		KeepForever:      keepForever,
+		Tags:             parseTags(r.FormValue("tags")),
+		Conflict:         r.FormValue("conflict"),
+	})
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.InvalidArgument:
+			http.Error(w, st.Message(), http.StatusBadRequest)
+		case codes.FailedPrecondition:
+			http.Error(w, "Não foi possível importar: "+st.Message(), http.StatusBadGateway)
+		case codes.PermissionDenied:
+			http.Error(w, "Sem permissão para escrever nesta pasta", http.StatusForbidden)
+		case codes.AlreadyExists:
+			http.Error(w, st.Message(), http.StatusConflict)
+		case codes.ResourceExhausted:
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Demasiadas importações em curso, tente mais tarde", http.StatusTooManyRequests)
+		default:
+			log.Printf("Error importing %s: %v", rawURL, err)
+			http.Error(w, "Erro ao importar o ficheiro", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/import/"+res.Import.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newImportRecord(res.Import, requestBaseURL(r)))
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Maruqes/KubeFile/shared/proto/shortener"
+)
+
+const (
+	maxBatchURLs      = 1000
+	maxBatchBodySize  = 4 * 1024 * 1024
+	maxImportBodySize = 64 * 1024 * 1024
+	importBatchSize   = 500
+)
+
+type batchResult struct {
+	URL      string `json:"url"`
+	UUID     string `json:"uuid,omitempty"`
+	ShortURL string `json:"shortUrl,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// linkRecord is one line of the JSONL export/import format.
+type linkRecord struct {
+	Slug       string `json:"slug"`
+	Target     string `json:"target"`
+	TTLSeconds int64  `json:"ttl_seconds,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+	CreatedAt  int64  `json:"created_at,omitempty"`
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// parseURLList accepts either a JSON array (of strings or {"url": ...}
+// objects) or CSV whose first column holds the URL.
+func parseURLList(contentType string, body io.Reader) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if mediaType == "application/json" {
+		var raw []json.RawMessage
+		if err := json.NewDecoder(body).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("JSON inválido: %v", err)
+		}
+		urls := make([]string, 0, len(raw))
+		for _, item := range raw {
+			var asString string
+			if err := json.Unmarshal(item, &asString); err == nil {
+				urls = append(urls, asString)
+				continue
+			}
+			var asObject struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(item, &asObject); err != nil {
+				return nil, fmt.Errorf("entrada JSON inválida: %s", string(item))
+			}
+			urls = append(urls, asObject.URL)
+		}
+		return urls, nil
+	}
+
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var urls []string
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("CSV inválido: %v", err)
+		}
+		if len(record) == 0 {
+			continue
+		}
+		value := strings.TrimSpace(record[0])
+		if line == 0 && strings.EqualFold(value, "url") {
+			continue
+		}
+		if value != "" {
+			urls = append(urls, value)
+		}
+	}
+	return urls, nil
+}
+
+func handleShortBatch(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient, policy *URLPolicy) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urls, err := parseURLList(r.Header.Get("Content-Type"), http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(urls) == 0 {
+		http.Error(w, "Nenhuma URL fornecida", http.StatusBadRequest)
+		return
+	}
+	if len(urls) > maxBatchURLs {
+		http.Error(w, fmt.Sprintf("Máximo de %d URLs por pedido", maxBatchURLs), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	results := make([]batchResult, len(urls))
+	var valid []string
+	var validIdx []int
+	for i, raw := range urls {
+		results[i].URL = raw
+		target, err := policy.Normalize(r.Context(), raw)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		valid = append(valid, target)
+		validIdx = append(validIdx, i)
+	}
+
+	if len(valid) > 0 {
+		res, err := client.ShortURLBatch(r.Context(), &shortener.ShortURLBatchRequest{
+			OriginalURLs: valid,
+			Owner:        requestUser(r),
+		})
+		if err != nil {
+			log.Printf("Error shortening batch: %v", err)
+			http.Error(w, "Erro ao encurtar URLs", http.StatusInternalServerError)
+			return
+		}
+		baseURL := requestBaseURL(r)
+		for j, item := range res.Results {
+			if j >= len(validIdx) {
+				break
+			}
+			result := &results[validIdx[j]]
+			result.URL = item.OriginalURL
+			result.Error = item.Error
+			if item.UUID != "" {
+				result.UUID = item.UUID
+				result.ShortURL = baseURL + "/geturl?uuid=" + item.UUID
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func handleExportLinks(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"kubefile-links.jsonl\"")
+
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	var cursor uint64
+	exported := 0
+	for {
+		res, err := client.ExportURLs(r.Context(), &shortener.ExportURLsRequest{
+			Cursor: cursor,
+		})
+		if err != nil {
+			log.Printf("Error exporting links after %d records: %v", exported, err)
+			if exported == 0 {
+				http.Error(w, "Erro ao exportar links", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		for _, link := range res.Links {
+			err := encoder.Encode(linkRecord{
+				Slug:       link.Slug,
+				Target:     link.OriginalURL,
+				TTLSeconds: link.TTLSeconds,
+				Owner:      link.Owner,
+				CreatedAt:  link.CreatedAt,
+			})
+			if err != nil {
+				log.Printf("Error writing export: %v", err)
+				return
+			}
+			exported++
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		cursor = res.NextCursor
+		if cursor == 0 {
+			break
+		}
+	}
+	log.Printf("Exported %d links", exported)
+}
+
+func handleImportLinks(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient, policy *URLPolicy) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	overwrite := parseBool(r.URL.Query().Get("overwrite"))
+
+	summary := struct {
+		Imported int32    `json:"imported"`
+		Skipped  int32    `json:"skipped"`
+		Errors   []string `json:"errors,omitempty"`
+	}{}
+
+	flush := func(batch []*shortener.LinkRecord) error {
+		if len(batch) == 0 {
+			return nil
+		}
+		res, err := client.ImportURLs(r.Context(), &shortener.ImportURLsRequest{
+			Links:     batch,
+			Overwrite: overwrite,
+		})
+		if err != nil {
+			return err
+		}
+		summary.Imported += res.Imported
+		summary.Skipped += res.Skipped
+		summary.Errors = append(summary.Errors, res.Errors...)
+		return nil
+	}
+
+	scanner := bufio.NewScanner(http.MaxBytesReader(w, r.Body, maxImportBodySize))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var batch []*shortener.LinkRecord
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record linkRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			summary.Skipped++
+			summary.Errors = append(summary.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		// Imported targets are held to the same policy as new links.
+		target, err := policy.Normalize(r.Context(), record.Target)
+		if err != nil {
+			summary.Skipped++
+			summary.Errors = append(summary.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		batch = append(batch, &shortener.LinkRecord{
+			Slug:        record.Slug,
+			OriginalURL: target,
+			TTLSeconds:  record.TTLSeconds,
+			Owner:       record.Owner,
+			CreatedAt:   record.CreatedAt,
+		})
+		if len(batch) == importBatchSize {
+			if err := flush(batch); err != nil {
+				log.Printf("Error importing links: %v", err)
+				http.Error(w, "Erro ao importar links", http.StatusInternalServerError)
+				return
+			}
+			batch = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, "Erro ao ler ficheiro de importação", http.StatusBadRequest)
+		return
+	}
+	if err := flush(batch); err != nil {
+		log.Printf("Error importing links: %v", err)
+		http.Error(w, "Erro ao importar links", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+func handleListLinks(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	res, err := client.ListURLs(r.Context(), &shortener.ListURLsRequest{
+		Owner:  requestUser(r),
+		Cursor: r.URL.Query().Get("cursor"),
+		Count:  int32(count),
+	})
+	if err != nil {
+		log.Printf("Error listing links: %v", err)
+		http.Error(w, "Erro ao listar links", http.StatusInternalServerError)
+		return
+	}
+
+	type listedLink struct {
+		linkRecord
+		ShortURL string `json:"shortUrl"`
+	}
+	out := struct {
+		Links      []listedLink `json:"links"`
+		NextCursor string       `json:"nextCursor,omitempty"`
+	}{
+		Links:      make([]listedLink, 0, len(res.Links)),
+		NextCursor: res.NextCursor,
+	}
+	baseURL := requestBaseURL(r)
+	for _, link := range res.Links {
+		out.Links = append(out.Links, listedLink{
+			linkRecord: linkRecord{
+				Slug:       link.Slug,
+				Target:     link.OriginalURL,
+				TTLSeconds: link.TTLSeconds,
+				Owner:      link.Owner,
+				CreatedAt:  link.CreatedAt,
+			},
+			ShortURL: baseURL + "/geturl?uuid=" + link.Slug,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
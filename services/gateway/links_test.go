@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/shortener"
+	"google.golang.org/grpc"
+)
+
+// recordingShortener keeps the links it is asked to import.
+type recordingShortener struct {
+	shortener.ShortenerClient
+	imported []*shortener.LinkRecord
+}
+
+func (s *recordingShortener) ImportURLs(ctx context.Context, req *shortener.ImportURLsRequest, opts ...grpc.CallOption) (*shortener.ImportURLsResponse, error) {
+	s.imported = append(s.imported, req.Links...)
+	return &shortener.ImportURLsResponse{Imported: int32(len(req.Links))}, nil
+}
+
+func TestImportLinksChecksTargets(t *testing.T) {
+	policy := &URLPolicy{AllowedSchemes: map[string]bool{"http": true, "https": true}, BlockedDomains: []string{"blocked.example"}, LookupTimeout: time.Second}
+	client := &recordingShortener{}
+	body := strings.Join([]string{
+		`{"slug":"ok","target":"https://93.184.216.34/page","owner":"bob"}`,
+		`{"slug":"private","target":"http://127.0.0.1:6379/"}`,
+		`{"slug":"scheme","target":"javascript:alert(1)"}`,
+		`{"slug":"blocked","target":"https://blocked.example/"}`,
+		`{"slug":"metadata","target":"http://169.254.169.254/latest/"}`,
+		`not json`,
+	}, "\n")
+	w := httptest.NewRecorder()
+	handleImportLinks(w, httptest.NewRequest(http.MethodPost, "/links/import", strings.NewReader(body)), client, policy)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var summary struct {
+		Imported, Skipped int
+		Errors            []string
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 1 || summary.Skipped != 5 || len(summary.Errors) != 5 {
+		t.Errorf("summary %+v, want 1 imported and 5 skipped", summary)
+	}
+	if len(client.imported) != 1 || client.imported[0].Slug != "ok" || client.imported[0].Owner != "bob" {
+		t.Errorf("imported %v", client.imported)
+	}
+}
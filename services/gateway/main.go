@@ -1,22 +1,29 @@
 package main
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"html"
+	"html/template"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Maruqes/KubeFile/shared/e2e"
+	"github.com/Maruqes/KubeFile/shared/paths"
 	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
 	"github.com/Maruqes/KubeFile/shared/proto/shortener"
 	"google.golang.org/grpc"
@@ -25,35 +32,17 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-func isValidURL(url string, trying ...bool) string {
-	// Simple URL validation logic
-	if len(url) < 5 || (len(url) > 2083) || !((url[:4] == "http") || (url[:5] == "https")) {
-		if len(trying) == 0 || !trying[0] {
-			url = "https://" + url
-			return isValidURL(url, true)
-		}
-		return ""
-	}
-	return url
-}
-
-func askForShortURL(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient) {
+func askForShortURL(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient, policy *URLPolicy) {
 	//get var url from GET request
-	url := r.URL.Query().Get("url")
-
-	url = isValidURL(url)
-	if url == "" {
-		http.Error(w, "URL inválida", http.StatusBadRequest)
-		return
-	}
-
-	if url == "" {
-		http.Error(w, "URL não fornecida", http.StatusBadRequest)
+	target, err := policy.Normalize(r.Context(), r.URL.Query().Get("url"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	url_final, err := client.ShortURL(r.Context(), &shortener.ShortURLRequest{
-		OriginalURL: url,
+		OriginalURL: target,
+		Owner:       requestUser(r),
 	})
 	if err != nil {
 		http.Error(w, "Erro ao encurtar URL", http.StatusInternalServerError)
@@ -62,7 +51,7 @@ func askForShortURL(w http.ResponseWriter, r *http.Request, client shortener.Sho
 	w.Write([]byte(url_final.UUID))
 }
 
-func getMainUrl(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient) {
+func getMainUrl(w http.ResponseWriter, r *http.Request, client shortener.ShortenerClient, policy *URLPolicy) {
 	//get var url from GET request
 	user_uuid := r.URL.Query().Get("uuid")
 	if user_uuid == "" {
@@ -78,27 +67,114 @@ func getMainUrl(w http.ResponseWriter, r *http.Request, client shortener.Shorten
 		return
 	}
 
-	w.Header().Set("Location", resp.OriginalURL)
+	// Links stored before the policy existed (or before it was tightened) are
+	// checked again so /geturl never works as an open redirector.
+	target, err := url.Parse(resp.OriginalURL)
+	if err == nil {
+		err = policy.Check(r.Context(), target)
+	}
+	if err != nil {
+		log.Printf("Refusing to redirect %s to %q: %v", user_uuid, resp.OriginalURL, err)
+		http.Error(w, "Destino bloqueado", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if parseBool(r.URL.Query().Get("preview")) {
+		serveLinkPreview(w, target)
+		return
+	}
+
+	w.Header().Set("Location", target.String())
 	w.WriteHeader(http.StatusFound)
-	fmt.Fprintf(w, "Redirecting to %s...", resp.OriginalURL)
+	fmt.Fprintf(w, "Redirecting to %s...", html.EscapeString(target.String()))
 }
 
-func handleUploadFile(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+func serveLinkPreview(w http.ResponseWriter, target *url.URL) {
+	filePath := filepath.Join(".", "static", "preview.html")
+	tmpl, err := template.ParseFiles(filePath)
+	if err != nil {
+		log.Printf("Error loading preview template: %v", err)
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err = tmpl.Execute(w, struct {
+		Host   string
+		Target string
+	}{
+		Host:   target.Hostname(),
+		Target: target.String(),
+	})
+	if err != nil {
+		log.Printf("Error rendering preview page: %v", err)
+	}
+}
+
+// parseExpiry reads the expires query parameter of an upload: seconds, or
+// "never" to keep the file forever.
+func parseExpiry(val string) (seconds int64, forever bool, ok bool) {
+	switch val {
+	case "":
+		return 0, false, true
+	case "never":
+		return 0, true, true
+	}
+	seconds, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || seconds <= 0 {
+		return 0, false, false
+	}
+	return seconds, false, true
+}
+
+// formatExpiry formats an expiry Unix time for the X-Expires-At header.
+func formatExpiry(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+func handleUploadFile(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Match, If-None-Match, "+e2e.MetadataHeader)
 
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	filename := r.URL.Query().Get("filename")
-	if filename == "" {
+	filename, err := paths.Clean(r.URL.Query().Get("filename"))
+	if err == paths.ErrEmpty {
 		http.Error(w, "Filename not provided", http.StatusBadRequest)
 		return
 	}
+	if err != nil {
+		http.Error(w, "Filename inválido", http.StatusBadRequest)
+		return
+	}
+
+	expiresIn, keepForever, ok := parseExpiry(r.URL.Query().Get("expires"))
+	if !ok {
+		http.Error(w, "Validade inválida (segundos ou never)", http.StatusBadRequest)
+		return
+	}
+	if keepForever && !isAdmin {
+		http.Error(w, "Apenas administradores podem guardar ficheiros para sempre", http.StatusForbidden)
+		return
+	}
+
+	// End-to-end encrypted uploads carry their sealed metadata in a header.
+	var encryptedMetadata []byte
+	if val := r.Header.Get(e2e.MetadataHeader); val != "" {
+		blob, err := e2e.DecodeBlob(val)
+		if err != nil || len(blob) == 0 {
+			http.Error(w, "Metadados encriptados inválidos", http.StatusBadRequest)
+			return
+		}
+		encryptedMetadata = blob
+	}
 
 	// Read file content from request body
 	contentFile, err := io.ReadAll(r.Body)
@@ -109,27 +185,68 @@ func handleUploadFile(w http.ResponseWriter, r *http.Request, client filesharing
 	defer r.Body.Close()
 
 	//get current url
-	scheme := "http"
-	if r.TLS != nil {
-		scheme = "https"
-	}
-	baseURL := scheme + "://" + r.Host
+	baseURL := requestBaseURL(r)
 
 	res, err := client.UploadFile(r.Context(), &filesharing.UploadFileRequest{
-		FileName:    filename,
-		FileContent: []byte(contentFile),
-		CurrentUrl:  baseURL + "/download/" + filename,
+		FileName:          filename,
+		FileContent:       []byte(contentFile),
+		CurrentUrl:        baseURL + "/download/" + escapePath(filename),
+		Complete:          parseBool(r.URL.Query().Get("complete")),
+		Compression:       r.URL.Query().Get("compress"),
+		EncryptedMetadata: encryptedMetadata,
+		ExpiresInSeconds:  expiresIn,
+		KeepForever:       keepForever,
+		Owner:             requestUser(r),
+		Tags:              parseTags(r.URL.Query().Get("tags")),
+		IsAdmin:           isAdmin,
+		Conflict:          r.URL.Query().Get("conflict"),
+		IfMatch:           parseETags(r.Header.Get("If-Match")),
+		IfNoneMatch:       parseETags(r.Header.Get("If-None-Match")),
 	})
 	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument:
+			http.Error(w, status.Convert(err).Message(), http.StatusBadRequest)
+			return
+		case codes.PermissionDenied:
+			http.Error(w, "Sem permissão para escrever este ficheiro", http.StatusForbidden)
+			return
+		case codes.AlreadyExists:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			return
+		case codes.Aborted:
+			http.Error(w, "O ficheiro mudou entretanto (ETag)", http.StatusPreconditionFailed)
+			return
+		}
 		fmt.Printf("Error uploading file: %v\n", err)
 		http.Error(w, "Erro ao fazer upload do ficheiro", http.StatusInternalServerError)
 		return
 	}
+	if res.ExpiresAt > 0 {
+		w.Header().Set("X-Expires-At", formatExpiry(res.ExpiresAt))
+	}
+	// With conflict=rename the file may be stored under another name, which
+	// /upload-chunk and /upload-complete must then be given.
+	w.Header().Set("X-File-Name", escapePath(res.FileName))
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintf(w, "File uploaded successfully: %s\n", res.FileName)
 }
 
-func handleUploadChuck(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+// parseETags reads an If-Match or If-None-Match header into the
+// comma-separated bare ETags (or "*") the filesharing service takes.
+// Weak ETags compare like strong ones: the service only has one kind.
+func parseETags(val string) string {
+	var etags []string
+	for _, etag := range strings.Split(val, ",") {
+		etag = strings.Trim(strings.TrimPrefix(strings.TrimSpace(etag), "W/"), `"`)
+		if etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return strings.Join(etags, ",")
+}
+
+func handleUploadChuck(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
@@ -154,11 +271,27 @@ func handleUploadChuck(w http.ResponseWriter, r *http.Request, client filesharin
 	}
 	defer r.Body.Close()
 
+	var partNumber int64
+	if part := r.URL.Query().Get("part"); part != "" {
+		partNumber, err = strconv.ParseInt(part, 10, 32)
+		if err != nil || partNumber < 1 {
+			http.Error(w, "Invalid part number", http.StatusBadRequest)
+			return
+		}
+	}
+
 	res, err := client.AddChunk(r.Context(), &filesharing.AddChunkRequest{
-		FileName:  filename,
-		ChunkData: []byte(contentFile),
+		FileName:   filename,
+		ChunkData:  []byte(contentFile),
+		PartNumber: int32(partNumber),
+		Owner:      requestUser(r),
+		IsAdmin:    isAdmin,
 	})
 	if err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			http.Error(w, "Nenhum upload em curso para este ficheiro", http.StatusConflict)
+			return
+		}
 		fmt.Printf("Error uploading file: %v\n", err)
 		http.Error(w, "Erro ao fazer upload do ficheiro", http.StatusInternalServerError)
 		return
@@ -167,7 +300,155 @@ func handleUploadChuck(w http.ResponseWriter, r *http.Request, client filesharin
 	fmt.Fprintf(w, "Chunk uploaded successfully: %s\nMessage: %s", filename, res.Message)
 }
 
-func handleGetStorageInfo(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+func handleCompleteUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		return
+	}
+
+	res, err := client.CompleteUpload(r.Context(), &filesharing.CompleteUploadRequest{
+		FileName: filename,
+		Owner:    requestUser(r),
+		IsAdmin:  isAdmin,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.FailedPrecondition:
+			http.Error(w, "Nenhum upload em curso para este ficheiro", http.StatusConflict)
+			return
+		case codes.AlreadyExists:
+			http.Error(w, status.Convert(err).Message(), http.StatusConflict)
+			return
+		case codes.Aborted:
+			http.Error(w, "O ficheiro mudou entretanto (ETag)", http.StatusPreconditionFailed)
+			return
+		case codes.PermissionDenied:
+			http.Error(w, status.Convert(err).Message(), http.StatusForbidden)
+			return
+		}
+		log.Printf("Error completing upload of %s: %v", filename, err)
+		http.Error(w, "Erro ao concluir o upload do ficheiro", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"fileName":  res.FileName,
+		"size":      res.Size,
+		"etag":      res.ETag,
+		"expiresAt": res.ExpiresAt,
+	})
+}
+
+func handleAbortUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := client.AbortUpload(r.Context(), &filesharing.AbortUploadRequest{
+		FileName: filename,
+		Owner:    requestUser(r),
+		IsAdmin:  isAdmin,
+	}); err != nil {
+		log.Printf("Error aborting upload of %s: %v", filename, err)
+		http.Error(w, "Erro ao cancelar o upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleRotateKeys(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := client.RotateKeys(r.Context(), &filesharing.RotateKeysRequest{})
+	if err != nil {
+		if status.Code(err) == codes.FailedPrecondition {
+			http.Error(w, "A encriptação em repouso não está ativa", http.StatusConflict)
+			return
+		}
+		log.Printf("Error rotating keys: %v", err)
+		http.Error(w, "Erro ao rodar as chaves", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"activeKeyId": res.ActiveKeyId,
+		"rewrapped":   res.Rewrapped,
+		"failed":      res.Failed,
+	})
+}
+
+// handleJobStatus reports which filesharing replica is the leader and how
+// its background jobs last ran, with the thumbnail worker counters of the
+// replica that answered.
+func handleJobStatus(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	res, err := client.GetJobStatus(r.Context(), &filesharing.GetJobStatusRequest{})
+	if err != nil {
+		log.Printf("Error getting job status: %v", err)
+		http.Error(w, "Erro ao obter o estado dos trabalhos", http.StatusInternalServerError)
+		return
+	}
+
+	jobs := make([]map[string]any, 0, len(res.Jobs))
+	for _, job := range res.Jobs {
+		entry := map[string]any{
+			"name":            job.Name,
+			"intervalSeconds": job.IntervalSeconds,
+			"lastRun":         nil,
+			"durationMs":      job.DurationMs,
+			"error":           job.Error,
+			"runBy":           job.RunBy,
+		}
+		if job.LastRun != 0 {
+			entry["lastRun"] = time.Unix(job.LastRun, 0).UTC().Format(time.RFC3339)
+		}
+		jobs = append(jobs, entry)
+	}
+	body := map[string]any{
+		"backend": res.Backend,
+		"leader":  res.Leader,
+		"replica": res.Replica,
+		"jobs":    jobs,
+	}
+	if thumbs := res.Thumbnails; thumbs != nil {
+		body["thumbnails"] = map[string]any{
+			"workers":   thumbs.Workers,
+			"queued":    thumbs.Queued,
+			"active":    thumbs.Active,
+			"enqueued":  thumbs.Enqueued,
+			"generated": thumbs.Generated,
+			"failed":    thumbs.Failed,
+			"retried":   thumbs.Retried,
+			"dropped":   thumbs.Dropped,
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+func handleGetStorageInfo(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
 	// Add CORS headers
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
@@ -186,7 +467,8 @@ func handleGetStorageInfo(w http.ResponseWriter, r *http.Request, client filesha
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"totalSize": %d, "usedSize": %d}`, res.TotalSize, res.UsedSize)
+	fmt.Fprintf(w, `{"totalSize": %d, "usedSize": %d, "logicalBytes": %d, "physicalBytes": %d, "defaultExpirySeconds": %d, "maxExpirySeconds": %d, "isAdmin": %t}`,
+		res.TotalSize, res.UsedSize, res.LogicalBytes, res.PhysicalBytes, res.DefaultExpirySeconds, res.MaxExpirySeconds, isAdmin)
 }
 
 func handleGetFileChunk(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
@@ -227,7 +509,7 @@ func handleGetFileChunk(w http.ResponseWriter, r *http.Request, client fileshari
 		return
 	}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", paths.Base(fileName)))
 	w.Header().Set("Content-Type", "application/octet-stream")
 	// Add custom header to indicate if this is the last chunk
 	if res.IsLastChunk {
@@ -238,68 +520,206 @@ func handleGetFileChunk(w http.ResponseWriter, r *http.Request, client fileshari
 	w.Write(res.ChunkData)
 }
 
-func handlePublicDownload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+// parseRange understands a single "bytes=" range and returns it in the
+// DownloadFile convention (negative offset for suffix ranges, length 0 for
+// "until the end"). Anything else is ignored and the whole file is served.
+func parseRange(header string) (offset, length int64, ok bool) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+	if startStr == "" {
+		suffix, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, false
+		}
+		return -suffix, 0, true
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false
+	}
+	if endStr == "" {
+		return start, 0, true
+	}
+	end, err := strconv.ParseInt(endStr, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end - start + 1, true
+}
+
+// acceptedEncodings returns the codings of an Accept-Encoding header that
+// the filesharing service can produce and the client didn't refuse with q=0.
+func acceptedEncodings(header string) []string {
+	var out []string
+	for _, item := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "zstd" && name != "gzip" {
+			continue
+		}
+		if q, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+			if val, err := strconv.ParseFloat(q, 64); err == nil && val == 0 {
+				continue
+			}
+		}
+		out = append(out, name)
+	}
+	return out
+}
+
+// handlePublicDownload serves /download/<name>, and /view/<name> when
+// inline is set.
+func handlePublicDownload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, inline bool) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 		return
 	}
 
-	rawName := strings.TrimPrefix(r.URL.Path, "/download/")
-	if rawName == "" {
-		http.Error(w, "Filename not provided", http.StatusBadRequest)
+	prefix := "/download/"
+	if inline {
+		prefix = "/view/"
+	}
+	fileName, entry, ok := publicEntryName(w, r, prefix)
+	if !ok {
 		return
 	}
+	serveDownload(w, r, client, fileName, entry, inline)
+}
 
+// publicFileName returns the file named by the path of r after prefix. The
+// name is a path in its own right ("/download/team/releases/v1.tar"),
+// cleaned the way the filesharing service does. When it is missing or
+// invalid, the error is sent and ok is false.
+func publicFileName(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	rawName := strings.TrimPrefix(r.URL.EscapedPath(), prefix)
+	if rawName == "" {
+		http.Error(w, "Filename not provided", http.StatusBadRequest)
+		return "", false
+	}
 	fileName, err := url.PathUnescape(rawName)
-	if err != nil {
-		http.Error(w, "Filename inválido", http.StatusBadRequest)
-		return
+	if err == nil {
+		fileName, err = paths.Clean(fileName)
 	}
-	fileName = filepath.Base(fileName)
-	if fileName == "" || fileName == "." {
+	if err != nil {
 		http.Error(w, "Filename inválido", http.StatusBadRequest)
-		return
+		return "", false
 	}
+	return fileName, true
+}
 
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-
-	flusher, _ := w.(http.Flusher)
-	chunkIndex := int32(0)
+// serveDownload streams fileName, or the range of it the request asks for;
+// with entry set, that member of the archive fileName instead. ?v=N
+// downloads version N instead of the current content. With inline set,
+// images, PDFs, video, audio and text are shown in the browser, under a
+// strict CSP, and anything else is still downloaded.
+func serveDownload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, fileName, entry string, inline bool) {
+	var version int64
+	if v := r.URL.Query().Get("v"); v != "" {
+		var err error
+		version, err = strconv.ParseInt(v, 10, 32)
+		if err != nil || version <= 0 {
+			http.Error(w, "Versão inválida", http.StatusBadRequest)
+			return
+		}
+	}
+	offset, length, ranged := parseRange(r.Header.Get("Range"))
+	var accepted []string
+	if !ranged {
+		accepted = acceptedEncodings(r.Header.Get("Accept-Encoding"))
+	}
 
-	for {
-		res, err := client.GetChunk(r.Context(), &filesharing.GetChunkRequest{
-			FileName:   fileName,
-			ChunkIndex: chunkIndex,
-		})
-		if err != nil {
-			errMsg := strings.ToLower(err.Error())
-			if chunkIndex == 0 && (strings.Contains(errMsg, "does not exist") || strings.Contains(errMsg, "no such key") || strings.Contains(errMsg, "not provided")) {
+	stream, err := client.DownloadFile(r.Context(), &filesharing.DownloadFileRequest{
+		FileName:       fileName,
+		Offset:         offset,
+		Length:         length,
+		AcceptEncoding: accepted,
+		Version:        int32(version),
+		Entry:          entry,
+	})
+	var first *filesharing.DownloadFileResponse
+	if err == nil {
+		first, err = stream.Recv()
+	}
+	if err != nil {
+		st, _ := status.FromError(err)
+		switch st.Code() {
+		case codes.NotFound:
+			if entry != "" {
+				http.Error(w, "Ficheiro ou entrada do arquivo não encontrados", http.StatusNotFound)
+			} else if version > 0 {
+				http.Error(w, "Versão não encontrada", http.StatusNotFound)
+			} else {
 				http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
-				return
-			}
-
-			st, ok := status.FromError(err)
-			if ok {
-				switch st.Code() {
-				case codes.NotFound:
-					http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
-					return
-				case codes.DeadlineExceeded:
-					http.Error(w, "Pedido expirou", http.StatusGatewayTimeout)
-					return
-				}
 			}
-
-			log.Printf("Erro ao transferir ficheiro %s chunk %d: %v", fileName, chunkIndex, err)
+		case codes.OutOfRange:
+			http.Error(w, "Intervalo inválido", http.StatusRequestedRangeNotSatisfiable)
+		case codes.InvalidArgument, codes.FailedPrecondition:
+			http.Error(w, "Não é possível abrir este ficheiro como arquivo: "+st.Message(), http.StatusBadRequest)
+		case codes.DeadlineExceeded:
+			http.Error(w, "Pedido expirou", http.StatusGatewayTimeout)
+		default:
+			log.Printf("Erro ao transferir ficheiro %s: %v", fileName, err)
 			http.Error(w, "Erro ao transferir ficheiro", http.StatusInternalServerError)
-			return
 		}
+		return
+	}
+
+	contentType, disposition := first.ContentType, "attachment"
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if viewType, ok := inlineContentType(contentType); inline && ok {
+		contentType, disposition = viewType, "inline"
+		if viewType == "application/pdf" {
+			w.Header().Set("Content-Security-Policy", viewPDFCSP)
+		} else {
+			w.Header().Set("Content-Security-Policy", viewCSP)
+		}
+		w.Header().Set("Referrer-Policy", "no-referrer")
+	}
+	downloadName := paths.Base(fileName)
+	if entry != "" {
+		downloadName = path.Base(entry)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, downloadName))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Vary", "Accept-Encoding")
+	if first.ExpiresAt > 0 {
+		w.Header().Set("X-Expires-At", formatExpiry(first.ExpiresAt))
+	}
+	if len(first.EncryptedMetadata) > 0 {
+		w.Header().Set(e2e.MetadataHeader, base64.RawURLEncoding.EncodeToString(first.EncryptedMetadata))
+	}
+	if first.ContentEncoding != "" {
+		// Stored compressed data is passed through as is.
+		w.Header().Set("Content-Encoding", first.ContentEncoding)
+	}
+	if first.Length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(first.Length, 10))
+	}
+	if ranged && first.Size > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first.Offset, first.Offset+first.Length-1, first.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
 
-		if len(res.ChunkData) > 0 {
-			if _, err := w.Write(res.ChunkData); err != nil {
-				log.Printf("Erro ao escrever chunk %d do ficheiro %s: %v", chunkIndex, fileName, err)
+	flusher, _ := w.(http.Flusher)
+	msg := first
+	for {
+		if len(msg.Data) > 0 {
+			if _, err := w.Write(msg.Data); err != nil {
+				log.Printf("Erro ao escrever ficheiro %s: %v", fileName, err)
 				return
 			}
 			if flusher != nil {
@@ -307,11 +727,15 @@ func handlePublicDownload(w http.ResponseWriter, r *http.Request, client filesha
 			}
 		}
 
-		if res.IsLastChunk {
-			break
+		msg, err = stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// Headers are already out, so all we can do is cut the body short.
+			log.Printf("Erro ao transferir ficheiro %s: %v", fileName, err)
+			return
 		}
-
-		chunkIndex++
 	}
 }
 
@@ -332,6 +756,23 @@ func serveUnifiedPage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, filePath)
 }
 
+// serveE2EPage serves the page that decrypts an end-to-end encrypted share in
+// the browser. The key is in the fragment, so the page is the same for every
+// file.
+func serveE2EPage(w http.ResponseWriter, r *http.Request) {
+	filePath := filepath.Join(".", "static", "e2e.html")
+
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		log.Printf("File not found: %s", filePath)
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	http.ServeFile(w, r, filePath)
+}
+
 func serveLoginPage(w http.ResponseWriter, r *http.Request) {
 	staticDir := filepath.Join(".", "static")
 	filePath := filepath.Join(staticDir, "login.html")
@@ -364,6 +805,11 @@ func parseBoolEnv(key string, defaultVal bool) bool {
 	return parsed
 }
 
+func parseBool(val string) bool {
+	parsed, err := strconv.ParseBool(strings.TrimSpace(val))
+	return err == nil && parsed
+}
+
 func validateCredentials(inputUser, inputPass, expectedUser, expectedPass string) bool {
 	userOK := subtle.ConstantTimeCompare([]byte(inputUser), []byte(expectedUser)) == 1
 	passOK := subtle.ConstantTimeCompare([]byte(inputPass), []byte(expectedPass)) == 1
@@ -390,37 +836,46 @@ func generateSessionToken(username string, secret []byte, duration time.Duration
 }
 
 func verifySessionToken(token string, secret []byte) bool {
+	_, ok := parseSessionToken(token, secret)
+	return ok
+}
+
+// parseSessionToken verifies token and returns the username it was issued to.
+func parseSessionToken(token string, secret []byte) (string, bool) {
 	parts := strings.SplitN(token, ".", 2)
 	if len(parts) != 2 {
-		return false
+		return "", false
 	}
 	payload, sigEncoded := parts[0], parts[1]
 
 	sig, err := base64.RawURLEncoding.DecodeString(sigEncoded)
 	if err != nil {
-		return false
+		return "", false
 	}
 
 	mac := hmac.New(sha256.New, secret)
 	if _, err := mac.Write([]byte(payload)); err != nil {
-		return false
+		return "", false
 	}
 	expectedSig := mac.Sum(nil)
 	if subtle.ConstantTimeCompare(expectedSig, sig) != 1 {
-		return false
+		return "", false
 	}
 
 	payloadParts := strings.SplitN(payload, "|", 3)
 	if len(payloadParts) != 3 {
-		return false
+		return "", false
 	}
 
 	expiryUnix, err := strconv.ParseInt(payloadParts[1], 10, 64)
 	if err != nil {
-		return false
+		return "", false
 	}
 
-	return time.Now().Unix() <= expiryUnix
+	if time.Now().Unix() > expiryUnix {
+		return "", false
+	}
+	return payloadParts[0], true
 }
 
 func setAuthCookie(w http.ResponseWriter, cookieName, token string, secure bool, duration time.Duration) {
@@ -437,17 +892,58 @@ func setAuthCookie(w http.ResponseWriter, cookieName, token string, secure bool,
 }
 
 func isAuthenticated(r *http.Request, cookieName string, secret []byte) bool {
+	_, ok := sessionUser(r, cookieName, secret)
+	return ok
+}
+
+func sessionUser(r *http.Request, cookieName string, secret []byte) (string, bool) {
 	c, err := r.Cookie(cookieName)
 	if err != nil {
-		return false
+		return "", false
+	}
+	return parseSessionToken(c.Value, secret)
+}
+
+type userContextKey struct{}
+
+// requestUser returns the authenticated username stored by authMiddleware.
+func requestUser(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey{}).(string)
+	return user
+}
+
+// parseAdmins reads ADMIN_USERS, a comma-separated list of usernames. The
+// configured AUTH_USERNAME is the admin when it isn't set.
+func parseAdmins(val, fallback string) map[string]bool {
+	admins := map[string]bool{}
+	for _, user := range strings.Split(val, ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			admins[user] = true
+		}
+	}
+	if len(admins) == 0 {
+		admins[fallback] = true
+	}
+	return admins
+}
+
+// adminOnly rejects requests from users that aren't admins. It runs inside
+// authMiddleware, which sets the user.
+func adminOnly(admins map[string]bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !admins[requestUser(r)] {
+			http.Error(w, "Acesso reservado a administradores", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
 	}
-	return verifySessionToken(c.Value, secret)
 }
 
 func authMiddleware(cookieName string, secret []byte, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if isAuthenticated(r, cookieName, secret) {
-			next.ServeHTTP(w, r)
+		if user, ok := sessionUser(r, cookieName, secret); ok {
+			ctx := context.WithValue(r.Context(), userContextKey{}, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
 		// Redirect unauthenticated users to login
@@ -532,6 +1028,9 @@ func main() {
 		log.Println("WARNING: secure cookies are disabled; enable COOKIE_SECURE=true when serving over HTTPS")
 	}
 	secretBytes := []byte(authSecret)
+	admins := parseAdmins(getEnv("ADMIN_USERS", ""), authUser)
+
+	urlPolicy := loadURLPolicy()
 
 	// Configure HTTP routes
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -539,11 +1038,28 @@ func main() {
 	})
 
 	http.HandleFunc("/short", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
-		askForShortURL(w, r, shortenerClient)
+		askForShortURL(w, r, shortenerClient, urlPolicy)
+	}))
+
+	http.HandleFunc("/short-batch", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleShortBatch(w, r, shortenerClient, urlPolicy)
+	}))
+
+	// Export and import cover every user's links, so they are for admins.
+	http.HandleFunc("/links/export", authMiddleware(sessionCookieName, secretBytes, adminOnly(admins, func(w http.ResponseWriter, r *http.Request) {
+		handleExportLinks(w, r, shortenerClient)
+	})))
+
+	http.HandleFunc("/links/import", authMiddleware(sessionCookieName, secretBytes, adminOnly(admins, func(w http.ResponseWriter, r *http.Request) {
+		handleImportLinks(w, r, shortenerClient, urlPolicy)
+	})))
+
+	http.HandleFunc("/links", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleListLinks(w, r, shortenerClient)
 	}))
 
 	http.HandleFunc("/geturl", func(w http.ResponseWriter, r *http.Request) {
-		getMainUrl(w, r, shortenerClient)
+		getMainUrl(w, r, shortenerClient, urlPolicy)
 	})
 
 	http.HandleFunc("/upload", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
@@ -551,23 +1067,71 @@ func main() {
 			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 			return
 		}
-		handleUploadFile(w, r, filesharingClient)
+		handleUploadFile(w, r, filesharingClient, admins[requestUser(r)])
 	}))
 
 	http.HandleFunc("/upload-chunk", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
-			handleUploadChuck(w, r, filesharingClient)
+			handleUploadChuck(w, r, filesharingClient, false)
 			return
 		}
 		if r.Method != http.MethodPost {
 			http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
 			return
 		}
-		handleUploadChuck(w, r, filesharingClient)
+		handleUploadChuck(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	http.HandleFunc("/upload-complete", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleCompleteUpload(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	http.HandleFunc("/upload-abort", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleAbortUpload(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	http.HandleFunc("/admin/rotate-keys", authMiddleware(sessionCookieName, secretBytes, adminOnly(admins, func(w http.ResponseWriter, r *http.Request) {
+		handleRotateKeys(w, r, filesharingClient)
+	})))
+
+	http.HandleFunc("/admin/status", authMiddleware(sessionCookieName, secretBytes, adminOnly(admins, func(w http.ResponseWriter, r *http.Request) {
+		handleJobStatus(w, r, filesharingClient)
+	})))
+
+	http.HandleFunc("/files", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleListFiles(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	http.HandleFunc("/files/", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleFile(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	http.HandleFunc("/folders", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleFolder(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	http.HandleFunc("/folders/", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleFolder(w, r, filesharingClient, admins[requestUser(r)])
+	}))
+
+	tusHandler := authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleTus(w, r, filesharingClient, admins[requestUser(r)])
+	})
+	http.HandleFunc("/tus", tusHandler)
+	http.HandleFunc("/tus/", tusHandler)
+
+	importHandler := authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleImport(w, r, filesharingClient, admins[requestUser(r)])
+	})
+	http.HandleFunc("/import", importHandler)
+	http.HandleFunc("/import/", importHandler)
+
+	http.HandleFunc("/archive", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+		handleArchive(w, r, filesharingClient, admins[requestUser(r)])
 	}))
 
 	http.HandleFunc("/get-storage-info", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
-		handleGetStorageInfo(w, r, filesharingClient)
+		handleGetStorageInfo(w, r, filesharingClient, admins[requestUser(r)])
 	}))
 
 	http.HandleFunc("/get-chunk", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
@@ -582,12 +1146,32 @@ func main() {
 		serveUnifiedPage(w, r)
 	}))
 
+	// Public QR codes for short links and download links
+	http.HandleFunc("/qr", handleQRCode)
+
 	// Public route for direct file downloads
 	http.HandleFunc("/download/", func(w http.ResponseWriter, r *http.Request) {
-		handlePublicDownload(w, r, filesharingClient)
+		handlePublicDownload(w, r, filesharingClient, false)
+	})
+
+	// Public route for showing files in the browser
+	http.HandleFunc("/view/", func(w http.ResponseWriter, r *http.Request) {
+		handlePublicDownload(w, r, filesharingClient, true)
+	})
+
+	// Public route for image thumbnails
+	http.HandleFunc("/thumb/", func(w http.ResponseWriter, r *http.Request) {
+		handleThumbnail(w, r, filesharingClient)
+	})
+
+	// Public route for folder share links
+	http.HandleFunc("/shared/", func(w http.ResponseWriter, r *http.Request) {
+		handleShared(w, r, filesharingClient)
 	})
 
-	http.HandleFunc("/streamsaver/mitm.html", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+	// StreamSaver is also used by the public page for encrypted shares, so its
+	// files are served without a session.
+	http.HandleFunc("/streamsaver/mitm.html", func(w http.ResponseWriter, r *http.Request) {
 		staticDir := filepath.Join(".", "static")
 		filePath := filepath.Join(staticDir, "mitm.html")
 
@@ -599,9 +1183,9 @@ func main() {
 
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		http.ServeFile(w, r, filePath)
-	}))
+	})
 
-	http.HandleFunc("/streamsaver/sw.js", authMiddleware(sessionCookieName, secretBytes, func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("/streamsaver/sw.js", func(w http.ResponseWriter, r *http.Request) {
 		staticDir := filepath.Join(".", "static")
 		filePath := filepath.Join(staticDir, "sw.js")
 
@@ -613,13 +1197,41 @@ func main() {
 
 		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
 		http.ServeFile(w, r, filePath)
-	}))
+	})
+
+	http.HandleFunc("/e2e/", func(w http.ResponseWriter, r *http.Request) {
+		serveE2EPage(w, r)
+	})
+
+	http.HandleFunc("/e2e.js", func(w http.ResponseWriter, r *http.Request) {
+		filePath := filepath.Join(".", "static", "e2e.js")
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		http.ServeFile(w, r, filePath)
+	})
 
 	// Login route (unprotected)
 	http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		handleLogin(w, r, authUser, authPass, sessionCookieName, secretBytes, cookieSecure, sessionDuration)
 	})
 
+	// The S3 API listens on its own port, and only when there are API tokens
+	// to sign requests with. It gets every path as is: object keys aren't
+	// cleaned the way ServeMux cleans paths.
+	apiTokens, err := parseAPITokens(getEnv("API_TOKENS", ""))
+	if err != nil {
+		log.Fatalf("Invalid API_TOKENS: %v", err)
+	}
+	if len(apiTokens) > 0 {
+		s3Port := getEnv("S3_PORT", "8513")
+		s3Handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handleS3(w, r, filesharingClient, apiTokens, admins)
+		})
+		go func() {
+			log.Printf("S3 API server starting on port %s (%d API tokens)...", s3Port, len(apiTokens))
+			log.Fatal(http.ListenAndServe(":"+s3Port, s3Handler))
+		}()
+	}
+
 	port := os.Getenv("GATEWAY_PORT")
 	if port == "" {
 		port = "8080"
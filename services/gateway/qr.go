@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 2048
+)
+
+var qrLevels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// qrTarget works out which of our own URLs the QR code should point at.
+// Only short links and download links can be encoded, so the endpoint can't
+// be used to mint codes for arbitrary third-party addresses.
+func qrTarget(r *http.Request) (string, error) {
+	q := r.URL.Query()
+	baseURL := requestBaseURL(r)
+
+	if id := q.Get("uuid"); id != "" {
+		if len(id) > 128 || strings.ContainsAny(id, "/?#&% ") {
+			return "", fmt.Errorf("uuid inválida")
+		}
+		return baseURL + "/geturl?uuid=" + url.QueryEscape(id), nil
+	}
+	if name := q.Get("file"); name != "" {
+		return baseURL + "/download/" + escapePath(name), nil
+	}
+	return "", fmt.Errorf("uuid ou file não fornecido")
+}
+
+// renderQRSVG draws the module matrix as one path, one horizontal run per
+// subpath, which keeps the SVG small and crisp at any scale.
+func renderQRSVG(code *qrcode.QRCode, size int) []byte {
+	bitmap := code.Bitmap()
+	modules := len(bitmap)
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	var svg strings.Builder
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, modules, modules)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="#fff"/>`, modules, modules)
+	fmt.Fprintf(&svg, `<path fill="#000" d="%s"/>`, path.String())
+	svg.WriteString(`</svg>`)
+	return []byte(svg.String())
+}
+
+func handleQRCode(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+
+	target, err := qrTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		http.Error(w, "Formato inválido (png ou svg)", http.StatusBadRequest)
+		return
+	}
+
+	size := defaultQRSize
+	if val := q.Get("size"); val != "" {
+		size, err = strconv.Atoi(val)
+		if err != nil || size < minQRSize || size > maxQRSize {
+			http.Error(w, fmt.Sprintf("Tamanho inválido (%d-%d)", minQRSize, maxQRSize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	levelName := strings.ToUpper(q.Get("level"))
+	if levelName == "" {
+		levelName = "M"
+	}
+	level, ok := qrLevels[levelName]
+	if !ok {
+		http.Error(w, "Nível de correção inválido (L, M, Q ou H)", http.StatusBadRequest)
+		return
+	}
+
+	// The image is a pure function of these inputs, so they make a strong ETag.
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s", target, format, size, levelName)))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400, immutable")
+	if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	code, err := qrcode.New(target, level)
+	if err != nil {
+		log.Printf("Error generating QR code for %s: %v", target, err)
+		http.Error(w, "Erro ao gerar QR code", http.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		body = renderQRSVG(code, size)
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+		body, err = code.PNG(size)
+		if err != nil {
+			log.Printf("Error encoding QR code PNG: %v", err)
+			http.Error(w, "Erro ao gerar QR code", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(body)
+}
@@ -0,0 +1,1276 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/paths"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The S3 API serves a subset of the S3 REST API, path-style, on its own
+// port: buckets are top-level folders and object keys are file names
+// inside them. Every request goes through the filesharing service as the
+// user of the API token that signed it, so folder access, ownership and
+// expiry work as they do for the web app.
+const (
+	s3Namespace  = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat = "2006-01-02T15:04:05.000Z"
+	// Bodies up to s3MaxSingleUpload go to UploadFile in one message;
+	// larger ones are sent as a one-part multipart upload.
+	s3MaxSingleUpload = 30 * 1024 * 1024
+	// s3FrameSize is how much of a part goes in each UploadPart message.
+	s3FrameSize     = 1024 * 1024
+	s3MaxKeys       = 1000
+	s3MaxDeleteKeys = 1000
+	s3MaxXMLBody    = 1024 * 1024
+	// skipPrefix sorts after every name that starts with a given prefix.
+	skipPrefix = "\U0010FFFF"
+)
+
+// s3Error is an S3 error response.
+type s3Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *s3Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func newS3Error(statusCode int, code, message string) *s3Error {
+	return &s3Error{Status: statusCode, Code: code, Message: message}
+}
+
+var (
+	errS3AccessDenied          = newS3Error(http.StatusForbidden, "AccessDenied", "Access Denied")
+	errS3InvalidAccessKeyID    = newS3Error(http.StatusForbidden, "InvalidAccessKeyId", "The access key ID you provided does not exist in our records.")
+	errS3SignatureMismatch     = newS3Error(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	errS3Expired               = newS3Error(http.StatusForbidden, "AccessDenied", "Request has expired")
+	errS3TimeSkewed            = newS3Error(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large.")
+	errS3MissingContentLength  = newS3Error(http.StatusLengthRequired, "MissingContentLength", "You must provide the Content-Length HTTP header.")
+	errS3InvalidDigest         = newS3Error(http.StatusBadRequest, "InvalidDigest", "The Content-MD5 you specified is not valid.")
+	errS3BadDigest             = newS3Error(http.StatusBadRequest, "BadDigest", "The checksum you specified did not match what we received.")
+	errS3IncompleteBody        = newS3Error(http.StatusBadRequest, "IncompleteBody", "The body does not match the length or framing the request declared.")
+	errS3ContentSHA256Mismatch = newS3Error(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+	errS3NoSuchBucket          = newS3Error(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	errS3NoSuchKey             = newS3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+	errS3NoSuchUpload          = newS3Error(http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+	errS3InvalidBucketName     = newS3Error(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	errS3BucketNotEmpty        = newS3Error(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+	errS3PreconditionFailed    = newS3Error(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	errS3InvalidRange          = newS3Error(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+	errS3MalformedXML          = newS3Error(http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+	errS3MethodNotAllowed      = newS3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	errS3NotImplemented        = newS3Error(http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+	errS3InternalError         = newS3Error(http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+)
+
+func errS3AuthorizationMalformed(message string) *s3Error {
+	return newS3Error(http.StatusBadRequest, "AuthorizationHeaderMalformed", message)
+}
+
+func errS3InvalidRequest(message string) *s3Error {
+	return newS3Error(http.StatusBadRequest, "InvalidRequest", message)
+}
+
+func errS3InvalidArgument(message string) *s3Error {
+	return newS3Error(http.StatusBadRequest, "InvalidArgument", message)
+}
+
+// s3ErrorFrom maps an error of the filesharing service to an S3 error;
+// notFound is what NOT_FOUND means for the operation.
+func s3ErrorFrom(err error, notFound *s3Error, action string) *s3Error {
+	var s3err *s3Error
+	if errors.As(err, &s3err) {
+		return s3err
+	}
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.NotFound:
+		return notFound
+	case codes.PermissionDenied:
+		return errS3AccessDenied
+	case codes.InvalidArgument:
+		return errS3InvalidArgument(st.Message())
+	case codes.FailedPrecondition:
+		return errS3InvalidRequest(st.Message())
+	case codes.AlreadyExists:
+		return newS3Error(http.StatusConflict, "OperationAborted", st.Message())
+	case codes.Aborted:
+		return errS3PreconditionFailed
+	case codes.OutOfRange:
+		return newS3Error(http.StatusBadRequest, "EntityTooLarge", st.Message())
+	}
+	log.Printf("S3 API: error %s: %v", action, err)
+	return errS3InternalError
+}
+
+type s3ErrorBody struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, e *s3Error) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.Status)
+		return
+	}
+	writeS3XML(w, e.Status, s3ErrorBody{
+		Code:      e.Code,
+		Message:   e.Message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+func writeS3XML(w http.ResponseWriter, statusCode int, body any) {
+	out, err := xml.Marshal(body)
+	if err != nil {
+		log.Printf("S3 API: error encoding response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(out)))
+	w.WriteHeader(statusCode)
+	io.WriteString(w, xml.Header)
+	w.Write(out)
+}
+
+// readS3XML decodes the XML body of a request, which was signed like any
+// other payload.
+func readS3XML(r *http.Request, signed *signedRequest, v any) *s3Error {
+	payload, _, s3err := payloadReader(r, signed)
+	if s3err != nil {
+		return s3err
+	}
+	body, err := io.ReadAll(io.LimitReader(payload, s3MaxXMLBody+1))
+	if err != nil {
+		return s3ErrorFrom(err, errS3MalformedXML, "reading request body")
+	}
+	if len(body) > s3MaxXMLBody || xml.Unmarshal(body, v) != nil {
+		return errS3MalformedXML
+	}
+	return nil
+}
+
+// discardS3Body reads a body the operation has no use for, which still has
+// to match its signature and checksums. Only small bodies are accepted.
+func discardS3Body(r *http.Request, signed *signedRequest) *s3Error {
+	payload, _, s3err := payloadReader(r, signed)
+	if s3err != nil {
+		return s3err
+	}
+	n, err := io.Copy(io.Discard, io.LimitReader(payload, s3MaxXMLBody+1))
+	if err != nil {
+		return s3ErrorFrom(err, errS3IncompleteBody, "reading request body")
+	}
+	if n > s3MaxXMLBody {
+		return errS3InvalidRequest("The request body is too large for this operation")
+	}
+	return nil
+}
+
+// s3ETag quotes the ETag of a file for S3 clients. KubeFile ETags look
+// like MD5 sums but aren't, and the "-1" suffix S3 gives multipart uploads
+// keeps clients from checking downloads against them.
+func s3ETag(etag string) string {
+	return `"` + etag + `-1"`
+}
+
+// parseS3ETags is parseETags for the ETags S3 clients send back.
+func parseS3ETags(val string) string {
+	etags := strings.Split(parseETags(val), ",")
+	for i, etag := range etags {
+		etags[i] = strings.TrimSuffix(etag, "-1")
+	}
+	return strings.Join(etags, ",")
+}
+
+// checkS3Preconditions evaluates the conditional headers of a GET or HEAD.
+// It returns the status to answer with instead, or 0.
+func checkS3Preconditions(r *http.Request, etag string, modified time.Time) int {
+	matches := func(header string) bool {
+		for _, want := range strings.Split(parseS3ETags(header), ",") {
+			if want == "*" || want == etag {
+				return true
+			}
+		}
+		return false
+	}
+	modified = modified.Truncate(time.Second)
+	if val := r.Header.Get("If-Match"); val != "" {
+		if !matches(val) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && modified.After(since) {
+		return http.StatusPreconditionFailed
+	}
+	if val := r.Header.Get("If-None-Match"); val != "" {
+		if matches(val) {
+			return http.StatusNotModified
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !modified.After(since) {
+		return http.StatusNotModified
+	}
+	return 0
+}
+
+// s3Request is an authenticated S3 API request.
+type s3Request struct {
+	User    string
+	IsAdmin bool
+	Bucket  string
+	Key     string
+	signed  *signedRequest
+}
+
+// s3Unsupported are the subresources the S3 API doesn't have.
+var s3Unsupported = []string{
+	"accelerate", "acl", "analytics", "attributes", "cors", "encryption", "intelligent-tiering",
+	"inventory", "legal-hold", "lifecycle", "logging", "metrics", "notification", "object-lock",
+	"ownershipControls", "policy", "policyStatus", "publicAccessBlock", "replication",
+	"requestPayment", "restore", "retention", "select", "tagging", "torrent", "versionId",
+	"versioning", "versions", "website",
+}
+
+// handleS3 serves the S3 API.
+func handleS3(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, tokens map[string]apiToken, admins map[string]bool) {
+	w.Header().Set("X-Amz-Request-Id", newS3RequestID())
+	w.Header().Set("Server", "KubeFile")
+
+	signed, s3err := verifySigV4(r, tokens, time.Now())
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	query := r.URL.Query()
+	for _, name := range s3Unsupported {
+		if query.Has(name) {
+			writeS3Error(w, r, errS3NotImplemented)
+			return
+		}
+	}
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		writeS3Error(w, r, errS3NotImplemented)
+		return
+	}
+
+	req := &s3Request{User: signed.User, IsAdmin: admins[signed.User], signed: signed}
+	req.Bucket, req.Key, _ = strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if req.Bucket == "" {
+		if r.Method != http.MethodGet {
+			writeS3Error(w, r, errS3MethodNotAllowed)
+			return
+		}
+		s3ListBuckets(w, r, client, req)
+		return
+	}
+	if cleaned, err := paths.Clean(req.Bucket); err != nil || cleaned != req.Bucket {
+		writeS3Error(w, r, errS3InvalidBucketName)
+		return
+	}
+
+	if req.Key == "" {
+		switch {
+		case r.Method == http.MethodGet && query.Has("location"):
+			s3GetBucketLocation(w, r, client, req)
+		case r.Method == http.MethodGet && query.Has("uploads"):
+			writeS3Error(w, r, errS3NotImplemented)
+		case r.Method == http.MethodGet:
+			s3ListObjects(w, r, client, req)
+		case r.Method == http.MethodHead:
+			if s3err := checkBucket(r.Context(), client, req); s3err != nil {
+				writeS3Error(w, r, s3err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case r.Method == http.MethodPut:
+			s3CreateBucket(w, r, client, req)
+		case r.Method == http.MethodDelete:
+			s3DeleteBucket(w, r, client, req)
+		case r.Method == http.MethodPost && query.Has("delete"):
+			s3DeleteObjects(w, r, client, req)
+		default:
+			writeS3Error(w, r, errS3MethodNotAllowed)
+		}
+		return
+	}
+
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPut && strings.HasSuffix(req.Key, "/") && uploadID == "":
+		s3PutFolder(w, r, client, req)
+	case r.Method == http.MethodDelete && strings.HasSuffix(req.Key, "/") && uploadID == "":
+		s3DeleteFolder(w, r, client, req)
+	case r.Method == http.MethodGet && uploadID != "":
+		writeS3Error(w, r, errS3NotImplemented) // ListParts
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s3GetObject(w, r, client, req)
+	case r.Method == http.MethodPut && uploadID != "":
+		s3UploadPart(w, r, client, req, uploadID)
+	case r.Method == http.MethodPut:
+		s3PutObject(w, r, client, req)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s3CreateMultipartUpload(w, r, client, req)
+	case r.Method == http.MethodPost && uploadID != "":
+		s3CompleteMultipartUpload(w, r, client, req, uploadID)
+	case r.Method == http.MethodDelete && uploadID != "":
+		s3AbortMultipartUpload(w, r, client, req, uploadID)
+	case r.Method == http.MethodDelete:
+		s3DeleteObject(w, r, client, req)
+	default:
+		writeS3Error(w, r, errS3MethodNotAllowed)
+	}
+}
+
+func newS3RequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return strings.ToUpper(hex.EncodeToString(id))
+}
+
+// objectName returns the file name of the object req is about. Keys that
+// aren't clean KubeFile names ("a//b", "./a", "a/../b") are rejected
+// rather than quietly stored under another name.
+func objectName(req *s3Request) (string, *s3Error) {
+	name := req.Bucket + "/" + req.Key
+	if cleaned, err := paths.Clean(name); err != nil || cleaned != name {
+		return "", errS3InvalidArgument("Object key " + strconv.Quote(req.Key) + " is not a valid KubeFile file name")
+	}
+	return name, nil
+}
+
+// checkBucket checks that the bucket of req exists and its user can read
+// it.
+func checkBucket(ctx context.Context, client filesharing.FileUploadClient, req *s3Request) *s3Error {
+	_, err := client.ListFolder(ctx, &filesharing.ListFolderRequest{
+		Path:    req.Bucket,
+		User:    req.User,
+		IsAdmin: req.IsAdmin,
+		Count:   1,
+	})
+	if err != nil {
+		return s3ErrorFrom(err, errS3NoSuchBucket, "checking bucket "+req.Bucket)
+	}
+	return nil
+}
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+// s3ListBuckets lists the top-level folders the user can read.
+func s3ListBuckets(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	res, err := client.ListFolder(r.Context(), &filesharing.ListFolderRequest{
+		User:    req.User,
+		IsAdmin: req.IsAdmin,
+		Count:   1,
+	})
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3InternalError, "listing buckets"))
+		return
+	}
+	result := listAllMyBucketsResult{Xmlns: s3Namespace, Owner: s3Owner{ID: req.User, DisplayName: req.User}}
+	for _, folder := range res.Folders {
+		result.Buckets = append(result.Buckets, s3Bucket{
+			Name:         folder.Path,
+			CreationDate: time.Unix(folder.Created, 0).UTC().Format(s3TimeFormat),
+		})
+	}
+	writeS3XML(w, http.StatusOK, result)
+}
+
+type locationConstraint struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:",chardata"`
+}
+
+// s3GetBucketLocation answers with the default region: KubeFile has one,
+// and requests signed for any region are accepted.
+func s3GetBucketLocation(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	if s3err := checkBucket(r.Context(), client, req); s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	writeS3XML(w, http.StatusOK, locationConstraint{Xmlns: s3Namespace})
+}
+
+func s3CreateBucket(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	// The body can only hold a CreateBucketConfiguration with a region.
+	if s3err := discardS3Body(r, req.signed); s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+
+	_, err := client.CreateFolder(r.Context(), &filesharing.CreateFolderRequest{
+		Path:    req.Bucket,
+		User:    req.User,
+		IsAdmin: req.IsAdmin,
+	})
+	if status.Code(err) == codes.AlreadyExists {
+		res, listErr := client.ListFolder(r.Context(), &filesharing.ListFolderRequest{Path: req.Bucket, User: req.User, IsAdmin: req.IsAdmin, Count: 1})
+		if listErr == nil && res.Folder != nil && res.Folder.Owner == req.User {
+			writeS3Error(w, r, newS3Error(http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it."))
+			return
+		}
+		writeS3Error(w, r, newS3Error(http.StatusConflict, "BucketAlreadyExists", "The requested bucket name is not available."))
+		return
+	}
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "creating bucket "+req.Bucket))
+		return
+	}
+	w.Header().Set("Location", "/"+req.Bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3DeleteBucket deletes a bucket without files. Folders left in it go
+// too: S3 tools have no way to delete the ones uploads made.
+func s3DeleteBucket(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	if s3err := checkBucket(r.Context(), client, req); s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	files, err := client.ListFiles(r.Context(), &filesharing.ListFilesRequest{Prefix: req.Bucket + "/", Count: 1})
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "listing bucket "+req.Bucket))
+		return
+	}
+	if len(files.Files) > 0 || files.NextCursor != "" {
+		writeS3Error(w, r, errS3BucketNotEmpty)
+		return
+	}
+	_, err = client.DeleteFolder(r.Context(), &filesharing.DeleteFolderRequest{
+		Path:      req.Bucket,
+		User:      req.User,
+		IsAdmin:   req.IsAdmin,
+		Recursive: true,
+	})
+	if status.Code(err) == codes.FailedPrecondition {
+		writeS3Error(w, r, errS3BucketNotEmpty)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "deleting bucket "+req.Bucket))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type s3Object struct {
+	Key          string   `xml:"Key"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+	Size         int64    `xml:"Size"`
+	StorageClass string   `xml:"StorageClass"`
+	Owner        *s3Owner `xml:"Owner,omitempty"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Marker                *string          `xml:"Marker,omitempty"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	KeyCount              *int             `xml:"KeyCount,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// s3ListObjects serves ListObjectsV2 and, without list-type=2, the older
+// ListObjects. Continuation tokens are the last key or common prefix
+// returned.
+func s3ListObjects(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	query := r.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	encodeURL := false
+	switch query.Get("encoding-type") {
+	case "":
+	case "url":
+		encodeURL = true
+	default:
+		writeS3Error(w, r, errS3InvalidArgument("Invalid Encoding Method specified in Request"))
+		return
+	}
+	maxKeys := s3MaxKeys
+	if val := query.Get("max-keys"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			writeS3Error(w, r, errS3InvalidArgument("max-keys must be a non-negative integer"))
+			return
+		}
+		maxKeys = min(n, s3MaxKeys)
+	}
+
+	result := listBucketResult{Xmlns: s3Namespace, Name: req.Bucket, MaxKeys: maxKeys}
+	var after string
+	if v2 {
+		after = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil || len(decoded) == 0 {
+				writeS3Error(w, r, errS3InvalidArgument("The continuation token provided is incorrect"))
+				return
+			}
+			after = string(decoded)
+			result.ContinuationToken = token
+		}
+	} else {
+		after = query.Get("marker")
+	}
+	if s3err := checkBucket(r.Context(), client, req); s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+
+	objects, prefixes, truncated, err := listObjects(r.Context(), client, req.Bucket, prefix, delimiter, after, maxKeys)
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "listing bucket "+req.Bucket))
+		return
+	}
+
+	encode := func(s string) string {
+		if encodeURL {
+			return awsURIEncode(s, false)
+		}
+		return s
+	}
+	last := ""
+	for _, info := range objects {
+		key := strings.TrimPrefix(info.Name, req.Bucket+"/")
+		etag := info.ETag
+		if etag == "" {
+			// Listings only know the ETag of files stored since it was
+			// kept with their manifest's metadata.
+			if res, err := client.StatFile(r.Context(), &filesharing.StatFileRequest{FileName: info.Name}); err == nil {
+				etag = res.File.ETag
+			}
+		}
+		object := s3Object{
+			Key:          encode(key),
+			LastModified: time.Unix(info.Created, 0).UTC().Format(s3TimeFormat),
+			ETag:         s3ETag(etag),
+			Size:         info.Size,
+			StorageClass: "STANDARD",
+		}
+		if (!v2 || parseBool(query.Get("fetch-owner"))) && info.Owner != "" {
+			object.Owner = &s3Owner{ID: info.Owner, DisplayName: info.Owner}
+		}
+		result.Contents = append(result.Contents, object)
+		last = max(last, key)
+	}
+	for _, cp := range prefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: encode(cp)})
+		last = max(last, cp)
+	}
+	result.Prefix, result.Delimiter, result.IsTruncated = encode(prefix), encode(delimiter), truncated
+	if encodeURL {
+		result.EncodingType = "url"
+	}
+	if v2 {
+		keyCount := len(objects) + len(prefixes)
+		result.KeyCount = &keyCount
+		result.StartAfter = encode(query.Get("start-after"))
+		if truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		marker := encode(after)
+		result.Marker = &marker
+		if truncated && delimiter != "" {
+			result.NextMarker = encode(last)
+		}
+	}
+	writeS3XML(w, http.StatusOK, result)
+}
+
+// listObjects lists up to maxKeys files of bucket whose key starts with
+// prefix, in key order after the key after. With delimiter set, keys with
+// it past the prefix are rolled up into common prefixes. An after that is
+// a common prefix skips the keys under it.
+func listObjects(ctx context.Context, client filesharing.FileUploadClient, bucket, prefix, delimiter, after string, maxKeys int) (objects []*filesharing.FileInfo, prefixes []string, truncated bool, err error) {
+	base := bucket + "/"
+	commonPrefix := func(key string) string {
+		if delimiter == "" || !strings.HasPrefix(key, prefix) {
+			return ""
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+			return key[:len(prefix)+i+len(delimiter)]
+		}
+		return ""
+	}
+	if after != "" && commonPrefix(after) == after {
+		after += skipPrefix
+	}
+	if after < prefix {
+		after = ""
+	}
+
+	cursor := ""
+	for {
+		req := &filesharing.ListFilesRequest{Prefix: base + prefix, Cursor: cursor, Count: 200}
+		if cursor == "" && after != "" {
+			req.StartAfter = base + after
+		}
+		res, err := client.ListFiles(ctx, req)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		current := ""
+		for _, info := range res.Files {
+			key := strings.TrimPrefix(info.Name, base)
+			if cp := commonPrefix(key); cp != "" {
+				if cp == current {
+					continue
+				}
+				if len(objects)+len(prefixes) == maxKeys {
+					return objects, prefixes, true, nil
+				}
+				prefixes = append(prefixes, cp)
+				current = cp
+				continue
+			}
+			if len(objects)+len(prefixes) == maxKeys {
+				return objects, prefixes, true, nil
+			}
+			objects = append(objects, info)
+		}
+		if res.NextCursor == "" {
+			return objects, prefixes, false, nil
+		}
+		// Start the next page past the keys under the last common prefix,
+		// which can be many; when the page had nothing to go on, the
+		// service stopped scanning and its cursor carries on.
+		switch {
+		case current != "" && strings.HasPrefix(strings.TrimPrefix(res.Files[len(res.Files)-1].Name, base), current):
+			after, cursor = current+skipPrefix, ""
+		case len(res.Files) > 0:
+			after, cursor = strings.TrimPrefix(res.Files[len(res.Files)-1].Name, base), ""
+		default:
+			cursor = res.NextCursor
+		}
+	}
+}
+
+// s3FileInfo is what GET and HEAD answer with.
+type s3FileInfo struct {
+	ETag        string
+	Modified    time.Time
+	ContentType string
+	ExpiresAt   int64
+}
+
+func setS3ObjectHeaders(w http.ResponseWriter, info s3FileInfo) {
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", s3ETag(info.ETag))
+	w.Header().Set("Last-Modified", info.Modified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if info.ExpiresAt > 0 {
+		expires := time.Unix(info.ExpiresAt, 0).UTC().Format(http.TimeFormat)
+		w.Header().Set("X-Amz-Expiration", fmt.Sprintf(`expiry-date="%s", rule-id="kubefile-expiry"`, expires))
+	}
+}
+
+// s3GetObject serves GetObject and HeadObject.
+func s3GetObject(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	name, s3err := objectName(req)
+	if s3err == nil {
+		s3err = checkBucket(r.Context(), client, req)
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		res, err := client.StatFile(r.Context(), &filesharing.StatFileRequest{FileName: name})
+		if err != nil {
+			writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchKey, "reading "+name))
+			return
+		}
+		info := s3FileInfo{
+			ETag:        res.File.ETag,
+			Modified:    time.Unix(res.File.Created, 0),
+			ContentType: res.File.ContentType,
+			ExpiresAt:   res.File.ExpiresAt,
+		}
+		if code := checkS3Preconditions(r, info.ETag, info.Modified); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		setS3ObjectHeaders(w, info)
+		w.Header().Set("Content-Length", strconv.FormatInt(res.File.Size, 10))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	offset, length, ranged := parseRange(r.Header.Get("Range"))
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream, err := client.DownloadFile(ctx, &filesharing.DownloadFileRequest{
+		FileName: name,
+		Offset:   offset,
+		Length:   length,
+	})
+	var first *filesharing.DownloadFileResponse
+	if err == nil {
+		first, err = stream.Recv()
+	}
+	if status.Code(err) == codes.OutOfRange {
+		writeS3Error(w, r, errS3InvalidRange)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchKey, "downloading "+name))
+		return
+	}
+	info := s3FileInfo{
+		ETag:        first.ETag,
+		Modified:    time.Unix(first.Modified, 0),
+		ContentType: first.ContentType,
+		ExpiresAt:   first.ExpiresAt,
+	}
+	if code := checkS3Preconditions(r, info.ETag, info.Modified); code == http.StatusPreconditionFailed {
+		writeS3Error(w, r, errS3PreconditionFailed)
+		return
+	} else if code != 0 {
+		w.WriteHeader(code)
+		return
+	}
+
+	setS3ObjectHeaders(w, info)
+	if first.Length >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(first.Length, 10))
+	}
+	if ranged && first.Size > 0 {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first.Offset, first.Offset+first.Length-1, first.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	msg := first
+	for {
+		if len(msg.Data) > 0 {
+			if _, err := w.Write(msg.Data); err != nil {
+				return
+			}
+		}
+		msg, err = stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// Headers are already out, so all we can do is cut the body short.
+			log.Printf("S3 API: error downloading %s: %v", name, err)
+			return
+		}
+	}
+}
+
+// s3UploadOptions reads what a PutObject or CreateMultipartUpload says
+// about the file besides its content: x-amz-meta-expires-in (seconds, or
+// "never" for admins) and x-amz-meta-tags (comma-separated).
+func s3UploadOptions(r *http.Request, req *s3Request) (expiresIn int64, keepForever bool, tags []string, s3err *s3Error) {
+	expiresIn, keepForever, ok := parseExpiry(r.Header.Get("X-Amz-Meta-Expires-In"))
+	if !ok {
+		return 0, false, nil, errS3InvalidArgument("x-amz-meta-expires-in must be a number of seconds or never")
+	}
+	if keepForever && !req.IsAdmin {
+		return 0, false, nil, errS3AccessDenied
+	}
+	return expiresIn, keepForever, parseTags(r.Header.Get("X-Amz-Meta-Tags")), nil
+}
+
+func s3PutObject(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	name, s3err := objectName(req)
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	expiresIn, keepForever, tags, s3err := s3UploadOptions(r, req)
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	payload, size, s3err := payloadReader(r, req.signed)
+	if s3err == nil && size < 0 {
+		s3err = errS3MissingContentLength
+	}
+	if s3err == nil {
+		s3err = checkBucket(r.Context(), client, req)
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	ifMatch, ifNoneMatch := parseS3ETags(r.Header.Get("If-Match")), parseS3ETags(r.Header.Get("If-None-Match"))
+
+	var etag string
+	if size <= s3MaxSingleUpload {
+		content, err := io.ReadAll(payload)
+		if err != nil {
+			writeS3Error(w, r, s3ErrorFrom(err, errS3IncompleteBody, "reading upload of "+name))
+			return
+		}
+		_, err = client.UploadFile(r.Context(), &filesharing.UploadFileRequest{
+			FileName:         name,
+			FileContent:      content,
+			Complete:         true,
+			ExpiresInSeconds: expiresIn,
+			KeepForever:      keepForever,
+			Owner:            req.User,
+			Tags:             tags,
+			IsAdmin:          req.IsAdmin,
+			IfMatch:          ifMatch,
+			IfNoneMatch:      ifNoneMatch,
+		})
+		if err != nil {
+			writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "uploading "+name))
+			return
+		}
+		res, err := client.StatFile(r.Context(), &filesharing.StatFileRequest{FileName: name})
+		if err != nil {
+			writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchKey, "reading "+name))
+			return
+		}
+		etag = res.File.ETag
+	} else {
+		// Too big for one message: upload it as a single streamed part.
+		start, err := client.StartMultipartUpload(r.Context(), &filesharing.StartMultipartUploadRequest{
+			FileName:         name,
+			Owner:            req.User,
+			IsAdmin:          req.IsAdmin,
+			ExpiresInSeconds: expiresIn,
+			KeepForever:      keepForever,
+			Tags:             tags,
+			IfMatch:          ifMatch,
+			IfNoneMatch:      ifNoneMatch,
+		})
+		if err != nil {
+			writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "uploading "+name))
+			return
+		}
+		etag, err = func() (string, error) {
+			if _, err := streamPart(r.Context(), client, req, name, start.UploadID, 1, payload); err != nil {
+				return "", err
+			}
+			res, err := client.CompleteMultipartUpload(r.Context(), &filesharing.CompleteMultipartUploadRequest{
+				FileName: name,
+				UploadID: start.UploadID,
+				Parts:    []int32{1},
+				User:     req.User,
+				IsAdmin:  req.IsAdmin,
+			})
+			if err != nil {
+				return "", err
+			}
+			return res.ETag, nil
+		}()
+		if err != nil {
+			// The request may be gone, but the upload still has to go.
+			if _, abortErr := client.AbortMultipartUpload(context.Background(), &filesharing.AbortMultipartUploadRequest{
+				FileName: name,
+				UploadID: start.UploadID,
+				User:     req.User,
+				IsAdmin:  req.IsAdmin,
+			}); abortErr != nil {
+				log.Printf("⚠️  Warning: could not abort upload of %s: %v", name, abortErr)
+			}
+			writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchUpload, "uploading "+name))
+			return
+		}
+	}
+	w.Header().Set("ETag", s3ETag(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// streamPart sends body as part n of a multipart upload. A body that fails
+// its checks cancels the stream, so the part is cut short rather than
+// completed; sending it again replaces it.
+func streamPart(ctx context.Context, client filesharing.FileUploadClient, req *s3Request, name, uploadID string, n int32, body io.Reader) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.UploadPart(ctx)
+	if err != nil {
+		return 0, err
+	}
+	msg := &filesharing.UploadPartRequest{
+		FileName:   name,
+		UploadID:   uploadID,
+		PartNumber: n,
+		User:       req.User,
+		IsAdmin:    req.IsAdmin,
+	}
+	buf := make([]byte, s3FrameSize)
+	sent := false
+	for {
+		read, err := io.ReadFull(body, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		if read > 0 || !sent {
+			msg.Data = buf[:read]
+			if sendErr := stream.Send(msg); sendErr != nil {
+				// The service gave up; CloseAndRecv says why.
+				if _, recvErr := stream.CloseAndRecv(); recvErr != nil {
+					return 0, recvErr
+				}
+				return 0, sendErr
+			}
+			msg = &filesharing.UploadPartRequest{}
+			sent = true
+		}
+		if err != nil {
+			break
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+	return res.Size, nil
+}
+
+// s3PutFolder creates the folder a zero-length key ending in "/" stands
+// for, which is how S3 tools make empty folders.
+func s3PutFolder(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	path := req.Bucket + "/" + strings.TrimSuffix(req.Key, "/")
+	if cleaned, err := paths.Clean(path); err != nil || cleaned != path {
+		writeS3Error(w, r, errS3InvalidArgument("Object key "+strconv.Quote(req.Key)+" is not a valid KubeFile folder name"))
+		return
+	}
+	size := r.ContentLength
+	if val := r.Header.Get("X-Amz-Decoded-Content-Length"); val != "" {
+		size, _ = strconv.ParseInt(val, 10, 64)
+	}
+	if size != 0 {
+		writeS3Error(w, r, errS3InvalidRequest("Keys ending in / are folders and can't have content"))
+		return
+	}
+	s3err := discardS3Body(r, req.signed)
+	if s3err == nil {
+		s3err = checkBucket(r.Context(), client, req)
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	_, err := client.CreateFolder(r.Context(), &filesharing.CreateFolderRequest{
+		Path:    path,
+		User:    req.User,
+		IsAdmin: req.IsAdmin,
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "creating folder "+path))
+		return
+	}
+	w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`) // MD5 of nothing
+	w.WriteHeader(http.StatusOK)
+}
+
+// s3DeleteFolder deletes the folder a key ending in "/" stands for, if it
+// is empty.
+func s3DeleteFolder(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	if s3err := checkBucket(r.Context(), client, req); s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	path := req.Bucket + "/" + strings.TrimSuffix(req.Key, "/")
+	_, err := client.DeleteFolder(r.Context(), &filesharing.DeleteFolderRequest{
+		Path:    path,
+		User:    req.User,
+		IsAdmin: req.IsAdmin,
+	})
+	if err != nil && status.Code(err) != codes.NotFound && status.Code(err) != codes.InvalidArgument {
+		writeS3Error(w, r, s3ErrorFrom(err, nil, "deleting folder "+path))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteObject deletes the file of key, as DeleteObject does: a key that
+// doesn't exist is not an error.
+func deleteObject(ctx context.Context, client filesharing.FileUploadClient, req *s3Request, key string) *s3Error {
+	name, s3err := objectName(&s3Request{Bucket: req.Bucket, Key: key})
+	if s3err != nil {
+		return s3err
+	}
+	_, err := client.DeleteFile(ctx, &filesharing.DeleteFileRequest{
+		FileName: name,
+		User:     req.User,
+		IsAdmin:  req.IsAdmin,
+	})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return s3ErrorFrom(err, nil, "deleting "+name)
+	}
+	return nil
+}
+
+func s3DeleteObject(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	s3err := checkBucket(r.Context(), client, req)
+	if s3err == nil {
+		s3err = deleteObject(r.Context(), client, req, req.Key)
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type deleteObjectsRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteObjectError struct {
+	Key     string `xml:"Key"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type deleteObjectsResult struct {
+	XMLName xml.Name            `xml:"DeleteResult"`
+	Xmlns   string              `xml:"xmlns,attr"`
+	Deleted []deletedObject     `xml:"Deleted"`
+	Errors  []deleteObjectError `xml:"Error"`
+}
+
+// s3DeleteObjects serves DeleteObjects, which S3 tools use to delete many
+// keys at once.
+func s3DeleteObjects(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	var body deleteObjectsRequest
+	s3err := readS3XML(r, req.signed, &body)
+	if s3err == nil && (len(body.Objects) == 0 || len(body.Objects) > s3MaxDeleteKeys) {
+		s3err = errS3MalformedXML
+	}
+	if s3err == nil {
+		s3err = checkBucket(r.Context(), client, req)
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+
+	result := deleteObjectsResult{Xmlns: s3Namespace}
+	for _, object := range body.Objects {
+		if s3err := deleteObject(r.Context(), client, req, object.Key); s3err != nil {
+			result.Errors = append(result.Errors, deleteObjectError{Key: object.Key, Code: s3err.Code, Message: s3err.Message})
+		} else if !body.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: object.Key})
+		}
+	}
+	writeS3XML(w, http.StatusOK, result)
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func s3CreateMultipartUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request) {
+	name, s3err := objectName(req)
+	if s3err == nil {
+		s3err = checkBucket(r.Context(), client, req)
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	expiresIn, keepForever, tags, s3err := s3UploadOptions(r, req)
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	res, err := client.StartMultipartUpload(r.Context(), &filesharing.StartMultipartUploadRequest{
+		FileName:         name,
+		Owner:            req.User,
+		IsAdmin:          req.IsAdmin,
+		ExpiresInSeconds: expiresIn,
+		KeepForever:      keepForever,
+		Tags:             tags,
+		IfMatch:          parseS3ETags(r.Header.Get("If-Match")),
+		IfNoneMatch:      parseS3ETags(r.Header.Get("If-None-Match")),
+	})
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchBucket, "starting upload of "+name))
+		return
+	}
+	writeS3XML(w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   req.Bucket,
+		Key:      req.Key,
+		UploadID: res.UploadID,
+	})
+}
+
+// s3UploadPart stores a part. Its ETag is the MD5 sum of its content, as
+// in S3; CompleteMultipartUpload doesn't check it.
+func s3UploadPart(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request, uploadID string) {
+	name, s3err := objectName(req)
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		writeS3Error(w, r, errS3InvalidArgument("Part number must be an integer between 1 and 10000, inclusive"))
+		return
+	}
+	payload, size, s3err := payloadReader(r, req.signed)
+	if s3err == nil && size < 0 {
+		s3err = errS3MissingContentLength
+	}
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	if _, err := streamPart(r.Context(), client, req, name, uploadID, int32(partNumber), payload); err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchUpload, "uploading part of "+name))
+		return
+	}
+	w.Header().Set("ETag", `"`+payload.MD5()+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+type completeMultipartUploadRequest struct {
+	Parts []struct {
+		PartNumber int32  `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func s3CompleteMultipartUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request, uploadID string) {
+	name, s3err := objectName(req)
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	var body completeMultipartUploadRequest
+	if s3err := readS3XML(r, req.signed, &body); s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	parts := make([]int32, 0, len(body.Parts))
+	for i, part := range body.Parts {
+		if i > 0 && part.PartNumber <= parts[i-1] {
+			writeS3Error(w, r, newS3Error(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order."))
+			return
+		}
+		parts = append(parts, part.PartNumber)
+	}
+	if len(parts) == 0 {
+		writeS3Error(w, r, errS3MalformedXML)
+		return
+	}
+
+	res, err := client.CompleteMultipartUpload(r.Context(), &filesharing.CompleteMultipartUploadRequest{
+		FileName: name,
+		UploadID: uploadID,
+		Parts:    parts,
+		User:     req.User,
+		IsAdmin:  req.IsAdmin,
+	})
+	if status.Code(err) == codes.InvalidArgument {
+		writeS3Error(w, r, newS3Error(http.StatusBadRequest, "InvalidPart", status.Convert(err).Message()))
+		return
+	}
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchUpload, "completing upload of "+name))
+		return
+	}
+	writeS3XML(w, http.StatusOK, completeMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Location: "/" + req.Bucket + "/" + awsURIEncode(req.Key, false),
+		Bucket:   req.Bucket,
+		Key:      req.Key,
+		ETag:     s3ETag(res.ETag),
+	})
+}
+
+func s3AbortMultipartUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, req *s3Request, uploadID string) {
+	name, s3err := objectName(req)
+	if s3err != nil {
+		writeS3Error(w, r, s3err)
+		return
+	}
+	_, err := client.AbortMultipartUpload(r.Context(), &filesharing.AbortMultipartUploadRequest{
+		FileName: name,
+		UploadID: uploadID,
+		User:     req.User,
+		IsAdmin:  req.IsAdmin,
+	})
+	if err != nil {
+		writeS3Error(w, r, s3ErrorFrom(err, errS3NoSuchUpload, "aborting upload of "+name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/paths"
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// fakeFilesharing keeps folders and whole files in memory and answers the
+// RPCs the S3 API uses for buckets and single-request objects.
+type fakeFilesharing struct {
+	filesharing.UnimplementedFileUploadServer
+
+	mu      sync.Mutex
+	folders map[string]*filesharing.FolderInfo
+	files   map[string]*fakeFile
+}
+
+type fakeFile struct {
+	info *filesharing.FileInfo
+	data []byte
+}
+
+func newFakeFilesharing() *fakeFilesharing {
+	return &fakeFilesharing{folders: map[string]*filesharing.FolderInfo{}, files: map[string]*fakeFile{}}
+}
+
+func (f *fakeFilesharing) CreateFolder(ctx context.Context, req *filesharing.CreateFolderRequest) (*filesharing.CreateFolderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.folders[req.Path]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "%s already exists", req.Path)
+	}
+	folder := &filesharing.FolderInfo{Path: req.Path, Owner: req.User, Created: time.Now().Unix()}
+	f.folders[req.Path] = folder
+	return &filesharing.CreateFolderResponse{Folder: folder}, nil
+}
+
+func (f *fakeFilesharing) DeleteFolder(ctx context.Context, req *filesharing.DeleteFolderRequest) (*filesharing.DeleteFolderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.folders[req.Path]; !ok {
+		return nil, status.Errorf(codes.NotFound, "folder %s does not exist", req.Path)
+	}
+	delete(f.folders, req.Path)
+	return &filesharing.DeleteFolderResponse{}, nil
+}
+
+func (f *fakeFilesharing) ListFolder(ctx context.Context, req *filesharing.ListFolderRequest) (*filesharing.ListFolderResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := &filesharing.ListFolderResponse{}
+	if req.Path != "" {
+		folder, ok := f.folders[req.Path]
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "folder %s does not exist", req.Path)
+		}
+		res.Folder = folder
+	}
+	for path, folder := range f.folders {
+		if paths.Dir(path) == req.Path {
+			res.Folders = append(res.Folders, folder)
+		}
+	}
+	sort.Slice(res.Folders, func(i, j int) bool { return res.Folders[i].Path < res.Folders[j].Path })
+	return res, nil
+}
+
+func (f *fakeFilesharing) UploadFile(ctx context.Context, req *filesharing.UploadFileRequest) (*filesharing.UploadFileResponse, error) {
+	if !req.Complete {
+		return nil, status.Error(codes.Unimplemented, "only whole files")
+	}
+	sum := md5.Sum(req.FileContent)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[req.FileName] = &fakeFile{
+		info: &filesharing.FileInfo{
+			Name:        req.FileName,
+			Size:        int64(len(req.FileContent)),
+			Created:     time.Now().Unix(),
+			Owner:       req.Owner,
+			ETag:        hex.EncodeToString(sum[:]),
+			ContentType: "application/octet-stream",
+		},
+		data: req.FileContent,
+	}
+	return &filesharing.UploadFileResponse{FileName: req.FileName}, nil
+}
+
+func (f *fakeFilesharing) file(name string) (*fakeFile, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.files[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "file %s does not exist", name)
+	}
+	return file, nil
+}
+
+func (f *fakeFilesharing) StatFile(ctx context.Context, req *filesharing.StatFileRequest) (*filesharing.StatFileResponse, error) {
+	file, err := f.file(req.FileName)
+	if err != nil {
+		return nil, err
+	}
+	return &filesharing.StatFileResponse{File: file.info}, nil
+}
+
+func (f *fakeFilesharing) DownloadFile(req *filesharing.DownloadFileRequest, stream filesharing.FileUpload_DownloadFileServer) error {
+	file, err := f.file(req.FileName)
+	if err != nil {
+		return err
+	}
+	size := int64(len(file.data))
+	offset, length := req.Offset, req.Length
+	if offset < 0 {
+		offset = max(size+offset, 0)
+	}
+	if offset > size {
+		return status.Error(codes.OutOfRange, "offset past the end")
+	}
+	if length <= 0 || offset+length > size {
+		length = size - offset
+	}
+	return stream.Send(&filesharing.DownloadFileResponse{
+		Data:        file.data[offset : offset+length],
+		Size:        size,
+		Offset:      offset,
+		Length:      length,
+		ContentType: file.info.ContentType,
+		ETag:        file.info.ETag,
+		Modified:    file.info.Created,
+	})
+}
+
+// ListFiles pages by name; the cursor is the last name of the page.
+func (f *fakeFilesharing) ListFiles(ctx context.Context, req *filesharing.ListFilesRequest) (*filesharing.ListFilesResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	after := req.StartAfter
+	if req.Cursor != "" {
+		after = req.Cursor
+	}
+	var names []string
+	for name := range f.files {
+		if strings.HasPrefix(name, req.Prefix) && name > after {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	res := &filesharing.ListFilesResponse{}
+	if req.Count > 0 && len(names) > int(req.Count) {
+		names = names[:req.Count]
+		res.NextCursor = names[len(names)-1]
+	}
+	for _, name := range names {
+		res.Files = append(res.Files, f.files[name].info)
+	}
+	return res, nil
+}
+
+func (f *fakeFilesharing) DeleteFile(ctx context.Context, req *filesharing.DeleteFileRequest) (*filesharing.DeleteFileResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.files[req.FileName]; !ok {
+		return nil, status.Errorf(codes.NotFound, "file %s does not exist", req.FileName)
+	}
+	delete(f.files, req.FileName)
+	return &filesharing.DeleteFileResponse{}, nil
+}
+
+// newS3TestServer serves the S3 API in front of a fake filesharing service,
+// for API token AKIDALICE of alice.
+func newS3TestServer(t *testing.T) (*httptest.Server, *fakeFilesharing) {
+	t.Helper()
+	fake := newFakeFilesharing()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	filesharing.RegisterFileUploadServer(grpcServer, fake)
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := filesharing.NewFileUploadClient(conn)
+	tokens := map[string]apiToken{"AKIDALICE": {User: "alice", Secret: "alice-secret"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleS3(w, r, client, tokens, map[string]bool{})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, fake
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	srv, fake := newS3TestServer(t)
+	mc, err := minio.New(strings.TrimPrefix(srv.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("AKIDALICE", "alice-secret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := mc.MakeBucket(ctx, "photos", minio.MakeBucketOptions{}); err != nil {
+		t.Fatalf("MakeBucket: %v", err)
+	}
+	content := bytes.Repeat([]byte("KubeFile "), 1000)
+	for _, key := range []string{"2024/a.jpg", "2024/b.jpg", "c.jpg"} {
+		if _, err := mc.PutObject(ctx, "photos", key, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{}); err != nil {
+			t.Fatalf("PutObject %s: %v", key, err)
+		}
+	}
+	if file, err := fake.file("photos/2024/a.jpg"); err != nil || file.info.Owner != "alice" || !bytes.Equal(file.data, content) {
+		t.Fatalf("stored file: %v, %v", file, err)
+	}
+
+	obj, err := mc.GetObject(ctx, "photos", "2024/a.jpg", minio.GetObjectOptions{})
+	if err != nil {
+		t.Fatalf("GetObject: %v", err)
+	}
+	got, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("GetObject read %d bytes, %v", len(got), err)
+	}
+
+	ranged := minio.GetObjectOptions{}
+	ranged.SetRange(9, 17)
+	obj, err = mc.GetObject(ctx, "photos", "c.jpg", ranged)
+	if err != nil {
+		t.Fatalf("ranged GetObject: %v", err)
+	}
+	got, err = io.ReadAll(obj)
+	obj.Close()
+	if err != nil || string(got) != "KubeFile " {
+		t.Fatalf("ranged GetObject read %q, %v", got, err)
+	}
+
+	list := func(opts minio.ListObjectsOptions) []string {
+		var keys []string
+		for info := range mc.ListObjects(ctx, "photos", opts) {
+			if info.Err != nil {
+				t.Fatalf("ListObjectsV2: %v", info.Err)
+			}
+			keys = append(keys, info.Key)
+		}
+		// Objects and common prefixes come in separate lists.
+		sort.Strings(keys)
+		return keys
+	}
+	if keys := list(minio.ListObjectsOptions{Recursive: true}); strings.Join(keys, ",") != "2024/a.jpg,2024/b.jpg,c.jpg" {
+		t.Errorf("recursive listing: %q", keys)
+	}
+	if keys := list(minio.ListObjectsOptions{}); strings.Join(keys, ",") != "2024/,c.jpg" {
+		t.Errorf("listing with delimiter: %q", keys)
+	}
+	if keys := list(minio.ListObjectsOptions{Recursive: true, MaxKeys: 1}); len(keys) != 3 {
+		t.Errorf("paged listing: %q", keys)
+	}
+
+	if err := mc.RemoveObject(ctx, "photos", "2024/a.jpg", minio.RemoveObjectOptions{}); err != nil {
+		t.Fatalf("RemoveObject: %v", err)
+	}
+	if _, err := mc.StatObject(ctx, "photos", "2024/a.jpg", minio.StatObjectOptions{}); minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		t.Errorf("StatObject of a deleted key: %v", err)
+	}
+	if keys := list(minio.ListObjectsOptions{Recursive: true}); strings.Join(keys, ",") != "2024/b.jpg,c.jpg" {
+		t.Errorf("listing after delete: %q", keys)
+	}
+}
+
+func TestS3RefusesBadSignature(t *testing.T) {
+	srv, _ := newS3TestServer(t)
+	mc, err := minio.New(strings.TrimPrefix(srv.URL, "http://"), &minio.Options{
+		Creds:  credentials.NewStaticV4("AKIDALICE", "wrong-secret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mc.MakeBucket(context.Background(), "photos", minio.MakeBucketOptions{})
+	if code := minio.ToErrorResponse(err).Code; code != "SignatureDoesNotMatch" {
+		t.Errorf("got %v (%s), want SignatureDoesNotMatch", err, code)
+	}
+}
@@ -0,0 +1,542 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// API tokens let programs use KubeFile without a browser session: each is
+// an access key ID and a secret that act as one user. The S3 API takes
+// requests signed with them, with AWS Signature Version 4.
+type apiToken struct {
+	User   string
+	Secret string
+}
+
+// parseAPITokens reads API_TOKENS, a comma-separated list of
+// user:accessKeyID:secret entries, into tokens by access key ID.
+func parseAPITokens(val string) (map[string]apiToken, error) {
+	tokens := map[string]apiToken{}
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		user, rest, _ := strings.Cut(entry, ":")
+		id, secret, _ := strings.Cut(rest, ":")
+		if user == "" || id == "" || secret == "" {
+			return nil, fmt.Errorf("API token of %q is not user:accessKeyID:secret", user)
+		}
+		if _, ok := tokens[id]; ok {
+			return nil, fmt.Errorf("access key ID %s is used twice", id)
+		}
+		tokens[id] = apiToken{User: user, Secret: secret}
+	}
+	return tokens, nil
+}
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+	// The x-amz-content-sha256 values that aren't a hash of the body.
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrailer  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// maxClockSkew is how far the time a request was signed can be from
+	// ours; presigned URLs can't be valid for longer than maxPresignExpiry.
+	maxClockSkew     = 15 * time.Minute
+	maxPresignExpiry = 7 * 24 * time.Hour
+	// maxSignedChunk bounds the chunks of streaming uploads, which are
+	// held in memory until their signature is checked.
+	maxSignedChunk = 16 * 1024 * 1024
+)
+
+// signedRequest is a request whose signature checked out.
+type signedRequest struct {
+	User string
+	// PayloadHash is the x-amz-content-sha256 the request was signed with.
+	PayloadHash string
+	key         []byte
+	amzDate     string
+	scope       string
+	signature   string
+}
+
+// verifySigV4 checks the signature of r, from its Authorization header or,
+// for presigned URLs, its query, and returns who signed it.
+func verifySigV4(r *http.Request, tokens map[string]apiToken, now time.Time) (*signedRequest, *s3Error) {
+	query := r.URL.Query()
+	var credential, signedHeaders, signature, amzDate, payloadHash string
+	var expires time.Duration
+	presigned := query.Has("X-Amz-Algorithm")
+	if presigned {
+		if query.Get("X-Amz-Algorithm") != sigV4Algorithm {
+			return nil, errS3AuthorizationMalformed("only " + sigV4Algorithm + " is supported")
+		}
+		credential = query.Get("X-Amz-Credential")
+		signedHeaders = query.Get("X-Amz-SignedHeaders")
+		signature = query.Get("X-Amz-Signature")
+		amzDate = query.Get("X-Amz-Date")
+		seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > maxPresignExpiry {
+			return nil, errS3AuthorizationMalformed("X-Amz-Expires must be between 1 and 604800 seconds")
+		}
+		expires = time.Duration(seconds) * time.Second
+		payloadHash = unsignedPayload
+		if val := r.Header.Get("X-Amz-Content-Sha256"); val != "" {
+			payloadHash = val
+		}
+	} else {
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			return nil, errS3AccessDenied
+		}
+		algorithm, fields, _ := strings.Cut(auth, " ")
+		if algorithm != sigV4Algorithm {
+			return nil, errS3AuthorizationMalformed("only " + sigV4Algorithm + " is supported")
+		}
+		for _, field := range strings.Split(fields, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(field), "=")
+			switch key {
+			case "Credential":
+				credential = val
+			case "SignedHeaders":
+				signedHeaders = val
+			case "Signature":
+				signature = val
+			}
+		}
+		amzDate = r.Header.Get("X-Amz-Date")
+		if amzDate == "" {
+			if date, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+				amzDate = date.UTC().Format(amzDateFormat)
+			}
+		}
+		payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == "" {
+			return nil, errS3InvalidRequest("missing x-amz-content-sha256 header")
+		}
+	}
+
+	signedAt, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return nil, errS3AuthorizationMalformed("missing or invalid request date")
+	}
+	scopeParts := strings.Split(credential, "/")
+	if len(scopeParts) != 5 || scopeParts[1] != signedAt.Format("20060102") || scopeParts[3] != "s3" || scopeParts[4] != "aws4_request" {
+		return nil, errS3AuthorizationMalformed("invalid credential scope " + strconv.Quote(credential))
+	}
+	if signedHeaders == "" || signature == "" {
+		return nil, errS3AuthorizationMalformed("missing SignedHeaders or Signature")
+	}
+	token, ok := tokens[scopeParts[0]]
+	if !ok {
+		return nil, errS3InvalidAccessKeyID
+	}
+	if presigned {
+		if now.After(signedAt.Add(expires)) {
+			return nil, errS3Expired
+		}
+		if signedAt.After(now.Add(maxClockSkew)) {
+			return nil, errS3TimeSkewed
+		}
+	} else if signedAt.Before(now.Add(-maxClockSkew)) || signedAt.After(now.Add(maxClockSkew)) {
+		return nil, errS3TimeSkewed
+	}
+
+	headers := strings.Split(signedHeaders, ";")
+	if !sort.StringsAreSorted(headers) || !containsString(headers, "host") {
+		return nil, errS3AuthorizationMalformed("SignedHeaders must be sorted and include host")
+	}
+	canonical := canonicalRequest(r, query, presigned, headers, payloadHash)
+	scope := strings.Join(scopeParts[1:], "/")
+	key := signingKey(token.Secret, scopeParts[1], scopeParts[2])
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign(amzDate, scope, sha256Hex([]byte(canonical)))))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, errS3SignatureMismatch
+	}
+	return &signedRequest{
+		User:        token.User,
+		PayloadHash: payloadHash,
+		key:         key,
+		amzDate:     amzDate,
+		scope:       scope,
+		signature:   signature,
+	}, nil
+}
+
+// canonicalRequest is r as SigV4 signs it, with the (sorted) headers and
+// the payload hash it was signed with.
+func canonicalRequest(r *http.Request, query url.Values, presigned bool, headers []string, payloadHash string) string {
+	return strings.Join([]string{
+		r.Method,
+		awsURIEncode(r.URL.Path, false),
+		canonicalQuery(query, presigned),
+		canonicalHeaders(r, headers),
+		strings.Join(headers, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func stringToSign(amzDate, scope, hashed string) string {
+	return sigV4Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hashed
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func signingKey(secret, date, region string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	return hmacSHA256(key, "aws4_request")
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, and
+// slashes unless encodeSlash is set, as SigV4 canonical requests do.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// canonicalQuery sorts and encodes query; the signature of a presigned URL
+// is not part of what it signs.
+func canonicalQuery(query url.Values, presigned bool) string {
+	var pairs []string
+	for key, vals := range query {
+		if presigned && key == "X-Amz-Signature" {
+			continue
+		}
+		for _, val := range vals {
+			pairs = append(pairs, awsURIEncode(key, true)+"="+awsURIEncode(val, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaders(r *http.Request, names []string) string {
+	var b strings.Builder
+	for _, name := range names {
+		var vals []string
+		switch name {
+		case "host":
+			vals = []string{r.Host}
+		case "content-length":
+			vals = []string{strconv.FormatInt(r.ContentLength, 10)}
+		case "transfer-encoding":
+			vals = r.TransferEncoding
+		default:
+			vals = r.Header.Values(name)
+		}
+		for i, val := range vals {
+			vals[i] = strings.Join(strings.Fields(val), " ")
+		}
+		b.WriteString(name + ":" + strings.Join(vals, ",") + "\n")
+	}
+	return b.String()
+}
+
+// payloadReader returns the body of r as the client meant it: aws-chunked
+// uploads are decoded, with each chunk's signature checked, and whatever
+// the request says about its content (x-amz-content-sha256, Content-MD5,
+// x-amz-checksum-*, the decoded length) is checked when the body ends. A
+// mismatch is an *s3Error in place of io.EOF, so nothing read from a body
+// that doesn't match should be kept. size is -1 when it isn't known.
+func payloadReader(r *http.Request, signed *signedRequest) (*checkedPayload, int64, *s3Error) {
+	body := io.Reader(r.Body)
+	size := r.ContentLength
+	payload := &checkedPayload{md5: md5.New()}
+
+	var chunked *chunkedReader
+	switch signed.PayloadHash {
+	case streamingPayload, streamingPayloadTrailer, streamingUnsignedTrailer:
+		decoded, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64)
+		if err != nil || decoded < 0 {
+			return nil, 0, errS3MissingContentLength
+		}
+		chunked = &chunkedReader{
+			r:       bufio.NewReader(r.Body),
+			trailer: signed.PayloadHash != streamingPayload,
+			prevSig: signed.signature,
+		}
+		if signed.PayloadHash != streamingUnsignedTrailer {
+			chunked.signed = signed
+		}
+		body, size = chunked, decoded
+		payload.trailers = func() http.Header { return chunked.trailers }
+	case unsignedPayload:
+	default:
+		if _, err := hex.DecodeString(signed.PayloadHash); err != nil || len(signed.PayloadHash) != 64 {
+			return nil, 0, errS3InvalidRequest("invalid x-amz-content-sha256 header")
+		}
+		payload.sha256, payload.wantSHA256 = sha256.New(), signed.PayloadHash
+	}
+	payload.body, payload.want = body, size
+
+	if val := r.Header.Get("Content-MD5"); val != "" {
+		sum, err := base64.StdEncoding.DecodeString(val)
+		if err != nil || len(sum) != md5.Size {
+			return nil, 0, errS3InvalidDigest
+		}
+		payload.wantMD5 = sum
+	}
+	algorithm := ""
+	for _, name := range checksumNames {
+		if val := r.Header.Get(name); val != "" {
+			algorithm, payload.wantChecksum = name, val
+		}
+	}
+	if trailer := strings.ToLower(r.Header.Get("X-Amz-Trailer")); algorithm == "" && trailer != "" {
+		if chunked == nil || !chunked.trailer {
+			return nil, 0, errS3InvalidRequest("x-amz-trailer needs a streaming trailer payload")
+		}
+		algorithm = trailer
+	}
+	if algorithm != "" {
+		payload.checksumName = algorithm
+		payload.checksum = newChecksum(algorithm)
+	}
+	return payload, size, nil
+}
+
+// checksumNames are the x-amz-checksum-* algorithms uploads are checked
+// against; others are accepted unchecked.
+var checksumNames = []string{"x-amz-checksum-crc32", "x-amz-checksum-crc32c", "x-amz-checksum-sha1", "x-amz-checksum-sha256"}
+
+func newChecksum(name string) hash.Hash {
+	switch name {
+	case "x-amz-checksum-crc32":
+		return crc32.NewIEEE()
+	case "x-amz-checksum-crc32c":
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case "x-amz-checksum-sha1":
+		return sha1.New()
+	case "x-amz-checksum-sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// checkedPayload is the reader payloadReader returns. MD5 is the MD5 sum
+// of what was read, which S3 uses as the ETag of parts.
+type checkedPayload struct {
+	body         io.Reader
+	want, read   int64
+	md5          hash.Hash
+	wantMD5      []byte
+	sha256       hash.Hash
+	wantSHA256   string
+	checksum     hash.Hash
+	checksumName string
+	wantChecksum string
+	trailers     func() http.Header
+	err          error
+}
+
+func (p *checkedPayload) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	n, err := p.body.Read(b)
+	p.read += int64(n)
+	for _, h := range []hash.Hash{p.md5, p.sha256, p.checksum} {
+		if h != nil {
+			h.Write(b[:n])
+		}
+	}
+	if p.want >= 0 && p.read > p.want {
+		p.err = errS3IncompleteBody
+		return 0, p.err
+	}
+	if err == io.EOF {
+		if s3err := p.check(); s3err != nil {
+			p.err = s3err
+			return n, s3err
+		}
+	} else if err != nil {
+		p.err = err
+	}
+	return n, err
+}
+
+func (p *checkedPayload) check() *s3Error {
+	if p.want >= 0 && p.read != p.want {
+		return errS3IncompleteBody
+	}
+	if p.sha256 != nil && hex.EncodeToString(p.sha256.Sum(nil)) != p.wantSHA256 {
+		return errS3ContentSHA256Mismatch
+	}
+	if p.wantMD5 != nil && !bytes.Equal(p.md5.Sum(nil), p.wantMD5) {
+		return errS3BadDigest
+	}
+	if p.checksum != nil {
+		want := p.wantChecksum
+		if want == "" && p.trailers != nil {
+			want = p.trailers().Get(p.checksumName)
+		}
+		if base64.StdEncoding.EncodeToString(p.checksum.Sum(nil)) != want {
+			return errS3BadDigest
+		}
+	}
+	return nil
+}
+
+// MD5 returns the hex MD5 sum of what was read.
+func (p *checkedPayload) MD5() string {
+	return hex.EncodeToString(p.md5.Sum(nil))
+}
+
+// chunkedReader decodes an aws-chunked body. With signed set every chunk,
+// and the trailer, carries a signature chained from the request's.
+type chunkedReader struct {
+	r        *bufio.Reader
+	signed   *signedRequest
+	trailer  bool
+	prevSig  string
+	chunk    []byte
+	trailers http.Header
+	done     bool
+}
+
+func (c *chunkedReader) Read(b []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(b, c.chunk)
+	c.chunk = c.chunk[n:]
+	return n, nil
+}
+
+// next reads the next chunk, or the end of the body.
+func (c *chunkedReader) next() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeHex, ext, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 || size > maxSignedChunk {
+		return errS3IncompleteBody
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return errS3IncompleteBody
+	}
+	if c.signed != nil {
+		sig, ok := strings.CutPrefix(ext, "chunk-signature=")
+		if !ok || !c.verify("AWS4-HMAC-SHA256-PAYLOAD", c.prevSig+"\n"+emptySHA256+"\n"+sha256Hex(data), sig) {
+			return errS3SignatureMismatch
+		}
+		c.prevSig = sig
+	}
+	if size > 0 {
+		if line, err := c.readLine(); err != nil || line != "" {
+			return errS3IncompleteBody
+		}
+		c.chunk = data
+		return nil
+	}
+
+	c.done = true
+	if !c.trailer {
+		return nil
+	}
+	// Trailers end with an empty line; signed ones end with the trailer
+	// signature and then one.
+	c.trailers = http.Header{}
+	var signedTrailers strings.Builder
+	gotSig := false
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return errS3IncompleteBody
+		}
+		if line == "" {
+			if c.signed != nil && !gotSig {
+				continue
+			}
+			break
+		}
+		name, val, _ := strings.Cut(line, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-amz-trailer-signature" {
+			if c.signed == nil || !c.verify("AWS4-HMAC-SHA256-TRAILER", c.prevSig+"\n"+sha256Hex([]byte(signedTrailers.String())), strings.TrimSpace(val)) {
+				return errS3SignatureMismatch
+			}
+			gotSig = true
+			continue
+		}
+		c.trailers.Add(name, strings.TrimSpace(val))
+		signedTrailers.WriteString(name + ":" + strings.TrimSpace(val) + "\n")
+	}
+	return nil
+}
+
+func (c *chunkedReader) verify(algorithm, rest, sig string) bool {
+	toSign := algorithm + "\n" + c.signed.amzDate + "\n" + c.signed.scope + "\n" + rest
+	expected := hex.EncodeToString(hmacSHA256(c.signed.key, toSign))
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+// readLine reads a line of the chunk framing, without its line ending.
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", errS3IncompleteBody
+	}
+	if len(line) > 4096 {
+		return "", errS3IncompleteBody
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,162 @@
+// End-to-end encryption for KubeFile uploads. The format is documented in
+// shared/e2e (the Go package the kubefile-decrypt CLI uses); both sides must
+// stay in sync.
+(function () {
+    const NONCE_SIZE = 12;
+    const OVERHEAD = NONCE_SIZE + 16;
+    const RECORD_SIZE = 1024 * 1024;
+    const RECORD_AAD = new TextEncoder().encode('kubefile-e2e');
+    const METADATA_AAD = new TextEncoder().encode('kubefile-e2e-meta');
+
+    function toBase64Url(bytes) {
+        let bin = '';
+        for (let i = 0; i < bytes.length; i++) bin += String.fromCharCode(bytes[i]);
+        return btoa(bin).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function fromBase64Url(s) {
+        s = s.replace(/-/g, '+').replace(/_/g, '/');
+        while (s.length % 4) s += '=';
+        const bin = atob(s);
+        const bytes = new Uint8Array(bin.length);
+        for (let i = 0; i < bin.length; i++) bytes[i] = bin.charCodeAt(i);
+        return bytes;
+    }
+
+    function recordAD(index, final) {
+        const ad = new Uint8Array(RECORD_AAD.length + 9);
+        ad.set(RECORD_AAD);
+        new DataView(ad.buffer).setBigUint64(RECORD_AAD.length, BigInt(index));
+        ad[ad.length - 1] = final ? 1 : 0;
+        return ad;
+    }
+
+    async function seal(key, data, ad) {
+        const nonce = crypto.getRandomValues(new Uint8Array(NONCE_SIZE));
+        const ct = new Uint8Array(await crypto.subtle.encrypt({ name: 'AES-GCM', iv: nonce, additionalData: ad }, key, data));
+        const out = new Uint8Array(NONCE_SIZE + ct.length);
+        out.set(nonce);
+        out.set(ct, NONCE_SIZE);
+        return out;
+    }
+
+    async function open(key, sealed, ad) {
+        if (sealed.length < OVERHEAD) throw new Error('Encrypted data is truncated');
+        const plain = await crypto.subtle.decrypt(
+            { name: 'AES-GCM', iv: sealed.subarray(0, NONCE_SIZE), additionalData: ad },
+            key, sealed.subarray(NONCE_SIZE));
+        return new Uint8Array(plain);
+    }
+
+    function recordCount(size, recordSize) {
+        return size === 0 ? 1 : Math.ceil(size / recordSize);
+    }
+
+    window.KubeFileE2E = {
+        OVERHEAD,
+        RECORD_SIZE,
+        toBase64Url,
+        fromBase64Url,
+        recordCount,
+
+        // newKey returns a fresh key and its share-link form.
+        async newKey() {
+            const raw = crypto.getRandomValues(new Uint8Array(32));
+            const key = await crypto.subtle.importKey('raw', raw, 'AES-GCM', false, ['encrypt', 'decrypt']);
+            return { key, encoded: toBase64Url(raw) };
+        },
+
+        async importKey(encoded) {
+            const raw = fromBase64Url(encoded);
+            if (raw.length !== 32) throw new Error('Invalid key in link');
+            return crypto.subtle.importKey('raw', raw, 'AES-GCM', false, ['decrypt']);
+        },
+
+        // newFileId returns a random name so the server doesn't learn the real one.
+        newFileId() {
+            return toBase64Url(crypto.getRandomValues(new Uint8Array(16)));
+        },
+
+        async sealMetadata(key, file) {
+            const meta = { v: 1, name: file.name, size: file.size, type: file.type || '', recordSize: RECORD_SIZE };
+            return toBase64Url(await seal(key, new TextEncoder().encode(JSON.stringify(meta)), METADATA_AAD));
+        },
+
+        async openMetadata(key, blob) {
+            let plain;
+            try {
+                plain = await open(key, fromBase64Url(blob), METADATA_AAD);
+            } catch (e) {
+                throw new Error('Could not decrypt this file, is the link complete?');
+            }
+            const meta = JSON.parse(new TextDecoder().decode(plain));
+            if (meta.v !== 1 || !(meta.recordSize > 0) || !(meta.size >= 0)) throw new Error('Unsupported file format');
+            return meta;
+        },
+
+        // sealRecords encrypts records [first, first+count) of file into one Blob.
+        async sealRecords(key, file, first, count) {
+            const total = recordCount(file.size, RECORD_SIZE);
+            const parts = [];
+            for (let i = first; i < Math.min(first + count, total); i++) {
+                const plain = new Uint8Array(await file.slice(i * RECORD_SIZE, (i + 1) * RECORD_SIZE).arrayBuffer());
+                parts.push(await seal(key, plain, recordAD(i, i === total - 1)));
+            }
+            return new Blob(parts);
+        },
+
+        // decryptStream turns the ciphertext body into a stream of plaintext.
+        decryptStream(key, meta, body) {
+            const total = recordCount(meta.size, meta.recordSize);
+            const reader = body.getReader();
+            let buffered = new Uint8Array(0);
+            let index = 0;
+
+            function recordLength(i) {
+                const plain = i === total - 1 ? meta.size - i * meta.recordSize : meta.recordSize;
+                return plain + OVERHEAD;
+            }
+
+            return new ReadableStream({
+                async pull(controller) {
+                    if (index === total) {
+                        const { done, value } = await reader.read();
+                        if (!done && value.length > 0 || buffered.length > 0) {
+                            controller.error(new Error('Unexpected data after the end of the file'));
+                        } else {
+                            controller.close();
+                        }
+                        return;
+                    }
+                    const want = recordLength(index);
+                    const record = new Uint8Array(want);
+                    let filled = Math.min(buffered.length, want);
+                    record.set(buffered.subarray(0, filled));
+                    buffered = buffered.subarray(filled);
+                    while (filled < want) {
+                        const { done, value } = await reader.read();
+                        if (done) {
+                            controller.error(new Error('The download was cut short'));
+                            return;
+                        }
+                        const n = Math.min(value.length, want - filled);
+                        record.set(value.subarray(0, n), filled);
+                        filled += n;
+                        buffered = value.subarray(n);
+                    }
+                    try {
+                        const plain = await open(key, record, recordAD(index, index === total - 1));
+                        controller.enqueue(plain);
+                    } catch (e) {
+                        controller.error(new Error(`Part ${index + 1} of the file failed verification`));
+                        return;
+                    }
+                    index++;
+                },
+                cancel(reason) {
+                    return reader.cancel(reason);
+                }
+            });
+        }
+    };
+})();
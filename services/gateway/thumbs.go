@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// thumbRetryAfter is what clients are told to wait for a thumbnail that is
+// still being made, in seconds.
+const thumbRetryAfter = "3"
+
+// handleThumbnail serves /thumb/<name>?size=N, a thumbnail of an image.
+// Like /download/, it needs no session.
+func handleThumbnail(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+		return
+	}
+	fileName, ok := publicFileName(w, r, "/thumb/")
+	if !ok {
+		return
+	}
+	serveThumbnail(w, r, client, fileName)
+}
+
+// serveThumbnail sends the thumbnail of fileName at the size in ?size=, the
+// service's default without one. Thumbnails not made yet get a 503 with
+// Retry-After; the request has queued them.
+func serveThumbnail(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, fileName string) {
+	var size int64
+	if s := r.URL.Query().Get("size"); s != "" {
+		var err error
+		size, err = strconv.ParseInt(s, 10, 32)
+		if err != nil || size <= 0 {
+			http.Error(w, "Tamanho inválido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := client.GetThumbnail(r.Context(), &filesharing.GetThumbnailRequest{FileName: fileName, Size: int32(size)})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.NotFound:
+			http.Error(w, "Ficheiro não encontrado", http.StatusNotFound)
+		case codes.FailedPrecondition:
+			http.Error(w, "Este ficheiro não tem miniatura", http.StatusNotFound)
+		case codes.Unavailable:
+			w.Header().Set("Retry-After", thumbRetryAfter)
+			http.Error(w, "A miniatura ainda está a ser gerada", http.StatusServiceUnavailable)
+		default:
+			log.Printf("Error getting thumbnail of %s: %v", fileName, err)
+			http.Error(w, "Erro ao obter a miniatura", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// The name can get new content at any time, so caches revalidate; the
+	// ETag spares them the body when it hasn't changed.
+	etag := fmt.Sprintf(`"%s-%d"`, res.ETag, res.Size)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", res.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(res.Data)))
+	w.Header().Set("Content-Security-Policy", viewCSP)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(res.Data)
+}
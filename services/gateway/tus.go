@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/filesharing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// /tus/ speaks tus 1.0 (https://tus.io/protocols/resumable-upload), so
+// standard clients can upload and resume: POST creates an upload (the
+// creation and creation-with-upload extensions), HEAD says how far it got,
+// PATCH appends at that offset, DELETE drops it (termination). PATCH bodies
+// can carry an Upload-Checksum (checksum) and every upload has an
+// Upload-Expires (expiration).
+//
+// Upload-Metadata names the file: filename, which can be a path, and
+// optionally folder, expires, tags and conflict, as for /upload.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,termination,checksum,expiration"
+	tusAlgorithms = "sha1,sha256,md5"
+	tusPatchType  = "application/offset+octet-stream"
+	// tusPartSize is how much of a PATCH body goes to the filesharing
+	// service at a time, under the gRPC message limit.
+	tusPartSize = 30 * 1024 * 1024
+)
+
+// statusChecksumMismatch is tus' status for a body that doesn't match its
+// Upload-Checksum.
+const statusChecksumMismatch = 460
+
+var errTusChecksum = errors.New("checksum mismatch")
+
+// parseTusMetadata reads an Upload-Metadata header: comma-separated keys,
+// each followed by a space and its base64 value, or alone.
+func parseTusMetadata(header string) (map[string]string, bool) {
+	meta := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return meta, true
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, false
+		}
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, false
+		}
+		meta[key] = string(value)
+	}
+	return meta, true
+}
+
+// tusChecksum reads an Upload-Checksum header into a hash to feed the body
+// to and the sum the body must have.
+func tusChecksum(header string) (hash.Hash, []byte, bool) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, nil, false
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, false
+	}
+	switch strings.ToLower(algorithm) {
+	case "sha1":
+		return sha1.New(), sum, true
+	case "sha256":
+		return sha256.New(), sum, true
+	case "md5":
+		return md5.New(), sum, true
+	}
+	return nil, nil, false
+}
+
+func setTusUploadHeaders(w http.ResponseWriter, upload *filesharing.ResumableUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Complete {
+		// With conflict=rename the file may be stored under another name.
+		w.Header().Set("X-File-Name", escapePath(upload.FileName))
+	} else if upload.ExpiresAt > 0 {
+		w.Header().Set("Upload-Expires", time.Unix(upload.ExpiresAt, 0).UTC().Format(http.TimeFormat))
+	}
+}
+
+// tusError sends the HTTP status for an error of the filesharing service.
+func tusError(w http.ResponseWriter, err error, action string) {
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.NotFound:
+		http.Error(w, "Upload não encontrado", http.StatusNotFound)
+	case codes.FailedPrecondition:
+		http.Error(w, st.Message(), http.StatusConflict)
+	case codes.OutOfRange:
+		http.Error(w, st.Message(), http.StatusRequestEntityTooLarge)
+	case codes.InvalidArgument:
+		http.Error(w, st.Message(), http.StatusBadRequest)
+	case codes.PermissionDenied:
+		http.Error(w, "Sem permissão para escrever nesta pasta", http.StatusForbidden)
+	case codes.AlreadyExists:
+		http.Error(w, st.Message(), http.StatusConflict)
+	default:
+		log.Printf("Error %s tus upload: %v", action, err)
+		http.Error(w, "Erro no upload", http.StatusInternalServerError)
+	}
+}
+
+// handleTus serves /tus/ and /tus/<id>.
+func handleTus(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == http.MethodPost {
+		method = strings.ToUpper(override)
+	}
+	if method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		w.Header().Set("Tus-Checksum-Algorithm", tusAlgorithms)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Versão do protocolo tus não suportada", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/tus"), "/")
+	switch {
+	case method == http.MethodPost && id == "":
+		createTusUpload(w, r, client, isAdmin)
+	case id == "" || strings.Contains(id, "/"):
+		http.Error(w, "Upload não encontrado", http.StatusNotFound)
+	case method == http.MethodHead:
+		res, err := client.GetResumableUpload(r.Context(), &filesharing.GetResumableUploadRequest{
+			ID:      id,
+			User:    requestUser(r),
+			IsAdmin: isAdmin,
+		})
+		w.Header().Set("Cache-Control", "no-store")
+		if err != nil {
+			tusError(w, err, "reading")
+			return
+		}
+		setTusUploadHeaders(w, res.Upload)
+		w.Header().Set("Upload-Length", strconv.FormatInt(res.Upload.Length, 10))
+		if res.Upload.Metadata != "" {
+			w.Header().Set("Upload-Metadata", res.Upload.Metadata)
+		}
+		w.WriteHeader(http.StatusOK)
+	case method == http.MethodPatch:
+		if r.Header.Get("Content-Type") != tusPatchType {
+			http.Error(w, "Content-Type tem de ser "+tusPatchType, http.StatusUnsupportedMediaType)
+			return
+		}
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			http.Error(w, "Upload-Offset inválido", http.StatusBadRequest)
+			return
+		}
+		patchTusUpload(w, r, client, isAdmin, id, offset)
+	case method == http.MethodDelete:
+		_, err := client.CancelResumableUpload(r.Context(), &filesharing.CancelResumableUploadRequest{
+			ID:      id,
+			User:    requestUser(r),
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			tusError(w, err, "cancelling")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Método não permitido", http.StatusMethodNotAllowed)
+	}
+}
+
+func createTusUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length não é suportado", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length inválido", http.StatusBadRequest)
+		return
+	}
+	header := r.Header.Get("Upload-Metadata")
+	meta, ok := parseTusMetadata(header)
+	if !ok {
+		http.Error(w, "Upload-Metadata inválido", http.StatusBadRequest)
+		return
+	}
+	name := meta["filename"]
+	if name == "" {
+		http.Error(w, "Upload-Metadata tem de incluir filename", http.StatusBadRequest)
+		return
+	}
+	if folder := strings.Trim(meta["folder"], "/"); folder != "" {
+		name = folder + "/" + name
+	}
+	expiresIn, keepForever, ok := parseExpiry(meta["expires"])
+	if !ok {
+		http.Error(w, "Validade inválida (segundos ou never)", http.StatusBadRequest)
+		return
+	}
+	if keepForever && !isAdmin {
+		http.Error(w, "Apenas administradores podem guardar ficheiros para sempre", http.StatusForbidden)
+		return
+	}
+
+	res, err := client.StartResumableUpload(r.Context(), &filesharing.StartResumableUploadRequest{
+		FileName:         name,
+		Length:           length,
+		Metadata:         header,
+		Owner:            requestUser(r),
+		IsAdmin:          isAdmin,
+		ExpiresInSeconds: expiresIn,
+		KeepForever:      keepForever,
+		Tags:             parseTags(meta["tags"]),
+		Conflict:         meta["conflict"],
+	})
+	if err != nil {
+		tusError(w, err, "creating")
+		return
+	}
+	upload := res.Upload
+	if upload.Complete {
+		// An empty file has no upload to come back to; Location, which
+		// clients require, points at the file.
+		w.Header().Set("Location", requestBaseURL(r)+"/download/"+escapePath(upload.FileName))
+		setTusUploadHeaders(w, upload)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	w.Header().Set("Location", requestBaseURL(r)+"/tus/"+upload.ID)
+
+	// creation-with-upload: the body is the start of the file.
+	if r.Header.Get("Content-Type") == tusPatchType && r.ContentLength != 0 {
+		upload, err = appendTusBody(r, client, isAdmin, upload.ID, 0)
+		if err != nil && upload == nil {
+			tusError(w, err, "appending to")
+			return
+		}
+	}
+	setTusUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func patchTusUpload(w http.ResponseWriter, r *http.Request, client filesharing.FileUploadClient, isAdmin bool, id string, offset int64) {
+	upload, err := appendTusBody(r, client, isAdmin, id, offset)
+	if err == errTusChecksum {
+		http.Error(w, "Checksum não corresponde", statusChecksumMismatch)
+		return
+	}
+	if err != nil && upload == nil {
+		tusError(w, err, "appending to")
+		return
+	}
+	setTusUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// appendTusBody sends the request body to upload id from offset. Without a
+// checksum it goes in tusPartSize pieces as it arrives, and a body cut
+// short keeps what came; the upload is then returned along with the error.
+// With one, the body has to fit in one piece, which is only stored once it
+// matches.
+func appendTusBody(r *http.Request, client filesharing.FileUploadClient, isAdmin bool, id string, offset int64) (*filesharing.ResumableUpload, error) {
+	appendData := func(data []byte) (*filesharing.ResumableUpload, error) {
+		res, err := client.AppendResumableUpload(r.Context(), &filesharing.AppendResumableUploadRequest{
+			ID:      id,
+			Offset:  offset,
+			Data:    data,
+			User:    requestUser(r),
+			IsAdmin: isAdmin,
+		})
+		if err != nil {
+			return nil, err
+		}
+		offset = res.Upload.Offset
+		return res.Upload, nil
+	}
+
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		h, sum, ok := tusChecksum(header)
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "Upload-Checksum inválido (algoritmos: "+tusAlgorithms+")")
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, tusPartSize+1))
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "corpo do pedido incompleto")
+		}
+		if len(data) > tusPartSize {
+			return nil, status.Errorf(codes.OutOfRange, "com Upload-Checksum, cada PATCH tem no máximo %d MB", tusPartSize>>20)
+		}
+		h.Write(data)
+		if !bytes.Equal(h.Sum(nil), sum) {
+			return nil, errTusChecksum
+		}
+		return appendData(data)
+	}
+
+	buf := make([]byte, tusPartSize)
+	var upload *filesharing.ResumableUpload
+	for {
+		n, readErr := io.ReadFull(r.Body, buf)
+		if n > 0 || upload == nil {
+			next, err := appendData(buf[:n])
+			if err != nil {
+				return upload, err
+			}
+			upload = next
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF || upload.Complete {
+			return upload, nil
+		}
+		if readErr != nil {
+			return upload, readErr
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const maxURLLength = 2083
+
+// URLPolicy decides which targets may be stored in the shortener and which
+// stored targets the gateway is willing to redirect to.
+type URLPolicy struct {
+	AllowedSchemes map[string]bool
+	AllowedDomains []string // empty means every domain not blocked is allowed
+	BlockedDomains []string
+	AllowPrivate   bool
+	LookupTimeout  time.Duration
+}
+
+func splitList(val string) []string {
+	var out []string
+	for _, item := range strings.Split(val, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func loadURLPolicy() *URLPolicy {
+	policy := &URLPolicy{
+		AllowedSchemes: map[string]bool{},
+		AllowedDomains: splitList(getEnv("REDIRECT_DOMAIN_ALLOWLIST", "")),
+		BlockedDomains: splitList(getEnv("REDIRECT_DOMAIN_BLOCKLIST", "")),
+		AllowPrivate:   parseBoolEnv("REDIRECT_ALLOW_PRIVATE", false),
+		LookupTimeout:  2 * time.Second,
+	}
+	for _, scheme := range splitList(getEnv("REDIRECT_ALLOWED_SCHEMES", "http,https")) {
+		policy.AllowedSchemes[scheme] = true
+	}
+	return policy
+}
+
+// matchesDomain reports whether host is domain itself or one of its subdomains.
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(domain, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		// Carrier-grade NAT (100.64.0.0/10) and the "this network" block
+		// aren't covered by IsPrivate.
+		if ip4[0] == 0 || (ip4[0] == 100 && ip4[1]&0xc0 == 64) {
+			return true
+		}
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+func isInternalHostname(host string) bool {
+	return host == "localhost" || strings.HasSuffix(host, ".localhost") ||
+		strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal") ||
+		strings.HasSuffix(host, ".cluster.local") || !strings.Contains(host, ".")
+}
+
+// Normalize parses raw, prepends https:// when no scheme was given and checks
+// the result against the policy. It returns the canonical URL to store.
+func (p *URLPolicy) Normalize(ctx context.Context, raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("URL não fornecida")
+	}
+	if len(raw) > maxURLLength {
+		return "", fmt.Errorf("URL demasiado longa")
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("URL inválida: %v", err)
+	}
+	if err := p.Check(ctx, u); err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Check validates an already parsed URL against the policy.
+func (p *URLPolicy) Check(ctx context.Context, u *url.URL) error {
+	scheme := strings.ToLower(u.Scheme)
+	if !p.AllowedSchemes[scheme] {
+		return fmt.Errorf("esquema %q não permitido", u.Scheme)
+	}
+	if u.Opaque != "" || u.User != nil {
+		return fmt.Errorf("URL inválida")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("URL sem host")
+	}
+	if matchesDomain(host, p.BlockedDomains) {
+		return fmt.Errorf("domínio %s bloqueado", host)
+	}
+	if len(p.AllowedDomains) > 0 && !matchesDomain(host, p.AllowedDomains) {
+		return fmt.Errorf("domínio %s não permitido", host)
+	}
+	if p.AllowPrivate {
+		return nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("endereço privado não permitido")
+		}
+		return nil
+	}
+	if isInternalHostname(host) {
+		return fmt.Errorf("host interno não permitido")
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, p.LookupTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, host)
+	if err != nil || len(addrs) == 0 {
+		// A host that doesn't resolve now could resolve to a private
+		// address later, so it isn't let through.
+		return fmt.Errorf("não foi possível resolver o host %s", host)
+	}
+	for _, addr := range addrs {
+		if isPrivateIP(addr.IP) {
+			return fmt.Errorf("host %s resolve para um endereço privado", host)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// viewCSP locks down files shown inline by /view/: nothing they contain can
+// run scripts, load anything but themselves or be framed by other sites.
+const viewCSP = "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'self'; sandbox"
+
+// viewPDFCSP is viewCSP for PDFs: browsers don't open their PDF viewer in a
+// sandboxed document.
+const viewPDFCSP = "default-src 'none'; object-src 'self'; style-src 'unsafe-inline'; frame-ancestors 'self'"
+
+// inlineContentType returns the type to serve a file of contentType with
+// when it is shown inline, and false for types that are only downloaded.
+// Text of any kind is shown as plain text, so HTML and SVG sources don't
+// render as pages.
+func inlineContentType(contentType string) (string, bool) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"), mediaType == "application/pdf":
+		return mediaType, true
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "/json"),
+		strings.HasSuffix(mediaType, "/xml"), strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "/javascript"), strings.HasSuffix(mediaType, "/yaml"),
+		strings.HasSuffix(mediaType, "/toml"):
+		charset := params["charset"]
+		if charset == "" {
+			charset = "utf-8"
+		}
+		return mime.FormatMediaType("text/plain", map[string]string{"charset": charset}), true
+	}
+	return "", false
+}
+
+// contentDisposition builds a Content-Disposition header for a file called
+// name (RFC 6266): an ASCII filename for old clients and, when the name
+// needs it, the exact name UTF-8 encoded in filename* (RFC 5987).
+func contentDisposition(disposition, name string) string {
+	var fallback strings.Builder
+	for _, r := range name {
+		if r < 0x20 || r >= 0x7f || r == '"' || r == '\\' || r == '%' {
+			fallback.WriteByte('_')
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+	header := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	if fallback.String() == name || !utf8.ValidString(name) {
+		return header
+	}
+	var encoded strings.Builder
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; isAttrChar(c) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return header + "; filename*=UTF-8''" + encoded.String()
+}
+
+// isAttrChar reports whether c can appear unescaped in an RFC 5987 value.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/shortener"
+	"github.com/google/uuid"
+)
+
+const (
+	maxBatchSize      = 1000
+	defaultExportPage = 200
+	maxExportPage     = 1000
+)
+
+func (s *ShortenerService) ShortURLBatch(ctx context.Context, req *shortener.ShortURLBatchRequest) (*shortener.ShortURLBatchResponse, error) {
+	if len(req.OriginalURLs) > maxBatchSize {
+		return nil, fmt.Errorf("batch too large: %d URLs (max %d)", len(req.OriginalURLs), maxBatchSize)
+	}
+
+	now := time.Now().Unix()
+	results := make([]*shortener.ShortURLBatchResult, len(req.OriginalURLs))
+	pipe := redisClient.TxPipeline()
+	for i, original := range req.OriginalURLs {
+		results[i] = &shortener.ShortURLBatchResult{OriginalURL: original}
+		if original == "" {
+			results[i].Error = "empty URL"
+			continue
+		}
+		results[i].UUID = uuid.NewString()
+		storeLink(ctx, pipe, &shortener.LinkRecord{
+			Slug:        results[i].UUID,
+			OriginalURL: original,
+			Owner:       req.Owner,
+			CreatedAt:   now,
+		}, linkTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("error storing short URLs: %v", err)
+	}
+
+	return &shortener.ShortURLBatchResponse{Results: results}, nil
+}
+
+// ExportURLs walks the kf:link: namespace with SCAN so a full export never
+// blocks Redis. The cursor is Redis' own SCAN cursor.
+func (s *ShortenerService) ExportURLs(ctx context.Context, req *shortener.ExportURLsRequest) (*shortener.ExportURLsResponse, error) {
+	count := int64(req.Count)
+	if count <= 0 {
+		count = defaultExportPage
+	}
+	if count > maxExportPage {
+		count = maxExportPage
+	}
+
+	keys, next, err := redisClient.Scan(ctx, req.Cursor, linkKeyPrefix+"*", count).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error scanning links: %v", err)
+	}
+
+	ids := make([]string, len(keys))
+	for i, key := range keys {
+		ids[i] = strings.TrimPrefix(key, linkKeyPrefix)
+	}
+	loaded, err := loadLinks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]*shortener.LinkRecord, 0, len(loaded))
+	for _, link := range loaded {
+		if link != nil {
+			links = append(links, link)
+		}
+	}
+
+	return &shortener.ExportURLsResponse{
+		Links:      links,
+		NextCursor: next,
+	}, nil
+}
+
+func (s *ShortenerService) ImportURLs(ctx context.Context, req *shortener.ImportURLsRequest) (*shortener.ImportURLsResponse, error) {
+	if len(req.Links) > maxBatchSize {
+		return nil, fmt.Errorf("batch too large: %d links (max %d)", len(req.Links), maxBatchSize)
+	}
+
+	now := time.Now().Unix()
+	res := &shortener.ImportURLsResponse{}
+	for _, link := range req.Links {
+		if !validSlug(link.Slug) {
+			res.Skipped++
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: invalid slug", link.Slug))
+			continue
+		}
+		if link.OriginalURL == "" {
+			res.Skipped++
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: missing target URL", link.Slug))
+			continue
+		}
+
+		if link.CreatedAt == 0 {
+			link.CreatedAt = now
+		}
+		ttl := time.Duration(link.TTLSeconds) * time.Second
+		var err error
+		if req.Overwrite {
+			pipe := redisClient.TxPipeline()
+			storeLink(ctx, pipe, link, ttl)
+			_, err = pipe.Exec(ctx)
+		} else {
+			err = storeNewLink(ctx, link, ttl)
+		}
+		if err == errSlugTaken {
+			res.Skipped++
+			continue
+		}
+		if err != nil {
+			res.Skipped++
+			res.Errors = append(res.Errors, fmt.Sprintf("%s: %v", link.Slug, err))
+			continue
+		}
+		res.Imported++
+	}
+
+	log.Printf("Imported %d links (%d skipped)", res.Imported, res.Skipped)
+	return res, nil
+}
@@ -14,6 +14,8 @@ import (
 	"google.golang.org/grpc"
 )
 
+const linkTTL = 5 * 24 * time.Hour
+
 var (
 	redisClient *redis.Client
 	ctx         = context.Background()
@@ -24,19 +26,31 @@ type ShortenerService struct {
 }
 
 func (s *ShortenerService) ShortURL(ctx context.Context, req *shortener.ShortURLRequest) (*shortener.ShortURLResponse, error) {
-	cur_uuid := uuid.NewString()
-	ttl := 5 * 24 * time.Hour
-	err := redisClient.Set(ctx, cur_uuid, req.OriginalURL, ttl).Err()
-	if err != nil {
+	link := &shortener.LinkRecord{
+		Slug:        uuid.NewString(),
+		OriginalURL: req.OriginalURL,
+		Owner:       req.Owner,
+		CreatedAt:   time.Now().Unix(),
+	}
+	pipe := redisClient.TxPipeline()
+	storeLink(ctx, pipe, link, linkTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
 		return nil, fmt.Errorf("error storing short URL: %v", err)
 	}
 	return &shortener.ShortURLResponse{
-		UUID: cur_uuid,
+		UUID: link.Slug,
 	}, nil
 }
 
 func (s *ShortenerService) ResolveURL(ctx context.Context, req *shortener.ResolveURLRequest) (*shortener.ResolveURLResponse, error) {
-	val, err := redisClient.Get(ctx, req.UUID).Result()
+	if !validSlug(req.UUID) {
+		return nil, fmt.Errorf("URL not found for UUID: %s", req.UUID)
+	}
+	val, err := redisClient.HGet(ctx, linkKey(req.UUID), "url").Result()
+	if err == redis.Nil {
+		// Links written by a replica that predates the v1 schema.
+		val, err = redisClient.Get(ctx, req.UUID).Result()
+	}
 	if err != nil {
 		if err == redis.Nil {
 			return nil, fmt.Errorf("URL not found for UUID: %s", req.UUID)
@@ -49,6 +63,46 @@ func (s *ShortenerService) ResolveURL(ctx context.Context, req *shortener.Resolv
 	}, nil
 }
 
+func (s *ShortenerService) ListURLs(ctx context.Context, req *shortener.ListURLsRequest) (*shortener.ListURLsResponse, error) {
+	if req.Owner == "" {
+		return nil, fmt.Errorf("owner not provided")
+	}
+	count := int(req.Count)
+	if count <= 0 {
+		count = defaultExportPage
+	}
+	if count > maxExportPage {
+		count = maxExportPage
+	}
+
+	ids, next, err := listOwnerLinks(ctx, req.Owner, req.Cursor, count)
+	if err != nil {
+		return nil, err
+	}
+	links, err := loadLinks(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &shortener.ListURLsResponse{NextCursor: next}
+	var expired []interface{}
+	for i, link := range links {
+		if link == nil || link.Owner != req.Owner {
+			expired = append(expired, ids[i])
+			continue
+		}
+		res.Links = append(res.Links, link)
+	}
+	if len(expired) > 0 {
+		// Hashes expire (or get re-imported under another owner) on their
+		// own; drop the dangling index entries.
+		if err := redisClient.ZRem(ctx, userLinksKey(req.Owner), expired...).Err(); err != nil {
+			log.Printf("Warning: failed to prune expired links for %s: %v", req.Owner, err)
+		}
+	}
+	return res, nil
+}
+
 func connectRedis(ctx context.Context, addr string, attempts int, delay time.Duration) (*redis.Client, error) {
 	client := redis.NewClient(&redis.Options{
 		Addr: addr,
@@ -77,6 +131,10 @@ func main() {
 	redisClient = client
 	fmt.Println("Connected to Redis")
 
+	if err := migrateSchema(ctx, os.Getenv("MIGRATE_LINKS_OWNER")); err != nil {
+		log.Fatalf("Redis schema migration failed: %v", err)
+	}
+
 	lis, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%d", 50051))
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
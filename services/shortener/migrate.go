@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/shortener"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const migrationLockKey = "kf:schema:migration-lock"
+
+// migrateSchema moves links stored as bare "uuid -> url" strings (schema 0)
+// into kf:link:<id> hashes. It runs once per keyspace: the version key marks
+// completion and a short-lived lock keeps replicas from migrating in parallel.
+// Legacy links have no owner; when defaultOwner is set they are indexed
+// under that user so they show up in "my links".
+func migrateSchema(ctx context.Context, defaultOwner string) error {
+	version, err := redisClient.Get(ctx, schemaVersionKey).Int()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	if version >= schemaVersion {
+		return nil
+	}
+
+	locked, err := redisClient.SetNX(ctx, migrationLockKey, "1", 10*time.Minute).Result()
+	if err != nil {
+		return fmt.Errorf("error acquiring migration lock: %v", err)
+	}
+	if !locked {
+		log.Println("Another replica is migrating the Redis schema, skipping")
+		return nil
+	}
+	defer redisClient.Del(ctx, migrationLockKey)
+
+	log.Printf("Migrating Redis keyspace from schema %d to %d", version, schemaVersion)
+	now := time.Now().Unix()
+	migrated := 0
+	var cursor uint64
+	for {
+		keys, next, err := redisClient.Scan(ctx, cursor, "*", 500).Result()
+		if err != nil {
+			return fmt.Errorf("error scanning keyspace: %v", err)
+		}
+
+		for _, key := range keys {
+			if _, err := uuid.Parse(key); err != nil {
+				continue
+			}
+			if err := migrateLegacyLink(ctx, key, defaultOwner, now); err != nil {
+				log.Printf("Warning: failed to migrate link %s: %v", key, err)
+				continue
+			}
+			migrated++
+		}
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if err := redisClient.Set(ctx, schemaVersionKey, schemaVersion, 0).Err(); err != nil {
+		return fmt.Errorf("error writing schema version: %v", err)
+	}
+	log.Printf("Migrated %d legacy links to schema %d", migrated, schemaVersion)
+	return nil
+}
+
+func migrateLegacyLink(ctx context.Context, key, owner string, created int64) error {
+	target, err := redisClient.Get(ctx, key).Result()
+	if err != nil {
+		// Expired in the meantime, or not a string key we created.
+		return err
+	}
+	ttl, err := redisClient.TTL(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := redisClient.TxPipeline()
+	storeLink(ctx, pipe, &shortener.LinkRecord{
+		Slug:        key,
+		OriginalURL: target,
+		Owner:       owner,
+		CreatedAt:   created,
+	}, ttl)
+	pipe.Del(ctx, key)
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Maruqes/KubeFile/shared/proto/shortener"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis key schema (version 1):
+//
+//	kf:schema:version      schema version of the keyspace
+//	kf:link:<id>           hash {url, owner, created}, expires with the link
+//	kf:user:<owner>:links  sorted set of link ids scored by creation time
+const (
+	schemaVersion    = 1
+	schemaVersionKey = "kf:schema:version"
+	linkKeyPrefix    = "kf:link:"
+)
+
+var slugPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+func linkKey(id string) string {
+	return linkKeyPrefix + id
+}
+
+func userLinksKey(owner string) string {
+	return "kf:user:" + owner + ":links"
+}
+
+func validSlug(slug string) bool {
+	return slugPattern.MatchString(slug)
+}
+
+// storeLink queues the writes for one link and its owner index entry on pipe.
+func storeLink(ctx context.Context, pipe redis.Pipeliner, link *shortener.LinkRecord, ttl time.Duration) {
+	key := linkKey(link.Slug)
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"url":     link.OriginalURL,
+		"owner":   link.Owner,
+		"created": link.CreatedAt,
+	})
+	if ttl > 0 {
+		pipe.Expire(ctx, key, ttl)
+	} else {
+		pipe.Persist(ctx, key)
+	}
+	if link.Owner != "" {
+		pipe.ZAdd(ctx, userLinksKey(link.Owner), redis.Z{
+			Score:  float64(link.CreatedAt),
+			Member: link.Slug,
+		})
+	}
+}
+
+// errSlugTaken is returned by storeNewLink when the slug is in use.
+var errSlugTaken = errors.New("slug already in use")
+
+// storeNewLink stores link unless its slug is taken. The link key is watched
+// between the check and the write, so of two writers of the same slug only
+// one succeeds and the other gets errSlugTaken.
+func storeNewLink(ctx context.Context, link *shortener.LinkRecord, ttl time.Duration) error {
+	key := linkKey(link.Slug)
+	err := redisClient.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return errSlugTaken
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			storeLink(ctx, pipe, link, ttl)
+			return nil
+		})
+		return err
+	}, key)
+	if err == redis.TxFailedErr {
+		return errSlugTaken
+	}
+	return err
+}
+
+func linkFromHash(id string, fields map[string]string) *shortener.LinkRecord {
+	created, _ := strconv.ParseInt(fields["created"], 10, 64)
+	return &shortener.LinkRecord{
+		Slug:        id,
+		OriginalURL: fields["url"],
+		Owner:       fields["owner"],
+		CreatedAt:   created,
+	}
+}
+
+// loadLinks fetches the hashes and remaining TTLs of ids in one round trip.
+// Entries whose hash no longer exists are returned as nil.
+func loadLinks(ctx context.Context, ids []string) ([]*shortener.LinkRecord, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	hashCmds := make([]*redis.MapStringStringCmd, len(ids))
+	ttlCmds := make([]*redis.DurationCmd, len(ids))
+	pipe := redisClient.Pipeline()
+	for i, id := range ids {
+		hashCmds[i] = pipe.HGetAll(ctx, linkKey(id))
+		ttlCmds[i] = pipe.TTL(ctx, linkKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("error loading links: %v", err)
+	}
+
+	links := make([]*shortener.LinkRecord, len(ids))
+	for i, id := range ids {
+		fields := hashCmds[i].Val()
+		if len(fields) == 0 || fields["url"] == "" {
+			continue
+		}
+		link := linkFromHash(id, fields)
+		if ttl := ttlCmds[i].Val(); ttl > 0 {
+			link.TTLSeconds = int64(ttl / time.Second)
+		}
+		links[i] = link
+	}
+	return links, nil
+}
+
+func encodeListCursor(score float64, member string) string {
+	return strconv.FormatInt(int64(score), 10) + ":" + member
+}
+
+func decodeListCursor(cursor string) (int64, string, error) {
+	score, member, ok := strings.Cut(cursor, ":")
+	if !ok {
+		return 0, "", fmt.Errorf("invalid cursor")
+	}
+	parsed, err := strconv.ParseInt(score, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid cursor")
+	}
+	return parsed, member, nil
+}
+
+// listOwnerLinks walks an owner's index newest first. The cursor is the
+// (score, member) pair of the last entry visited; Redis orders members with
+// equal scores lexicographically, so that pair is a stable position even
+// when many links share a creation second.
+func listOwnerLinks(ctx context.Context, owner, cursor string, count int) ([]string, string, error) {
+	max := "+inf"
+	var afterScore int64
+	var afterMember string
+	if cursor != "" {
+		var err error
+		afterScore, afterMember, err = decodeListCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		max = strconv.FormatInt(afterScore, 10)
+	}
+
+	var ids []string
+	var scores []float64
+	offset := int64(0)
+	for len(ids) <= count {
+		batch, err := redisClient.ZRangeArgsWithScores(ctx, redis.ZRangeArgs{
+			Key:     userLinksKey(owner),
+			Start:   "-inf",
+			Stop:    max,
+			ByScore: true,
+			Rev:     true,
+			Offset:  offset,
+			Count:   int64(count + 1),
+		}).Result()
+		if err != nil {
+			return nil, "", fmt.Errorf("error listing links: %v", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		offset += int64(len(batch))
+
+		for _, z := range batch {
+			member, _ := z.Member.(string)
+			if cursor != "" && int64(z.Score) == afterScore && member >= afterMember {
+				continue
+			}
+			ids = append(ids, member)
+			scores = append(scores, z.Score)
+		}
+	}
+
+	if len(ids) <= count {
+		return ids, "", nil
+	}
+	ids = ids[:count]
+	return ids, encodeListCursor(scores[count-1], ids[count-1]), nil
+}
@@ -0,0 +1,191 @@
+// Package e2e reads the end-to-end encrypted file format the browser writes
+// with WebCrypto (see static/e2e.js in the gateway). The server only ever
+// sees the ciphertext and the sealed metadata blob; the key travels in the
+// #fragment of the share link, which browsers never send.
+//
+// A file is encrypted with one random 256-bit AES-GCM key. The plaintext is
+// cut into records of Metadata.RecordSize bytes (the last one may be shorter,
+// an empty file is one empty record) and each record is stored as
+//
+//	nonce (12 bytes) || ciphertext || tag (16 bytes)
+//
+// with "kubefile-e2e" || uint64 big-endian record index || final flag byte as
+// additional data, so records can't be reordered, dropped or truncated
+// without failing authentication. The metadata blob is a JSON Metadata sealed
+// the same way with "kubefile-e2e-meta" as additional data.
+package e2e
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	KeySize   = 32
+	NonceSize = 12
+	// Overhead is what sealing adds to every record.
+	Overhead = NonceSize + 16
+
+	// DefaultRecordSize is the record size the browser uses.
+	DefaultRecordSize = 1024 * 1024
+	// MetadataHeader carries the base64url metadata blob on uploads and
+	// downloads.
+	MetadataHeader = "X-Encrypted-Metadata"
+)
+
+const maxRecordSize = 64 * 1024 * 1024
+
+var (
+	recordAAD   = []byte("kubefile-e2e")
+	metadataAAD = []byte("kubefile-e2e-meta")
+)
+
+// Metadata describes the plaintext file; it's only readable with the key.
+type Metadata struct {
+	Version    int    `json:"v"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Type       string `json:"type,omitempty"`
+	RecordSize int    `json:"recordSize"`
+}
+
+// Records returns how many records a file of m.Size bytes has.
+func (m *Metadata) Records() int64 {
+	if m.Size == 0 {
+		return 1
+	}
+	return (m.Size + int64(m.RecordSize) - 1) / int64(m.RecordSize)
+}
+
+// ParseKey decodes a key as it appears in a share link fragment
+// (unpadded base64url).
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(key) != KeySize {
+		return nil, errors.New("invalid key, expected 32 bytes of base64url")
+	}
+	return key, nil
+}
+
+// DecodeBlob decodes the metadata blob from its MetadataHeader form.
+func DecodeBlob(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < Overhead {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:NonceSize], sealed[NonceSize:], aad)
+}
+
+// OpenMetadata decrypts and validates a metadata blob.
+func OpenMetadata(key, blob []byte) (*Metadata, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := open(aead, blob, metadataAAD)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting metadata (wrong key?): %v", err)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("corrupt metadata: %v", err)
+	}
+	if meta.Version != 1 {
+		return nil, fmt.Errorf("unsupported format version %d", meta.Version)
+	}
+	if meta.RecordSize <= 0 || meta.RecordSize > maxRecordSize || meta.Size < 0 {
+		return nil, errors.New("corrupt metadata: bad sizes")
+	}
+	return &meta, nil
+}
+
+func recordAD(index int64, final bool) []byte {
+	ad := binary.BigEndian.AppendUint64(append([]byte{}, recordAAD...), uint64(index))
+	if final {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// Reader decrypts a ciphertext stream record by record.
+type Reader struct {
+	src     io.Reader
+	aead    cipher.AEAD
+	meta    *Metadata
+	index   int64
+	records int64
+	sealed  []byte
+	plain   []byte
+}
+
+// NewReader returns a Reader that yields the plaintext of src. Every byte it
+// returns has been authenticated; a stream that is cut short or has trailing
+// data fails with an error instead of EOF.
+func NewReader(src io.Reader, key []byte, meta *Metadata) (*Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		src:     src,
+		aead:    aead,
+		meta:    meta,
+		records: meta.Records(),
+		sealed:  make([]byte, meta.RecordSize+Overhead),
+	}, nil
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.index == r.records {
+			// Anything after the final record was not written by us.
+			if n, _ := r.src.Read(r.sealed[:1]); n > 0 {
+				return 0, errors.New("unexpected data after the final record")
+			}
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *Reader) next() error {
+	final := r.index == r.records-1
+	size := int64(r.meta.RecordSize)
+	if final {
+		size = r.meta.Size - r.index*size
+	}
+	sealed := r.sealed[:size+Overhead]
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return fmt.Errorf("record %d is incomplete: %v", r.index, err)
+	}
+	plain, err := open(r.aead, sealed, recordAD(r.index, final))
+	if err != nil {
+		return fmt.Errorf("record %d failed authentication: %v", r.index, err)
+	}
+	r.plain = plain
+	r.index++
+	return nil
+}
@@ -0,0 +1,122 @@
+// Package paths normalizes the slash-separated file and folder names the
+// gateway and the filesharing service pass around ("team/releases/v1.tar").
+// Both sides clean names the same way, so a name that reaches the storage
+// layer can never climb out of its folder or alias another name.
+package paths
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxLength bounds a whole path, MaxSegmentLength each name in it.
+	MaxLength        = 1024
+	MaxSegmentLength = 255
+)
+
+var (
+	ErrEmpty    = errors.New("path is empty")
+	ErrInvalid  = errors.New("invalid path")
+	ErrReserved = errors.New("path is in a reserved namespace")
+)
+
+// reserved are the prefixes the filesharing service keeps its own objects
+// under. A path starting with one of them could alias those objects through
+// the layouts that store files under their bare name.
+var reserved = map[string]bool{
+	"manifests": true, "chunks": true, "refs": true, "uploads": true,
+	"parts": true, "files": true, "versions": true, "thumbs": true,
+	"expiry": true, "index": true, "folders": true, "shares": true,
+	"status": true, "assemblies": true,
+}
+
+// Clean returns the canonical form of name: segments joined by single
+// slashes, without leading or trailing slashes or "." segments. Names with
+// "..", backslashes, control characters or invalid UTF-8 are rejected rather
+// than rewritten, and so is the empty name. So are names whose first segment
+// is one of the service's storage namespaces.
+func Clean(name string) (string, error) {
+	if !utf8.ValidString(name) || strings.ContainsRune(name, '\\') {
+		return "", ErrInvalid
+	}
+	var segments []string
+	for _, segment := range strings.Split(name, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", ErrInvalid
+		}
+		if len(segment) > MaxSegmentLength || strings.TrimSpace(segment) == "" {
+			return "", ErrInvalid
+		}
+		for _, c := range segment {
+			if unicode.IsControl(c) {
+				return "", ErrInvalid
+			}
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", ErrEmpty
+	}
+	if reserved[segments[0]] {
+		return "", ErrReserved
+	}
+	cleaned := strings.Join(segments, "/")
+	if len(cleaned) > MaxLength {
+		return "", ErrInvalid
+	}
+	return cleaned, nil
+}
+
+// CleanFolder is Clean for folder paths, where the empty path is the root.
+func CleanFolder(name string) (string, error) {
+	cleaned, err := Clean(name)
+	if err == ErrEmpty {
+		return "", nil
+	}
+	return cleaned, err
+}
+
+// Base returns the last segment of a clean path.
+func Base(name string) string {
+	return name[strings.LastIndexByte(name, '/')+1:]
+}
+
+// Dir returns the folder of a clean path, "" for the root.
+func Dir(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// Join appends name to folder, which may be the root.
+func Join(folder, name string) string {
+	if folder == "" {
+		return name
+	}
+	return folder + "/" + name
+}
+
+// Within reports whether name is folder or lies below it. Everything lies
+// within the root.
+func Within(name, folder string) bool {
+	return folder == "" || name == folder || strings.HasPrefix(name, folder+"/")
+}
+
+// Parents returns the folders above a clean path, outermost first:
+// "a/b/c" gives "a" and "a/b".
+func Parents(name string) []string {
+	var parents []string
+	for i := 0; i < len(name); i++ {
+		if name[i] == '/' {
+			parents = append(parents, name[:i])
+		}
+	}
+	return parents
+}
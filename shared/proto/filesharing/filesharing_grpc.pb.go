@@ -19,10 +19,43 @@ import (
 const _ = grpc.SupportPackageIsVersion8
 
 const (
-	FileUpload_UploadFile_FullMethodName     = "/filesharing.FileUpload/UploadFile"
-	FileUpload_AddChunk_FullMethodName       = "/filesharing.FileUpload/AddChunk"
-	FileUpload_GetChunk_FullMethodName       = "/filesharing.FileUpload/GetChunk"
-	FileUpload_GetStorageInfo_FullMethodName = "/filesharing.FileUpload/GetStorageInfo"
+	FileUpload_UploadFile_FullMethodName              = "/filesharing.FileUpload/UploadFile"
+	FileUpload_AddChunk_FullMethodName                = "/filesharing.FileUpload/AddChunk"
+	FileUpload_GetChunk_FullMethodName                = "/filesharing.FileUpload/GetChunk"
+	FileUpload_GetStorageInfo_FullMethodName          = "/filesharing.FileUpload/GetStorageInfo"
+	FileUpload_CompleteUpload_FullMethodName          = "/filesharing.FileUpload/CompleteUpload"
+	FileUpload_AbortUpload_FullMethodName             = "/filesharing.FileUpload/AbortUpload"
+	FileUpload_DownloadFile_FullMethodName            = "/filesharing.FileUpload/DownloadFile"
+	FileUpload_RotateKeys_FullMethodName              = "/filesharing.FileUpload/RotateKeys"
+	FileUpload_GetJobStatus_FullMethodName            = "/filesharing.FileUpload/GetJobStatus"
+	FileUpload_ListFiles_FullMethodName               = "/filesharing.FileUpload/ListFiles"
+	FileUpload_StatFile_FullMethodName                = "/filesharing.FileUpload/StatFile"
+	FileUpload_SearchFiles_FullMethodName             = "/filesharing.FileUpload/SearchFiles"
+	FileUpload_DeleteFile_FullMethodName              = "/filesharing.FileUpload/DeleteFile"
+	FileUpload_RenameFile_FullMethodName              = "/filesharing.FileUpload/RenameFile"
+	FileUpload_CopyFile_FullMethodName                = "/filesharing.FileUpload/CopyFile"
+	FileUpload_CreateFolder_FullMethodName            = "/filesharing.FileUpload/CreateFolder"
+	FileUpload_ListFolder_FullMethodName              = "/filesharing.FileUpload/ListFolder"
+	FileUpload_MoveFolder_FullMethodName              = "/filesharing.FileUpload/MoveFolder"
+	FileUpload_DeleteFolder_FullMethodName            = "/filesharing.FileUpload/DeleteFolder"
+	FileUpload_SetFolderMembers_FullMethodName        = "/filesharing.FileUpload/SetFolderMembers"
+	FileUpload_ShareFolder_FullMethodName             = "/filesharing.FileUpload/ShareFolder"
+	FileUpload_RevokeShare_FullMethodName             = "/filesharing.FileUpload/RevokeShare"
+	FileUpload_ResolveShare_FullMethodName            = "/filesharing.FileUpload/ResolveShare"
+	FileUpload_ListVersions_FullMethodName            = "/filesharing.FileUpload/ListVersions"
+	FileUpload_RestoreVersion_FullMethodName          = "/filesharing.FileUpload/RestoreVersion"
+	FileUpload_GetThumbnail_FullMethodName            = "/filesharing.FileUpload/GetThumbnail"
+	FileUpload_ListArchiveEntries_FullMethodName      = "/filesharing.FileUpload/ListArchiveEntries"
+	FileUpload_ImportFromURL_FullMethodName           = "/filesharing.FileUpload/ImportFromURL"
+	FileUpload_GetImport_FullMethodName               = "/filesharing.FileUpload/GetImport"
+	FileUpload_StartResumableUpload_FullMethodName    = "/filesharing.FileUpload/StartResumableUpload"
+	FileUpload_GetResumableUpload_FullMethodName      = "/filesharing.FileUpload/GetResumableUpload"
+	FileUpload_AppendResumableUpload_FullMethodName   = "/filesharing.FileUpload/AppendResumableUpload"
+	FileUpload_CancelResumableUpload_FullMethodName   = "/filesharing.FileUpload/CancelResumableUpload"
+	FileUpload_StartMultipartUpload_FullMethodName    = "/filesharing.FileUpload/StartMultipartUpload"
+	FileUpload_UploadPart_FullMethodName              = "/filesharing.FileUpload/UploadPart"
+	FileUpload_CompleteMultipartUpload_FullMethodName = "/filesharing.FileUpload/CompleteMultipartUpload"
+	FileUpload_AbortMultipartUpload_FullMethodName    = "/filesharing.FileUpload/AbortMultipartUpload"
 )
 
 // FileUploadClient is the client API for FileUpload service.
@@ -33,6 +66,39 @@ type FileUploadClient interface {
 	AddChunk(ctx context.Context, in *AddChunkRequest, opts ...grpc.CallOption) (*AddChunkResponse, error)
 	GetChunk(ctx context.Context, in *GetChunkRequest, opts ...grpc.CallOption) (*GetChunkResponse, error)
 	GetStorageInfo(ctx context.Context, in *GetStorageInfoRequest, opts ...grpc.CallOption) (*GetStorageInfoResponse, error)
+	CompleteUpload(ctx context.Context, in *CompleteUploadRequest, opts ...grpc.CallOption) (*CompleteUploadResponse, error)
+	AbortUpload(ctx context.Context, in *AbortUploadRequest, opts ...grpc.CallOption) (*AbortUploadResponse, error)
+	DownloadFile(ctx context.Context, in *DownloadFileRequest, opts ...grpc.CallOption) (FileUpload_DownloadFileClient, error)
+	RotateKeys(ctx context.Context, in *RotateKeysRequest, opts ...grpc.CallOption) (*RotateKeysResponse, error)
+	GetJobStatus(ctx context.Context, in *GetJobStatusRequest, opts ...grpc.CallOption) (*GetJobStatusResponse, error)
+	ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error)
+	StatFile(ctx context.Context, in *StatFileRequest, opts ...grpc.CallOption) (*StatFileResponse, error)
+	SearchFiles(ctx context.Context, in *SearchFilesRequest, opts ...grpc.CallOption) (*SearchFilesResponse, error)
+	DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error)
+	RenameFile(ctx context.Context, in *RenameFileRequest, opts ...grpc.CallOption) (*RenameFileResponse, error)
+	CopyFile(ctx context.Context, in *CopyFileRequest, opts ...grpc.CallOption) (*CopyFileResponse, error)
+	CreateFolder(ctx context.Context, in *CreateFolderRequest, opts ...grpc.CallOption) (*CreateFolderResponse, error)
+	ListFolder(ctx context.Context, in *ListFolderRequest, opts ...grpc.CallOption) (*ListFolderResponse, error)
+	MoveFolder(ctx context.Context, in *MoveFolderRequest, opts ...grpc.CallOption) (*MoveFolderResponse, error)
+	DeleteFolder(ctx context.Context, in *DeleteFolderRequest, opts ...grpc.CallOption) (*DeleteFolderResponse, error)
+	SetFolderMembers(ctx context.Context, in *SetFolderMembersRequest, opts ...grpc.CallOption) (*SetFolderMembersResponse, error)
+	ShareFolder(ctx context.Context, in *ShareFolderRequest, opts ...grpc.CallOption) (*ShareFolderResponse, error)
+	RevokeShare(ctx context.Context, in *RevokeShareRequest, opts ...grpc.CallOption) (*RevokeShareResponse, error)
+	ResolveShare(ctx context.Context, in *ResolveShareRequest, opts ...grpc.CallOption) (*ResolveShareResponse, error)
+	ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error)
+	RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*RestoreVersionResponse, error)
+	GetThumbnail(ctx context.Context, in *GetThumbnailRequest, opts ...grpc.CallOption) (*GetThumbnailResponse, error)
+	ListArchiveEntries(ctx context.Context, in *ListArchiveEntriesRequest, opts ...grpc.CallOption) (*ListArchiveEntriesResponse, error)
+	ImportFromURL(ctx context.Context, in *ImportFromURLRequest, opts ...grpc.CallOption) (*ImportFromURLResponse, error)
+	GetImport(ctx context.Context, in *GetImportRequest, opts ...grpc.CallOption) (*GetImportResponse, error)
+	StartResumableUpload(ctx context.Context, in *StartResumableUploadRequest, opts ...grpc.CallOption) (*ResumableUploadResponse, error)
+	GetResumableUpload(ctx context.Context, in *GetResumableUploadRequest, opts ...grpc.CallOption) (*ResumableUploadResponse, error)
+	AppendResumableUpload(ctx context.Context, in *AppendResumableUploadRequest, opts ...grpc.CallOption) (*ResumableUploadResponse, error)
+	CancelResumableUpload(ctx context.Context, in *CancelResumableUploadRequest, opts ...grpc.CallOption) (*CancelResumableUploadResponse, error)
+	StartMultipartUpload(ctx context.Context, in *StartMultipartUploadRequest, opts ...grpc.CallOption) (*StartMultipartUploadResponse, error)
+	UploadPart(ctx context.Context, opts ...grpc.CallOption) (FileUpload_UploadPartClient, error)
+	CompleteMultipartUpload(ctx context.Context, in *CompleteMultipartUploadRequest, opts ...grpc.CallOption) (*CompleteUploadResponse, error)
+	AbortMultipartUpload(ctx context.Context, in *AbortMultipartUploadRequest, opts ...grpc.CallOption) (*AbortMultipartUploadResponse, error)
 }
 
 type fileUploadClient struct {
@@ -83,6 +149,384 @@ func (c *fileUploadClient) GetStorageInfo(ctx context.Context, in *GetStorageInf
 	return out, nil
 }
 
+func (c *fileUploadClient) CompleteUpload(ctx context.Context, in *CompleteUploadRequest, opts ...grpc.CallOption) (*CompleteUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompleteUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_CompleteUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) AbortUpload(ctx context.Context, in *AbortUploadRequest, opts ...grpc.CallOption) (*AbortUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AbortUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_AbortUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) DownloadFile(ctx context.Context, in *DownloadFileRequest, opts ...grpc.CallOption) (FileUpload_DownloadFileClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileUpload_ServiceDesc.Streams[0], FileUpload_DownloadFile_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileUploadDownloadFileClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FileUpload_DownloadFileClient interface {
+	Recv() (*DownloadFileResponse, error)
+	grpc.ClientStream
+}
+
+type fileUploadDownloadFileClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileUploadDownloadFileClient) Recv() (*DownloadFileResponse, error) {
+	m := new(DownloadFileResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileUploadClient) RotateKeys(ctx context.Context, in *RotateKeysRequest, opts ...grpc.CallOption) (*RotateKeysResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RotateKeysResponse)
+	err := c.cc.Invoke(ctx, FileUpload_RotateKeys_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) GetJobStatus(ctx context.Context, in *GetJobStatusRequest, opts ...grpc.CallOption) (*GetJobStatusResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetJobStatusResponse)
+	err := c.cc.Invoke(ctx, FileUpload_GetJobStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ListFiles(ctx context.Context, in *ListFilesRequest, opts ...grpc.CallOption) (*ListFilesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFilesResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ListFiles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) StatFile(ctx context.Context, in *StatFileRequest, opts ...grpc.CallOption) (*StatFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatFileResponse)
+	err := c.cc.Invoke(ctx, FileUpload_StatFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) SearchFiles(ctx context.Context, in *SearchFilesRequest, opts ...grpc.CallOption) (*SearchFilesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchFilesResponse)
+	err := c.cc.Invoke(ctx, FileUpload_SearchFiles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) DeleteFile(ctx context.Context, in *DeleteFileRequest, opts ...grpc.CallOption) (*DeleteFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteFileResponse)
+	err := c.cc.Invoke(ctx, FileUpload_DeleteFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) RenameFile(ctx context.Context, in *RenameFileRequest, opts ...grpc.CallOption) (*RenameFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RenameFileResponse)
+	err := c.cc.Invoke(ctx, FileUpload_RenameFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) CopyFile(ctx context.Context, in *CopyFileRequest, opts ...grpc.CallOption) (*CopyFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CopyFileResponse)
+	err := c.cc.Invoke(ctx, FileUpload_CopyFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) CreateFolder(ctx context.Context, in *CreateFolderRequest, opts ...grpc.CallOption) (*CreateFolderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CreateFolderResponse)
+	err := c.cc.Invoke(ctx, FileUpload_CreateFolder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ListFolder(ctx context.Context, in *ListFolderRequest, opts ...grpc.CallOption) (*ListFolderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListFolderResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ListFolder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) MoveFolder(ctx context.Context, in *MoveFolderRequest, opts ...grpc.CallOption) (*MoveFolderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MoveFolderResponse)
+	err := c.cc.Invoke(ctx, FileUpload_MoveFolder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) DeleteFolder(ctx context.Context, in *DeleteFolderRequest, opts ...grpc.CallOption) (*DeleteFolderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteFolderResponse)
+	err := c.cc.Invoke(ctx, FileUpload_DeleteFolder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) SetFolderMembers(ctx context.Context, in *SetFolderMembersRequest, opts ...grpc.CallOption) (*SetFolderMembersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetFolderMembersResponse)
+	err := c.cc.Invoke(ctx, FileUpload_SetFolderMembers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ShareFolder(ctx context.Context, in *ShareFolderRequest, opts ...grpc.CallOption) (*ShareFolderResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShareFolderResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ShareFolder_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) RevokeShare(ctx context.Context, in *RevokeShareRequest, opts ...grpc.CallOption) (*RevokeShareResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RevokeShareResponse)
+	err := c.cc.Invoke(ctx, FileUpload_RevokeShare_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ResolveShare(ctx context.Context, in *ResolveShareRequest, opts ...grpc.CallOption) (*ResolveShareResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResolveShareResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ResolveShare_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ListVersions(ctx context.Context, in *ListVersionsRequest, opts ...grpc.CallOption) (*ListVersionsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListVersionsResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ListVersions_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) RestoreVersion(ctx context.Context, in *RestoreVersionRequest, opts ...grpc.CallOption) (*RestoreVersionResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RestoreVersionResponse)
+	err := c.cc.Invoke(ctx, FileUpload_RestoreVersion_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) GetThumbnail(ctx context.Context, in *GetThumbnailRequest, opts ...grpc.CallOption) (*GetThumbnailResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetThumbnailResponse)
+	err := c.cc.Invoke(ctx, FileUpload_GetThumbnail_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ListArchiveEntries(ctx context.Context, in *ListArchiveEntriesRequest, opts ...grpc.CallOption) (*ListArchiveEntriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListArchiveEntriesResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ListArchiveEntries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) ImportFromURL(ctx context.Context, in *ImportFromURLRequest, opts ...grpc.CallOption) (*ImportFromURLResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ImportFromURLResponse)
+	err := c.cc.Invoke(ctx, FileUpload_ImportFromURL_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) GetImport(ctx context.Context, in *GetImportRequest, opts ...grpc.CallOption) (*GetImportResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetImportResponse)
+	err := c.cc.Invoke(ctx, FileUpload_GetImport_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) StartResumableUpload(ctx context.Context, in *StartResumableUploadRequest, opts ...grpc.CallOption) (*ResumableUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResumableUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_StartResumableUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) GetResumableUpload(ctx context.Context, in *GetResumableUploadRequest, opts ...grpc.CallOption) (*ResumableUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResumableUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_GetResumableUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) AppendResumableUpload(ctx context.Context, in *AppendResumableUploadRequest, opts ...grpc.CallOption) (*ResumableUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ResumableUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_AppendResumableUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) CancelResumableUpload(ctx context.Context, in *CancelResumableUploadRequest, opts ...grpc.CallOption) (*CancelResumableUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelResumableUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_CancelResumableUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) StartMultipartUpload(ctx context.Context, in *StartMultipartUploadRequest, opts ...grpc.CallOption) (*StartMultipartUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StartMultipartUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_StartMultipartUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) UploadPart(ctx context.Context, opts ...grpc.CallOption) (FileUpload_UploadPartClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &FileUpload_ServiceDesc.Streams[1], FileUpload_UploadPart_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fileUploadUploadPartClient{stream}
+	return x, nil
+}
+
+type FileUpload_UploadPartClient interface {
+	Send(*UploadPartRequest) error
+	CloseAndRecv() (*UploadPartResponse, error)
+	grpc.ClientStream
+}
+
+type fileUploadUploadPartClient struct {
+	grpc.ClientStream
+}
+
+func (x *fileUploadUploadPartClient) Send(m *UploadPartRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fileUploadUploadPartClient) CloseAndRecv() (*UploadPartResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadPartResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fileUploadClient) CompleteMultipartUpload(ctx context.Context, in *CompleteMultipartUploadRequest, opts ...grpc.CallOption) (*CompleteUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CompleteUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_CompleteMultipartUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *fileUploadClient) AbortMultipartUpload(ctx context.Context, in *AbortMultipartUploadRequest, opts ...grpc.CallOption) (*AbortMultipartUploadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AbortMultipartUploadResponse)
+	err := c.cc.Invoke(ctx, FileUpload_AbortMultipartUpload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // FileUploadServer is the server API for FileUpload service.
 // All implementations must embed UnimplementedFileUploadServer
 // for forward compatibility
@@ -91,106 +535,843 @@ type FileUploadServer interface {
 	AddChunk(context.Context, *AddChunkRequest) (*AddChunkResponse, error)
 	GetChunk(context.Context, *GetChunkRequest) (*GetChunkResponse, error)
 	GetStorageInfo(context.Context, *GetStorageInfoRequest) (*GetStorageInfoResponse, error)
+	CompleteUpload(context.Context, *CompleteUploadRequest) (*CompleteUploadResponse, error)
+	AbortUpload(context.Context, *AbortUploadRequest) (*AbortUploadResponse, error)
+	DownloadFile(*DownloadFileRequest, FileUpload_DownloadFileServer) error
+	RotateKeys(context.Context, *RotateKeysRequest) (*RotateKeysResponse, error)
+	GetJobStatus(context.Context, *GetJobStatusRequest) (*GetJobStatusResponse, error)
+	ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error)
+	StatFile(context.Context, *StatFileRequest) (*StatFileResponse, error)
+	SearchFiles(context.Context, *SearchFilesRequest) (*SearchFilesResponse, error)
+	DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error)
+	RenameFile(context.Context, *RenameFileRequest) (*RenameFileResponse, error)
+	CopyFile(context.Context, *CopyFileRequest) (*CopyFileResponse, error)
+	CreateFolder(context.Context, *CreateFolderRequest) (*CreateFolderResponse, error)
+	ListFolder(context.Context, *ListFolderRequest) (*ListFolderResponse, error)
+	MoveFolder(context.Context, *MoveFolderRequest) (*MoveFolderResponse, error)
+	DeleteFolder(context.Context, *DeleteFolderRequest) (*DeleteFolderResponse, error)
+	SetFolderMembers(context.Context, *SetFolderMembersRequest) (*SetFolderMembersResponse, error)
+	ShareFolder(context.Context, *ShareFolderRequest) (*ShareFolderResponse, error)
+	RevokeShare(context.Context, *RevokeShareRequest) (*RevokeShareResponse, error)
+	ResolveShare(context.Context, *ResolveShareRequest) (*ResolveShareResponse, error)
+	ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error)
+	RestoreVersion(context.Context, *RestoreVersionRequest) (*RestoreVersionResponse, error)
+	GetThumbnail(context.Context, *GetThumbnailRequest) (*GetThumbnailResponse, error)
+	ListArchiveEntries(context.Context, *ListArchiveEntriesRequest) (*ListArchiveEntriesResponse, error)
+	ImportFromURL(context.Context, *ImportFromURLRequest) (*ImportFromURLResponse, error)
+	GetImport(context.Context, *GetImportRequest) (*GetImportResponse, error)
+	StartResumableUpload(context.Context, *StartResumableUploadRequest) (*ResumableUploadResponse, error)
+	GetResumableUpload(context.Context, *GetResumableUploadRequest) (*ResumableUploadResponse, error)
+	AppendResumableUpload(context.Context, *AppendResumableUploadRequest) (*ResumableUploadResponse, error)
+	CancelResumableUpload(context.Context, *CancelResumableUploadRequest) (*CancelResumableUploadResponse, error)
+	StartMultipartUpload(context.Context, *StartMultipartUploadRequest) (*StartMultipartUploadResponse, error)
+	UploadPart(FileUpload_UploadPartServer) error
+	CompleteMultipartUpload(context.Context, *CompleteMultipartUploadRequest) (*CompleteUploadResponse, error)
+	AbortMultipartUpload(context.Context, *AbortMultipartUploadRequest) (*AbortMultipartUploadResponse, error)
 	mustEmbedUnimplementedFileUploadServer()
 }
 
-// UnimplementedFileUploadServer must be embedded to have forward compatible implementations.
-type UnimplementedFileUploadServer struct {
+// UnimplementedFileUploadServer must be embedded to have forward compatible implementations.
+type UnimplementedFileUploadServer struct {
+}
+
+func (UnimplementedFileUploadServer) UploadFile(context.Context, *UploadFileRequest) (*UploadFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
+}
+func (UnimplementedFileUploadServer) AddChunk(context.Context, *AddChunkRequest) (*AddChunkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddChunk not implemented")
+}
+func (UnimplementedFileUploadServer) GetChunk(context.Context, *GetChunkRequest) (*GetChunkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChunk not implemented")
+}
+func (UnimplementedFileUploadServer) GetStorageInfo(context.Context, *GetStorageInfoRequest) (*GetStorageInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStorageInfo not implemented")
+}
+func (UnimplementedFileUploadServer) CompleteUpload(context.Context, *CompleteUploadRequest) (*CompleteUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompleteUpload not implemented")
+}
+func (UnimplementedFileUploadServer) AbortUpload(context.Context, *AbortUploadRequest) (*AbortUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortUpload not implemented")
+}
+func (UnimplementedFileUploadServer) DownloadFile(*DownloadFileRequest, FileUpload_DownloadFileServer) error {
+	return status.Errorf(codes.Unimplemented, "method DownloadFile not implemented")
+}
+func (UnimplementedFileUploadServer) RotateKeys(context.Context, *RotateKeysRequest) (*RotateKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateKeys not implemented")
+}
+func (UnimplementedFileUploadServer) GetJobStatus(context.Context, *GetJobStatusRequest) (*GetJobStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJobStatus not implemented")
+}
+func (UnimplementedFileUploadServer) ListFiles(context.Context, *ListFilesRequest) (*ListFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFiles not implemented")
+}
+func (UnimplementedFileUploadServer) StatFile(context.Context, *StatFileRequest) (*StatFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StatFile not implemented")
+}
+func (UnimplementedFileUploadServer) SearchFiles(context.Context, *SearchFilesRequest) (*SearchFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchFiles not implemented")
+}
+func (UnimplementedFileUploadServer) DeleteFile(context.Context, *DeleteFileRequest) (*DeleteFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFile not implemented")
+}
+func (UnimplementedFileUploadServer) RenameFile(context.Context, *RenameFileRequest) (*RenameFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenameFile not implemented")
+}
+func (UnimplementedFileUploadServer) CopyFile(context.Context, *CopyFileRequest) (*CopyFileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CopyFile not implemented")
+}
+func (UnimplementedFileUploadServer) CreateFolder(context.Context, *CreateFolderRequest) (*CreateFolderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateFolder not implemented")
+}
+func (UnimplementedFileUploadServer) ListFolder(context.Context, *ListFolderRequest) (*ListFolderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListFolder not implemented")
+}
+func (UnimplementedFileUploadServer) MoveFolder(context.Context, *MoveFolderRequest) (*MoveFolderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MoveFolder not implemented")
+}
+func (UnimplementedFileUploadServer) DeleteFolder(context.Context, *DeleteFolderRequest) (*DeleteFolderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFolder not implemented")
+}
+func (UnimplementedFileUploadServer) SetFolderMembers(context.Context, *SetFolderMembersRequest) (*SetFolderMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetFolderMembers not implemented")
+}
+func (UnimplementedFileUploadServer) ShareFolder(context.Context, *ShareFolderRequest) (*ShareFolderResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ShareFolder not implemented")
+}
+func (UnimplementedFileUploadServer) RevokeShare(context.Context, *RevokeShareRequest) (*RevokeShareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeShare not implemented")
+}
+func (UnimplementedFileUploadServer) ResolveShare(context.Context, *ResolveShareRequest) (*ResolveShareResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResolveShare not implemented")
+}
+func (UnimplementedFileUploadServer) ListVersions(context.Context, *ListVersionsRequest) (*ListVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVersions not implemented")
+}
+func (UnimplementedFileUploadServer) RestoreVersion(context.Context, *RestoreVersionRequest) (*RestoreVersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreVersion not implemented")
+}
+func (UnimplementedFileUploadServer) GetThumbnail(context.Context, *GetThumbnailRequest) (*GetThumbnailResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetThumbnail not implemented")
+}
+func (UnimplementedFileUploadServer) ListArchiveEntries(context.Context, *ListArchiveEntriesRequest) (*ListArchiveEntriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListArchiveEntries not implemented")
+}
+func (UnimplementedFileUploadServer) ImportFromURL(context.Context, *ImportFromURLRequest) (*ImportFromURLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportFromURL not implemented")
+}
+func (UnimplementedFileUploadServer) GetImport(context.Context, *GetImportRequest) (*GetImportResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetImport not implemented")
+}
+func (UnimplementedFileUploadServer) StartResumableUpload(context.Context, *StartResumableUploadRequest) (*ResumableUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartResumableUpload not implemented")
+}
+func (UnimplementedFileUploadServer) GetResumableUpload(context.Context, *GetResumableUploadRequest) (*ResumableUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetResumableUpload not implemented")
+}
+func (UnimplementedFileUploadServer) AppendResumableUpload(context.Context, *AppendResumableUploadRequest) (*ResumableUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendResumableUpload not implemented")
+}
+func (UnimplementedFileUploadServer) CancelResumableUpload(context.Context, *CancelResumableUploadRequest) (*CancelResumableUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelResumableUpload not implemented")
+}
+func (UnimplementedFileUploadServer) StartMultipartUpload(context.Context, *StartMultipartUploadRequest) (*StartMultipartUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartMultipartUpload not implemented")
+}
+func (UnimplementedFileUploadServer) UploadPart(FileUpload_UploadPartServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadPart not implemented")
+}
+func (UnimplementedFileUploadServer) CompleteMultipartUpload(context.Context, *CompleteMultipartUploadRequest) (*CompleteUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CompleteMultipartUpload not implemented")
+}
+func (UnimplementedFileUploadServer) AbortMultipartUpload(context.Context, *AbortMultipartUploadRequest) (*AbortMultipartUploadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortMultipartUpload not implemented")
+}
+func (UnimplementedFileUploadServer) mustEmbedUnimplementedFileUploadServer() {}
+
+// UnsafeFileUploadServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FileUploadServer will
+// result in compilation errors.
+type UnsafeFileUploadServer interface {
+	mustEmbedUnimplementedFileUploadServer()
+}
+
+func RegisterFileUploadServer(s grpc.ServiceRegistrar, srv FileUploadServer) {
+	s.RegisterService(&FileUpload_ServiceDesc, srv)
+}
+
+func _FileUpload_UploadFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).UploadFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_UploadFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).UploadFile(ctx, req.(*UploadFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_AddChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddChunkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).AddChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_AddChunk_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).AddChunk(ctx, req.(*AddChunkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_GetChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChunkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).GetChunk(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_GetChunk_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).GetChunk(ctx, req.(*GetChunkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_GetStorageInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStorageInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).GetStorageInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_GetStorageInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).GetStorageInfo(ctx, req.(*GetStorageInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_CompleteUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompleteUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).CompleteUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_CompleteUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).CompleteUpload(ctx, req.(*CompleteUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_AbortUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).AbortUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_AbortUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).AbortUpload(ctx, req.(*AbortUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_DownloadFile_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadFileRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FileUploadServer).DownloadFile(m, &fileUploadDownloadFileServer{stream})
+}
+
+type FileUpload_DownloadFileServer interface {
+	Send(*DownloadFileResponse) error
+	grpc.ServerStream
+}
+
+type fileUploadDownloadFileServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileUploadDownloadFileServer) Send(m *DownloadFileResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FileUpload_RotateKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).RotateKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_RotateKeys_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).RotateKeys(ctx, req.(*RotateKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_GetJobStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).GetJobStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_GetJobStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).GetJobStatus(ctx, req.(*GetJobStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_ListFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ListFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ListFiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ListFiles(ctx, req.(*ListFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_StatFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).StatFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_StatFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).StatFile(ctx, req.(*StatFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_SearchFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).SearchFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_SearchFiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).SearchFiles(ctx, req.(*SearchFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_DeleteFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).DeleteFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_DeleteFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).DeleteFile(ctx, req.(*DeleteFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_RenameFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).RenameFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_RenameFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).RenameFile(ctx, req.(*RenameFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_CopyFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CopyFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).CopyFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_CopyFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).CopyFile(ctx, req.(*CopyFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_CreateFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).CreateFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_CreateFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).CreateFolder(ctx, req.(*CreateFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_ListFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ListFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ListFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ListFolder(ctx, req.(*ListFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_MoveFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).MoveFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_MoveFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).MoveFolder(ctx, req.(*MoveFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_DeleteFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).DeleteFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_DeleteFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).DeleteFolder(ctx, req.(*DeleteFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_SetFolderMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetFolderMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).SetFolderMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_SetFolderMembers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).SetFolderMembers(ctx, req.(*SetFolderMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_ShareFolder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShareFolderRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ShareFolder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ShareFolder_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ShareFolder(ctx, req.(*ShareFolderRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_RevokeShare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeShareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).RevokeShare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_RevokeShare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).RevokeShare(ctx, req.(*RevokeShareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func (UnimplementedFileUploadServer) UploadFile(context.Context, *UploadFileRequest) (*UploadFileResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method UploadFile not implemented")
+func _FileUpload_ResolveShare_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResolveShareRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ResolveShare(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ResolveShare_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ResolveShare(ctx, req.(*ResolveShareRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
-func (UnimplementedFileUploadServer) AddChunk(context.Context, *AddChunkRequest) (*AddChunkResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method AddChunk not implemented")
+
+func _FileUpload_ListVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ListVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ListVersions_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ListVersions(ctx, req.(*ListVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
-func (UnimplementedFileUploadServer) GetChunk(context.Context, *GetChunkRequest) (*GetChunkResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetChunk not implemented")
+
+func _FileUpload_RestoreVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).RestoreVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_RestoreVersion_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).RestoreVersion(ctx, req.(*RestoreVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
-func (UnimplementedFileUploadServer) GetStorageInfo(context.Context, *GetStorageInfoRequest) (*GetStorageInfoResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetStorageInfo not implemented")
+
+func _FileUpload_GetThumbnail_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetThumbnailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).GetThumbnail(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_GetThumbnail_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).GetThumbnail(ctx, req.(*GetThumbnailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
-func (UnimplementedFileUploadServer) mustEmbedUnimplementedFileUploadServer() {}
 
-// UnsafeFileUploadServer may be embedded to opt out of forward compatibility for this service.
-// Use of this interface is not recommended, as added methods to FileUploadServer will
-// result in compilation errors.
-type UnsafeFileUploadServer interface {
-	mustEmbedUnimplementedFileUploadServer()
+func _FileUpload_ListArchiveEntries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListArchiveEntriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ListArchiveEntries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ListArchiveEntries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ListArchiveEntries(ctx, req.(*ListArchiveEntriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func RegisterFileUploadServer(s grpc.ServiceRegistrar, srv FileUploadServer) {
-	s.RegisterService(&FileUpload_ServiceDesc, srv)
+func _FileUpload_ImportFromURL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportFromURLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).ImportFromURL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_ImportFromURL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).ImportFromURL(ctx, req.(*ImportFromURLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-func _FileUpload_UploadFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UploadFileRequest)
+func _FileUpload_GetImport_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetImportRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(FileUploadServer).UploadFile(ctx, in)
+		return srv.(FileUploadServer).GetImport(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: FileUpload_UploadFile_FullMethodName,
+		FullMethod: FileUpload_GetImport_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(FileUploadServer).UploadFile(ctx, req.(*UploadFileRequest))
+		return srv.(FileUploadServer).GetImport(ctx, req.(*GetImportRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _FileUpload_AddChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(AddChunkRequest)
+func _FileUpload_StartResumableUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartResumableUploadRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(FileUploadServer).AddChunk(ctx, in)
+		return srv.(FileUploadServer).StartResumableUpload(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: FileUpload_AddChunk_FullMethodName,
+		FullMethod: FileUpload_StartResumableUpload_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(FileUploadServer).AddChunk(ctx, req.(*AddChunkRequest))
+		return srv.(FileUploadServer).StartResumableUpload(ctx, req.(*StartResumableUploadRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _FileUpload_GetChunk_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetChunkRequest)
+func _FileUpload_GetResumableUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetResumableUploadRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(FileUploadServer).GetChunk(ctx, in)
+		return srv.(FileUploadServer).GetResumableUpload(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: FileUpload_GetChunk_FullMethodName,
+		FullMethod: FileUpload_GetResumableUpload_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(FileUploadServer).GetChunk(ctx, req.(*GetChunkRequest))
+		return srv.(FileUploadServer).GetResumableUpload(ctx, req.(*GetResumableUploadRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _FileUpload_GetStorageInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetStorageInfoRequest)
+func _FileUpload_AppendResumableUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendResumableUploadRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(FileUploadServer).GetStorageInfo(ctx, in)
+		return srv.(FileUploadServer).AppendResumableUpload(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: FileUpload_GetStorageInfo_FullMethodName,
+		FullMethod: FileUpload_AppendResumableUpload_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(FileUploadServer).GetStorageInfo(ctx, req.(*GetStorageInfoRequest))
+		return srv.(FileUploadServer).AppendResumableUpload(ctx, req.(*AppendResumableUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_CancelResumableUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelResumableUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).CancelResumableUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_CancelResumableUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).CancelResumableUpload(ctx, req.(*CancelResumableUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_StartMultipartUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartMultipartUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).StartMultipartUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_StartMultipartUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).StartMultipartUpload(ctx, req.(*StartMultipartUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_UploadPart_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FileUploadServer).UploadPart(&fileUploadUploadPartServer{stream})
+}
+
+type FileUpload_UploadPartServer interface {
+	SendAndClose(*UploadPartResponse) error
+	Recv() (*UploadPartRequest, error)
+	grpc.ServerStream
+}
+
+type fileUploadUploadPartServer struct {
+	grpc.ServerStream
+}
+
+func (x *fileUploadUploadPartServer) SendAndClose(m *UploadPartResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fileUploadUploadPartServer) Recv() (*UploadPartRequest, error) {
+	m := new(UploadPartRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FileUpload_CompleteMultipartUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CompleteMultipartUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).CompleteMultipartUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_CompleteMultipartUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).CompleteMultipartUpload(ctx, req.(*CompleteMultipartUploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FileUpload_AbortMultipartUpload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortMultipartUploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FileUploadServer).AbortMultipartUpload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: FileUpload_AbortMultipartUpload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FileUploadServer).AbortMultipartUpload(ctx, req.(*AbortMultipartUploadRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -218,7 +1399,142 @@ var FileUpload_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetStorageInfo",
 			Handler:    _FileUpload_GetStorageInfo_Handler,
 		},
+		{
+			MethodName: "CompleteUpload",
+			Handler:    _FileUpload_CompleteUpload_Handler,
+		},
+		{
+			MethodName: "AbortUpload",
+			Handler:    _FileUpload_AbortUpload_Handler,
+		},
+		{
+			MethodName: "RotateKeys",
+			Handler:    _FileUpload_RotateKeys_Handler,
+		},
+		{
+			MethodName: "GetJobStatus",
+			Handler:    _FileUpload_GetJobStatus_Handler,
+		},
+		{
+			MethodName: "ListFiles",
+			Handler:    _FileUpload_ListFiles_Handler,
+		},
+		{
+			MethodName: "StatFile",
+			Handler:    _FileUpload_StatFile_Handler,
+		},
+		{
+			MethodName: "SearchFiles",
+			Handler:    _FileUpload_SearchFiles_Handler,
+		},
+		{
+			MethodName: "DeleteFile",
+			Handler:    _FileUpload_DeleteFile_Handler,
+		},
+		{
+			MethodName: "RenameFile",
+			Handler:    _FileUpload_RenameFile_Handler,
+		},
+		{
+			MethodName: "CopyFile",
+			Handler:    _FileUpload_CopyFile_Handler,
+		},
+		{
+			MethodName: "CreateFolder",
+			Handler:    _FileUpload_CreateFolder_Handler,
+		},
+		{
+			MethodName: "ListFolder",
+			Handler:    _FileUpload_ListFolder_Handler,
+		},
+		{
+			MethodName: "MoveFolder",
+			Handler:    _FileUpload_MoveFolder_Handler,
+		},
+		{
+			MethodName: "DeleteFolder",
+			Handler:    _FileUpload_DeleteFolder_Handler,
+		},
+		{
+			MethodName: "SetFolderMembers",
+			Handler:    _FileUpload_SetFolderMembers_Handler,
+		},
+		{
+			MethodName: "ShareFolder",
+			Handler:    _FileUpload_ShareFolder_Handler,
+		},
+		{
+			MethodName: "RevokeShare",
+			Handler:    _FileUpload_RevokeShare_Handler,
+		},
+		{
+			MethodName: "ResolveShare",
+			Handler:    _FileUpload_ResolveShare_Handler,
+		},
+		{
+			MethodName: "ListVersions",
+			Handler:    _FileUpload_ListVersions_Handler,
+		},
+		{
+			MethodName: "RestoreVersion",
+			Handler:    _FileUpload_RestoreVersion_Handler,
+		},
+		{
+			MethodName: "GetThumbnail",
+			Handler:    _FileUpload_GetThumbnail_Handler,
+		},
+		{
+			MethodName: "ListArchiveEntries",
+			Handler:    _FileUpload_ListArchiveEntries_Handler,
+		},
+		{
+			MethodName: "ImportFromURL",
+			Handler:    _FileUpload_ImportFromURL_Handler,
+		},
+		{
+			MethodName: "GetImport",
+			Handler:    _FileUpload_GetImport_Handler,
+		},
+		{
+			MethodName: "StartResumableUpload",
+			Handler:    _FileUpload_StartResumableUpload_Handler,
+		},
+		{
+			MethodName: "GetResumableUpload",
+			Handler:    _FileUpload_GetResumableUpload_Handler,
+		},
+		{
+			MethodName: "AppendResumableUpload",
+			Handler:    _FileUpload_AppendResumableUpload_Handler,
+		},
+		{
+			MethodName: "CancelResumableUpload",
+			Handler:    _FileUpload_CancelResumableUpload_Handler,
+		},
+		{
+			MethodName: "StartMultipartUpload",
+			Handler:    _FileUpload_StartMultipartUpload_Handler,
+		},
+		{
+			MethodName: "CompleteMultipartUpload",
+			Handler:    _FileUpload_CompleteMultipartUpload_Handler,
+		},
+		{
+			MethodName: "AbortMultipartUpload",
+			Handler:    _FileUpload_AbortMultipartUpload_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "DownloadFile",
+			Handler:       _FileUpload_DownloadFile_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "UploadPart",
+			Handler:       _FileUpload_UploadPart_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/filesharing.proto",
 }
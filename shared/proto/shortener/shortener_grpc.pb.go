@@ -19,8 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion8
 
 const (
-	Shortener_ShortURL_FullMethodName   = "/shortener.Shortener/ShortURL"
-	Shortener_ResolveURL_FullMethodName = "/shortener.Shortener/ResolveURL"
+	Shortener_ShortURL_FullMethodName      = "/shortener.Shortener/ShortURL"
+	Shortener_ResolveURL_FullMethodName    = "/shortener.Shortener/ResolveURL"
+	Shortener_ShortURLBatch_FullMethodName = "/shortener.Shortener/ShortURLBatch"
+	Shortener_ExportURLs_FullMethodName    = "/shortener.Shortener/ExportURLs"
+	Shortener_ImportURLs_FullMethodName    = "/shortener.Shortener/ImportURLs"
+	Shortener_ListURLs_FullMethodName      = "/shortener.Shortener/ListURLs"
 )
 
 // ShortenerClient is the client API for Shortener service.
@@ -29,6 +33,10 @@ const (
 type ShortenerClient interface {
 	ShortURL(ctx context.Context, in *ShortURLRequest, opts ...grpc.CallOption) (*ShortURLResponse, error)
 	ResolveURL(ctx context.Context, in *ResolveURLRequest, opts ...grpc.CallOption) (*ResolveURLResponse, error)
+	ShortURLBatch(ctx context.Context, in *ShortURLBatchRequest, opts ...grpc.CallOption) (*ShortURLBatchResponse, error)
+	ExportURLs(ctx context.Context, in *ExportURLsRequest, opts ...grpc.CallOption) (*ExportURLsResponse, error)
+	ImportURLs(ctx context.Context, in *ImportURLsRequest, opts ...grpc.CallOption) (*ImportURLsResponse, error)
+	ListURLs(ctx context.Context, in *ListURLsRequest, opts ...grpc.CallOption) (*ListURLsResponse, error)
 }
 
 type shortenerClient struct {
@@ -59,12 +67,56 @@ func (c *shortenerClient) ResolveURL(ctx context.Context, in *ResolveURLRequest,
 	return out, nil
 }
 
+func (c *shortenerClient) ShortURLBatch(ctx context.Context, in *ShortURLBatchRequest, opts ...grpc.CallOption) (*ShortURLBatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ShortURLBatchResponse)
+	err := c.cc.Invoke(ctx, Shortener_ShortURLBatch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) ExportURLs(ctx context.Context, in *ExportURLsRequest, opts ...grpc.CallOption) (*ExportURLsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ExportURLsResponse)
+	err := c.cc.Invoke(ctx, Shortener_ExportURLs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) ImportURLs(ctx context.Context, in *ImportURLsRequest, opts ...grpc.CallOption) (*ImportURLsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ImportURLsResponse)
+	err := c.cc.Invoke(ctx, Shortener_ImportURLs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *shortenerClient) ListURLs(ctx context.Context, in *ListURLsRequest, opts ...grpc.CallOption) (*ListURLsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListURLsResponse)
+	err := c.cc.Invoke(ctx, Shortener_ListURLs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ShortenerServer is the server API for Shortener service.
 // All implementations must embed UnimplementedShortenerServer
 // for forward compatibility
 type ShortenerServer interface {
 	ShortURL(context.Context, *ShortURLRequest) (*ShortURLResponse, error)
 	ResolveURL(context.Context, *ResolveURLRequest) (*ResolveURLResponse, error)
+	ShortURLBatch(context.Context, *ShortURLBatchRequest) (*ShortURLBatchResponse, error)
+	ExportURLs(context.Context, *ExportURLsRequest) (*ExportURLsResponse, error)
+	ImportURLs(context.Context, *ImportURLsRequest) (*ImportURLsResponse, error)
+	ListURLs(context.Context, *ListURLsRequest) (*ListURLsResponse, error)
 	mustEmbedUnimplementedShortenerServer()
 }
 
@@ -78,6 +130,18 @@ func (UnimplementedShortenerServer) ShortURL(context.Context, *ShortURLRequest)
 func (UnimplementedShortenerServer) ResolveURL(context.Context, *ResolveURLRequest) (*ResolveURLResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ResolveURL not implemented")
 }
+func (UnimplementedShortenerServer) ShortURLBatch(context.Context, *ShortURLBatchRequest) (*ShortURLBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ShortURLBatch not implemented")
+}
+func (UnimplementedShortenerServer) ExportURLs(context.Context, *ExportURLsRequest) (*ExportURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExportURLs not implemented")
+}
+func (UnimplementedShortenerServer) ImportURLs(context.Context, *ImportURLsRequest) (*ImportURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportURLs not implemented")
+}
+func (UnimplementedShortenerServer) ListURLs(context.Context, *ListURLsRequest) (*ListURLsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListURLs not implemented")
+}
 func (UnimplementedShortenerServer) mustEmbedUnimplementedShortenerServer() {}
 
 // UnsafeShortenerServer may be embedded to opt out of forward compatibility for this service.
@@ -127,6 +191,78 @@ func _Shortener_ResolveURL_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Shortener_ShortURLBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ShortURLBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).ShortURLBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shortener_ShortURLBatch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).ShortURLBatch(ctx, req.(*ShortURLBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_ExportURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExportURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).ExportURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shortener_ExportURLs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).ExportURLs(ctx, req.(*ExportURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_ImportURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).ImportURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shortener_ImportURLs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).ImportURLs(ctx, req.(*ImportURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Shortener_ListURLs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListURLsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ShortenerServer).ListURLs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Shortener_ListURLs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ShortenerServer).ListURLs(ctx, req.(*ListURLsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Shortener_ServiceDesc is the grpc.ServiceDesc for Shortener service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -142,6 +278,22 @@ var Shortener_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ResolveURL",
 			Handler:    _Shortener_ResolveURL_Handler,
 		},
+		{
+			MethodName: "ShortURLBatch",
+			Handler:    _Shortener_ShortURLBatch_Handler,
+		},
+		{
+			MethodName: "ExportURLs",
+			Handler:    _Shortener_ExportURLs_Handler,
+		},
+		{
+			MethodName: "ImportURLs",
+			Handler:    _Shortener_ImportURLs_Handler,
+		},
+		{
+			MethodName: "ListURLs",
+			Handler:    _Shortener_ListURLs_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/shortener.proto",